@@ -0,0 +1,254 @@
+package arg
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Command describes a command for NewParserFromCommand without a destination
+// struct, which is useful for programs whose options are only known at run
+// time, such as those defined by plugins. Each option and positional is
+// stored in a variable given by a pointer, and is configured with the same
+// options as the arg tag of a struct field.
+type Command struct {
+	name        string
+	help        string
+	args        []*builtArg
+	subcommands []*Command
+}
+
+// builtArg is an option or positional added to a Command
+type builtArg struct {
+	name       string
+	dest       interface{}
+	positional bool
+	tag        []string // the options of the arg tag, other than the name
+	help       string
+	def        string
+	hasDefault bool
+}
+
+// ArgOption configures an option or positional added with Command.Flag or
+// Command.Positional
+type ArgOption func(*builtArg)
+
+// WithShort gives an option a short name, as in -v
+func WithShort(name string) ArgOption {
+	return func(a *builtArg) { a.tag = append(a.tag, "-"+name) }
+}
+
+// WithHelp sets the help text of an option or positional, like the help tag
+func WithHelp(text string) ArgOption {
+	return func(a *builtArg) { a.help = text }
+}
+
+// WithEnv reads an option or positional from the named environment variable,
+// or from one derived from its name if name is empty, like env in an arg tag
+func WithEnv(name string) ArgOption {
+	if name == "" {
+		return WithTag("env")
+	}
+	return WithTag("env:" + name)
+}
+
+// WithDefault sets the default value of an option or positional, like the
+// default tag
+func WithDefault(value string) ArgOption {
+	return func(a *builtArg) { a.def, a.hasDefault = value, true }
+}
+
+// WithRequired makes an option or positional required
+func WithRequired() ArgOption {
+	return WithTag("required")
+}
+
+// WithPlaceholder sets the name of the value of an option or positional in
+// help text, like the placeholder tag
+func WithPlaceholder(name string) ArgOption {
+	return WithTag("placeholder:" + name)
+}
+
+// WithTag adds any other option that an arg tag accepts, such as "hidden" or
+// "choices:json|yaml"
+func WithTag(option string) ArgOption {
+	return func(a *builtArg) { a.tag = append(a.tag, option) }
+}
+
+// NewCommand creates a command with the given name. The name of the top-level
+// command is used as the program name unless Config.Program is set.
+func NewCommand(name string) *Command {
+	return &Command{name: name}
+}
+
+// Help sets the help text of the command, which for the top-level command is
+// used as its description
+func (c *Command) Help(text string) *Command {
+	c.help = text
+	return c
+}
+
+// Flag adds an option with the given long name, without hyphens, which is
+// stored in the variable to which dest points. For the top-level command, the
+// value of that variable when NewParserFromCommand is called is the default,
+// as for the fields of a destination struct. Use WithDefault for the options
+// of subcommands.
+func (c *Command) Flag(name string, dest interface{}, opts ...ArgOption) *Command {
+	return c.add(&builtArg{name: name, dest: dest}, opts)
+}
+
+// Positional adds a positional argument, which is stored in the variable to
+// which dest points. The name is used to derive its placeholder in help text.
+func (c *Command) Positional(name string, dest interface{}, opts ...ArgOption) *Command {
+	return c.add(&builtArg{name: name, dest: dest, positional: true}, opts)
+}
+
+// Subcommand adds a subcommand with the given name and returns it, so that
+// its options can be added. Use Parser.SubcommandNames to find out which
+// subcommand was selected.
+func (c *Command) Subcommand(name string) *Command {
+	sub := NewCommand(name)
+	c.subcommands = append(c.subcommands, sub)
+	return sub
+}
+
+func (c *Command) add(a *builtArg, opts []ArgOption) *Command {
+	for _, opt := range opts {
+		opt(a)
+	}
+	c.args = append(c.args, a)
+	return c
+}
+
+// NewParserFromCommand constructs a parser for the given command, which is
+// parsed as if it were a destination struct with a field for each option,
+// positional, and subcommand, so that all features of arg tags are
+// available. The values are stored in the variables given to Command.Flag
+// and Command.Positional each time arguments are processed.
+func NewParserFromCommand(config Config, cmd *Command) (*Parser, error) {
+	t, err := cmd.structType()
+	if err != nil {
+		return nil, fmt.Errorf("NewParserFromCommand: %v", err)
+	}
+	root := reflect.New(t)
+	cmd.load(root.Elem())
+
+	if config.Program == "" {
+		config.Program = cmd.name
+	}
+	p, err := NewParser(config, root.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if cmd.help != "" {
+		p.description = cmd.help
+	}
+	p.built = cmd
+	return p, nil
+}
+
+// structType returns the type of the struct with a field for each argument
+// and subcommand of the command, with the arg tags that they were given
+func (c *Command) structType() (reflect.Type, error) {
+	var fields []reflect.StructField
+	names := make(map[string]bool)
+	for i, a := range c.args {
+		v := reflect.ValueOf(a.dest)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return nil, fmt.Errorf("the destination of %s must be a non-nil pointer but got %T", a.name, a.dest)
+		}
+
+		options := []string{"--" + a.name}
+		if a.positional {
+			options = []string{"positional"}
+		}
+		tag := fmt.Sprintf("arg:%s", strconv.Quote(strings.Join(append(options, a.tag...), ",")))
+		if a.help != "" {
+			tag += fmt.Sprintf(" help:%s", strconv.Quote(a.help))
+		}
+		if a.hasDefault {
+			tag += fmt.Sprintf(" default:%s", strconv.Quote(a.def))
+		}
+		fields = append(fields, reflect.StructField{
+			Name: fieldName(a.name, i, names),
+			Type: v.Elem().Type(),
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+	for i, sub := range c.subcommands {
+		t, err := sub.structType()
+		if err != nil {
+			return nil, err
+		}
+		tag := fmt.Sprintf("arg:%s", strconv.Quote("subcommand:"+sub.name))
+		if sub.help != "" {
+			tag += fmt.Sprintf(" help:%s", strconv.Quote(sub.help))
+		}
+		fields = append(fields, reflect.StructField{
+			Name: fieldName(sub.name, len(c.args)+i, names),
+			Type: reflect.PtrTo(t),
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+	return reflect.StructOf(fields), nil
+}
+
+// fieldName returns an exported field name derived from the name of an
+// argument, as in DryRun for dry-run, or one derived from its index if that
+// is not a valid identifier or is already taken. Underscores are appended to
+// the name derived from the index until it is not taken either, since the
+// names of arguments, such as arg1, may resemble it.
+func fieldName(name string, index int, taken map[string]bool) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r := []rune(word)
+		b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+	}
+	field := b.String()
+	if !isExported(field) || taken[field] {
+		field = fmt.Sprintf("Arg%d", index)
+		for taken[field] {
+			field += "_"
+		}
+	}
+	taken[field] = true
+	return field
+}
+
+// load copies the values of the variables of the command into the fields of
+// the given struct, so that they become the defaults
+func (c *Command) load(v reflect.Value) {
+	for i, a := range c.args {
+		v.Field(i).Set(reflect.ValueOf(a.dest).Elem())
+	}
+}
+
+// store copies the fields of the given struct into the variables of the
+// command and its subcommands. The variables of the subcommands that were not
+// selected are set to their zero values, so that none of them keeps a value
+// from an earlier call to Parse.
+func (c *Command) store(v reflect.Value) {
+	for i, a := range c.args {
+		reflect.ValueOf(a.dest).Elem().Set(v.Field(i))
+	}
+	for i, sub := range c.subcommands {
+		field := v.Field(len(c.args) + i)
+		if field.IsNil() {
+			sub.store(reflect.New(field.Type().Elem()).Elem())
+		} else {
+			sub.store(field.Elem())
+		}
+	}
+}
+
+// storeBuilt copies the values that were parsed into the variables of the
+// Command from which the parser was constructed, if any
+func (p *Parser) storeBuilt() {
+	if p.built != nil {
+		p.built.store(p.roots[0].Elem())
+	}
+}
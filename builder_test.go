@@ -0,0 +1,177 @@
+package arg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewParserFromCommand(t *testing.T) {
+	var (
+		name    string
+		count   = 3
+		verbose bool
+		tags    []string
+		input   string
+	)
+	cmd := NewCommand("example").
+		Flag("name", &name, WithShort("n"), WithHelp("who to greet"), WithDefault("world")).
+		Flag("count", &count).
+		Flag("verbose", &verbose, WithShort("v")).
+		Flag("tag", &tags).
+		Positional("input", &input, WithRequired())
+
+	p, err := NewParserFromCommand(Config{}, cmd)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Parse([]string{"in.txt", "-v", "--tag", "a", "b"}))
+	assert.Equal(t, "world", name)
+	assert.Equal(t, 3, count)
+	assert.True(t, verbose)
+	assert.Equal(t, []string{"a", "b"}, tags)
+	assert.Equal(t, "in.txt", input)
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"-n", "bob", "--count", "5", "x"}))
+	assert.Equal(t, "bob", name)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, "x", input)
+	assert.False(t, verbose)
+
+	p.Reset()
+	err = p.Parse(nil)
+	assert.EqualError(t, err, "input is required")
+}
+
+func TestNewParserFromCommandEnv(t *testing.T) {
+	var token, region string
+	cmd := NewCommand("example").
+		Flag("token", &token, WithEnv("API_TOKEN")).
+		Flag("region", &region, WithEnv(""))
+	t.Setenv("API_TOKEN", "secret")
+	t.Setenv("REGION", "eu")
+
+	p, err := NewParserFromCommand(Config{}, cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(nil))
+	assert.Equal(t, "secret", token)
+	assert.Equal(t, "eu", region)
+}
+
+func TestNewParserFromCommandSubcommands(t *testing.T) {
+	var (
+		verbose bool
+		target  string
+		app     string
+		force   bool
+	)
+	cmd := NewCommand("example").Flag("verbose", &verbose)
+	cmd.Subcommand("deploy").Help("deploy the app").
+		Flag("target", &target, WithDefault("staging")).
+		Positional("app", &app)
+	cmd.Subcommand("remove").Help("remove the app").
+		Flag("force", &force)
+
+	p, err := NewParserFromCommand(Config{}, cmd)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Parse([]string{"--verbose", "deploy", "web"}))
+	assert.True(t, verbose)
+	assert.Equal(t, "staging", target)
+	assert.Equal(t, "web", app)
+	assert.False(t, force)
+	assert.Equal(t, []string{"deploy"}, p.SubcommandNames())
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"remove", "--force"}))
+	assert.True(t, force)
+	assert.Equal(t, "", target)
+	assert.Equal(t, "", app)
+	assert.Equal(t, []string{"remove"}, p.SubcommandNames())
+
+	p.Reset()
+	assert.False(t, force)
+	require.NoError(t, p.Parse([]string{"deploy", "web"}))
+	assert.False(t, force)
+	assert.Equal(t, "staging", target)
+	assert.Equal(t, []string{"deploy"}, p.SubcommandNames())
+}
+
+func TestNewParserFromCommandHelp(t *testing.T) {
+	expectedUsage := "Usage: example [--name NAME] [--dry-run] FILE"
+	expectedHelp := `
+greets people
+Usage: example [--name NAME] [--dry-run] FILE
+
+Positional arguments:
+  FILE                   the file to read
+
+Options:
+  --name NAME, -n NAME   who to greet [default: world]
+  --dry-run              do nothing
+  --help, -h             display this help and exit
+`
+	var name, file string
+	var dryRun bool
+	cmd := NewCommand("example").Help("greets people").
+		Flag("name", &name, WithShort("n"), WithHelp("who to greet"), WithDefault("world")).
+		Flag("dry-run", &dryRun, WithHelp("do nothing")).
+		Positional("file", &file, WithRequired(), WithHelp("the file to read"))
+
+	p, err := NewParserFromCommand(Config{}, cmd)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, expectedUsage, strings.TrimSpace(usage.String()))
+}
+
+func TestNewParserFromCommandErrors(t *testing.T) {
+	var name string
+	_, err := NewParserFromCommand(Config{}, NewCommand("example").Flag("name", name))
+	assert.EqualError(t, err, "NewParserFromCommand: the destination of name must be a non-nil pointer but got string")
+
+	_, err = NewParserFromCommand(Config{}, NewCommand("example").Flag("name", &name, WithTag("nosuchoption")))
+	assert.Error(t, err)
+
+	_, err = NewParserFromCommand(Config{}, NewCommand("example").Flag("name", &name, WithTag("hidden:yes")))
+	assert.Error(t, err)
+
+	p, err := NewParserFromCommand(Config{LenientTags: true}, NewCommand("example").Flag("name", &name, WithTag("hidden:yes")))
+	require.NoError(t, err)
+	require.Len(t, p.Warnings(), 1)
+	assert.Contains(t, p.Warnings()[0], `hidden does not take a value but got "yes"`)
+}
+
+func TestFieldName(t *testing.T) {
+	taken := make(map[string]bool)
+	assert.Equal(t, "DryRun", fieldName("dry-run", 0, taken))
+	assert.Equal(t, "Arg1", fieldName("dry_run", 1, taken))
+	assert.Equal(t, "Name", fieldName("name", 2, taken))
+	assert.Equal(t, "Arg3", fieldName("--", 3, taken))
+	assert.Equal(t, "Arg4", fieldName("2fa", 4, taken))
+	assert.Equal(t, "Über", fieldName("über", 5, taken))
+	assert.Equal(t, "Arg7", fieldName("arg7", 6, taken))
+	assert.Equal(t, "Arg7_", fieldName("7", 7, taken))
+}
+
+func TestNewParserFromCommandFieldNameCollision(t *testing.T) {
+	var a, b, c string
+	cmd := NewCommand("example").
+		Flag("arg1", &a).
+		Flag("1", &b).
+		Flag("arg1-", &c)
+	p, err := NewParserFromCommand(Config{}, cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--arg1", "x", "--1", "y", "--arg1-", "z"}))
+	assert.Equal(t, "x", a)
+	assert.Equal(t, "y", b)
+	assert.Equal(t, "z", c)
+}
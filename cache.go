@@ -0,0 +1,162 @@
+package arg
+
+import (
+	"reflect"
+	"sync"
+)
+
+// commandKey identifies the result of cmdFromStruct, which depends only on
+// the type of the destination struct, its position among the destination
+// structs, and whether tags are parsed strictly
+type commandKey struct {
+	t      reflect.Type
+	root   int
+	strict bool
+}
+
+// commands caches the commands constructed by cmdFromStruct, so that
+// constructing a parser for a type that has been seen before does not walk
+// the struct again. The cached commands are never given out, only copies of
+// them, because NewParser and the methods of Parser modify their specs.
+var commands = struct {
+	sync.RWMutex
+	m map[commandKey]*command
+}{m: make(map[commandKey]*command)}
+
+// cachedCmdFromStruct is like cmdFromStruct but returns a copy of the command
+// constructed for the same type before, if any. Errors are not cached.
+func cachedCmdFromStruct(name string, dest path, t reflect.Type, strict bool) (*command, error) {
+	key := commandKey{t: t, root: dest.root, strict: strict}
+	commands.RLock()
+	cmd, ok := commands.m[key]
+	commands.RUnlock()
+	if !ok {
+		var err error
+		cmd, err = cmdFromStruct(name, dest, t, strict)
+		if err != nil {
+			return nil, err
+		}
+		commands.Lock()
+		commands.m[key] = cmd
+		commands.Unlock()
+	}
+
+	c := cloneCommand(cmd)
+	c.name = name
+	return c, nil
+}
+
+// clearCommandCache discards the cached commands, which is necessary when
+// something that cmdFromStruct depends on changes, such as the parsers
+// registered with RegisterParser
+func clearCommandCache() {
+	commands.Lock()
+	defer commands.Unlock()
+	commands.m = make(map[commandKey]*command)
+}
+
+// cloneCommand returns a copy of the command, its subcommands, and their
+// specs, in which the references between specs, such as those of groups and
+// the setmode tag, are to the copies. Default values that are
+// pointers, slices, or maps are copied too, so that modifying the value of a
+// field that was set to its default does not affect other parsers.
+func cloneCommand(cmd *command) *command {
+	clones := make(map[*spec]*spec)
+	var pending []*spec
+	clone := func(s *spec) *spec {
+		if s == nil {
+			return nil
+		}
+		if c, ok := clones[s]; ok {
+			return c
+		}
+		c := *s
+		clones[s] = &c
+		pending = append(pending, &c)
+		return &c
+	}
+	cloneAll := func(specs []*spec) []*spec {
+		if specs == nil {
+			return nil
+		}
+		out := make([]*spec, len(specs))
+		for i, s := range specs {
+			out[i] = clone(s)
+		}
+		return out
+	}
+
+	var cloneCmd func(cmd, parent *command) *command
+	cloneCmd = func(cmd, parent *command) *command {
+		c := *cmd
+		c.parent = parent
+		c.specs = cloneAll(cmd.specs)
+		c.subcommands = make([]*command, len(cmd.subcommands))
+		for i, subcmd := range cmd.subcommands {
+			c.subcommands[i] = cloneCmd(subcmd, &c)
+		}
+		c.passthrough = clone(cmd.passthrough)
+		c.unknown = clone(cmd.unknown)
+		c.rest = clone(cmd.rest)
+		return &c
+	}
+	root := cloneCmd(cmd, nil)
+
+	// the references of each copy are resolved once it has been made, which
+	// may copy further specs, such as the fields of slices of structs
+	groups := make(map[*optionGroup]*optionGroup)
+	for i := 0; i < len(pending); i++ {
+		s := pending[i]
+		s.elems = cloneAll(s.elems)
+		s.templateDeps = cloneAll(s.templateDeps)
+		s.modeTarget = clone(s.modeTarget)
+		s.inheritFrom = clone(s.inheritFrom)
+		s.defaultValue = copyDefault(s.defaultValue)
+		if g := s.optionGroup; g != nil {
+			if _, ok := groups[g]; !ok {
+				groups[g] = &optionGroup{name: g.name, exclusive: g.exclusive}
+				groups[g].members = cloneAll(g.members)
+			}
+			s.optionGroup = groups[g]
+		}
+	}
+	return root
+}
+
+// copyDefault returns a copy of a default value that does not share the
+// memory to which it points, if it is a pointer, slice, or map
+func copyDefault(v reflect.Value) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(copyDefault(v.Elem()))
+		return out
+	case reflect.Slice:
+		out := reflect.New(v.Type()).Elem()
+		if !v.IsNil() {
+			out.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+			reflect.Copy(out, v)
+		}
+		return out
+	case reflect.Map:
+		out := reflect.New(v.Type()).Elem()
+		if !v.IsNil() {
+			out.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+			iter := v.MapRange()
+			for iter.Next() {
+				out.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		return out
+	default:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		return out
+	}
+}
@@ -0,0 +1,142 @@
+package arg
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cachedArgs struct {
+	Name    string `arg:"-n" default:"world" help:"who to greet"`
+	Level   string `arg:"choices:debug|info"`
+	Port    *int   `default:"80"`
+	Tags    []string
+	Verbose bool `arg:"group:output=exclusive"`
+	Quiet   bool `arg:"group:output=exclusive"`
+	Deploy  *struct {
+		Target string `arg:"positional,required"`
+		Force  bool
+	} `arg:"subcommand"`
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	var args1, args2 cachedArgs
+	p1, err := NewParser(Config{}, &args1)
+	require.NoError(t, err)
+	p2, err := NewParser(Config{AutoShortFlags: true}, &args2)
+	require.NoError(t, err)
+
+	require.NoError(t, p1.RegisterChoices("Level", []string{"warn"}))
+	require.NoError(t, p1.Parse([]string{"--level", "warn"}))
+	assert.Error(t, p2.Parse([]string{"--level", "warn"}))
+
+	info1, _ := p1.FlagByName("port")
+	info2, _ := p2.FlagByName("port")
+	assert.Equal(t, "", info1.Short)
+	assert.Equal(t, "p", info2.Short)
+}
+
+func TestCacheCopiesDefaults(t *testing.T) {
+	var args1, args2 cachedArgs
+	p1, err := NewParser(Config{}, &args1)
+	require.NoError(t, err)
+	p2, err := NewParser(Config{}, &args2)
+	require.NoError(t, err)
+
+	require.NoError(t, p1.Parse(nil))
+	*args1.Port = 8080
+
+	require.NoError(t, p2.Parse(nil))
+	assert.Equal(t, 80, *args2.Port)
+}
+
+func TestCacheKeepsReferences(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		var args cachedArgs
+		_, err := parseWithEnvErr(t, "--verbose --quiet", nil, &args)
+		assert.EqualError(t, err, "--verbose and --quiet cannot be used together")
+
+		args = cachedArgs{}
+		parse(t, "deploy prod --force", &args)
+		require.NotNil(t, args.Deploy)
+		assert.Equal(t, "prod", args.Deploy.Target)
+		assert.True(t, args.Deploy.Force)
+	}
+}
+
+func TestCacheClearedByRegisterParser(t *testing.T) {
+	var args struct {
+		Origin coord
+	}
+	registerCoord(t)
+	_, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	RegisterParser(reflect.TypeOf(coord{}), nil)
+	_, err = NewParser(Config{}, &args)
+	assert.Error(t, err)
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var args cachedArgs
+			p, err := NewParser(Config{}, &args)
+			if !assert.NoError(t, err) {
+				return
+			}
+			name := strconv.Itoa(i)
+			assert.NoError(t, p.Parse([]string{"--name", name, "--tags", name}))
+			assert.Equal(t, name, args.Name)
+			assert.Equal(t, []string{name}, args.Tags)
+
+			var help bytes.Buffer
+			p.WriteHelp(&help)
+			assert.Contains(t, help.String(), "who to greet")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkNewParser(b *testing.B) {
+	var args cachedArgs
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewParser(Config{}, &args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewParserUncached(b *testing.B) {
+	var args cachedArgs
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clearCommandCache()
+		if _, err := NewParser(Config{}, &args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var args cachedArgs
+		p, err := NewParser(Config{}, &args)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := p.Parse([]string{"--name", "bob", "--tags", "x", "y", "deploy", "prod"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package arg
+
+import "fmt"
+
+// RegisterChoices sets the values allowed for the given field in the same
+// way as the choices tag, which allows the choices to come from Go constants
+// rather than being repeated in a struct tag. The field is named as in the
+// keys of ValueSources, as in "Level" or "Deploy.Target". The choices replace
+// those of a choices tag on the field, if any, and are used for validation
+// and in help text. It returns an error if there is no such field, if the
+// field cannot have choices or has a choicesfn tag, or if the parser is
+// frozen.
+func (p *Parser) RegisterChoices(fieldPath string, choices []string) error {
+	if err := p.checkFrozen("RegisterChoices"); err != nil {
+		return err
+	}
+	spec := findSpecByName(p.cmd, fieldPath)
+	if spec == nil {
+		return fmt.Errorf("RegisterChoices: there is no field %s", fieldPath)
+	}
+	if isBoolean(spec.field.Type) || isMap(spec.field.Type) || spec.elems != nil || spec.nargs > 0 {
+		return fmt.Errorf("RegisterChoices: choices cannot be used with boolean, map, array, or struct slice fields but %s is %v",
+			fieldPath, spec.field.Type)
+	}
+	if spec.choicesFn != "" {
+		return fmt.Errorf("RegisterChoices: %s already has choices from choicesfn:%s", fieldPath, spec.choicesFn)
+	}
+	if spec.foldCase {
+		if a, b, found := foldDuplicate(choices); found {
+			return fmt.Errorf("RegisterChoices: foldcase cannot distinguish the choices %q and %q of %s", a, b, fieldPath)
+		}
+	}
+	spec.choiceList = append([]string{}, choices...)
+	return nil
+}
+
+// findSpecByName finds the spec of the given command or its subcommands whose
+// destination has the given name, as returned by path.Name
+func findSpecByName(cmd *command, name string) *spec {
+	for _, spec := range cmd.specs {
+		if spec.dest.Name() == name {
+			return spec
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		if spec := findSpecByName(subcmd, name); spec != nil {
+			return spec
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package arg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+)
+
+func TestRegisterChoices(t *testing.T) {
+	type deployCmd struct {
+		Target string `help:"where to deploy"`
+	}
+	var args struct {
+		Level  string     `arg:"choices:x|y"`
+		Deploy *deployCmd `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterChoices("Level", []string{levelDebug, levelInfo}))
+	require.NoError(t, p.RegisterChoices("Deploy.Target", []string{"prod", "staging"}))
+
+	require.NoError(t, p.Parse([]string{"--level", "info", "deploy", "--target", "prod"}))
+	assert.Equal(t, "info", args.Level)
+	assert.Equal(t, "prod", args.Deploy.Target)
+
+	p.Reset()
+	err = p.Parse([]string{"--level", "x"})
+	assert.EqualError(t, err, `error processing --level: "x" is not one of debug, info`)
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "deploy"))
+	assert.Contains(t, help.String(), "where to deploy (one of prod, staging)")
+}
+
+func TestRegisterChoicesFoldCase(t *testing.T) {
+	var args struct {
+		Level string `arg:"choices:x,foldcase"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterChoices("Level", []string{levelDebug, levelInfo}))
+	require.NoError(t, p.Parse([]string{"--level", "INFO"}))
+	assert.Equal(t, "info", args.Level)
+
+	err = p.RegisterChoices("Level", []string{"a", "A"})
+	assert.EqualError(t, err, `RegisterChoices: foldcase cannot distinguish the choices "a" and "A" of Level`)
+}
+
+func TestRegisterChoicesErrors(t *testing.T) {
+	var args struct {
+		Level   string
+		Verbose bool
+		Region  string `arg:"choicesfn:Regions"`
+		regionArgs
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	err = p.RegisterChoices("Missing", nil)
+	assert.EqualError(t, err, "RegisterChoices: there is no field Missing")
+
+	err = p.RegisterChoices("Verbose", []string{"a"})
+	assert.EqualError(t, err, "RegisterChoices: choices cannot be used with boolean, map, array, or struct slice fields but Verbose is bool")
+
+	err = p.RegisterChoices("Region", []string{"a"})
+	assert.EqualError(t, err, "RegisterChoices: Region already has choices from choicesfn:Regions")
+
+	p.Freeze()
+	err = p.RegisterChoices("Level", []string{"a"})
+	assert.True(t, errors.Is(err, ErrFrozen))
+}
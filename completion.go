@@ -0,0 +1,484 @@
+package arg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteCompletion writes a script that provides completion of options,
+// positionals, and subcommands for the given shell, which must be "bash",
+// "zsh", or "fish". The script can be sourced directly, or installed where the
+// shell looks for completions, such as _program in a directory on $fpath for
+// zsh. The help text of each option and subcommand is used as its description
+// where the shell supports descriptions, and the choices of an option, if
+// any, are offered as the candidates for its value. Other values are
+// completed as file names. Hidden options and subcommands are omitted.
+func (p *Parser) WriteCompletion(w io.Writer, shell string) error {
+	var b strings.Builder
+	switch shell {
+	case "bash":
+		p.writeBashCompletion(&b)
+	case "zsh":
+		p.writeZshCompletion(&b)
+	case "fish":
+		p.writeFishCompletion(&b)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh, or fish", shell)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeZshCompletion writes a zsh completion script with one function per
+// command, each of which calls _arguments and dispatches to the function of
+// the selected subcommand
+func (p *Parser) writeZshCompletion(b *strings.Builder) {
+	fn := "_" + shellIdentifier(p.cmd.name)
+	_, _ = fmt.Fprintf(b, "#compdef %s\n", p.cmd.name)
+	p.writeZshCommand(b, p.cmd, fn, nil)
+	_, _ = fmt.Fprintf(b, "\nif [ \"$funcstack[1]\" = %q ]; then\n", fn)
+	_, _ = fmt.Fprintf(b, "  %s \"$@\"\n", fn)
+	_, _ = fmt.Fprintf(b, "else\n")
+	_, _ = fmt.Fprintf(b, "  compdef %s %s\n", fn, p.cmd.name)
+	_, _ = fmt.Fprintf(b, "fi\n")
+}
+
+// writeZshCommand writes the completion function for the given command and
+// then for each of its subcommands. The options of ancestors are completed as
+// well, since they may be given after the subcommand, unless
+// Config.StrictSubcommands is set.
+func (p *Parser) writeZshCommand(b *strings.Builder, cmd *command, fn string, inherited []FlagInfo) {
+	flags, positionals := p.completionArgs(cmd)
+	subcommands := visibleSubcommands(cmd)
+
+	var args []string
+	for _, flag := range append(append([]FlagInfo{}, flags...), inherited...) {
+		args = append(args, zshFlagSpecs(flag)...)
+	}
+	if !cmd.nohelp {
+		args = append(args,
+			shellQuote("(- *)-h["+zshEscape("display this help and exit")+"]"),
+			shellQuote("(- *)--help["+zshEscape("display this help and exit")+"]"))
+	}
+	if findOption(cmd.specs, "version") == nil && p.versionFor(cmd) != "" {
+		args = append(args, shellQuote("(- *)--version["+zshEscape("display version and exit")+"]"))
+	}
+	for i, positional := range positionals {
+		position := fmt.Sprint(i + 1)
+		if positional.Cardinality == multiple.String() {
+			position = "*"
+		}
+		args = append(args, shellQuote(position+":"+zshMessage(positional.Placeholder)+":"+zshAction(positional)))
+	}
+	if len(subcommands) > 0 {
+		args = append(args, shellQuote("1: :->cmds"), shellQuote("*::arg:->args"))
+	}
+
+	_, _ = fmt.Fprintf(b, "\nfunction %s {\n", fn)
+	_, _ = fmt.Fprintf(b, "  local context state state_descr line\n")
+	_, _ = fmt.Fprintf(b, "  typeset -A opt_args\n")
+	_, _ = fmt.Fprintf(b, "  _arguments -C")
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(b, " \\\n    %s", arg)
+	}
+	_, _ = fmt.Fprintf(b, "\n")
+
+	if len(subcommands) > 0 {
+		_, _ = fmt.Fprintf(b, "  case $state in\n")
+		_, _ = fmt.Fprintf(b, "    cmds)\n")
+		_, _ = fmt.Fprintf(b, "      local -a commands\n")
+		_, _ = fmt.Fprintf(b, "      commands=(\n")
+		for _, subcmd := range subcommands {
+			help := subcmd.help
+			if d, ok := p.dynamicDescription(subcmd); ok {
+				help = d
+			}
+			_, _ = fmt.Fprintf(b, "        %s\n", shellQuote(strings.ReplaceAll(subcmd.name, ":", `\:`)+":"+help))
+		}
+		_, _ = fmt.Fprintf(b, "      )\n")
+		_, _ = fmt.Fprintf(b, "      _describe -t commands 'command' commands\n")
+		_, _ = fmt.Fprintf(b, "      ;;\n")
+		_, _ = fmt.Fprintf(b, "    args)\n")
+		_, _ = fmt.Fprintf(b, "      case $line[1] in\n")
+		for _, subcmd := range subcommands {
+			_, _ = fmt.Fprintf(b, "        %s)\n", shellQuote(subcmd.name))
+			_, _ = fmt.Fprintf(b, "          %s_%s\n", fn, shellIdentifier(subcmd.name))
+			_, _ = fmt.Fprintf(b, "          ;;\n")
+		}
+		_, _ = fmt.Fprintf(b, "      esac\n")
+		_, _ = fmt.Fprintf(b, "      ;;\n")
+		_, _ = fmt.Fprintf(b, "  esac\n")
+	}
+	_, _ = fmt.Fprintf(b, "}\n")
+
+	if !p.config.StrictSubcommands {
+		inherited = append(append([]FlagInfo{}, flags...), inherited...)
+	}
+	for _, subcmd := range subcommands {
+		p.writeZshCommand(b, subcmd, fn+"_"+shellIdentifier(subcmd.name), inherited)
+	}
+}
+
+// completionArgs returns the visible options and positionals of the given
+// command, as completed by the scripts of all shells
+func (p *Parser) completionArgs(cmd *command) (flags, positionals []FlagInfo) {
+	for _, spec := range cmd.specs {
+		switch {
+		case p.isHidden(spec):
+		case spec.positional:
+			positionals = append(positionals, p.flagInfo(spec))
+		case spec.long != "" || spec.short != "":
+			flags = append(flags, p.flagInfo(spec))
+		}
+	}
+	return flags, positionals
+}
+
+// completionChoices returns the candidates for the value of the given option
+// or positional, or nil if any value, such as a file name, is acceptable
+func completionChoices(flag FlagInfo) []string {
+	if len(flag.Choices) == 0 && flag.Positional && flag.Cardinality == zero.String() {
+		return []string{"true", "false"}
+	}
+	return flag.Choices
+}
+
+// zshFlagSpecs returns the _arguments specs for each form of the given option.
+// The forms exclude each other unless the option can be repeated.
+func zshFlagSpecs(flag FlagInfo) []string {
+	var forms []string
+	if flag.Short != "" {
+		forms = append(forms, "-"+flag.Short)
+	}
+	if flag.Long != "" {
+		forms = append(forms, "--"+flag.Long)
+	}
+
+	prefix := "(" + strings.Join(forms, " ") + ")"
+	if flag.Cardinality == multiple.String() {
+		prefix = "*"
+	}
+	var value string
+	if flag.Cardinality != zero.String() {
+		value = ":" + zshMessage(flag.Placeholder) + ":" + zshAction(flag)
+	}
+
+	var specs []string
+	for _, form := range forms {
+		specs = append(specs, shellQuote(prefix+form+"["+zshEscape(flag.Help)+"]"+value))
+	}
+	return specs
+}
+
+// zshAction returns the _arguments action that completes the value of the
+// given option or positional
+func zshAction(flag FlagInfo) string {
+	choices := completionChoices(flag)
+	if len(choices) == 0 {
+		return "_default"
+	}
+	escaped := make([]string, len(choices))
+	for i, choice := range choices {
+		escaped[i] = shellEscape(choice)
+	}
+	return "(" + strings.Join(escaped, " ") + ")"
+}
+
+// zshEscape escapes the characters that have a special meaning in the
+// description of an _arguments spec
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "\n", " ").Replace(s)
+}
+
+// zshMessage escapes the characters that have a special meaning in the message
+// of an _arguments spec
+func zshMessage(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`).Replace(s)
+}
+
+// shellQuote quotes s as a single word for bash or zsh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellEscape escapes the characters of s that would otherwise split it into
+// several words or be interpreted by the shell
+func shellEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if !(r == '_' || r == '-' || r == '.' || r == '/' || r == '=' || r == ',' ||
+			('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// shellIdentifier converts s into a string that can be used in the name of a
+// shell function, by replacing other characters with underscores
+func shellIdentifier(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// builtinCompletionFlags returns the builtin options of the given command,
+// which are -h and --help unless it is tagged nohelp, and --version if the
+// command has a version and no option of its own with that name
+func (p *Parser) builtinCompletionFlags(cmd *command) []FlagInfo {
+	var flags []FlagInfo
+	if !cmd.nohelp {
+		flags = append(flags, FlagInfo{Short: "h", Long: "help", Help: "display this help and exit", Cardinality: zero.String()})
+	}
+	if findOption(cmd.specs, "version") == nil && p.versionFor(cmd) != "" {
+		flags = append(flags, FlagInfo{Long: "version", Help: "display version and exit", Cardinality: zero.String()})
+	}
+	return flags
+}
+
+// writeCommandSwitch writes, for each subcommand of the given command and of
+// its subcommands, a case of a shell switch that moves from the function of
+// the parent to the function of the subcommand when its name is seen. The
+// format of each case is given by arm, which receives the pattern and the
+// function of the subcommand.
+func writeCommandSwitch(cmd *command, fn string, arm func(pattern, fn string)) {
+	for _, subcmd := range visibleSubcommands(cmd) {
+		subfn := fn + "_" + shellIdentifier(subcmd.name)
+		arm(fn+":"+subcmd.name, subfn)
+		writeCommandSwitch(subcmd, subfn, arm)
+	}
+}
+
+// writeBashCompletion writes a bash completion script with a single function
+// that finds the selected subcommand and then completes the options,
+// positionals, or subcommands of that command
+func (p *Parser) writeBashCompletion(b *strings.Builder) {
+	fn := "_" + shellIdentifier(p.cmd.name)
+	_, _ = fmt.Fprintf(b, "# bash completion for %s\n\n", p.cmd.name)
+	_, _ = fmt.Fprintf(b, "%s() {\n", fn)
+	_, _ = fmt.Fprintf(b, "  local cur prev cmd i\n")
+	_, _ = fmt.Fprintf(b, "  cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	_, _ = fmt.Fprintf(b, "  prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	_, _ = fmt.Fprintf(b, "  cmd=%s\n", fn)
+	if len(visibleSubcommands(p.cmd)) > 0 {
+		_, _ = fmt.Fprintf(b, "  for ((i = 1; i < COMP_CWORD; i++)); do\n")
+		_, _ = fmt.Fprintf(b, "    case \"$cmd:${COMP_WORDS[i]}\" in\n")
+		writeCommandSwitch(p.cmd, fn, func(pattern, subfn string) {
+			_, _ = fmt.Fprintf(b, "      %s) cmd=%s ;;\n", shellQuote(pattern), subfn)
+		})
+		_, _ = fmt.Fprintf(b, "    esac\n")
+		_, _ = fmt.Fprintf(b, "  done\n")
+	}
+	_, _ = fmt.Fprintf(b, "  case \"$cmd\" in\n")
+	p.writeBashCommand(b, p.cmd, fn, nil)
+	_, _ = fmt.Fprintf(b, "  esac\n")
+	_, _ = fmt.Fprintf(b, "}\n\n")
+	_, _ = fmt.Fprintf(b, "complete -F %s %s\n", fn, p.cmd.name)
+}
+
+// writeBashCommand writes the case that completes the given command, and then
+// the cases of its subcommands. As for zsh, the options of ancestors are
+// completed as well unless Config.StrictSubcommands is set.
+func (p *Parser) writeBashCommand(b *strings.Builder, cmd *command, fn string, inherited []FlagInfo) {
+	flags, positionals := p.completionArgs(cmd)
+	all := append(append(append([]FlagInfo{}, flags...), inherited...), p.builtinCompletionFlags(cmd)...)
+
+	_, _ = fmt.Fprintf(b, "    %s)\n", fn)
+
+	// complete the value of the option before the cursor
+	var words []string
+	var valueCases []string
+	for _, flag := range all {
+		forms := flagForms(flag)
+		words = append(words, forms...)
+		if flag.Cardinality != zero.String() {
+			valueCases = append(valueCases, fmt.Sprintf("        %s) %s; return ;;\n",
+				strings.Join(forms, "|"), bashAction(completionChoices(flag), true)))
+		}
+	}
+	if len(valueCases) > 0 {
+		_, _ = fmt.Fprintf(b, "      case \"$prev\" in\n")
+		for _, c := range valueCases {
+			_, _ = fmt.Fprint(b, c)
+		}
+		_, _ = fmt.Fprintf(b, "      esac\n")
+	}
+
+	// otherwise complete an option, a subcommand, or a positional
+	_, _ = fmt.Fprintf(b, "      if [[ \"$cur\" == -* ]]; then\n")
+	_, _ = fmt.Fprintf(b, "        %s\n", bashAction(words, false))
+	_, _ = fmt.Fprintf(b, "      else\n")
+	if subcommands := visibleSubcommands(cmd); len(subcommands) > 0 {
+		var names []string
+		for _, subcmd := range subcommands {
+			names = append(names, subcmd.name)
+		}
+		_, _ = fmt.Fprintf(b, "        %s\n", bashAction(names, false))
+	} else {
+		var choices []string
+		files := false
+		for _, positional := range positionals {
+			if c := completionChoices(positional); len(c) > 0 {
+				choices = append(choices, c...)
+			} else {
+				files = true
+			}
+		}
+		switch {
+		case len(choices) > 0 && files:
+			_, _ = fmt.Fprintf(b, "        COMPREPLY=($(compgen -W %s -- \"$cur\") $(compgen -f -- \"$cur\"))\n", shellQuote(strings.Join(escapeAll(choices), " ")))
+		case len(choices) > 0 || files:
+			_, _ = fmt.Fprintf(b, "        %s\n", bashAction(choices, files))
+		default:
+			_, _ = fmt.Fprintf(b, "        COMPREPLY=()\n")
+		}
+	}
+	_, _ = fmt.Fprintf(b, "      fi\n")
+	_, _ = fmt.Fprintf(b, "      ;;\n")
+
+	if !p.config.StrictSubcommands {
+		inherited = append(append([]FlagInfo{}, flags...), inherited...)
+	}
+	for _, subcmd := range visibleSubcommands(cmd) {
+		p.writeBashCommand(b, subcmd, fn+"_"+shellIdentifier(subcmd.name), inherited)
+	}
+}
+
+// flagForms returns the forms in which an option can be given, as in -v and
+// --verbose
+func flagForms(flag FlagInfo) []string {
+	var forms []string
+	if flag.Short != "" {
+		forms = append(forms, "-"+flag.Short)
+	}
+	if flag.Long != "" {
+		forms = append(forms, "--"+flag.Long)
+	}
+	return forms
+}
+
+// bashAction returns the bash command that completes the current word from
+// the given candidates, or as a file name if there are no candidates and
+// files is true
+func bashAction(candidates []string, files bool) string {
+	if len(candidates) == 0 && files {
+		return `COMPREPLY=($(compgen -f -- "$cur"))`
+	}
+	return fmt.Sprintf(`COMPREPLY=($(compgen -W %s -- "$cur"))`, shellQuote(strings.Join(escapeAll(candidates), " ")))
+}
+
+// escapeAll applies shellEscape to each of the given strings
+func escapeAll(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = shellEscape(s)
+	}
+	return out
+}
+
+// writeFishCompletion writes a fish completion script with a function that
+// finds the selected subcommand, which is used as the condition of the
+// completions of each command
+func (p *Parser) writeFishCompletion(b *strings.Builder) {
+	prog := p.cmd.name
+	fn := "_" + shellIdentifier(prog)
+	current := "__fish" + fn + "_command"
+	_, _ = fmt.Fprintf(b, "# fish completion for %s\n\n", prog)
+	_, _ = fmt.Fprintf(b, "function %s\n", current)
+	_, _ = fmt.Fprintf(b, "    set -l cmd %s\n", fn)
+	if len(visibleSubcommands(p.cmd)) > 0 {
+		_, _ = fmt.Fprintf(b, "    set -l words (commandline -opc)\n")
+		_, _ = fmt.Fprintf(b, "    set -e words[1]\n")
+		_, _ = fmt.Fprintf(b, "    for word in $words\n")
+		_, _ = fmt.Fprintf(b, "        switch \"$cmd:$word\"\n")
+		writeCommandSwitch(p.cmd, fn, func(pattern, subfn string) {
+			_, _ = fmt.Fprintf(b, "            case %s\n", fishQuote(pattern))
+			_, _ = fmt.Fprintf(b, "                set cmd %s\n", subfn)
+		})
+		_, _ = fmt.Fprintf(b, "        end\n")
+		_, _ = fmt.Fprintf(b, "    end\n")
+	}
+	_, _ = fmt.Fprintf(b, "    echo $cmd\n")
+	_, _ = fmt.Fprintf(b, "end\n\n")
+	_, _ = fmt.Fprintf(b, "complete -c %s -f\n", prog)
+	p.writeFishCommand(b, p.cmd, fn, current, nil)
+}
+
+// writeFishCommand writes the completions of the given command and then of
+// its subcommands. As for zsh, the options of ancestors are completed as well
+// unless Config.StrictSubcommands is set.
+func (p *Parser) writeFishCommand(b *strings.Builder, cmd *command, fn, current string, inherited []FlagInfo) {
+	flags, positionals := p.completionArgs(cmd)
+	prefix := fmt.Sprintf("complete -c %s -n %s", p.cmd.name, fishQuote("test ("+current+") = "+fn))
+
+	for _, flag := range append(append(append([]FlagInfo{}, flags...), inherited...), p.builtinCompletionFlags(cmd)...) {
+		line := prefix
+		if len(flag.Short) == 1 {
+			line += " -s " + flag.Short
+		} else if flag.Short != "" {
+			line += " -o " + flag.Short
+		}
+		if flag.Long != "" {
+			line += " -l " + flag.Long
+		}
+		if flag.Help != "" {
+			line += " -d " + fishQuote(flag.Help)
+		}
+		if flag.Cardinality != zero.String() {
+			if choices := completionChoices(flag); len(choices) > 0 {
+				line += " -x -a " + fishQuote(strings.Join(escapeAll(choices), " "))
+			} else {
+				line += " -r -F"
+			}
+		}
+		_, _ = fmt.Fprintln(b, line)
+	}
+
+	for _, subcmd := range visibleSubcommands(cmd) {
+		help := subcmd.help
+		if d, ok := p.dynamicDescription(subcmd); ok {
+			help = d
+		}
+		line := prefix + " -a " + fishQuote(shellEscape(subcmd.name))
+		if help != "" {
+			line += " -d " + fishQuote(help)
+		}
+		_, _ = fmt.Fprintln(b, line)
+	}
+
+	for _, positional := range positionals {
+		line := prefix
+		if choices := completionChoices(positional); len(choices) > 0 {
+			line += " -a " + fishQuote(strings.Join(escapeAll(choices), " "))
+		} else {
+			line += " -F"
+		}
+		if positional.Help != "" {
+			line += " -d " + fishQuote(positional.Help)
+		}
+		_, _ = fmt.Fprintln(b, line)
+	}
+
+	if !p.config.StrictSubcommands {
+		inherited = append(append([]FlagInfo{}, flags...), inherited...)
+	}
+	for _, subcmd := range visibleSubcommands(cmd) {
+		p.writeFishCommand(b, subcmd, fn+"_"+shellIdentifier(subcmd.name), current, inherited)
+	}
+}
+
+// fishQuote quotes s as a single word for fish, in which a backslash escapes
+// a quote or another backslash inside single quotes. Newlines are replaced
+// with spaces, since descriptions are on a single line.
+func fishQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", " ").Replace(s)
+	return "'" + s + "'"
+}
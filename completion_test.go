@@ -0,0 +1,285 @@
+package arg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCompletionZsh(t *testing.T) {
+	expected := `#compdef example
+
+function _example {
+  local context state state_descr line
+  typeset -A opt_args
+  _arguments -C \
+    '(-v --verbose)-v[be \[very\] verbose]' \
+    '(-v --verbose)--verbose[be \[very\] verbose]' \
+    '(--level)--level[how much to log]:LEVEL:(debug info)' \
+    '(- *)-h[display this help and exit]' \
+    '(- *)--help[display this help and exit]' \
+    '1: :->cmds' \
+    '*::arg:->args'
+  case $state in
+    cmds)
+      local -a commands
+      commands=(
+        'deploy:deploy the app'\''s code'
+        'list-all:'
+      )
+      _describe -t commands 'command' commands
+      ;;
+    args)
+      case $line[1] in
+        'deploy')
+          _example_deploy
+          ;;
+        'list-all')
+          _example_list_all
+          ;;
+      esac
+      ;;
+  esac
+}
+
+function _example_deploy {
+  local context state state_descr line
+  typeset -A opt_args
+  _arguments -C \
+    '*--tag[tags to apply]:TAG:_default' \
+    '(-v --verbose)-v[be \[very\] verbose]' \
+    '(-v --verbose)--verbose[be \[very\] verbose]' \
+    '(--level)--level[how much to log]:LEVEL:(debug info)' \
+    '(- *)-h[display this help and exit]' \
+    '(- *)--help[display this help and exit]' \
+    '1:TARGET:(prod staging)' \
+    '*:FILES:_default'
+}
+
+function _example_list_all {
+  local context state state_descr line
+  typeset -A opt_args
+  _arguments -C \
+    '(-v --verbose)-v[be \[very\] verbose]' \
+    '(-v --verbose)--verbose[be \[very\] verbose]' \
+    '(--level)--level[how much to log]:LEVEL:(debug info)' \
+    '(- *)-h[display this help and exit]' \
+    '(- *)--help[display this help and exit]'
+}
+
+if [ "$funcstack[1]" = "_example" ]; then
+  _example "$@"
+else
+  compdef _example example
+fi
+`
+	type deployCmd struct {
+		Tag    []string `arg:"--tag,separate" help:"tags to apply"`
+		Target string   `arg:"positional" help:"where to deploy"`
+		Files  []string `arg:"positional"`
+	}
+	var args struct {
+		Verbose bool       `arg:"-v" help:"be [very] verbose"`
+		Level   string     `arg:"choices:debug|info" help:"how much to log"`
+		Secret  string     `arg:"hidden"`
+		Deploy  *deployCmd `arg:"subcommand" help:"deploy the app's code"`
+		ListAll *struct{}  `arg:"subcommand:list-all"`
+		Beta    *struct{}  `arg:"subcommand,hidden"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterChoices("Deploy.Target", []string{"prod", "staging"}))
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteCompletion(&b, "zsh"))
+	assert.Equal(t, expected, b.String())
+}
+
+func TestWriteCompletionBash(t *testing.T) {
+	expected := `# bash completion for example
+
+_example() {
+  local cur prev cmd i
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  prev="${COMP_WORDS[COMP_CWORD-1]}"
+  cmd=_example
+  for ((i = 1; i < COMP_CWORD; i++)); do
+    case "$cmd:${COMP_WORDS[i]}" in
+      '_example:deploy') cmd=_example_deploy ;;
+      '_example:list-all') cmd=_example_list_all ;;
+    esac
+  done
+  case "$cmd" in
+    _example)
+      case "$prev" in
+        --level) COMPREPLY=($(compgen -W 'debug info' -- "$cur")); return ;;
+      esac
+      if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W '-v --verbose --level -h --help' -- "$cur"))
+      else
+        COMPREPLY=($(compgen -W 'deploy list-all' -- "$cur"))
+      fi
+      ;;
+    _example_deploy)
+      case "$prev" in
+        --tag) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+        --level) COMPREPLY=($(compgen -W 'debug info' -- "$cur")); return ;;
+      esac
+      if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W '--tag -v --verbose --level -h --help' -- "$cur"))
+      else
+        COMPREPLY=($(compgen -W 'prod staging' -- "$cur") $(compgen -f -- "$cur"))
+      fi
+      ;;
+    _example_list_all)
+      case "$prev" in
+        --level) COMPREPLY=($(compgen -W 'debug info' -- "$cur")); return ;;
+      esac
+      if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W '-v --verbose --level -h --help' -- "$cur"))
+      else
+        COMPREPLY=()
+      fi
+      ;;
+  esac
+}
+
+complete -F _example example
+`
+	type deployCmd struct {
+		Tag    []string `arg:"--tag,separate" help:"tags to apply"`
+		Target string   `arg:"positional" help:"where to deploy"`
+		Files  []string `arg:"positional"`
+	}
+	var args struct {
+		Verbose bool       `arg:"-v" help:"be [very] verbose"`
+		Level   string     `arg:"choices:debug|info" help:"how much to log"`
+		Secret  string     `arg:"hidden"`
+		Deploy  *deployCmd `arg:"subcommand" help:"deploy the app's code"`
+		ListAll *struct{}  `arg:"subcommand:list-all"`
+		Beta    *struct{}  `arg:"subcommand,hidden"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterChoices("Deploy.Target", []string{"prod", "staging"}))
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteCompletion(&b, "bash"))
+	assert.Equal(t, expected, b.String())
+}
+
+func TestWriteCompletionFish(t *testing.T) {
+	expected := `# fish completion for example
+
+function __fish_example_command
+    set -l cmd _example
+    set -l words (commandline -opc)
+    set -e words[1]
+    for word in $words
+        switch "$cmd:$word"
+            case '_example:deploy'
+                set cmd _example_deploy
+            case '_example:list-all'
+                set cmd _example_list_all
+        end
+    end
+    echo $cmd
+end
+
+complete -c example -f
+complete -c example -n 'test (__fish_example_command) = _example' -s v -l verbose -d 'be [very] verbose'
+complete -c example -n 'test (__fish_example_command) = _example' -l level -d 'how much to log' -x -a 'debug info'
+complete -c example -n 'test (__fish_example_command) = _example' -s h -l help -d 'display this help and exit'
+complete -c example -n 'test (__fish_example_command) = _example' -a 'deploy' -d 'deploy the app\'s code'
+complete -c example -n 'test (__fish_example_command) = _example' -a 'list-all'
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -l tag -d 'tags to apply' -r -F
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -s v -l verbose -d 'be [very] verbose'
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -l level -d 'how much to log' -x -a 'debug info'
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -s h -l help -d 'display this help and exit'
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -a 'prod staging' -d 'where to deploy'
+complete -c example -n 'test (__fish_example_command) = _example_deploy' -F
+complete -c example -n 'test (__fish_example_command) = _example_list_all' -s v -l verbose -d 'be [very] verbose'
+complete -c example -n 'test (__fish_example_command) = _example_list_all' -l level -d 'how much to log' -x -a 'debug info'
+complete -c example -n 'test (__fish_example_command) = _example_list_all' -s h -l help -d 'display this help and exit'
+`
+	type deployCmd struct {
+		Tag    []string `arg:"--tag,separate" help:"tags to apply"`
+		Target string   `arg:"positional" help:"where to deploy"`
+		Files  []string `arg:"positional"`
+	}
+	var args struct {
+		Verbose bool       `arg:"-v" help:"be [very] verbose"`
+		Level   string     `arg:"choices:debug|info" help:"how much to log"`
+		Secret  string     `arg:"hidden"`
+		Deploy  *deployCmd `arg:"subcommand" help:"deploy the app's code"`
+		ListAll *struct{}  `arg:"subcommand:list-all"`
+		Beta    *struct{}  `arg:"subcommand,hidden"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterChoices("Deploy.Target", []string{"prod", "staging"}))
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteCompletion(&b, "fish"))
+	assert.Equal(t, expected, b.String())
+}
+
+func TestWriteCompletionUnsupportedShell(t *testing.T) {
+	var args struct{}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.WriteCompletion(&bytes.Buffer{}, "tcsh")
+	assert.EqualError(t, err, `unsupported shell "tcsh", expected bash, zsh, or fish`)
+}
+
+func TestWriteCompletionStrictSubcommands(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Run     *struct {
+			Fast bool
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example", StrictSubcommands: true}, &args)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteCompletion(&b, "fish"))
+	assert.Contains(t, b.String(), "= _example_run' -l fast\n")
+	assert.NotContains(t, b.String(), "= _example_run' -l verbose")
+}
+
+func TestCompletionFlag(t *testing.T) {
+	var args struct {
+		Name string `arg:"required"`
+	}
+	var stdout bytes.Buffer
+	exitCode := -1
+	p, err := NewParser(Config{Program: "example", CompletionFlag: "completion-script", Out: &stdout, Exit: func(code int) { exitCode = code }}, &args)
+	require.NoError(t, err)
+
+	err = p.Parse([]string{"--completion-script-fish"})
+	var completion *CompletionRequestError
+	require.True(t, errors.As(err, &completion))
+	assert.Equal(t, "fish", completion.Shell)
+
+	p.Reset()
+	p.MustParse([]string{"--completion-script-bash"})
+	assert.Equal(t, 0, exitCode)
+	var expected bytes.Buffer
+	require.NoError(t, p.WriteCompletion(&expected, "bash"))
+	assert.Equal(t, expected.String(), stdout.String())
+
+	// the options are not listed in help
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.NotContains(t, help.String(), "completion-script")
+}
+
+func TestCompletionFlagDisabled(t *testing.T) {
+	var args struct{}
+	_, err := parseWithEnvErr(t, "--completion-script-bash", nil, &args)
+	assert.EqualError(t, err, "unknown argument --completion-script-bash")
+}
@@ -0,0 +1,209 @@
+package arg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// applyConfigFile reads the config file named by the option tagged
+// "conffile", if any, and sets from it each of the given specs that was not
+// already present. The keys of the file are the long names of the options of
+// the top-level command, and an object under the name of a subcommand holds
+// the options of that subcommand, which are set only if it was selected. The
+// path is taken from the command line or environment, or else from the
+// default of the option, in which case a missing file is not an error.
+func (p *Parser) applyConfigFile(specs []*spec, wasPresent map[*spec]bool) error {
+	conf := p.confSpec
+	if conf == nil {
+		return nil
+	}
+
+	var filename string
+	if wasPresent[conf] {
+		filename = p.val(conf.dest).String()
+	} else if conf.defaultValue.IsValid() && !p.config.IgnoreDefault {
+		filename = conf.defaultString
+	}
+	if filename == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) && !wasPresent[conf] {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error processing %s: %v", argName(conf), err)
+	}
+	unmarshal := p.config.ConfigUnmarshal
+	if unmarshal == nil {
+		unmarshal = json.Unmarshal
+	}
+	var values map[string]interface{}
+	if err := unmarshal(data, &values); err != nil {
+		return fmt.Errorf("error reading config file %s: %v", filename, err)
+	}
+
+	candidates := make(map[*spec]bool)
+	for _, spec := range specs {
+		if !wasPresent[spec] {
+			candidates[spec] = true
+		}
+	}
+
+	// the selected chain of subcommands, from the top-level command down
+	var chain []*command
+	for c := p.lastCmd; c != nil; c = c.parent {
+		chain = append([]*command{c}, chain...)
+	}
+	return p.applyConfigValues(filename, "", values, chain, candidates, wasPresent)
+}
+
+// applyConfigValues sets the options of chain[0] from the given values of a
+// config file, and the options of the subcommands in the rest of the chain
+// from the objects under their names. prefix is the path of these values
+// within the file for use in errors, such as "deploy." for a subcommand.
+func (p *Parser) applyConfigValues(filename, prefix string, values map[string]interface{}, chain []*command, candidates, wasPresent map[*spec]bool) error {
+	cmd := chain[0]
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		if sub := findSubcommand(cmd.subcommands, key); sub != nil {
+			section, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("error processing %s%s from config file %s: expected an object", prefix, key, filename)
+			}
+			if len(chain) > 1 && chain[1] == sub {
+				if err := p.applyConfigValues(filename, prefix+key+".", section, chain[1:], candidates, wasPresent); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		spec := findOption(cmd.specs, key)
+		if spec == nil || spec.long != key || spec.positional {
+			if p.config.IgnoreUnknownKeys {
+				continue
+			}
+			return fmt.Errorf("unknown key %q in config file %s", prefix+key, filename)
+		}
+		if !candidates[spec] || spec.conffile {
+			continue
+		}
+		if err := p.setFromConfig(spec, value); err != nil {
+			return fmt.Errorf("error processing %s%s from config file %s: %v", prefix, key, filename, err)
+		}
+		wasPresent[spec] = true
+	}
+	return nil
+}
+
+// setFromConfig sets the given spec from a value decoded from a config file.
+// Arrays set slices and arrays, objects set maps, and other values are
+// formatted as they would be given on the command line.
+func (p *Parser) setFromConfig(spec *spec, value interface{}) error {
+	var values []string
+	switch value := value.(type) {
+	case []interface{}:
+		if spec.cardinality != multiple && spec.nargs == 0 {
+			return errors.New("expected a single value but got a list")
+		}
+		for _, elem := range value {
+			s, err := configScalar(elem)
+			if err != nil {
+				return err
+			}
+			values = append(values, s)
+		}
+	case map[string]interface{}:
+		if !isMap(spec.field.Type) {
+			return errors.New("expected a single value but got an object")
+		}
+		kvsep := spec.kvsep
+		if kvsep == "" {
+			kvsep = "="
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s, err := configScalar(value[key])
+			if err != nil {
+				return err
+			}
+			values = append(values, key+kvsep+s)
+		}
+	default:
+		s, err := configScalar(value)
+		if err != nil {
+			return err
+		}
+		values = []string{s}
+	}
+
+	p.sources[spec] = SourceConfigFile
+	switch {
+	case spec.nargs > 0:
+		return setArray(p.val(spec.dest), spec.ungroupAll(values))
+	case spec.cardinality == multiple:
+		var err error
+		if spec.strict {
+			err = checkDuplicateKeys(spec.field.Type, values, spec.kvsep, make(map[interface{}]bool))
+		}
+		if err == nil {
+			err = p.checkChoices(spec, values...)
+		}
+		clear := true
+		if err == nil && p.resetToDefault(spec) {
+			clear = false
+			p.sources[spec] = SourceAppended
+		}
+		if err == nil {
+			err = setSliceOrMap(p.val(spec.dest), spec.ungroupAll(values), clear, spec.kvsep)
+		}
+		return err
+	default:
+		s := values[0]
+		var err error
+		if spec.inverted {
+			s, err = invertBool(s)
+		}
+		if err == nil {
+			s, err = p.matchChoice(spec, s)
+		}
+		if err == nil {
+			s, err = spec.readFile(s)
+		}
+		if err == nil {
+			err = spec.parse(p.val(spec.dest), s)
+		}
+		return err
+	}
+}
+
+// configScalar formats a single value decoded from a config file as it would
+// be given on the command line. Numbers are formatted without an exponent, so
+// that a JSON number such as 1e6 can be parsed into an integer.
+func configScalar(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case nil, []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("unsupported value %v", value)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
@@ -0,0 +1,230 @@
+package arg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes a config file with the given contents to a temporary
+// directory and returns its path
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	type argsType struct {
+		Config string            `arg:"--config,conffile"`
+		Host   string            `default:"localhost"`
+		Port   int               `arg:"env" default:"80"`
+		Tags   []string          `arg:"--tag"`
+		Labels map[string]string `arg:"--label"`
+		Debug  bool
+	}
+	path := writeConfigFile(t, `{"host": "example.com", "port": 8080, "tag": ["a", "b"], "label": {"env": "prod"}, "debug": true}`)
+
+	var args argsType
+	p := pparse(t, "--config "+path, &args)
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, []string{"a", "b"}, args.Tags)
+	assert.Equal(t, map[string]string{"env": "prod"}, args.Labels)
+	assert.True(t, args.Debug)
+	assert.Equal(t, SourceConfigFile, p.ValueSources()["Host"])
+	assert.Equal(t, SourceArg, p.ValueSources()["Config"])
+
+	// without a config file the defaults apply
+	args = argsType{}
+	pparse(t, "", &args)
+	assert.Equal(t, "localhost", args.Host)
+	assert.Equal(t, 80, args.Port)
+
+	// the command line and environment take precedence over the config file
+	args = argsType{}
+	p = parseWithEnv(t, "--config "+path+" --host other --tag c", []string{"PORT=9000"}, &args)
+	assert.Equal(t, "other", args.Host)
+	assert.Equal(t, 9000, args.Port)
+	assert.Equal(t, []string{"c"}, args.Tags)
+	assert.Equal(t, SourceEnv, p.ValueSources()["Port"])
+}
+
+func TestConfigFileFromEnvAndDefault(t *testing.T) {
+	path := writeConfigFile(t, `{"name": "from-file"}`)
+
+	var args struct {
+		Config string `arg:"--config,conffile,env:APP_CONFIG"`
+		Name   string
+	}
+	parseWithEnv(t, "", []string{"APP_CONFIG=" + path}, &args)
+	assert.Equal(t, "from-file", args.Name)
+
+	var missing struct {
+		Config string `arg:"conffile" default:"/nonexistent/config.json"`
+		Name   string `default:"fallback"`
+	}
+	pparse(t, "", &missing)
+	assert.Equal(t, "fallback", missing.Name)
+
+	// a missing file that was given explicitly is an error
+	_, err := parseWithEnvErr(t, "--config /nonexistent/config.json", nil, &missing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing --config: ")
+}
+
+func TestConfigFileRequired(t *testing.T) {
+	path := writeConfigFile(t, `{"token": "secret"}`)
+
+	type argsType struct {
+		Config string `arg:"conffile"`
+		Token  string `arg:"required"`
+	}
+	var args argsType
+	pparse(t, "--config "+path, &args)
+	assert.Equal(t, "secret", args.Token)
+
+	args = argsType{}
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	assert.EqualError(t, err, "--token is required")
+}
+
+func TestConfigFileSubcommand(t *testing.T) {
+	path := writeConfigFile(t, `{"verbose": true, "deploy": {"target": "prod"}, "list": {"all": true}}`)
+
+	var args struct {
+		Config  string `arg:"conffile"`
+		Verbose bool
+		Deploy  *struct {
+			Target string
+		} `arg:"subcommand"`
+		List *struct {
+			All bool
+		} `arg:"subcommand"`
+	}
+	pparse(t, "--config "+path+" deploy", &args)
+	assert.True(t, args.Verbose)
+	require.NotNil(t, args.Deploy)
+	assert.Equal(t, "prod", args.Deploy.Target)
+	assert.Nil(t, args.List)
+}
+
+func TestConfigFileUnmarshal(t *testing.T) {
+	path := writeConfigFile(t, "name = from-file\nlevel = 3\n")
+
+	var args struct {
+		Config string `arg:"conffile"`
+		Name   string
+		Level  int
+	}
+	config := Config{
+		ConfigUnmarshal: func(data []byte, v interface{}) error {
+			values := make(map[string]interface{})
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				parts := strings.SplitN(line, " = ", 2)
+				values[parts[0]] = parts[1]
+			}
+			*v.(*map[string]interface{}) = values
+			return nil
+		},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "--config "+path, nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", args.Name)
+	assert.Equal(t, 3, args.Level)
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	var args struct {
+		Config string `arg:"conffile"`
+		Port   int
+		Name   string
+	}
+
+	_, err := parseWithEnvErr(t, "--config "+writeConfigFile(t, `{"colour": "red"}`), nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown key "colour" in config file `)
+
+	_, err = parseWithConfigEnvErr(t, Config{IgnoreUnknownKeys: true}, "--config "+writeConfigFile(t, `{"colour": "red"}`), nil, &args)
+	require.NoError(t, err)
+
+	_, err = parseWithEnvErr(t, "--config "+writeConfigFile(t, `{"port": "abc"}`), nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing port from config file ")
+
+	_, err = parseWithEnvErr(t, "--config "+writeConfigFile(t, `{"name": ["a", "b"]}`), nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected a single value but got a list")
+
+	_, err = parseWithEnvErr(t, "--config "+writeConfigFile(t, `{"name": `), nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error reading config file ")
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	var notString struct {
+		Config int `arg:"conffile"`
+	}
+	_, err := NewParser(Config{}, &notString)
+	assert.EqualError(t, err, ".Config: conffile can only be used with string options")
+
+	var twice struct {
+		A string `arg:"conffile"`
+		B string `arg:"conffile"`
+	}
+	_, err = NewParser(Config{}, &twice)
+	assert.EqualError(t, err, "only one field can be tagged conffile but A and B both are")
+
+	var inSubcommand struct {
+		Run *struct {
+			Config string `arg:"conffile"`
+		} `arg:"subcommand"`
+	}
+	_, err = NewParser(Config{}, &inSubcommand)
+	assert.EqualError(t, err, "Run.Config: conffile can only be used in the top-level command")
+}
+
+func TestConfigFilePrompt(t *testing.T) {
+	var args struct {
+		Config   string `arg:"--config,conffile"`
+		User     string `arg:"required,prompt"`
+		Password string `arg:"required,prompt:secret"`
+	}
+	f := fakePrompt{answers: map[string]string{"PASSWORD: ": "hunter2"}}
+	path := writeConfigFile(t, `{"user": "alice"}`)
+	_, err := parseWithConfigEnvErr(t, Config{Prompt: f.prompt}, "--config "+path, nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", args.User)
+	assert.Equal(t, "hunter2", args.Password)
+	assert.Equal(t, []string{"PASSWORD: "}, f.asked)
+}
+
+func TestConfigFileReloadEnv(t *testing.T) {
+	var args struct {
+		Config string `arg:"--config,conffile"`
+		Host   string `default:"localhost"`
+		Port   int    `arg:"env" default:"80"`
+	}
+	path := writeConfigFile(t, `{"host": "example.com", "port": 8080}`)
+	p := parseWithEnv(t, "--config "+path, []string{"PORT=9000"}, &args)
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, 9000, args.Port)
+
+	// values from the config file are kept, and apply once the environment
+	// no longer overrides them
+	os.Unsetenv("PORT")
+	require.NoError(t, p.ReloadEnv())
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, SourceConfigFile, p.ValueSources()["Port"])
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"host": "other.com"}`), 0600))
+	require.NoError(t, p.ReloadEnv())
+	assert.Equal(t, "other.com", args.Host)
+	assert.Equal(t, 80, args.Port)
+}
@@ -0,0 +1,300 @@
+package arg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// docEntry is an argument as it is listed by WriteManPage and WriteMarkdown:
+// the ways in which it is given, and its help text with its default value and
+// environment variable, as in help text
+type docEntry struct {
+	term string
+	help string
+}
+
+// docCommand holds what WriteManPage and WriteMarkdown document for a command
+type docCommand struct {
+	name        string // the names of the command and its ancestors, as in "example deploy"
+	synopsis    string // the usage string after the names of the commands
+	description string
+	positionals []docEntry
+	options     []docEntry
+	sections    []docSection // options with a section struct tag, which are not in options
+	envOnly     []docEntry
+	subcommands []docEntry
+}
+
+// docSection is a heading given by the section struct tag and the options
+// that are listed under it
+type docSection struct {
+	title   string
+	entries []docEntry
+}
+
+// docCommands returns the top-level command followed by each subcommand that
+// is not hidden, depth first and in declaration order
+func (p *Parser) docCommands() []docCommand {
+	var docs []docCommand
+	var visit func(cmd *command)
+	visit = func(cmd *command) {
+		docs = append(docs, p.docCommand(cmd))
+		for _, subcmd := range visibleSubcommands(cmd) {
+			visit(subcmd)
+		}
+	}
+	visit(p.cmd)
+	return docs
+}
+
+// docCommand collects the arguments of the given command that are not hidden,
+// as they are listed in help text. Options of the ancestors of a subcommand
+// are documented with the ancestors rather than with the subcommand.
+func (p *Parser) docCommand(cmd *command) docCommand {
+	var names []string
+	for c := cmd; c != nil; c = c.parent {
+		names = append([]string{c.name}, names...)
+	}
+	doc := docCommand{name: strings.Join(names, " ")}
+
+	var synopsis strings.Builder
+	p.writeCommandSynopsis(&synopsis, cmd)
+	doc.synopsis = strings.TrimPrefix(synopsis.String(), " "+doc.name)
+
+	doc.description = cmd.help
+	if cmd.parent == nil {
+		doc.description = p.description
+	}
+	if d, ok := p.dynamicDescription(cmd); ok {
+		doc.description = d
+	}
+
+	// options are listed with the short-only ones first, as in help text
+	var shortOptions, longOptions, sectioned []*spec
+	hasVersionOption := false
+	for c := cmd; c != nil; c = c.parent {
+		hasVersionOption = hasVersionOption || findOption(c.specs, "version") != nil
+	}
+	for _, spec := range cmd.specs {
+		switch {
+		case p.isHidden(spec):
+		case spec.section != "" && (spec.long != "" || spec.short != ""):
+			sectioned = append(sectioned, spec)
+		case spec.positional:
+			doc.positionals = append(doc.positionals, docEntry{
+				term: spec.placeholder + p.requiredMarker(spec),
+				help: p.withChoices(spec, spec.help) + annotation(spec.defaultString, ""),
+			})
+		case spec.long != "":
+			longOptions = append(longOptions, spec)
+		case spec.short != "":
+			shortOptions = append(shortOptions, spec)
+		default:
+			env := p.helpEnv(spec)
+			if env == "" {
+				env = p.envName(spec)
+			}
+			help := "Optional."
+			if spec.required {
+				help = "Required."
+			}
+			if spec.help != "" {
+				help += " " + spec.help
+			}
+			doc.envOnly = append(doc.envOnly, docEntry{term: env, help: help + annotation(spec.defaultString, "")})
+		}
+	}
+	options := append(append(shortOptions, longOptions...), p.builtinOptions(cmd, hasVersionOption)...)
+	for _, spec := range options {
+		doc.options = append(doc.options, p.optionEntry(spec))
+	}
+	for _, title := range sectionTitles(sectioned) {
+		section := docSection{title: title}
+		for _, spec := range sectioned {
+			if spec.section == title {
+				section.entries = append(section.entries, p.optionEntry(spec))
+			}
+		}
+		doc.sections = append(doc.sections, section)
+	}
+
+	for _, subcmd := range visibleSubcommands(cmd) {
+		help := subcmd.help
+		if d, ok := p.dynamicDescription(subcmd); ok {
+			help = d
+		}
+		doc.subcommands = append(doc.subcommands, docEntry{term: subcmd.name, help: help})
+	}
+	return doc
+}
+
+// optionEntry returns the entry for an option, as it is listed in help text
+func (p *Parser) optionEntry(spec *spec) docEntry {
+	env := ""
+	if !p.config.HideEnvInHelp {
+		env = p.helpEnv(spec)
+	}
+	return docEntry{
+		term: strings.Join(optionForms(spec), ", ") + p.requiredMarker(spec),
+		help: p.optionHelp(spec) + annotation(spec.defaultString, env),
+	}
+}
+
+// WriteMarkdown writes reference documentation for the program in Markdown,
+// with a section for the top-level command followed by one for each
+// subcommand that is not hidden. Each section has the description and usage
+// string of the command and lists its arguments, with their help text,
+// default values, and environment variables, as in help text.
+func (p *Parser) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	for i, doc := range p.docCommands() {
+		heading := "#"
+		if i > 0 {
+			heading = "##"
+			_, _ = fmt.Fprint(&b, "\n")
+		}
+		_, _ = fmt.Fprintf(&b, "%s %s\n", heading, doc.name)
+		if doc.description != "" {
+			_, _ = fmt.Fprintf(&b, "\n%s\n", doc.description)
+		}
+		_, _ = fmt.Fprintf(&b, "\n```\n%s%s\n```\n", doc.name, doc.synopsis)
+
+		writeList := func(label, fallback string, entries []docEntry) {
+			if len(entries) == 0 {
+				return
+			}
+			_, _ = fmt.Fprintf(&b, "\n%s# %s\n\n", heading, labelOr(label, fallback))
+			for _, entry := range entries {
+				_, _ = fmt.Fprintf(&b, "- `%s`", entry.term)
+				if entry.help != "" {
+					_, _ = fmt.Fprintf(&b, ": %s", strings.TrimSpace(entry.help))
+				}
+				_, _ = fmt.Fprint(&b, "\n")
+			}
+		}
+		writeList(p.config.Labels.Positional, "Positional arguments", doc.positionals)
+		writeList(p.config.Labels.Options, "Options", doc.options)
+		for _, section := range doc.sections {
+			writeList(section.title, "", section.entries)
+		}
+		writeList(p.config.Labels.Env, "Environment variables", doc.envOnly)
+		writeList(p.config.Labels.Commands, "Commands", doc.subcommands)
+
+		if i == 0 && p.epilogue != "" {
+			_, _ = fmt.Fprintf(&b, "\n%s\n", p.epilogue)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteManPage writes reference documentation for the program as a manual
+// page in roff format, for section 1 of the manual. It documents the same
+// commands and arguments as WriteMarkdown, with the subcommands in a COMMANDS
+// section, and is typically generated at build time and installed with
+// the program.
+func (p *Parser) WriteManPage(w io.Writer) error {
+	docs := p.docCommands()
+	top := docs[0]
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, ".TH %s 1 \"\" %s\n", roffQuote(strings.ToUpper(top.name)), roffQuote(p.version))
+	_, _ = fmt.Fprint(&b, ".SH NAME\n")
+	_, _ = fmt.Fprint(&b, roffEscape(top.name))
+	if top.description != "" {
+		_, _ = fmt.Fprint(&b, ` \- `+roffEscape(firstLine(top.description)))
+	}
+	_, _ = fmt.Fprint(&b, "\n.SH SYNOPSIS\n")
+	_, _ = fmt.Fprintf(&b, "\\fB%s\\fR%s\n", roffEscape(top.name), roffEscape(top.synopsis))
+	if top.description != "" || p.epilogue != "" {
+		_, _ = fmt.Fprint(&b, ".SH DESCRIPTION\n")
+		if top.description != "" {
+			_, _ = fmt.Fprint(&b, roffEscape(top.description)+"\n")
+		}
+		if p.epilogue != "" {
+			_, _ = fmt.Fprint(&b, ".PP\n"+roffEscape(p.epilogue)+"\n")
+		}
+	}
+
+	writeEntries := func(entries []docEntry) {
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(&b, ".TP\n\\fB%s\\fR\n", roffEscape(entry.term))
+			if help := strings.TrimSpace(entry.help); help != "" {
+				_, _ = fmt.Fprint(&b, roffEscape(help)+"\n")
+			}
+		}
+	}
+	writeSection := func(title string, entries []docEntry) {
+		if len(entries) > 0 {
+			_, _ = fmt.Fprint(&b, ".SH "+title+"\n")
+			writeEntries(entries)
+		}
+	}
+	writeSection("ARGUMENTS", top.positionals)
+	writeSection("OPTIONS", top.options)
+	for _, section := range top.sections {
+		writeSection(roffQuote(strings.ToUpper(section.title)), section.entries)
+	}
+	writeSection("ENVIRONMENT", top.envOnly)
+
+	if len(docs) > 1 {
+		_, _ = fmt.Fprint(&b, ".SH COMMANDS\n")
+	}
+	for _, doc := range docs[1:] {
+		_, _ = fmt.Fprintf(&b, ".SS %s\n", roffQuote(doc.name))
+		if doc.description != "" {
+			_, _ = fmt.Fprint(&b, roffEscape(doc.description)+"\n.PP\n")
+		}
+		_, _ = fmt.Fprintf(&b, "\\fB%s\\fR%s\n", roffEscape(doc.name), roffEscape(doc.synopsis))
+		writeEntries(doc.positionals)
+		writeEntries(doc.options)
+		for _, section := range doc.sections {
+			writeEntries(section.entries)
+		}
+		writeEntries(doc.envOnly)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sectionTitles returns the distinct section tags of the given options, in the
+// order in which they first appear
+func sectionTitles(specs []*spec) []string {
+	var titles []string
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if !seen[spec.section] {
+			seen[spec.section] = true
+			titles = append(titles, spec.section)
+		}
+	}
+	return titles
+}
+
+// roffEscape escapes text for use in a roff document, so that backslashes and
+// hyphens are printed as they are and lines are not taken for requests
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// roffQuote escapes text for use as a quoted argument of a roff request
+func roffQuote(s string) string {
+	return `"` + strings.ReplaceAll(roffEscape(s), `"`, `\(dq`) + `"`
+}
+
+// firstLine returns the text up to the first newline
+func firstLine(s string) string {
+	if pos := strings.Index(s, "\n"); pos != -1 {
+		return s[:pos]
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package arg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type docsArgs struct {
+	Name    string `arg:"-n,env" default:"world" help:"who to greet"`
+	Verbose bool   `arg:"-v" help:"print more"`
+	Secret  string `arg:"hidden"`
+	Token   string `arg:"--,env:API_TOKEN" help:"token for the API"`
+	Deploy  *struct {
+		Target string `help:"where to deploy"`
+		App    string `arg:"positional" help:"the app"`
+	} `arg:"subcommand" help:"deploy the app"`
+	Debug *struct{} `arg:"subcommand,hidden"`
+}
+
+func (docsArgs) Version() string     { return "example 1.0" }
+func (docsArgs) Description() string { return "greets people" }
+func (docsArgs) Epilogue() string    { return "see https://example.com" }
+
+func TestWriteMarkdown(t *testing.T) {
+	expected := "# example\n" +
+		"\n" +
+		"greets people\n" +
+		"\n" +
+		"```\n" +
+		"example [--name NAME] [--verbose] <command> [<args>]\n" +
+		"```\n" +
+		"\n" +
+		"## Options\n" +
+		"\n" +
+		"- `--name NAME, -n NAME`: who to greet [default: world, env: NAME]\n" +
+		"- `--verbose, -v`: print more\n" +
+		"- `--help, -h`: display this help and exit\n" +
+		"- `--version`: display version and exit\n" +
+		"\n" +
+		"## Environment variables\n" +
+		"\n" +
+		"- `API_TOKEN`: Optional. token for the API\n" +
+		"\n" +
+		"## Commands\n" +
+		"\n" +
+		"- `deploy`: deploy the app\n" +
+		"\n" +
+		"see https://example.com\n" +
+		"\n" +
+		"## example deploy\n" +
+		"\n" +
+		"deploy the app\n" +
+		"\n" +
+		"```\n" +
+		"example deploy [--target TARGET] [APP]\n" +
+		"```\n" +
+		"\n" +
+		"### Positional arguments\n" +
+		"\n" +
+		"- `APP`: the app\n" +
+		"\n" +
+		"### Options\n" +
+		"\n" +
+		"- `--target TARGET`: where to deploy\n" +
+		"- `--help, -h`: display this help and exit\n" +
+		"- `--version`: display version and exit\n"
+
+	var args docsArgs
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteMarkdown(&b))
+	assert.Equal(t, expected, b.String())
+}
+
+func TestWriteManPage(t *testing.T) {
+	expected := `.TH "EXAMPLE" 1 "" "example 1.0"
+.SH NAME
+example \- greets people
+.SH SYNOPSIS
+\fBexample\fR [\-\-name NAME] [\-\-verbose] <command> [<args>]
+.SH DESCRIPTION
+greets people
+.PP
+see https://example.com
+.SH OPTIONS
+.TP
+\fB\-\-name NAME, \-n NAME\fR
+who to greet [default: world, env: NAME]
+.TP
+\fB\-\-verbose, \-v\fR
+print more
+.TP
+\fB\-\-help, \-h\fR
+display this help and exit
+.TP
+\fB\-\-version\fR
+display version and exit
+.SH ENVIRONMENT
+.TP
+\fBAPI_TOKEN\fR
+Optional. token for the API
+.SH COMMANDS
+.SS "example deploy"
+deploy the app
+.PP
+\fBexample deploy\fR [\-\-target TARGET] [APP]
+.TP
+\fBAPP\fR
+the app
+.TP
+\fB\-\-target TARGET\fR
+where to deploy
+.TP
+\fB\-\-help, \-h\fR
+display this help and exit
+.TP
+\fB\-\-version\fR
+display version and exit
+`
+
+	var args docsArgs
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteManPage(&b))
+	assert.Equal(t, expected, b.String())
+}
+
+func TestWriteManPageHideEnv(t *testing.T) {
+	var args struct {
+		Name string `arg:"env" help:"who to greet"`
+	}
+	p, err := NewParser(Config{Program: "example", HideEnvInHelp: true}, &args)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteManPage(&b))
+	assert.Contains(t, b.String(), "\\fB\\-\\-name NAME\\fR\nwho to greet\n")
+}
+
+func TestWriteMarkdownSections(t *testing.T) {
+	var args struct {
+		Verbose bool `help:"print more"`
+		Port    int  `help:"the port" section:"Networking"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, p.WriteMarkdown(&b))
+	assert.Contains(t, b.String(), "## Options\n\n- `--verbose`: print more\n- `--help, -h`: display this help and exit\n\n## Networking\n\n- `--port PORT`: the port\n")
+
+	b.Reset()
+	require.NoError(t, p.WriteManPage(&b))
+	assert.Contains(t, b.String(), ".SH \"NETWORKING\"\n.TP\n\\fB\\-\\-port PORT\\fR\nthe port\n")
+}
+
+func TestRoffEscape(t *testing.T) {
+	assert.Equal(t, `a\-b`, roffEscape("a-b"))
+	assert.Equal(t, `C:\eTemp`, roffEscape(`C:\Temp`))
+	assert.Equal(t, "first\n\\&.second\n\\&'third", roffEscape("first\n.second\n'third"))
+	assert.Equal(t, `"say \(dqhi\(dq"`, roffQuote(`say "hi"`))
+}
@@ -0,0 +1,164 @@
+// Package gen generates code for programs that use github.com/alexflint/go-arg.
+// It is kept apart from that package so that programs that parse their
+// arguments do not depend on the packages that read Go source.
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenerateHelp writes the source of a Go file that makes each of the named
+// struct types in the package in dir implement arg.HelpProvider, so that the
+// doc comments of their fields are used as help text. The types of embedded
+// structs and of subcommands declared in the same package are included as
+// well. Fields that have a help tag, that are excluded with arg:"-", or that
+// have no doc comment are left out. It is meant to be called from a program
+// run by a go:generate directive, as in
+//
+//	//go:generate go run ./cmd/genhelp
+//
+// where cmd/genhelp writes the output of gen.GenerateHelp(f, ".", "Args") to
+// a file in the package, such as args_help.go.
+func GenerateHelp(w io.Writer, dir string, typeNames ...string) error {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return fmt.Errorf("GenerateHelp: %v", err)
+	}
+
+	// collect the struct types declared in the package
+	structs := make(map[string]*ast.StructType)
+	fset := token.NewFileSet()
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("GenerateHelp: %v", err)
+		}
+		for _, decl := range f.Decls {
+			if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.TypeSpec)
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						structs[spec.Name.Name] = st
+					}
+				}
+			}
+		}
+	}
+
+	// visit the named types and then the types of their embedded structs and
+	// subcommands, in the order in which they are found
+	var b bytes.Buffer
+	_, _ = fmt.Fprintf(&b, "// Code generated by gen.GenerateHelp; DO NOT EDIT.\n\npackage %s\n", pkg.Name)
+	queue := append([]string{}, typeNames...)
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		st, ok := structs[name]
+		if !ok {
+			return fmt.Errorf("GenerateHelp: there is no struct type %s in %s", name, dir)
+		}
+
+		help := make(map[string]string)
+		for _, field := range st.Fields.List {
+			var tag reflect.StructTag
+			if field.Tag != nil {
+				s, err := strconv.Unquote(field.Tag.Value)
+				if err != nil {
+					return fmt.Errorf("GenerateHelp: %s: %v", fset.Position(field.Tag.Pos()), err)
+				}
+				tag = reflect.StructTag(s)
+			}
+			if tag.Get("arg") == "-" {
+				continue
+			}
+			if typeName, ok := localTypeName(field.Type); ok && structs[typeName] != nil {
+				if len(field.Names) == 0 || isSubcommandTag(tag) {
+					queue = append(queue, typeName)
+				}
+			}
+			if _, hasHelp := tag.Lookup("help"); hasHelp {
+				continue
+			}
+			text := field.Doc.Text()
+			if text == "" {
+				text = field.Comment.Text()
+			}
+			text = strings.Join(strings.Fields(text), " ")
+			if text == "" {
+				continue
+			}
+			for _, ident := range field.Names {
+				if ident.IsExported() {
+					help[ident.Name] = text
+				}
+			}
+		}
+		if len(help) == 0 {
+			continue
+		}
+
+		fields := make([]string, 0, len(help))
+		for field := range help {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		_, _ = fmt.Fprintf(&b, "\n// FieldHelp implements arg.HelpProvider\n")
+		_, _ = fmt.Fprintf(&b, "func (%s) FieldHelp() map[string]string {\n", name)
+		_, _ = fmt.Fprintf(&b, "return map[string]string{\n")
+		for _, field := range fields {
+			_, _ = fmt.Fprintf(&b, "%q: %q,\n", field, help[field])
+		}
+		_, _ = fmt.Fprintf(&b, "}\n}\n")
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("GenerateHelp: error formatting generated code: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// localTypeName returns the name of the type of a field if it is named by an
+// identifier without a package, or is a pointer to such a type
+func localTypeName(expr ast.Expr) (string, bool) {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	return ident.Name, true
+}
+
+// isSubcommandTag returns true if the arg tag of a field marks it as a
+// subcommand
+func isSubcommandTag(tag reflect.StructTag) bool {
+	for _, key := range strings.Split(tag.Get("arg"), ",") {
+		key = strings.TrimLeft(key, " ")
+		if key == "subcommand" || strings.HasPrefix(key, "subcommand:") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package gen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const generateHelpSource = `package main
+
+type Args struct {
+	Common
+
+	// Verbose turns on
+	// verbose output
+	Verbose bool
+	Level   int    // the log level
+	Name    string ` + "`help:\"from the tag\"`" + `
+	Skip    string ` + "`arg:\"-\"`" + `
+	NoDoc   string
+	private string // not an option
+
+	// deploy to a server
+	Deploy *DeployCmd ` + "`arg:\"subcommand:deploy\"`" + `
+}
+
+type Common struct {
+	// the configuration file
+	Config string
+}
+
+type DeployCmd struct {
+	// the server to deploy to
+	Target string ` + "`arg:\"positional\"`" + `
+}
+
+type Unrelated struct {
+	// not reachable from Args
+	Field string
+}
+`
+
+const generateHelpExpected = `// Code generated by gen.GenerateHelp; DO NOT EDIT.
+
+package main
+
+// FieldHelp implements arg.HelpProvider
+func (Args) FieldHelp() map[string]string {
+	return map[string]string{
+		"Deploy":  "deploy to a server",
+		"Level":   "the log level",
+		"Verbose": "Verbose turns on verbose output",
+	}
+}
+
+// FieldHelp implements arg.HelpProvider
+func (Common) FieldHelp() map[string]string {
+	return map[string]string{
+		"Config": "the configuration file",
+	}
+}
+
+// FieldHelp implements arg.HelpProvider
+func (DeployCmd) FieldHelp() map[string]string {
+	return map[string]string{
+		"Target": "the server to deploy to",
+	}
+}
+`
+
+func TestGenerateHelp(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(generateHelpSource), 0644))
+
+	var b bytes.Buffer
+	require.NoError(t, GenerateHelp(&b, dir, "Args"))
+	assert.Equal(t, generateHelpExpected, b.String())
+}
+
+func TestGenerateHelpUnknownType(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(generateHelpSource), 0644))
+
+	var b bytes.Buffer
+	err := GenerateHelp(&b, dir, "Missing")
+	assert.EqualError(t, err, "GenerateHelp: there is no struct type Missing in "+dir)
+}
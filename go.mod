@@ -5,10 +5,12 @@ go 1.18
 require (
 	github.com/alexflint/go-scalar v1.2.0
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/term v0.15.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
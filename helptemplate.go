@@ -0,0 +1,70 @@
+package arg
+
+import "strings"
+
+// HelpData is the data with which Config.HelpTemplate is executed
+type HelpData struct {
+	Program     string        // the name of the program
+	Command     string        // the names of the command and its ancestors, as in "example deploy"
+	Usage       string        // the usage string, as in "example deploy [--force] APP", without a label
+	Description string        // the description of the command, if any
+	Sections    []HelpSection // the arguments and subcommands of the command, under their headings
+	Epilogue    string        // the epilogue of the program, if any
+}
+
+// HelpSection is a heading in help text and the entries listed under it
+type HelpSection struct {
+	Title   string // the heading, as in "Options", without the trailing colon
+	Entries []HelpEntry
+}
+
+// HelpEntry is an argument or subcommand listed in help text
+type HelpEntry struct {
+	Name string // the ways in which the argument is given, as in "--name NAME, -n NAME", or the name of the subcommand
+	Help string // the help text, with the default value and environment variable, if any
+}
+
+// helpData collects the data for Config.HelpTemplate from the arguments of
+// the given command that are not hidden, with the same headings and in the
+// same order as help text. Options of the ancestors of a subcommand are
+// listed as global options.
+func (p *Parser) helpData(cmd *command) *HelpData {
+	doc := p.docCommand(cmd)
+	data := HelpData{
+		Program:     p.cmd.name,
+		Command:     doc.name,
+		Usage:       doc.name + doc.synopsis,
+		Description: doc.description,
+		Epilogue:    p.epilogue,
+	}
+
+	addSection := func(title string, entries []docEntry) {
+		if len(entries) == 0 {
+			return
+		}
+		section := HelpSection{Title: title}
+		for _, entry := range entries {
+			section.Entries = append(section.Entries, HelpEntry{Name: entry.term, Help: strings.TrimSpace(entry.help)})
+		}
+		data.Sections = append(data.Sections, section)
+	}
+
+	var globals []docEntry
+	for ancestor := cmd.parent; ancestor != nil; ancestor = ancestor.parent {
+		for _, spec := range ancestor.specs {
+			if !p.isHidden(spec) && !spec.positional && (spec.long != "" || spec.short != "") {
+				globals = append(globals, p.optionEntry(spec))
+			}
+		}
+	}
+
+	addSection(labelOr(p.config.Labels.Positional, "Positional arguments"), doc.positionals)
+	addSection(labelOr(p.config.Labels.Options, "Options"), doc.options)
+	addSection(labelOr(p.config.Labels.GlobalOptions, "Global options"), globals)
+	for _, section := range doc.sections {
+		addSection(section.title, section.entries)
+	}
+	addSection(labelOr(p.config.Labels.Env, "Environment variables"), doc.envOnly)
+	addSection(labelOr(p.config.Labels.Commands, "Commands"), doc.subcommands)
+	return &data
+}
@@ -0,0 +1,120 @@
+package arg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHelpTemplate = `{{.Usage}}
+{{range .Sections}}
+== {{.Title}} ==
+{{range .Entries}}{{.Name}}{{with .Help}}: {{.}}{{end}}
+{{end}}{{end}}`
+
+func TestHelpTemplate(t *testing.T) {
+	expected := `example [--name NAME] [--port PORT] [--verbose] <command> [<args>]
+
+== Options ==
+--name NAME: who to greet [default: world]
+--verbose: print more
+--help, -h: display this help and exit
+
+== Networking ==
+--port PORT: the port to listen on [env: PORT]
+
+== Commands ==
+deploy: deploy the app
+`
+	var args struct {
+		Name    string `default:"world" help:"who to greet"`
+		Port    int    `arg:"env" help:"the port to listen on" section:"Networking"`
+		Verbose bool   `help:"print more"`
+		Deploy  *struct {
+			Force bool
+		} `arg:"subcommand" help:"deploy the app"`
+	}
+	p, err := NewParser(Config{Program: "example", HelpTemplate: testHelpTemplate}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expected, help.String())
+}
+
+func TestHelpTemplateSubcommand(t *testing.T) {
+	expected := `example deploy [--force]
+
+== Options ==
+--force
+--help, -h: display this help and exit
+
+== Global options ==
+--verbose: print more
+`
+	var args struct {
+		Verbose bool `help:"print more"`
+		Deploy  *struct {
+			Force bool
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example", HelpTemplate: testHelpTemplate}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "deploy"))
+	assert.Equal(t, expected, help.String())
+
+	var all bytes.Buffer
+	p.WriteHelpAll(&all)
+	assert.Contains(t, all.String(), "Usage: example [--verbose] <command> [<args>]")
+}
+
+func TestHelpTemplateData(t *testing.T) {
+	var args struct {
+		Src string `arg:"positional" help:"the source"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	data := p.helpData(p.cmd)
+	assert.Equal(t, "example", data.Program)
+	assert.Equal(t, "example", data.Command)
+	assert.Equal(t, "example [SRC]", data.Usage)
+	require.Len(t, data.Sections, 2)
+	assert.Equal(t, HelpSection{Title: "Positional arguments", Entries: []HelpEntry{{Name: "SRC", Help: "the source"}}}, data.Sections[0])
+	assert.Equal(t, "Options", data.Sections[1].Title)
+}
+
+func TestHelpTemplateErrors(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	_, err := NewParser(Config{HelpTemplate: "{{.Usage"}, &args)
+	assert.ErrorContains(t, err, "error in HelpTemplate: ")
+
+	_, err = NewParser(Config{HelpTemplate: "{{.NoSuchField}}"}, &args)
+	assert.ErrorContains(t, err, "error in HelpTemplate: ")
+}
+
+func TestHelpTemplateSubcommandError(t *testing.T) {
+	var args struct {
+		Name  string
+		Serve *struct {
+			Port int
+		} `arg:"subcommand"`
+	}
+	// the template fails for every command other than the top-level one
+	config := Config{Program: "example", HelpTemplate: `{{if eq .Command "example"}}{{.Usage}}{{else}}{{template "missing"}}{{end}}`}
+	p, err := NewParser(config, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	err = p.WriteHelpForSubcommand(&help, "serve")
+	assert.ErrorContains(t, err, "error in HelpTemplate: ")
+	assert.Contains(t, help.String(), "--port PORT")
+	require.Len(t, p.Warnings(), 1)
+	assert.Equal(t, err.Error(), p.Warnings()[0])
+}
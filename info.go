@@ -0,0 +1,187 @@
+package arg
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// helpSchemaVersion is the version of the JSON document written by
+// WriteHelpJSON. It is incremented whenever the schema changes in a way that
+// is not backwards compatible.
+const helpSchemaVersion = 1
+
+// FlagInfo describes an option or positional argument
+type FlagInfo struct {
+	Field       string     `json:"field"`                 // path of the struct field, as in "Deploy.Target"
+	Long        string     `json:"long,omitempty"`        // the --long form, without hyphens
+	Short       string     `json:"short,omitempty"`       // the -s short form, without hyphen
+	Placeholder string     `json:"placeholder,omitempty"` // the name of the value in help text
+	Help        string     `json:"help,omitempty"`        // the help text
+	Env         string     `json:"env,omitempty"`         // the environment variable, if any
+	Default     string     `json:"default,omitempty"`     // the default value, as displayed in help text
+	Type        string     `json:"type"`                  // the Go type of the field
+	Cardinality string     `json:"cardinality"`           // one of "zero", "one", or "multiple"
+	Required    bool       `json:"required,omitempty"`    // whether the argument is required
+	Positional  bool       `json:"positional,omitempty"`  // whether this is a positional argument
+	Hidden      bool       `json:"hidden,omitempty"`      // whether the argument is omitted from help text
+	Choices     []string   `json:"choices,omitempty"`     // the allowed values, from a choices or choicesfn tag or RegisterChoices
+	Fields      []FlagInfo `json:"fields,omitempty"`      // for slices of structs, the fields of each element
+}
+
+// CommandInfo describes the top-level command or a subcommand
+type CommandInfo struct {
+	Name        string        `json:"name"`
+	Help        string        `json:"help,omitempty"`
+	Hidden      bool          `json:"hidden,omitempty"`
+	Flags       []FlagInfo    `json:"flags"`
+	Positionals []FlagInfo    `json:"positionals"`
+	Subcommands []CommandInfo `json:"subcommands"`
+}
+
+// helpJSON is the document written by WriteHelpJSON
+type helpJSON struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Program       string `json:"program"`
+	Version       string `json:"version,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Epilogue      string `json:"epilogue,omitempty"`
+	CommandInfo
+}
+
+// WriteHelpJSON writes a description of all options, positionals, and
+// subcommands of the program as a JSON document, for use by external tools.
+// The document has a top-level "schemaVersion" field that is incremented
+// whenever the schema changes in a backwards incompatible way. The builtin
+// --help and --version options are not included.
+func (p *Parser) WriteHelpJSON(w io.Writer) error {
+	doc := helpJSON{
+		SchemaVersion: helpSchemaVersion,
+		Program:       p.cmd.name,
+		Version:       p.version,
+		Description:   p.description,
+		Epilogue:      p.epilogue,
+		CommandInfo:   p.commandInfo(p.cmd),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// commandInfo describes the given command and its subcommands
+func (p *Parser) commandInfo(cmd *command) CommandInfo {
+	info := CommandInfo{
+		Name:        cmd.name,
+		Help:        cmd.help,
+		Hidden:      cmd.hidden,
+		Flags:       []FlagInfo{},
+		Positionals: []FlagInfo{},
+		Subcommands: []CommandInfo{},
+	}
+	for _, spec := range cmd.specs {
+		if spec.positional {
+			info.Positionals = append(info.Positionals, p.flagInfo(spec))
+		} else {
+			info.Flags = append(info.Flags, p.flagInfo(spec))
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		info.Subcommands = append(info.Subcommands, p.commandInfo(subcmd))
+	}
+	return info
+}
+
+// flagInfo describes the given spec
+func (p *Parser) flagInfo(spec *spec) FlagInfo {
+	info := FlagInfo{
+		Field:       spec.dest.Name(),
+		Long:        spec.long,
+		Short:       spec.short,
+		Placeholder: spec.placeholder,
+		Help:        spec.help,
+		Env:         spec.env,
+		Default:     spec.defaultString,
+		Type:        spec.field.Type.String(),
+		Cardinality: spec.cardinality.String(),
+		Required:    spec.required,
+		Positional:  spec.positional,
+		Hidden:      spec.hidden,
+		Choices:     p.choices(spec),
+	}
+	if spec.positional {
+		// positionals have a long name internally but it cannot be used
+		info.Long = ""
+		info.Short = ""
+	}
+	for _, elem := range spec.elems {
+		elemInfo := p.flagInfo(elem)
+		elemInfo.Field = elem.field.Name
+		info.Fields = append(info.Fields, elemInfo)
+	}
+	return info
+}
+
+// FlagByName looks up an option by its long name (without hyphens) or its
+// short name (without hyphen). The option is looked up in the subcommand given
+// by the sequence of subcommand names, or in the top-level command if none are
+// given. Names are matched case-sensitively. The second return value is false
+// if there is no such option or no such subcommand.
+func (p *Parser) FlagByName(name string, subcommand ...string) (FlagInfo, bool) {
+	cmd, err := p.lookupCommand(subcommand...)
+	if err != nil || name == "" {
+		return FlagInfo{}, false
+	}
+	for _, spec := range cmd.specs {
+		if spec.positional {
+			continue
+		}
+		if spec.long == name || spec.short == name {
+			return p.flagInfo(spec), true
+		}
+	}
+	return FlagInfo{}, false
+}
+
+// SpecNode describes one command visited by Walk
+type SpecNode struct {
+	Path        []string   // the names of the subcommands leading to this command, empty for the top-level command
+	Name        string     // the name of the command
+	Help        string     // the help text of a subcommand
+	Hidden      bool       // whether the subcommand is omitted from help text
+	Flags       []FlagInfo // the options of the command, in declaration order
+	Positionals []FlagInfo // the positional arguments of the command, in declaration order
+	Subcommands []string   // the names of the direct subcommands, in declaration order
+}
+
+// Walk calls fn for the top-level command and then for each subcommand, depth
+// first and in declaration order. If fn returns an error then the walk stops
+// and Walk returns that error.
+func (p *Parser) Walk(fn func(node SpecNode) error) error {
+	return p.walkCommand(p.cmd, nil, fn)
+}
+
+// walkCommand calls fn for the given command and its subcommands, as
+// described for Walk
+func (p *Parser) walkCommand(cmd *command, path []string, fn func(node SpecNode) error) error {
+	info := p.commandInfo(cmd)
+	node := SpecNode{
+		Path:        path,
+		Name:        info.Name,
+		Help:        info.Help,
+		Hidden:      info.Hidden,
+		Flags:       info.Flags,
+		Positionals: info.Positionals,
+	}
+	for _, subcmd := range cmd.subcommands {
+		node.Subcommands = append(node.Subcommands, subcmd.name)
+	}
+	if err := fn(node); err != nil {
+		return err
+	}
+	for _, subcmd := range cmd.subcommands {
+		subpath := append(append([]string{}, path...), subcmd.name)
+		if err := p.walkCommand(subcmd, subpath, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
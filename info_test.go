@@ -0,0 +1,208 @@
+package arg
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHelpJSON(t *testing.T) {
+	expected := `
+{
+  "schemaVersion": 1,
+  "program": "example",
+  "version": "example 3.2.1",
+  "name": "example",
+  "flags": [
+    {
+      "field": "Verbose",
+      "long": "verbose",
+      "short": "v",
+      "placeholder": "VERBOSE",
+      "help": "verbosity level",
+      "type": "bool",
+      "cardinality": "zero"
+    },
+    {
+      "field": "Workers",
+      "long": "workers",
+      "placeholder": "N",
+      "env": "WORKERS",
+      "default": "10",
+      "type": "int",
+      "cardinality": "one"
+    }
+  ],
+  "positionals": [],
+  "subcommands": [
+    {
+      "name": "get",
+      "help": "fetch an item",
+      "flags": [],
+      "positionals": [
+        {
+          "field": "Get.Items",
+          "placeholder": "ITEMS",
+          "type": "[]string",
+          "cardinality": "multiple",
+          "required": true,
+          "positional": true
+        }
+      ],
+      "subcommands": []
+    }
+  ]
+}
+`
+	var args struct {
+		versioned
+		Verbose bool `arg:"-v" help:"verbosity level"`
+		Workers int  `arg:"env" default:"10" placeholder:"N"`
+		Get     *struct {
+			Items []string `arg:"positional,required"`
+		} `arg:"subcommand" help:"fetch an item"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteHelpJSON(&buf))
+	assert.Equal(t, expected[1:], buf.String())
+}
+
+func TestWriteHelpJSONSliceOfStructs(t *testing.T) {
+	var args struct {
+		Server []struct {
+			Host string `arg:"--hostname"`
+		}
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteHelpJSON(&buf))
+	assert.Contains(t, buf.String(), `"fields": [
+        {
+          "field": "Host",
+          "long": "hostname",
+          "placeholder": "HOSTNAME",
+          "type": "string",
+          "cardinality": "one"
+        }
+      ]`)
+}
+
+func TestFlagByName(t *testing.T) {
+	type remote struct {
+		Force bool   `arg:"-f" help:"overwrite existing"`
+		Name  string `arg:"positional"`
+	}
+	var args struct {
+		Workers int     `arg:"-w,required" help:"number of workers"`
+		Remote  *remote `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	info, ok := p.FlagByName("workers")
+	require.True(t, ok)
+	assert.Equal(t, "Workers", info.Field)
+	assert.Equal(t, "number of workers", info.Help)
+	assert.True(t, info.Required)
+
+	info, ok = p.FlagByName("w")
+	require.True(t, ok)
+	assert.Equal(t, "Workers", info.Field)
+
+	info, ok = p.FlagByName("f", "remote")
+	require.True(t, ok)
+	assert.Equal(t, "Remote.Force", info.Field)
+
+	_, ok = p.FlagByName("Workers")
+	assert.False(t, ok)
+	_, ok = p.FlagByName("force")
+	assert.False(t, ok)
+	_, ok = p.FlagByName("name", "remote")
+	assert.False(t, ok)
+	_, ok = p.FlagByName("force", "nosuchcommand")
+	assert.False(t, ok)
+	_, ok = p.FlagByName("")
+	assert.False(t, ok)
+}
+
+func TestWalk(t *testing.T) {
+	type show struct {
+		Format string
+	}
+	type remote struct {
+		Show *show  `arg:"subcommand"`
+		Name string `arg:"--remote-name"`
+	}
+	var args struct {
+		Verbose bool
+		Remote  *remote   `arg:"subcommand" help:"manage remotes"`
+		Status  *struct{} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var visited []string
+	err = p.Walk(func(node SpecNode) error {
+		var flags []string
+		for _, flag := range node.Flags {
+			flags = append(flags, flag.Long)
+		}
+		visited = append(visited, node.Name+" ["+strings.Join(node.Path, " ")+"] "+strings.Join(flags, ","))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"example [] verbose",
+		"remote [remote] remote-name",
+		"show [remote show] format",
+		"status [status] ",
+	}, visited)
+}
+
+func TestWalkStopsOnError(t *testing.T) {
+	var args struct {
+		A *struct{} `arg:"subcommand"`
+		B *struct{} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var visited []string
+	stop := errors.New("stop")
+	err = p.Walk(func(node SpecNode) error {
+		visited = append(visited, node.Name)
+		if node.Name == "a" {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{"example", "a"}, visited)
+}
+
+func TestWalkRootNode(t *testing.T) {
+	var simple struct {
+		Name string `arg:"positional"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &simple)
+	require.NoError(t, err)
+	var nodes []SpecNode
+	require.NoError(t, p.Walk(func(node SpecNode) error {
+		nodes = append(nodes, node)
+		return nil
+	}))
+	require.Len(t, nodes, 1)
+	assert.Empty(t, nodes[0].Path)
+	require.Len(t, nodes[0].Positionals, 1)
+	assert.Equal(t, "Name", nodes[0].Positionals[0].Field)
+	assert.Empty(t, nodes[0].Subcommands)
+}
@@ -0,0 +1,107 @@
+package arg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MergeNonZero copies each non-zero field of src into dst. Both must be
+// pointers to structs of the same type. Fields are visited using the same
+// rules as NewParser: fields tagged `arg:"-"` are skipped, unexported fields
+// are skipped unless they are embedded structs, and struct fields (including
+// pointers to structs such as subcommands) are merged recursively.
+//
+// Slices and maps are treated as follows: a non-empty slice in src replaces
+// the corresponding slice in dst, and a non-empty map in src is merged into
+// the corresponding map in dst key by key, with entries from src taking
+// precedence over existing entries in dst.
+func MergeNonZero(dst, src interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dst must be a pointer to a struct but is %T", dst)
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Kind() != reflect.Ptr || sv.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("src must be a pointer to a struct but is %T", src)
+	}
+	if dv.Type() != sv.Type() {
+		return fmt.Errorf("cannot merge %T into %T", src, dst)
+	}
+	if dv.IsNil() {
+		return fmt.Errorf("dst must not be nil")
+	}
+	if sv.IsNil() {
+		return nil
+	}
+	mergeStruct(dv.Elem(), sv.Elem())
+	return nil
+}
+
+// mergeStruct copies the non-zero fields of src into dst, which must be
+// struct values of the same type
+func mergeStruct(dst, src reflect.Value) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("arg") == "-" {
+			continue
+		}
+
+		// exported fields on unexported embedded structs are still writable
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			mergeStruct(dst.Field(i), src.Field(i))
+			continue
+		}
+
+		if !isExported(field.Name) {
+			continue
+		}
+		mergeValue(dst.Field(i), src.Field(i))
+	}
+}
+
+// mergeValue copies src into dst if src is non-zero, descending into structs
+// and merging maps as described in MergeNonZero
+func mergeValue(dst, src reflect.Value) {
+	t := dst.Type()
+	switch {
+	case t.Kind() == reflect.Struct && !isMergeLeaf(t):
+		mergeStruct(dst, src)
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && !isMergeLeaf(t):
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(t.Elem()))
+		}
+		mergeStruct(dst.Elem(), src.Elem())
+	case t.Kind() == reflect.Slice:
+		if src.Len() == 0 {
+			return
+		}
+		// copy the elements so that dst and src do not share a backing array
+		dst.Set(reflect.AppendSlice(reflect.MakeSlice(t, 0, src.Len()), src))
+	case t.Kind() == reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(t))
+		}
+		iter := src.MapRange()
+		for iter.Next() {
+			dst.SetMapIndex(iter.Key(), iter.Value())
+		}
+	default:
+		if !isZero(src) {
+			dst.Set(src)
+		}
+	}
+}
+
+// isMergeLeaf returns true if values of the given struct or pointer-to-struct
+// type should be copied as a whole rather than merged field by field, which is
+// the case for types such as url.URL or time.Time that go-arg parses directly
+func isMergeLeaf(t reflect.Type) bool {
+	return canParse(t) || isTextUnmarshaler(t)
+}
@@ -0,0 +1,113 @@
+package arg
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeNonZero(t *testing.T) {
+	type T struct {
+		Name    string
+		Count   int
+		Verbose bool
+		Ptr     *int
+	}
+	two := 2
+	dst := T{Name: "base", Count: 1}
+	src := T{Count: 3, Ptr: &two}
+	require.NoError(t, MergeNonZero(&dst, &src))
+	assert.Equal(t, "base", dst.Name)
+	assert.Equal(t, 3, dst.Count)
+	assert.False(t, dst.Verbose)
+	assert.Equal(t, 2, *dst.Ptr)
+}
+
+func TestMergeNonZeroSlicesAndMaps(t *testing.T) {
+	type T struct {
+		Tags   []string
+		Paths  []string
+		Labels map[string]string
+	}
+	dst := T{
+		Tags:   []string{"a", "b"},
+		Paths:  []string{"/usr"},
+		Labels: map[string]string{"x": "1", "y": "2"},
+	}
+	src := T{
+		Tags:   []string{"c"},
+		Labels: map[string]string{"y": "3", "z": "4"},
+	}
+	require.NoError(t, MergeNonZero(&dst, &src))
+	assert.Equal(t, []string{"c"}, dst.Tags)
+	assert.Equal(t, []string{"/usr"}, dst.Paths)
+	assert.Equal(t, map[string]string{"x": "1", "y": "3", "z": "4"}, dst.Labels)
+
+	// the merged slice must not share storage with src
+	src.Tags[0] = "changed"
+	assert.Equal(t, []string{"c"}, dst.Tags)
+}
+
+func TestMergeNonZeroNested(t *testing.T) {
+	type Inner struct {
+		Host string
+		Port int
+	}
+	type sub struct {
+		Level int
+	}
+	type T struct {
+		Inner
+		sub
+		Site Inner
+		Cmd  *Inner
+		URL  *url.URL
+	}
+	var dst T
+	dst.Host = "localhost"
+	dst.Site.Port = 80
+	src := T{
+		Inner: Inner{Port: 8080},
+		sub:   sub{Level: 3},
+		Site:  Inner{Host: "example.com"},
+		Cmd:   &Inner{Host: "cmd"},
+		URL:   &url.URL{Scheme: "https", Host: "example.com"},
+	}
+	require.NoError(t, MergeNonZero(&dst, &src))
+	assert.Equal(t, "localhost", dst.Host)
+	assert.Equal(t, 8080, dst.Port)
+	assert.Equal(t, 3, dst.Level)
+	assert.Equal(t, Inner{Host: "example.com", Port: 80}, dst.Site)
+	require.NotNil(t, dst.Cmd)
+	assert.Equal(t, "cmd", dst.Cmd.Host)
+	assert.NotSame(t, src.Cmd, dst.Cmd)
+	assert.Same(t, src.URL, dst.URL)
+}
+
+func TestMergeNonZeroSkipsIgnoredAndUnexported(t *testing.T) {
+	type T struct {
+		Public  string
+		Ignored string `arg:"-"`
+		private string
+	}
+	var dst T
+	src := T{Public: "a", Ignored: "b", private: "c"}
+	require.NoError(t, MergeNonZero(&dst, &src))
+	assert.Equal(t, "a", dst.Public)
+	assert.Equal(t, "", dst.Ignored)
+	assert.Equal(t, "", dst.private)
+}
+
+func TestMergeNonZeroErrors(t *testing.T) {
+	type T struct{ A string }
+	type U struct{ A string }
+	var x T
+	var y U
+	assert.Error(t, MergeNonZero(x, &x))
+	assert.Error(t, MergeNonZero(&x, "abc"))
+	assert.Error(t, MergeNonZero(&x, &y))
+	assert.Error(t, MergeNonZero((*T)(nil), &x))
+	assert.NoError(t, MergeNonZero(&x, (*T)(nil)))
+}
@@ -0,0 +1,51 @@
+package arg
+
+import "reflect"
+
+// Occurrence is a value given for an option, together with its position on
+// the command line. A field of type []Occurrence receives one element each
+// time its option appears, in order and including duplicates, so that the
+// program can reconstruct how the occurrences were interleaved with other
+// arguments, as for include paths given with -I. Each occurrence of the
+// option takes exactly one value, as for the "separate" tag.
+//
+// Index is the position of the option in the arguments passed to Parse, so
+// that the occurrences of different []Occurrence fields can be compared with
+// one another. It is -1 for values that came from an environment variable or
+// a default. If Config.RecordOrder is also set then each occurrence appears
+// in ParseOrder as well, but the positions of events in ParseOrder are not
+// related to Index, since ParseOrder has one event per option rather than
+// one per token.
+type Occurrence struct {
+	Value string
+	Index int
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. The position of the
+// occurrence is not known at this point, so Index is set to -1.
+func (o *Occurrence) UnmarshalText(text []byte) error {
+	o.Value = string(text)
+	o.Index = -1
+	return nil
+}
+
+var occurrenceSliceType = reflect.TypeOf([]Occurrence{})
+
+// isOccurrences returns true if t is []Occurrence or a pointer to one
+func isOccurrences(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == occurrenceSliceType
+}
+
+// setOccurrenceIndex sets Index of the last n elements of dest, which must be
+// a []Occurrence or a pointer to one, to the given position on the command line
+func setOccurrenceIndex(dest reflect.Value, n, index int) {
+	if dest.Kind() == reflect.Ptr {
+		dest = dest.Elem()
+	}
+	for i := dest.Len() - n; i < dest.Len(); i++ {
+		dest.Index(i).Addr().Interface().(*Occurrence).Index = index
+	}
+}
@@ -0,0 +1,54 @@
+package arg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOccurrences(t *testing.T) {
+	var args struct {
+		Include []Occurrence `arg:"-I"`
+		Library []Occurrence `arg:"-l"`
+		Verbose bool
+	}
+	parse(t, "-I a -l m --verbose -I b -I a", &args)
+	assert.Equal(t, []Occurrence{{"a", 0}, {"b", 5}, {"a", 7}}, args.Include)
+	assert.Equal(t, []Occurrence{{"m", 2}}, args.Library)
+}
+
+func TestOccurrencesWithEquals(t *testing.T) {
+	var args struct {
+		Include []Occurrence
+	}
+	parse(t, "--include=a --include b", &args)
+	assert.Equal(t, []Occurrence{{"a", 0}, {"b", 1}}, args.Include)
+}
+
+func TestOccurrencesFromEnv(t *testing.T) {
+	var args struct {
+		Include []Occurrence `arg:"env"`
+	}
+	parseWithEnv(t, "", []string{"INCLUDE=a,b"}, &args)
+	assert.Equal(t, []Occurrence{{"a", -1}, {"b", -1}}, args.Include)
+}
+
+func TestOccurrencesPositional(t *testing.T) {
+	var args struct {
+		Include []Occurrence `arg:"positional"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Include: []arg.Occurrence fields cannot be positional")
+}
+
+func TestOccurrencesWithParseOrder(t *testing.T) {
+	var args struct {
+		Include []Occurrence `arg:"-I"`
+	}
+	p, err := NewParser(Config{RecordOrder: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-I", "a", "-I", "b"}))
+	assert.Equal(t, []Occurrence{{"a", 0}, {"b", 2}}, args.Include)
+	assert.Len(t, p.ParseOrder(), 2)
+}
@@ -0,0 +1,94 @@
+package arg
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// Optional holds a value of type T together with whether it was set. It can be
+// used as the type of an option or positional argument in place of a pointer
+// in order to distinguish an argument that was not provided from one that was
+// provided with the zero value. T may be any type that can be parsed from a
+// single string, and the option is a boolean flag if T is bool.
+//
+// An Optional is set when a value is provided on the command line, by an
+// environment variable, or by a default value, which matches the behavior of
+// pointer fields.
+type Optional[T any] struct {
+	value T
+	set   bool
+}
+
+// Some returns an Optional that is set to the given value. It can be used to
+// provide a default value by assigning it to the field before parsing.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{value: v, set: true}
+}
+
+// IsSet returns true if a value was provided
+func (o Optional[T]) IsSet() bool {
+	return o.set
+}
+
+// Value returns the value, or the zero value of T if no value was provided
+func (o Optional[T]) Value() T {
+	return o.value
+}
+
+// UnmarshalText parses the value from a string and marks it as set
+func (o *Optional[T]) UnmarshalText(b []byte) error {
+	if err := parseScalar(reflect.ValueOf(&o.value).Elem(), string(b)); err != nil {
+		return err
+	}
+	o.set = true
+	return nil
+}
+
+// MarshalText formats the value, or returns an empty string if no value was
+// provided
+func (o Optional[T]) MarshalText() ([]byte, error) {
+	if !o.set {
+		return nil, nil
+	}
+	if m, ok := interface{}(o.value).(encoding.TextMarshaler); ok {
+		return m.MarshalText()
+	}
+	return []byte(fmt.Sprint(o.value)), nil
+}
+
+// optionalElem returns the reflect.Type of T
+func (o Optional[T]) optionalElem() reflect.Type {
+	return reflect.TypeOf(&o.value).Elem()
+}
+
+// optional is implemented by all instantiations of Optional
+type optional interface {
+	optionalElem() reflect.Type
+}
+
+var optionalType = reflect.TypeOf([]optional{}).Elem()
+
+// optionalElemOf returns the type T if t is Optional[T] or *Optional[T]
+func optionalElemOf(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !t.Implements(optionalType) {
+		return nil, false
+	}
+	return reflect.Zero(t).Interface().(optional).optionalElem(), true
+}
+
+// isOptionalSequence returns true if t is a slice or map whose elements are
+// Optional, which are not supported
+func isOptionalSequence(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+		return false
+	}
+	_, ok := optionalElemOf(t.Elem())
+	return ok
+}
@@ -0,0 +1,82 @@
+package arg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptional(t *testing.T) {
+	var args struct {
+		Count   Optional[int]
+		Name    Optional[string]
+		Verbose Optional[bool]
+	}
+	parse(t, "--count 0 --verbose", &args)
+	assert.True(t, args.Count.IsSet())
+	assert.Equal(t, 0, args.Count.Value())
+	assert.False(t, args.Name.IsSet())
+	assert.Equal(t, "", args.Name.Value())
+	assert.True(t, args.Verbose.IsSet())
+	assert.True(t, args.Verbose.Value())
+}
+
+func TestOptionalNegativeNumber(t *testing.T) {
+	var args struct {
+		Offset Optional[int]
+	}
+	parse(t, "--offset -3", &args)
+	assert.Equal(t, -3, args.Offset.Value())
+}
+
+func TestOptionalDefaultsAndEnv(t *testing.T) {
+	var args struct {
+		Port  Optional[int] `default:"8080"`
+		Host  Optional[string]
+		Level Optional[int] `arg:"env"`
+	}
+	args.Host = Some("localhost")
+	p := parseWithEnv(t, "", []string{"LEVEL=2"}, &args)
+	assert.True(t, args.Port.IsSet())
+	assert.Equal(t, 8080, args.Port.Value())
+	assert.Equal(t, "localhost", args.Host.Value())
+	assert.True(t, args.Level.IsSet())
+	assert.Equal(t, 2, args.Level.Value())
+	assert.Equal(t, SourceDefault, p.ValueSources()["Port"])
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--host HOST [default: localhost]")
+}
+
+func TestOptionalPositional(t *testing.T) {
+	var args struct {
+		Input Optional[string] `arg:"positional"`
+	}
+	parse(t, "", &args)
+	assert.False(t, args.Input.IsSet())
+	parse(t, "in.txt", &args)
+	assert.Equal(t, "in.txt", args.Input.Value())
+}
+
+func TestOptionalErrors(t *testing.T) {
+	var slice struct {
+		Counts []Optional[int]
+	}
+	_, err := NewParser(Config{}, &slice)
+	assert.EqualError(t, err, ".Counts: slices and maps of Optional are not supported")
+
+	var inner struct {
+		Counts Optional[[]int]
+	}
+	_, err = NewParser(Config{}, &inner)
+	assert.Error(t, err)
+
+	var args struct {
+		Count Optional[int]
+	}
+	_, err = parseWithEnvErr(t, "--count x", nil, &args)
+	assert.Error(t, err)
+	assert.False(t, args.Count.IsSet())
+}
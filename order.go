@@ -0,0 +1,57 @@
+package arg
+
+import "fmt"
+
+// ParseEventKind describes what kind of token a ParseEvent was created for
+type ParseEventKind int
+
+const (
+	// EventFlag means that an option such as --name was processed
+	EventFlag ParseEventKind = iota
+	// EventPositional means that a positional argument was processed
+	EventPositional
+	// EventSubcommand means that a subcommand was selected
+	EventSubcommand
+	// EventTerminator means that the "--" token was encountered
+	EventTerminator
+)
+
+func (k ParseEventKind) String() string {
+	switch k {
+	case EventFlag:
+		return "flag"
+	case EventPositional:
+		return "positional"
+	case EventSubcommand:
+		return "subcommand"
+	case EventTerminator:
+		return "terminator"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// ParseEvent records a token or group of tokens processed by Parse
+type ParseEvent struct {
+	Kind   ParseEventKind
+	Arg    string   // the token as it appeared on the command line, as in "--name=x", "-v", or "input.txt"
+	Field  string   // the name of the struct field, as in "Deploy.Target", or empty for the terminator
+	Values []string // the values given for a flag, or the positional argument itself
+}
+
+// ParseOrder returns the tokens processed during the most recent call to Parse
+// in the order in which they appeared on the command line. It returns nil
+// unless Config.RecordOrder is set.
+func (p *Parser) ParseOrder() []ParseEvent {
+	return p.order
+}
+
+// record appends an event to the parse order if Config.RecordOrder is set, and
+// returns the index of the event
+func (p *Parser) record(kind ParseEventKind, arg, field string, values ...string) int {
+	if !p.config.RecordOrder {
+		return -1
+	}
+	p.order = append(p.order, ParseEvent{Kind: kind, Arg: arg, Field: field, Values: values})
+	return len(p.order) - 1
+}
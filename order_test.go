@@ -0,0 +1,62 @@
+package arg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrder(t *testing.T) {
+	var args struct {
+		Name    string
+		Verbose bool `arg:"-v"`
+		Tags    []string
+		Find    *struct {
+			Type  string
+			Paths []string `arg:"positional"`
+		} `arg:"subcommand"`
+	}
+	p, err := parseWithConfigEnvErr(t, Config{RecordOrder: true}, "-v find a --type=f --name x b -- -c", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []ParseEvent{
+		{Kind: EventFlag, Arg: "-v", Field: "Verbose", Values: []string{"true"}},
+		{Kind: EventSubcommand, Arg: "find", Field: "Find"},
+		{Kind: EventPositional, Arg: "a", Field: "Find.Paths", Values: []string{"a"}},
+		{Kind: EventFlag, Arg: "--type=f", Field: "Find.Type", Values: []string{"f"}},
+		{Kind: EventFlag, Arg: "--name", Field: "Name", Values: []string{"x"}},
+		{Kind: EventPositional, Arg: "b", Field: "Find.Paths", Values: []string{"b"}},
+		{Kind: EventTerminator, Arg: "--"},
+		{Kind: EventPositional, Arg: "-c", Field: "Find.Paths", Values: []string{"-c"}},
+	}, p.ParseOrder())
+}
+
+func TestParseOrderMultipleValuesAndPositionals(t *testing.T) {
+	var args struct {
+		Tags []string
+		Src  string `arg:"positional"`
+		Dst  string `arg:"positional"`
+	}
+	p, err := parseWithConfigEnvErr(t, Config{RecordOrder: true}, "in --tags a b", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []ParseEvent{
+		{Kind: EventPositional, Arg: "in", Field: "Src", Values: []string{"in"}},
+		{Kind: EventFlag, Arg: "--tags", Field: "Tags", Values: []string{"a", "b"}},
+	}, p.ParseOrder())
+}
+
+func TestParseOrderDisabled(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p := pparse(t, "--name x", &args)
+	assert.Nil(t, p.ParseOrder())
+}
+
+func TestParseEventKindString(t *testing.T) {
+	assert.Equal(t, "flag", EventFlag.String())
+	assert.Equal(t, "positional", EventPositional.String())
+	assert.Equal(t, "subcommand", EventSubcommand.String())
+	assert.Equal(t, "terminator", EventTerminator.String())
+	assert.Equal(t, "unknown(42)", ParseEventKind(42).String())
+}
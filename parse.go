@@ -1,7 +1,6 @@
 package arg
 
 import (
-	"encoding"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -9,9 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/alexflint/go-scalar"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
 )
 
 // path represents a sequence of steps to find the output location for an
@@ -30,6 +32,16 @@ func (p path) String() string {
 	return s
 }
 
+// Name gets the names of the struct fields in this path joined by dots, such
+// as "Deploy.Target"
+func (p path) Name() string {
+	names := make([]string, len(p.fields))
+	for i, f := range p.fields {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ".")
+}
+
 // Child gets a new path representing a child of this path.
 func (p path) Child(f reflect.StructField) path {
 	// copy the entire slice of fields to avoid possible slice overwrite
@@ -52,11 +64,123 @@ type spec struct {
 	required      bool                // if true, this option must be present on the command line
 	positional    bool                // if true, this option will be looked for in the positional flags
 	separate      bool                // if true, each slice and map entry will have its own --flag
+	sep           string              // if non-empty, each token for a slice or map is split at this separator
+	quoted        bool                // if true, separators inside double quotes or escaped with a backslash do not split
+	encoding      string              // if non-empty, values are decoded from this encoding and passed to UnmarshalBinary
+	group         string              // if non-empty, this grouping separator is removed from numbers before parsing
+	unit          string              // if non-empty, integers may have a unit suffix in this system, either si or iec
+	clock         bool                // if true, durations may be given as m:ss or h:mm:ss
+	clearable     bool                // if true, this slice or map can be emptied with --no-long
+	negatable     bool                // if true, this boolean can be set to false with --no-long
+	counter       bool                // if true, this integer takes no value and counts the number of times it is given
+	appendDefault bool                // if true, values given for this slice are appended to its default rather than replacing it, unless it was emptied with --no-long
+	fromFile      bool                // if true, the value is the path of a file from which the bytes are read
+	listFile      string              // if non-empty, the long name of a second option whose value is a file with one element of this slice per line
+	prompt        bool                // if true, the user is prompted for a value that was not otherwise provided
+	promptSecret  bool                // if true, the value entered at a prompt is not echoed
+	promptText    string              // the text of the prompt, from the prompt struct tag
+	hidden        bool                // if true, this option is not listed in help or usage text
+	section       string              // if non-empty, the heading under which this option is listed in help text
+	aliases       []string            // additional long names of this option, as in --colour for --color
+	deprecated    bool                // if true, the aliases of this option, or the option itself if it has none, are deprecated
+	deprecation   string              // the message of the deprecated tag, which is included in the warning
+	experimental  bool                // if true, this option is accepted and listed only when Config.ExperimentalEnv is enabled
+	terminal      bool                // if true, parsing stops once this option is given, as described for TerminalError
+	profile       bool                // if true, the value of this option is a prefix for the environment variables of other options
+	conffile      bool                // if true, the value of this option is the path of a config file from which other options take their values
+	strict        bool                // if true, a map key given more than once is an error rather than the last value winning
+	kvsep         string              // if non-empty, separates keys from values in map entries instead of "="
+	split         string              // if non-empty, the value of this string option is split at this separator into the fields named by into
+	intoNames     []string            // the fields named by the into tag, which receive the pieces of the value in order
+	into          []path              // the paths of the fields named by intoNames
+	nargs         int                 // if non-zero, the number of tokens consumed for an array field
+	inverted      bool                // if true, this boolean defaults to true and is set to false when present
 	help          string              // the help text for this option
 	env           string              // the name of the environment variable for this option, or empty for none
+	envFallbacks  []string            // environment variables that are read in order if env is not set, as in env:NEW|OLD
+	noenv         bool                // if true, no environment variable is derived for this option by Config.AutoEnv
+	envDerived    bool                // if true, env was derived from the field name rather than given in a tag
 	defaultValue  reflect.Value       // default value for this option
 	defaultString string              // default value for this option, in string form to be displayed in help text
+	defaultFunc   reflect.Type        // if non-nil, a pointer to this type implements DefaultProvider
+	tmpl          *template.Template  // if non-nil, the default value is rendered from this template, parsed from the default tag
+	templateDeps  []*spec             // the options with template defaults to which tmpl refers, which are rendered first
 	placeholder   string              // name of the data in help
+	index         int                 // explicit position of this positional relative to other positionals
+	hasIndex      bool                // if true, the position of this positional was given explicitly via index
+	elems         []*spec             // for slices of structs, the fields of each element, addressed as --long.N.field
+	owner         path                // the struct of the command to which this option belongs
+	choicesFn     string              // if non-empty, the method of the owner struct that lists the allowed values
+	choiceList    []string            // if non-empty, the allowed values, from the choices tag
+	foldCase      bool                // if true, values are matched to choices case-insensitively and stored in the case of the choice
+	inherit       bool                // if true, the value of the option with the same long name in a parent command is the default
+	requiredIfEnv string              // if non-empty, this option is required when this environment variable is set
+	togetherNames []string            // the fields named by the together tag, which join this one in a together group
+	groupName     string              // if non-empty, the name of the group of options given by the group tag
+	groupMode     string              // the mode of the group named by groupName, either exclusive or together
+	optionGroup   *optionGroup        // the group named by groupName, or the together group given by togetherNames
+	setMode       string              // if non-empty, the long name of the option that this boolean sets to modeValue when present
+	modeValue     string              // the value to which this boolean sets the option named by setMode
+	modeTarget    *spec               // the option named by setMode
+	inheritFrom   *spec               // the option of a parent command from which the value is inherited
+}
+
+// ungroup removes the grouping separator of the spec from a number, so that
+// "1,000" becomes "1000" for options tagged "grouped"
+func (s *spec) ungroup(value string) string {
+	if s.group == "" {
+		return value
+	}
+	return strings.ReplaceAll(value, s.group, "")
+}
+
+// parse parses a single value into v after removing grouping separators and
+// converting unit suffixes and clock durations
+func (s *spec) parse(v reflect.Value, value string) error {
+	value = s.ungroup(value)
+	var err error
+	if s.unit != "" {
+		if value, err = convertUnits(value, s.unit); err != nil {
+			return err
+		}
+	}
+	if s.clock {
+		if value, err = convertClock(value); err != nil {
+			return err
+		}
+	}
+	return parseValue(v, value, s.encoding)
+}
+
+// readFile returns the contents of the file named by value for options tagged
+// "fromfile", or value itself for other options
+func (s *spec) readFile(value string) (string, error) {
+	if !s.fromFile {
+		return value, nil
+	}
+	b, err := os.ReadFile(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ungroupAll applies ungroup to each of the values
+func (s *spec) ungroupAll(values []string) []string {
+	if s.group == "" {
+		return values
+	}
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = s.ungroup(v)
+	}
+	return out
+}
+
+// hasDefault returns true if the spec has a default value, including one that
+// is rendered from a template
+func (s *spec) hasDefault() bool {
+	return s.defaultValue.IsValid() || s.tmpl != nil
 }
 
 // command represents a named subcommand, or the top-level command
@@ -67,14 +191,148 @@ type command struct {
 	specs       []*spec
 	subcommands []*command
 	parent      *command
+	hidden      bool  // if true, this subcommand is not listed in help text
+	passthrough *spec // if non-nil, all tokens after this subcommand are stored in this spec without parsing
+	unknown     *spec // if non-nil, unknown options given to this subcommand are collected in this spec
+	rest        *spec // if non-nil, the arguments that are not recognized are collected in this spec
+	nohelp      bool  // if true, -h and --help are not handled by the library once this subcommand is selected
+
+	tagWarnings []string // the problems with arg tags that were ignored because strict was not set
 }
 
 // ErrHelp indicates that the builtin -h or --help were provided
 var ErrHelp = errors.New("help requested by user")
 
+// ErrHelpAll indicates that the builtin --help-all was provided, as described
+// for Config.HelpAllFlag. It wraps ErrHelp, so errors.Is(err, ErrHelp) also
+// holds for it.
+var ErrHelpAll = fmt.Errorf("full %w", ErrHelp)
+
 // ErrVersion indicates that the builtin --version was provided
 var ErrVersion = errors.New("version requested by user")
 
+// ErrParsed is returned by Parse and ParseMap when the parser has already
+// processed arguments, unless Reset was called or Config.AllowReparse is set
+var ErrParsed = errors.New("arguments were already parsed; call Reset before parsing again")
+
+// ErrArgsTooLong is returned by Parse and ParseMap when the input exceeds
+// Config.MaxTotalArgsBytes. The error that is returned wraps ErrArgsTooLong
+// and reports the limit, but not the input.
+var ErrArgsTooLong = errors.New("arguments are too long")
+
+// TerminalError is returned by Parse when an option tagged "terminal" is
+// given, as in arg:"--print-config,terminal". The option and any value it
+// takes are processed, but the arguments after it are ignored, defaults are
+// applied, and required options are not enforced, so that the program can act
+// on the option without requiring the rest of the command line. Normalizer and
+// Validator are not called.
+type TerminalError struct {
+	Flag  string // the option as given on the command line, without any value, such as --print-config
+	Field string // the name of the field of the option, as used by ValueSources
+}
+
+func (e *TerminalError) Error() string {
+	return fmt.Sprintf("terminal option %s was given", e.Flag)
+}
+
+// CompletionRequestError is returned by Parse when one of the builtin options
+// described for Config.CompletionFlag is given. MustParse handles it by
+// writing the completion script for Shell and exiting.
+type CompletionRequestError struct {
+	Shell string // the shell for which a completion script was requested, as passed to WriteCompletion
+}
+
+func (e *CompletionRequestError) Error() string {
+	return "completion script for " + e.Shell + " requested by user"
+}
+
+// ErrFrozen is returned by methods that modify a parser after Freeze was called
+var ErrFrozen = errors.New("parser is frozen")
+
+// UnknownArgError is returned by Parse when an argument does not correspond to
+// any option
+type UnknownArgError struct {
+	Arg         string   // the argument as it appeared on the command line
+	Suggestions []string // known options or subcommands similar to Arg, if Config.SuggestFlags or Config.SuggestSubcommands is set
+	Subcommand  bool     // whether Arg was given in place of a subcommand
+	Positional  bool     // whether Arg is the first of the positionals that no field received
+}
+
+func (e *UnknownArgError) Error() string {
+	var msg string
+	switch {
+	case e.Subcommand:
+		msg = "invalid subcommand: " + e.Arg
+	case e.Positional:
+		msg = fmt.Sprintf("too many positional arguments at '%s'", e.Arg)
+	default:
+		msg = "unknown argument " + e.Arg
+	}
+	if len(e.Suggestions) > 0 {
+		msg += " (did you mean " + strings.Join(e.Suggestions, " or ") + "?)"
+	}
+	return msg
+}
+
+// ValueError is returned by Parse when the value of an option or positional
+// cannot be processed, for example because it cannot be parsed into the type
+// of the field or is not one of the choices
+type ValueError struct {
+	Arg   string // the option as it appeared on the command line but without its value, or the field name for positionals
+	Field string // the name of the field, as used by ValueSources
+	Err   error  // the reason the value was rejected
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("error processing %s: %v", e.Arg, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// RequiredError is returned by Parse when a required option was not provided
+type RequiredError struct {
+	Arg        string // the option, such as --name, or the placeholder of a positional, or empty if it can only be set from the environment
+	Field      string // the name of the field, as used by ValueSources
+	Env        string // the environment variable of the option, if any
+	IfEnv      string // the environment variable that made the option required, for options tagged "required-if-env"
+	Subcommand string // the path of the subcommand whose positional is missing, as in "remote add", if it is not the top-level command
+}
+
+func (e *RequiredError) Error() string {
+	switch {
+	case e.Subcommand != "":
+		return fmt.Sprintf("subcommand '%s' requires positional %s", e.Subcommand, e.Arg)
+	case e.IfEnv != "":
+		return fmt.Sprintf("%s is required when environment variable %s is set", e.Arg, e.IfEnv)
+	case e.Arg == "":
+		return fmt.Sprintf("environment variable %s is required", e.Env)
+	}
+	msg := fmt.Sprintf("%s is required", e.Arg)
+	if e.Env != "" {
+		msg += " (or environment variable " + e.Env + ")"
+	}
+	return msg
+}
+
+// UsageError is returned by Parse in place of other errors when
+// Config.UsageErrors is set. Its message includes the usage text, or the full
+// help text if Config.HelpOnError is set, of the subcommand that was being
+// processed when the error occurred.
+type UsageError struct {
+	Err   error  // the error that occurred while parsing
+	Usage string // the usage or help text, ending with a newline
+}
+
+func (e *UsageError) Error() string {
+	return e.Usage + "error: " + e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
 // for monkey patching in example code
 var mustParseExit = os.Exit
 
@@ -94,7 +352,11 @@ func mustParse(config Config, dest ...interface{}) *Parser {
 
 	p, err := NewParser(config, dest...)
 	if err != nil {
-		_, _ = fmt.Fprintln(config.Out, err)
+		errDest := config.ErrorDestination
+		if errDest == nil {
+			errDest = config.Out
+		}
+		_, _ = fmt.Fprintln(errDest, err)
 		config.Exit(-1)
 		return nil
 	}
@@ -120,6 +382,44 @@ func flags() []string {
 	return os.Args[1:]
 }
 
+// EnvCase is the case of environment variables derived from field names, as
+// described for Config.EnvCase
+type EnvCase int
+
+const (
+	// EnvCaseUpper derives upper case names, as in API_KEY
+	EnvCaseUpper EnvCase = iota
+	// EnvCaseLower derives lower case names, as in api_key
+	EnvCaseLower
+	// EnvCaseAsIs keeps the case of the field name, as in API_Key
+	EnvCaseAsIs
+)
+
+// UsageLayout is the arrangement of the options in the usage line, as
+// described for Config.UsageLayout
+type UsageLayout int
+
+const (
+	// UsageLayoutFull lists every option, with optional ones in brackets, as
+	// in "Usage: prog --name NAME [--verbose] [--count COUNT] SRC"
+	UsageLayoutFull UsageLayout = iota
+	// UsageLayoutCompact lists the required options and stands for the
+	// optional ones with [options], as in "Usage: prog --name NAME [options] SRC"
+	UsageLayoutCompact
+)
+
+// ErrorFormat is the format in which errors are reported, as described for
+// Config.ErrorFormat
+type ErrorFormat int
+
+const (
+	// ErrorFormatText reports errors as the usage text followed by a line of
+	// the form "error: message"
+	ErrorFormatText ErrorFormat = iota
+	// ErrorFormatJSON reports errors as a JSONError object on a single line
+	ErrorFormatJSON
+)
+
 // Config represents configuration options for an argument parser
 type Config struct {
 	// Program is the name of the program used in the help text
@@ -136,14 +436,235 @@ type Config struct {
 	// subcommand
 	StrictSubcommands bool
 
+	// UsageErrors instructs Parse to return a *UsageError for every error
+	// other than ErrHelp and ErrVersion, so that the message includes the
+	// usage text of the subcommand in which the error occurred
+	UsageErrors bool
+
+	// HelpOnError instructs the library to use the full help text rather than
+	// the one-line usage text when reporting errors, both in the output of
+	// MustParse and Fail and in the message of a UsageError
+	HelpOnError bool
+
+	// AutoEnv instructs the library to read an environment variable for every
+	// option that does not have an env tag. The name of the variable is the
+	// field name in upper case with underscores between words, so that a field
+	// named APIKey is read from API_KEY. Fields tagged with noenv are excluded.
+	AutoEnv bool
+
+	// EnvCase is the case of the environment variables that are derived from
+	// field names, either by AutoEnv or by an env tag without a name. Names
+	// given in env tags, as in env:API_KEY, are used as they are.
+	EnvCase EnvCase
+
+	// AutoShortFlags instructs the library to give each option that does not
+	// have a short name the first letter of its field name, in lower case, as
+	// its short name. Letters already used by an explicit short name, by an
+	// earlier option, or by -h are skipped, so that later options with the same
+	// first letter have no short name.
+	AutoShortFlags bool
+
+	// NoInterspersedFlags instructs the library to treat every argument after
+	// the first positional argument as a positional, POSIX style, rather than
+	// allowing options and positionals to be mixed freely, GNU style
+	NoInterspersedFlags bool
+
+	// AllowDashValues instructs the library to consume tokens that start with
+	// a hyphen as values for slice and map options, as in "--exclude -foo".
+	// By default such tokens end the list of values unless they are negative
+	// numbers for a numeric slice. Either way, the list of values always ends
+	// at a token that is a known option, or at "--".
+	AllowDashValues bool
+
+	// SliceDefaultSeparator, if non-empty, causes the default values of slice
+	// and map options to be displayed in help text with their elements joined
+	// by this separator, as in "a,b,c", rather than in the form "[a b c]".
+	// Fields with a sep tag are always displayed using that separator.
+	SliceDefaultSeparator string
+
+	// SuggestFlags instructs the library to suggest up to two similar known
+	// options when an unknown option is given
+	SuggestFlags bool
+
+	// SuggestSubcommands instructs the library to suggest the closest known
+	// subcommand when an unknown subcommand is given
+	SuggestSubcommands bool
+
 	// Exit is called to terminate the process with an error code (defaults to os.Exit)
 	Exit func(int)
 
 	// Out is where help text, usage text, and failure messages are printed (defaults to os.Stdout)
 	Out io.Writer
 
+	// HelpDestination is where help and version text requested by the user
+	// are printed (defaults to Out)
+	HelpDestination io.Writer
+
+	// ErrorDestination is where failure messages and the accompanying usage
+	// text are printed (defaults to Out). The default is kept for
+	// compatibility with programs that read errors from standard output,
+	// which is where they have always been printed. Set it to os.Stderr to
+	// keep errors separate from regular output.
+	ErrorDestination io.Writer
+
+	// ErrorFormat is the format in which MustParse, Fail, and FailSubcommand
+	// write errors to ErrorDestination. With ErrorFormatJSON they write a
+	// JSONError object rather than the usage text and message, so that the
+	// failure can be read by the program that ran this one.
+	ErrorFormat ErrorFormat
+
 	// Environment is a map of environment variables to override those in the process environment, or provide values to those not in the process environment.
 	Environment map[string]string
+
+	// ExperimentalEnv is the name of the environment variable that enables
+	// options tagged "experimental". Such options are rejected, and omitted
+	// from help text, unless this variable is set to a true value such as 1.
+	// It must be set if any option is experimental.
+	ExperimentalEnv string
+
+	// Prompt, if non-nil, is called to ask the user for the value of an option
+	// tagged "prompt" that was not provided on the command line, by an
+	// environment variable, or by a default value. It receives the text of the
+	// prompt and whether the value is secret, and returns false if the user
+	// cannot be prompted. If nil, the user is prompted on the terminal when
+	// standard input is a terminal.
+	Prompt func(text string, secret bool) (string, bool, error)
+
+	// TreatEmptyEnvAsUnset instructs the library to ignore environment
+	// variables that are set to the empty string, so that the default value
+	// applies, rather than treating them as an explicit empty value
+	TreatEmptyEnvAsUnset bool
+
+	// EnvAllowlist, if non-empty, is the list of environment variables that may
+	// be consulted. Options whose environment variable is not in the list are
+	// populated only from the command line or from their default value. This
+	// applies to variables provided by Environment too.
+	EnvAllowlist []string
+
+	// AllowResponseFiles instructs Parse to replace each argument of the form
+	// @path, other than those after "--", with the arguments read from the
+	// named file, which is useful when the command line would exceed the
+	// limits of the operating system. Each line of the file that is neither
+	// blank nor a comment starting with "#" is one argument, trimmed of
+	// surrounding whitespace unless it is quoted with double quotes, in which
+	// case it may use the escape sequences of Go string literals, or with
+	// single quotes. Response files may name other response files. The
+	// arguments are expanded before Preprocess is called. Nothing after a
+	// "--" is expanded, including a "--" read from a response file. If
+	// MaxTotalArgsBytes is set, the response files may not be longer in
+	// total than that limit, including blank lines and comments.
+	AllowResponseFiles bool
+
+	// Preprocess, if non-nil, is called with the raw command line arguments
+	// before they are parsed, and the arguments it returns are parsed instead.
+	// If it returns an error then parsing is aborted with that error.
+	Preprocess func(args []string) ([]string, error)
+
+	// StrictEnvPrefix, if non-empty, causes parsing to fail when an environment
+	// variable starting with this prefix does not correspond to any argument
+	StrictEnvPrefix string
+
+	// StrictEnvPrefixWarnOnly reports the environment variables found by
+	// StrictEnvPrefix through Parser.Warnings rather than failing
+	StrictEnvPrefixWarnOnly bool
+
+	// Labels overrides the section headings used in help and usage text
+	Labels Labels
+
+	// HelpTemplate, if non-empty, is a text/template that replaces the layout
+	// of help text. It is executed with a *HelpData describing the command for
+	// which help is written. WriteHelpAll is not affected. NewParser returns
+	// an error if the template cannot be parsed or executed. If it cannot be
+	// executed for a subcommand, the help is written in the default layout
+	// and the error is reported by Warnings and by WriteHelpForSubcommand.
+	HelpTemplate string
+
+	// UsageLayout is the arrangement of the options in the usage line. By
+	// default every option is listed. With UsageLayoutCompact only required
+	// options are listed, so that they stand out, and the others are
+	// summarized as [options] and described in the help text.
+	UsageLayout UsageLayout
+
+	// RecordOrder instructs the parser to record the order in which options,
+	// positionals, and subcommands appear, which is available from ParseOrder
+	RecordOrder bool
+
+	// RequiredMarker, if non-empty, is appended to required options and
+	// positionals in help text, for example "*" or " (required)"
+	RequiredMarker string
+
+	// AllowReparse permits Parse to be called more than once without calling
+	// Reset in between. Each call then starts from the values left by the
+	// previous one, so slices and maps set by default accumulate values.
+	AllowReparse bool
+
+	// HelpAllFlag is the long name of the builtin option that displays help
+	// for every option, including hidden and experimental ones, which are
+	// otherwise omitted from help. Defaults to "help-all". Set it to "-" to
+	// disable the option. It is listed in help text only for commands that
+	// have something hidden.
+	HelpAllFlag string
+
+	// CompletionFlag, if non-empty, is the prefix of the long names of hidden
+	// builtin options that request a completion script, one for each shell
+	// supported by WriteCompletion. For example, "completion-script" adds
+	// --completion-script-bash, --completion-script-zsh, and
+	// --completion-script-fish. Parse returns a *CompletionRequestError for
+	// them, and MustParse writes the script to HelpDestination and exits.
+	CompletionFlag string
+
+	// IgnoreUnknownKeys instructs ParseMap, and the reading of the config file
+	// named by the option tagged "conffile", to skip keys that are not the
+	// long name of any option, rather than failing
+	IgnoreUnknownKeys bool
+
+	// IgnoreUnknown instructs the parser to accept unknown options and extra
+	// positionals rather than failing. They are stored, in their original
+	// order, in the []string field tagged "passthrough" of the last subcommand
+	// that has one, or of its ancestors, and are otherwise discarded. Fields
+	// tagged "passthrough" collect unknown arguments even without this option.
+	// A word given where a subcommand is expected is still rejected unless a
+	// subcommand has already been chosen, so that mistyped subcommand names
+	// are reported.
+	IgnoreUnknown bool
+
+	// ConfigUnmarshal decodes the config file named by the option tagged
+	// "conffile" into the map[string]interface{} to which v points (defaults
+	// to json.Unmarshal). Set it to the Unmarshal function of a YAML or TOML
+	// package to read config files in those formats.
+	ConfigUnmarshal func(data []byte, v interface{}) error
+
+	// LenientTags makes NewParser accept options in arg tags that are given a
+	// value they do not take, such as required:true, or an empty value they
+	// need, such as env:, which are otherwise errors. The value is then
+	// ignored and the option is reported by Parser.Warnings. Unknown options
+	// are always errors.
+	LenientTags bool
+
+	// HideEnvInHelp omits the "env: NAME" annotation that help text otherwise
+	// shows for options that can be set from environment variables. Options
+	// that can only be set from the environment are still listed in their own
+	// section.
+	HideEnvInHelp bool
+
+	// MaxTotalArgsBytes, if positive, limits the combined length in bytes of
+	// the arguments given to Parse, both before and after Preprocess and the
+	// expansion of response files, and of the keys and values given to
+	// ParseMap. Longer input is rejected with ErrArgsTooLong before any of it
+	// is processed, even if it contains -h or --help.
+	MaxTotalArgsBytes int
+}
+
+// Labels contains the section headings used in help and usage text, without
+// the trailing colon. Empty fields fall back to the English defaults.
+type Labels struct {
+	Usage         string // defaults to "Usage"
+	Positional    string // defaults to "Positional arguments"
+	Options       string // defaults to "Options"
+	GlobalOptions string // defaults to "Global options"
+	HiddenOptions string // defaults to "Hidden options"
+	Env           string // defaults to "Environment variables"
+	Commands      string // defaults to "Commands"
 }
 
 // Parser represents a set of command line options with destination values
@@ -154,13 +675,45 @@ type Parser struct {
 	version     string
 	description string
 	epilogue    string
+	frozen      bool
+	profileSpec *spec              // the option tagged "profile", if any
+	confSpec    *spec              // the option tagged "conffile", if any
+	helpTmpl    *template.Template // parsed from Config.HelpTemplate, if set
+	built       *Command           // the command from which NewParserFromCommand constructed the parser, if any
+	parsed      bool               // whether Parse or ParseMap has been called since NewParser or Reset
+	partial     bool               // whether ParsePartial is in progress, so that unknown arguments are returned rather than rejected
+
+	// the following fields change during processing of command line arguments
+	lastCmd    *command
+	sources    map[*spec]Source
+	envSources map[*spec]string // the environment variable from which each argument was set
+	profile    string
+	order      []ParseEvent
+	warnings   []string
+	rest       []string // the arguments left unconsumed by ParsePartial
+
+	deprecations []string  // the warnings about deprecated options, which MustParse writes to warningDest
+	warningDest  io.Writer // Config.ErrorDestination if it was set, or else os.Stderr
+
+	specWarnings []string // problems with the destination structs found by NewParser
+}
 
-	// the following field changes during processing of command line arguments
-	lastCmd *command
+// Appender is the interface implemented by field types that receive a
+// sequence of key=value pairs, such as maps that preserve the order in which
+// keys were inserted. A field whose type, or a pointer to whose type,
+// implements Appender takes multiple values like a map field does, and each
+// value is split at the first "=", or at the separator given by the kvsep tag,
+// and passed to Append in the order in which the values were given. An error
+// returned by Append is reported with the option or environment variable that
+// supplied the value.
+type Appender interface {
+	Append(key, value string) error
 }
 
 // Versioned is the interface that the destination struct should implement to
-// make a version string appear at the top of the help message.
+// make a version string appear at the top of the help message. Subcommand
+// destinations may also implement it, in which case their version is
+// displayed instead when that subcommand is selected.
 type Versioned interface {
 	// Version returns the version string that will be printed on a line by itself
 	// at the top of the help message.
@@ -175,6 +728,69 @@ type Described interface {
 	Description() string
 }
 
+// DynamicDescribed is the interface that the destination struct, or the struct
+// for a subcommand, can implement to compute its description each time the help
+// message is written rather than once when the parser is constructed. It takes
+// precedence over Described and over the help tag of a subcommand.
+type DynamicDescribed interface {
+	// DynamicDescription returns the string that will be printed on a line by
+	// itself at the top of the help message, and next to the subcommand in the
+	// list of subcommands.
+	DynamicDescription() string
+}
+
+// DefaultProvider is the interface that the type of a field can implement to
+// compute the default value of the field at parse time, for example from the
+// current working directory. DefaultValue is called on a zero value of the
+// type whenever the field was not set from the command line or environment
+// and has no default tag, and the result is parsed as if it had been given on
+// the command line. The default is displayed as "(dynamic)" in help text.
+type DefaultProvider interface {
+	// DefaultValue returns the default value in string form
+	DefaultValue() string
+}
+
+// DefaultFiller is the interface that the destination struct can implement to
+// compute default values for many fields in one place. After the command line
+// and environment have been processed, FillDefaults is called for each field
+// that is still zero and has no other default, before required arguments are
+// checked. The field is identified by its name, with fields of subcommands
+// qualified by the subcommand field, as in "Deploy.Target". If FillDefaults
+// returns true then the string is parsed as if it had been given on the
+// command line, and otherwise the field is left as it is.
+type DefaultFiller interface {
+	FillDefaults(field string, t reflect.Type) (string, bool)
+}
+
+// Normalizer is the interface that the destination struct, or the struct for a
+// subcommand, can implement to adjust field values after parsing, for example
+// to derive one field from another. Normalize is called once all values have
+// been assigned and required arguments have been checked, first on the
+// top-level destination and then on each selected subcommand from the outermost
+// to the innermost. An error aborts parsing.
+type Normalizer interface {
+	Normalize() error
+}
+
+// Validator is the interface that the destination struct, or the struct for a
+// subcommand, can implement to check combinations of values that struct tags
+// cannot express. Validate is called after Normalize, in the same order, on
+// the destinations of the selected subcommands only. The first error that is
+// returned aborts parsing and is returned by Parse as it is, so that
+// MustParse prints it with the usage text of the selected subcommand.
+type Validator interface {
+	Validate() error
+}
+
+// HelpProvider is the interface that the destination struct, or the struct
+// for a subcommand or an embedded struct, can implement to supply help text
+// for its fields, keyed by field name. It is consulted for fields that have
+// no help tag, including subcommand fields, and is usually implemented by the
+// code that gen.GenerateHelp writes from the doc comments of the fields.
+type HelpProvider interface {
+	FieldHelp() map[string]string
+}
+
 // Epilogued is the interface that the destination struct should implement to
 // add an epilogue string at the bottom of the help message.
 type Epilogued interface {
@@ -213,6 +829,16 @@ func NewParser(config Config, dests ...interface{}) (*Parser, error) {
 	if config.Out == nil {
 		config.Out = os.Stdout
 	}
+	if config.HelpDestination == nil {
+		config.HelpDestination = config.Out
+	}
+	warningDest := config.ErrorDestination
+	if warningDest == nil {
+		warningDest = os.Stderr
+	}
+	if config.ErrorDestination == nil {
+		config.ErrorDestination = config.Out
+	}
 
 	// first pick a name for the command for use in the usage text
 	var name string
@@ -227,8 +853,9 @@ func NewParser(config Config, dests ...interface{}) (*Parser, error) {
 
 	// construct a parser
 	p := Parser{
-		cmd:    &command{name: name},
-		config: config,
+		cmd:         &command{name: name},
+		config:      config,
+		warningDest: warningDest,
 	}
 
 	// make a list of roots
@@ -237,16 +864,25 @@ func NewParser(config Config, dests ...interface{}) (*Parser, error) {
 	}
 
 	// process each of the destination values
+	var tagWarnings []string
 	for i, dest := range dests {
 		t := reflect.TypeOf(dest)
 		if t.Kind() != reflect.Ptr {
 			panic(fmt.Sprintf("%s is not a pointer (did you forget an ampersand?)", t))
 		}
 
-		cmd, err := cmdFromStruct(name, path{root: i}, t)
+		cmd, err := cachedCmdFromStruct(name, path{root: i}, t, !config.LenientTags)
 		if err != nil {
 			return nil, err
 		}
+		tagWarnings = append(tagWarnings, collectTagWarnings(cmd)...)
+
+		if config.AutoEnv {
+			deriveEnv(cmd)
+		}
+		if config.EnvCase != EnvCaseUpper {
+			caseEnv(cmd, config.EnvCase)
+		}
 
 		// for backwards compatibility, add nonzero field values as defaults
 		// this applies only to the top-level command, not to subcommands (this inconsistency
@@ -255,29 +891,36 @@ func NewParser(config Config, dests ...interface{}) (*Parser, error) {
 			// get the value
 			v := p.val(spec.dest)
 
-			// if the value is the "zero value" (e.g. nil pointer, empty struct) then ignore
-			if isZero(v) {
+			// if the value is the "zero value" (e.g. nil pointer, empty struct) then ignore,
+			// and a func(string) error is where values go rather than a default
+			if isZero(v) || isSetter(v.Type()) {
 				continue
 			}
 
-			// store as a default
-			spec.defaultValue = v
+			// store a copy as a default, so that it survives the field being overwritten
+			spec.defaultValue = reflect.New(v.Type()).Elem()
+			spec.defaultValue.Set(v)
 
 			// we need a string to display in help text
-			// if MarshalText is implemented then use that
-			if m, ok := v.Interface().(encoding.TextMarshaler); ok {
-				s, err := m.MarshalText()
-				if err != nil {
-					return nil, fmt.Errorf("%v: error marshaling default value to string: %v", spec.dest, err)
-				}
-				spec.defaultString = string(s)
-			} else {
-				spec.defaultString = fmt.Sprintf("%v", v)
+			sep := spec.sep
+			if sep == "" {
+				sep = config.SliceDefaultSeparator
+			}
+			s, err := formatDefault(v, sep)
+			if err != nil {
+				return nil, fmt.Errorf("%v: error marshaling default value to string: %v", spec.dest, err)
 			}
+			spec.defaultString = s
 		}
 
 		p.cmd.specs = append(p.cmd.specs, cmd.specs...)
 		p.cmd.subcommands = append(p.cmd.subcommands, cmd.subcommands...)
+		if cmd.rest != nil {
+			if p.cmd.rest != nil {
+				return nil, fmt.Errorf("passthrough cannot be used on both %s and %s", p.cmd.rest.dest, cmd.rest.dest)
+			}
+			p.cmd.rest = cmd.rest
+		}
 
 		if dest, ok := dest.(Versioned); ok {
 			p.version = dest.Version()
@@ -290,530 +933,3273 @@ func NewParser(config Config, dests ...interface{}) (*Parser, error) {
 		}
 	}
 
-	return &p, nil
-}
+	if config.AutoShortFlags {
+		deriveShorts(p.cmd, map[string]bool{"h": true})
+	}
 
-func cmdFromStruct(name string, dest path, t reflect.Type) (*command, error) {
-	// commands can only be created from pointers to structs
-	if t.Kind() != reflect.Ptr {
-		return nil, fmt.Errorf("subcommands must be pointers to structs but %s is a %s",
-			dest, t.Kind())
+	if err := resolveInherited(p.cmd, nil); err != nil {
+		return nil, err
 	}
+	p.specWarnings = append(tagWarnings, checkNoHelp(p.cmd)...)
 
-	t = t.Elem()
-	if t.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("subcommands must be pointers to structs but %s is a pointer to %s",
-			dest, t.Kind())
+	if config.ExperimentalEnv == "" {
+		if spec := findExperimental(p.cmd); spec != nil {
+			return nil, fmt.Errorf("%s: experimental options require Config.ExperimentalEnv", spec.dest)
+		}
 	}
 
-	cmd := command{
-		name: name,
-		dest: dest,
+	profileSpec, err := findTaggedSpec(p.cmd, "profile", func(s *spec) bool { return s.profile })
+	if err != nil {
+		return nil, err
 	}
+	p.profileSpec = profileSpec
 
-	var errs []string
-	walkFields(t, func(field reflect.StructField, t reflect.Type) bool {
-		// check for the ignore switch in the tag
-		tag := field.Tag.Get("arg")
-		if tag == "-" {
-			return false
-		}
+	confSpec, err := findTaggedSpec(p.cmd, "conffile", func(s *spec) bool { return s.conffile })
+	if err != nil {
+		return nil, err
+	}
+	p.confSpec = confSpec
 
-		// if this is an embedded struct then recurse into its fields, even if
-		// it is unexported, because exported fields on unexported embedded
-		// structs are still writable
-		if field.Anonymous && field.Type.Kind() == reflect.Struct {
-			return true
+	if config.HelpTemplate != "" {
+		tmpl, err := template.New("help").Parse(config.HelpTemplate)
+		if err == nil {
+			err = tmpl.Execute(io.Discard, p.helpData(p.cmd))
 		}
-
-		// ignore any other unexported field
-		if !isExported(field.Name) {
-			return false
+		if err != nil {
+			return nil, fmt.Errorf("error in HelpTemplate: %v", err)
 		}
+		p.helpTmpl = tmpl
+	}
 
-		// duplicate the entire path to avoid slice overwrites
-		subdest := dest.Child(field)
-		spec := spec{
-			dest:  subdest,
-			field: field,
-			long:  strings.ToLower(field.Name),
+	return &p, nil
+}
+
+// findExperimental returns the first option of the command or its subcommands
+// that is tagged "experimental", or nil if there is none
+func findExperimental(cmd *command) *spec {
+	for _, spec := range cmd.specs {
+		if spec.experimental {
+			return spec
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		if spec := findExperimental(subcmd); spec != nil {
+			return spec
 		}
+	}
+	return nil
+}
 
-		help, exists := field.Tag.Lookup("help")
-		if exists {
-			spec.help = help
+// resolveModes finds the option named by the setmode tag of each boolean of
+// the command, and checks that the value given in the tag can be assigned to
+// that option
+func resolveModes(cmd *command) error {
+	for _, spec := range cmd.specs {
+		if spec.setMode == "" {
+			continue
 		}
+		target := findOption(cmd.specs, spec.setMode)
+		if target == nil || target.long != spec.setMode {
+			return fmt.Errorf("%s: setmode refers to --%s but there is no such option", spec.dest, spec.setMode)
+		}
+		if target.cardinality != one || target.nargs > 0 || target.setMode != "" {
+			return fmt.Errorf("%s: setmode refers to --%s, which is not an option with a single value", spec.dest, spec.setMode)
+		}
+		if err := target.parse(reflect.New(target.field.Type).Elem(), spec.modeValue); err != nil {
+			return fmt.Errorf("%s: error processing setmode value %q: %v", spec.dest, spec.modeValue, err)
+		}
+		if choices := target.choiceList; choices != nil {
+			var found bool
+			for _, choice := range choices {
+				found = found || choice == spec.modeValue
+			}
+			if !found {
+				return fmt.Errorf("%s: setmode value %q is not one of %s", spec.dest, spec.modeValue, strings.Join(choices, ", "))
+			}
+		}
+		spec.modeTarget = target
+	}
+	return nil
+}
 
-		// Look at the tag
-		var isSubcommand bool // tracks whether this field is a subcommand
+// optionGroup is a set of arguments of a command that share a group tag, or
+// that are named by together tags. At most one of the options of an exclusive
+// group can be given, and either all or none of the arguments of a together
+// group, which may include positionals.
+type optionGroup struct {
+	name      string
+	exclusive bool
+	members   []*spec
+}
 
-		for _, key := range strings.Split(tag, ",") {
-			if key == "" {
-				continue
+// resolveGroups collects the arguments of the command that share a group tag
+// into groups, each of which must have at least two members that agree on
+// its mode. The together tag is shorthand for a together group of its field
+// and the fields that it names, so it joins those fields to the group of any
+// of them, or else to a new group.
+func resolveGroups(cmd *command) error {
+	groups := make(map[string]*optionGroup)
+	for _, s := range cmd.specs {
+		if s.groupName == "" {
+			continue
+		}
+		exclusive := s.groupMode != "together"
+		g, ok := groups[s.groupName]
+		if !ok {
+			g = &optionGroup{name: s.groupName, exclusive: exclusive}
+			groups[s.groupName] = g
+		} else if g.exclusive != exclusive {
+			return fmt.Errorf("%s: group %s is both exclusive and together", s.dest, s.groupName)
+		}
+		s.optionGroup = g
+	}
+
+	for _, s := range cmd.specs {
+		for _, name := range s.togetherNames {
+			var member *spec
+			for _, other := range cmd.specs {
+				if other.field.Name == name {
+					member = other
+				}
 			}
-			key = strings.TrimLeft(key, " ")
-			var value string
-			if pos := strings.Index(key, ":"); pos != -1 {
-				value = key[pos+1:]
-				key = key[:pos]
+			if member == nil {
+				return fmt.Errorf("%s: together refers to %s but there is no such field", s.dest, name)
+			}
+			if member == s {
+				return fmt.Errorf("%s: together cannot refer to the field itself", s.dest)
 			}
+			if err := joinTogether(cmd, s, member); err != nil {
+				return err
+			}
+		}
+	}
 
-			switch {
-			case strings.HasPrefix(key, "---"):
-				errs = append(errs, fmt.Sprintf("%s.%s: too many hyphens", t.Name(), field.Name))
-			case strings.HasPrefix(key, "--"):
-				spec.long = key[2:]
-			case strings.HasPrefix(key, "-"):
-				if len(key) > 2 {
-					errs = append(errs, fmt.Sprintf("%s.%s: short arguments must be one character only",
-						t.Name(), field.Name))
-					return false
-				}
-				spec.short = key[1:]
-			case key == "required":
-				spec.required = true
-			case key == "positional":
-				spec.positional = true
-			case key == "separate":
-				spec.separate = true
-			case key == "help": // deprecated
-				spec.help = value
-			case key == "env":
-				// Use override name if provided
-				if value != "" {
-					spec.env = value
-				} else {
-					spec.env = strings.ToUpper(field.Name)
-				}
-			case key == "subcommand":
-				// decide on a name for the subcommand
+	// the members are listed in the order of the fields, which is the order
+	// in which errors and the usage string refer to them
+	for _, s := range cmd.specs {
+		if g := s.optionGroup; g != nil {
+			g.members = nil
+		}
+	}
+	for _, s := range cmd.specs {
+		if g := s.optionGroup; g != nil {
+			g.members = append(g.members, s)
+		}
+	}
+	for _, s := range cmd.specs {
+		if s.optionGroup != nil && len(s.optionGroup.members) < 2 {
+			return fmt.Errorf("%s: group %s must have at least two members", s.dest, s.groupName)
+		}
+	}
+	return nil
+}
+
+// joinTogether puts the spec with a together tag and a spec that it names in
+// the same together group, merging their groups if both have one
+func joinTogether(cmd *command, s, member *spec) error {
+	for _, g := range []*optionGroup{s.optionGroup, member.optionGroup} {
+		if g != nil && g.exclusive {
+			return fmt.Errorf("%s: together cannot be used with the exclusive group %s", s.dest, g.name)
+		}
+	}
+	g, other := s.optionGroup, member.optionGroup
+	if g == nil {
+		g, other = other, nil
+	}
+	if g == nil {
+		g = &optionGroup{}
+	}
+	for _, spec := range cmd.specs {
+		if other != nil && spec.optionGroup == other {
+			spec.optionGroup = g
+		}
+	}
+	s.optionGroup, member.optionGroup = g, g
+	return nil
+}
+
+// collectTagWarnings returns the tagWarnings of the command and of its
+// subcommands
+func collectTagWarnings(cmd *command) []string {
+	warnings := append([]string(nil), cmd.tagWarnings...)
+	for _, subcmd := range cmd.subcommands {
+		warnings = append(warnings, collectTagWarnings(subcmd)...)
+	}
+	return warnings
+}
+
+// checkNoHelp returns a warning for each subcommand of the command tagged
+// "nohelp" that has no way of displaying help, because it has no -h or --help
+// option of its own and does not receive unparsed tokens
+func checkNoHelp(cmd *command) []string {
+	var warnings []string
+	for _, subcmd := range cmd.subcommands {
+		if subcmd.nohelp && subcmd.passthrough == nil && subcmd.unknown == nil &&
+			findOption(subcmd.specs, "help") == nil && findOption(subcmd.specs, "h") == nil {
+			warnings = append(warnings, fmt.Sprintf("subcommand %s is tagged nohelp but has no -h or --help option, so it cannot display help",
+				strings.Join(commandPath(subcmd), " ")))
+		}
+		warnings = append(warnings, checkNoHelp(subcmd)...)
+	}
+	return warnings
+}
+
+// resolveInherited finds, for each option of the command or its subcommands
+// that is tagged "inherit", the option with the same long name in the nearest
+// of the given ancestors, which are ordered from the top-level command down.
+// The options must have the same type.
+func resolveInherited(cmd *command, ancestors []*command) error {
+	for _, spec := range cmd.specs {
+		if !spec.inherit {
+			continue
+		}
+		if spec.long == "" || spec.positional {
+			return fmt.Errorf("%s: inherit can only be used with options that have a long name", spec.dest)
+		}
+		for i := len(ancestors) - 1; i >= 0 && spec.inheritFrom == nil; i-- {
+			spec.inheritFrom = findOption(ancestors[i].specs, spec.long)
+		}
+		if spec.inheritFrom == nil {
+			return fmt.Errorf("%s: inherit requires an option --%s in a parent command", spec.dest, spec.long)
+		}
+		if spec.inheritFrom.field.Type != spec.field.Type {
+			return fmt.Errorf("%s: cannot inherit --%s because it is %v in the parent command but %v here",
+				spec.dest, spec.long, spec.inheritFrom.field.Type, spec.field.Type)
+		}
+	}
+	ancestors = append(ancestors, cmd)
+	for _, subcmd := range cmd.subcommands {
+		if err := resolveInherited(subcmd, ancestors[:len(ancestors):len(ancestors)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findTaggedSpec returns the option for which tagged is true, of which there
+// may be at most one, and which must belong to the top-level command. The
+// name of the tag is used in errors.
+func findTaggedSpec(cmd *command, tag string, tagged func(*spec) bool) (*spec, error) {
+	var found *spec
+	for _, spec := range cmd.specs {
+		if !tagged(spec) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("only one field can be tagged %s but %s and %s both are", tag, found.dest.Name(), spec.dest.Name())
+		}
+		found = spec
+	}
+	var visit func(cmd *command) error
+	visit = func(cmd *command) error {
+		for _, spec := range cmd.specs {
+			if tagged(spec) {
+				return fmt.Errorf("%s: %s can only be used in the top-level command", spec.dest.Name(), tag)
+			}
+		}
+		for _, subcmd := range cmd.subcommands {
+			if err := visit(subcmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, subcmd := range cmd.subcommands {
+		if err := visit(subcmd); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// NewParserWithDefaults constructs a parser for dest after copying the non-zero
+// fields of defaults into dest, as described for MergeNonZero. This provides
+// typed default values as an alternative to default tags. Defaults for fields
+// of the top-level command are displayed in help text. The defaults must be a
+// pointer to a struct of the same type as dest.
+func NewParserWithDefaults(config Config, defaults, dest interface{}) (*Parser, error) {
+	if reflect.TypeOf(defaults) != reflect.TypeOf(dest) {
+		return nil, fmt.Errorf("defaults must have the same type as dest but %T is not %T", defaults, dest)
+	}
+	if err := MergeNonZero(dest, defaults); err != nil {
+		return nil, err
+	}
+	return NewParser(config, dest)
+}
+
+// ValidateSpec checks that dest, which is a pointer to a struct or the
+// reflect.Type of one, could be used to construct a parser. It runs the same
+// checks on fields, tags, positionals, and subcommands as NewParser without
+// requiring an instance of the struct, which makes it suitable for unit tests.
+// Problems with arg tags are reported as errors, as if Config.LenientTags
+// were not set.
+// Checks that depend on the values of fields, such as whether a non-zero field
+// can be formatted as a default value, are not performed.
+func ValidateSpec(dest interface{}) error {
+	t, ok := dest.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(dest)
+	}
+	if t == nil {
+		return errors.New("ValidateSpec requires a pointer to a struct")
+	}
+	if t.Kind() == reflect.Struct {
+		t = reflect.PtrTo(t)
+	}
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ValidateSpec requires a pointer to a struct but got %v", t)
+	}
+
+	cmd, err := cmdFromStruct("", path{}, t, true)
+	if err != nil {
+		return err
+	}
+	if err := resolveInherited(cmd, nil); err != nil {
+		return err
+	}
+	if _, err := findTaggedSpec(cmd, "profile", func(s *spec) bool { return s.profile }); err != nil {
+		return err
+	}
+	_, err = findTaggedSpec(cmd, "conffile", func(s *spec) bool { return s.conffile })
+	return err
+}
+
+// cmdFromStruct constructs the command for the destination struct that t
+// points to. Options in arg tags that are unknown are reported as errors, and
+// so are those that are malformed if strict is set. Otherwise the malformed
+// options are recorded in the tagWarnings of the command.
+func cmdFromStruct(name string, dest path, t reflect.Type, strict bool) (*command, error) {
+	// commands can only be created from pointers to structs
+	if t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("subcommands must be pointers to structs but %s is a %s",
+			dest, t.Kind())
+	}
+
+	t = t.Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("subcommands must be pointers to structs but %s is a pointer to %s",
+			dest, t.Kind())
+	}
+
+	cmd := command{
+		name: name,
+		dest: dest,
+	}
+	structType := t
+
+	var errs []string
+	walkFields(t, func(field reflect.StructField, t reflect.Type) bool {
+		// check for the ignore switch in the tag
+		tag := field.Tag.Get("arg")
+		if tag == "-" {
+			return false
+		}
+
+		// if this is an embedded struct then recurse into its fields, even if
+		// it is unexported, because exported fields on unexported embedded
+		// structs are still writable
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			return true
+		}
+
+		// ignore any other unexported field
+		if !isExported(field.Name) {
+			return false
+		}
+
+		// duplicate the entire path to avoid slice overwrites
+		subdest := dest.Child(field)
+		spec := spec{
+			dest:  subdest,
+			field: field,
+			long:  strings.ToLower(field.Name),
+			owner: dest,
+		}
+
+		help, exists := field.Tag.Lookup("help")
+		if !exists {
+			help, exists = fieldHelp(t, field.Name)
+		}
+		if exists {
+			spec.help = help
+		}
+		spec.promptText = field.Tag.Get("prompt")
+		spec.section = field.Tag.Get("section")
+
+		// Look at the tag
+		var isSubcommand bool     // tracks whether this field is a subcommand
+		var passthrough bool      // tracks whether this subcommand receives all remaining tokens
+		var templated bool        // tracks whether the default value is a template
+		var collectUnknown string // the field of this subcommand that collects unknown options
+		var noHelp bool           // tracks whether this subcommand handles -h and --help itself
+		var cardinalityTag string // overrides the cardinality inferred from the field type
+		var hasLong bool          // tracks whether the tag gave a long name, after which long names are aliases
+
+		var badTags []string // options in the tag that are unknown or malformed
+		var unknownTag bool  // whether any of badTags is unknown rather than malformed
+		for _, key := range strings.Split(tag, ",") {
+			if key == "" {
+				continue
+			}
+			key = strings.TrimLeft(key, " ")
+			var value string
+			var hasValue bool
+			if pos := strings.Index(key, ":"); pos != -1 {
+				value = key[pos+1:]
+				key = key[:pos]
+				hasValue = true
+			}
+
+			if hasValue && tagTakesNoValue[key] {
+				badTags = append(badTags, fmt.Sprintf("%s does not take a value but got %q", key, value))
+				if strict {
+					continue
+				}
+			}
+
+			switch {
+			case strings.HasPrefix(key, "---"):
+				errs = append(errs, fmt.Sprintf("%s.%s: too many hyphens", t.Name(), field.Name))
+			case strings.HasPrefix(key, "--") && hasLong:
+				spec.aliases = append(spec.aliases, key[2:])
+			case strings.HasPrefix(key, "--"):
+				spec.long = key[2:]
+				hasLong = true
+			case strings.HasPrefix(key, "-"):
+				if len(key) > 2 {
+					errs = append(errs, fmt.Sprintf("%s.%s: short arguments must be one character only",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.short = key[1:]
+			case key == "required":
+				spec.required = true
+			case key == "positional":
+				spec.positional = true
+			case key == "separate":
+				spec.separate = true
+			case key == "sep":
+				spec.sep = unescapeSep(value)
+				if spec.sep == "" {
+					spec.sep = ","
+				}
+			case key == "quoted":
+				spec.quoted = true
+			case key == "split":
+				spec.split = unescapeSep(value)
+			case key == "into":
+				spec.intoNames = strings.Split(value, "|")
+			case key == "profile":
+				spec.profile = true
+			case key == "counter":
+				spec.counter = true
+			case key == "conffile":
+				spec.conffile = true
+			case key == "strict":
+				spec.strict = true
+			case key == "kvsep":
+				if value == "" {
+					errs = append(errs, fmt.Sprintf("%s.%s: kvsep requires a separator, as in kvsep:=",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.kvsep = unescapeSep(value)
+			case key == "inverted":
+				spec.inverted = true
+			case key == "nargs":
+				nargs, err := strconv.Atoi(value)
+				if err != nil || nargs < 1 {
+					errs = append(errs, fmt.Sprintf("%s.%s: nargs must be a positive integer but got %q",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.nargs = nargs
+			case key == "index":
+				index, err := strconv.Atoi(value)
+				if err != nil || index < 0 {
+					errs = append(errs, fmt.Sprintf("%s.%s: index must be a non-negative integer but got %q",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.index = index
+				spec.hasIndex = true
+			case key == "help": // deprecated
+				spec.help = value
+			case key == "env":
+				if hasValue && value == "" {
+					badTags = append(badTags, "env: requires the name of an environment variable, or use env alone to derive it from the field name")
+					if strict {
+						continue
+					}
+				}
+				// Use override name if provided, which may list fallbacks in
+				// order of precedence, as in env:NEW_TOKEN|OLD_TOKEN
+				if strings.Contains(value, "|") {
+					names := strings.Split(value, "|")
+					for _, name := range names {
+						if !isIdentifier(name) {
+							errs = append(errs, fmt.Sprintf("%s.%s: %q is not a valid environment variable name",
+								t.Name(), field.Name, name))
+							return false
+						}
+					}
+					spec.env, spec.envFallbacks = names[0], names[1:]
+				} else if value != "" {
+					spec.env = value
+				} else {
+					spec.env = strings.ToUpper(field.Name)
+					spec.envDerived = true
+				}
+			case key == "noenv":
+				spec.noenv = true
+			case key == "encoding":
+				if !isBinaryEncoding(value) {
+					errs = append(errs, fmt.Sprintf("%s.%s: unknown encoding %q, expected base64, base64url, or hex",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.encoding = value
+			case key == "grouped":
+				spec.group = unescapeSep(value)
+				if spec.group == "" {
+					spec.group = ","
+				}
+			case key == "cardinality":
+				cardinalityTag = value
+			case key == "choicesfn":
+				if !hasChoicesMethod(structType, value) {
+					errs = append(errs, fmt.Sprintf("%s.%s: choicesfn:%s requires a method %s() []string",
+						t.Name(), field.Name, value, value))
+					return false
+				}
+				spec.choicesFn = value
+			case key == "choices":
+				spec.choiceList = strings.Split(value, "|")
+			case key == "foldcase":
+				spec.foldCase = true
+			case key == "clearable":
+				spec.clearable = true
+			case key == "negatable":
+				spec.negatable = true
+			case key == "default-policy":
+				if value != "append" && value != "replace" {
+					errs = append(errs, fmt.Sprintf("%s.%s: unknown default-policy %q, expected append or replace",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.appendDefault = value == "append"
+			case key == "fromfile":
+				spec.fromFile = true
+			case key == "listfile":
+				spec.listFile = strings.TrimLeft(value, "-")
+				if spec.listFile == "" {
+					errs = append(errs, fmt.Sprintf("%s.%s: listfile requires the name of an option, as in listfile:exclude-from",
+						t.Name(), field.Name))
+					return false
+				}
+			case key == "clock":
+				spec.clock = true
+			case key == "prompt":
+				if value != "" && value != "secret" {
+					errs = append(errs, fmt.Sprintf("%s.%s: unknown prompt mode %q, expected prompt or prompt:secret",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.prompt = true
+				spec.promptSecret = value == "secret"
+			case key == "unit":
+				if value != "si" && value != "iec" {
+					errs = append(errs, fmt.Sprintf("%s.%s: unknown unit %q, expected si or iec",
+						t.Name(), field.Name, value))
+					return false
+				}
+				spec.unit = value
+			case key == "hidden":
+				spec.hidden = true
+			case key == "experimental":
+				spec.experimental = true
+			case key == "deprecated":
+				spec.deprecated = true
+				spec.deprecation = value
+			case key == "terminal":
+				spec.terminal = true
+			case key == "inherit":
+				spec.inherit = true
+			case key == "required-if-env":
+				if !isIdentifier(value) {
+					errs = append(errs, fmt.Sprintf("%s.%s: required-if-env requires the name of an environment variable",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.requiredIfEnv = value
+			case key == "setmode":
+				pos := strings.Index(value, "=")
+				if pos <= 0 {
+					errs = append(errs, fmt.Sprintf("%s.%s: setmode must be of the form setmode:option=value",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.setMode, spec.modeValue = value[:pos], value[pos+1:]
+			case key == "together":
+				if value == "" {
+					errs = append(errs, fmt.Sprintf("%s.%s: together requires the names of other fields, as in together:Format",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.togetherNames = strings.Split(value, "|")
+			case key == "group":
+				spec.groupName, spec.groupMode = value, "exclusive"
+				if pos := strings.Index(value, "="); pos != -1 {
+					spec.groupName, spec.groupMode = value[:pos], value[pos+1:]
+				}
+				if spec.groupName == "" {
+					errs = append(errs, fmt.Sprintf("%s.%s: group requires the name of a group, as in group:format",
+						t.Name(), field.Name))
+					return false
+				}
+				if spec.groupMode != "exclusive" && spec.groupMode != "together" {
+					errs = append(errs, fmt.Sprintf("%s.%s: group mode must be exclusive or together but got %q",
+						t.Name(), field.Name, spec.groupMode))
+					return false
+				}
+			case key == "passthrough":
+				passthrough = true
+			case key == "template":
+				templated = true
+			case key == "nohelp":
+				noHelp = true
+			case key == "collectunknown":
+				collectUnknown = value
+				if collectUnknown == "" {
+					collectUnknown = "Unknown"
+				}
+			case key == "subcommand":
+				// decide on a name for the subcommand
 				cmdname := value
 				if cmdname == "" {
 					cmdname = strings.ToLower(field.Name)
 				}
 
 				// parse the subcommand recursively
-				subcmd, err := cmdFromStruct(cmdname, subdest, field.Type)
+				subcmd, err := cmdFromStruct(cmdname, subdest, field.Type, strict)
 				if err != nil {
 					errs = append(errs, err.Error())
 					return false
 				}
 
-				subcmd.parent = &cmd
-				subcmd.help = field.Tag.Get("help")
+				subcmd.parent = &cmd
+				subcmd.help = help
+
+				cmd.subcommands = append(cmd.subcommands, subcmd)
+				isSubcommand = true
+			default:
+				badTags = append(badTags, fmt.Sprintf("unknown option %q", key))
+				unknownTag = true
+			}
+		}
+		if len(badTags) > 0 {
+			msg := fmt.Sprintf("%s.%s: %s in arg tag; recognized options are -x, --name, %s",
+				t.Name(), field.Name, strings.Join(badTags, ", "), strings.Join(tagOptions, ", "))
+			if strict || unknownTag {
+				errs = append(errs, msg)
+				return false
+			}
+			cmd.tagWarnings = append(cmd.tagWarnings, msg)
+		}
+
+		if spec.noenv && spec.env != "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: env and noenv cannot be used together",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.hasIndex && !spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: index can only be used with positional arguments",
+				t.Name(), field.Name))
+			return false
+		}
+
+		placeholder, hasPlaceholder := field.Tag.Lookup("placeholder")
+		if hasPlaceholder {
+			spec.placeholder = placeholder
+		} else if spec.long != "" {
+			spec.placeholder = strings.ToUpper(spec.long)
+		} else {
+			spec.placeholder = strings.ToUpper(spec.field.Name)
+		}
+
+		// if this is a subcommand then we've done everything we need to do
+		if isSubcommand {
+			subcmd := cmd.subcommands[len(cmd.subcommands)-1]
+			subcmd.hidden = spec.hidden
+			subcmd.nohelp = noHelp
+			if passthrough {
+				if err := setPassthrough(subcmd); err != nil {
+					errs = append(errs, fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+				}
+			}
+			if collectUnknown != "" {
+				if err := setCollectUnknown(subcmd, collectUnknown); err != nil {
+					errs = append(errs, fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+				}
+			}
+			return false
+		}
+
+		if collectUnknown != "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: collectunknown can only be used with subcommands",
+				t.Name(), field.Name))
+			return false
+		}
+
+		// a []string field tagged passthrough collects the unknown options and
+		// extra positionals, which are then not errors
+		if passthrough {
+			if field.Type != reflect.TypeOf([]string{}) || spec.positional {
+				errs = append(errs, fmt.Sprintf("%s.%s: passthrough can only be used with subcommands and []string fields that are not positional",
+					t.Name(), field.Name))
+				return false
+			}
+			if cmd.rest != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: passthrough cannot be used on both %s and %s",
+					t.Name(), field.Name, cmd.rest.field.Name, field.Name))
+				return false
+			}
+			spec.long, spec.short, spec.env = "", "", ""
+			spec.noenv, spec.hidden = true, true
+			spec.cardinality = multiple
+			cmd.rest = &spec
+			cmd.specs = append(cmd.specs, &spec)
+			return false
+		}
+
+		if noHelp {
+			errs = append(errs, fmt.Sprintf("%s.%s: nohelp can only be used with subcommands",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.hidden && spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: positional arguments cannot be hidden",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.choicesFn != "" && (isBoolean(field.Type) || isMap(field.Type) || isStructSlice(field.Type) || spec.nargs > 0) {
+			errs = append(errs, fmt.Sprintf("%s.%s: choicesfn cannot be used with boolean, map, array, or struct slice fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.choiceList != nil && (isBoolean(field.Type) || isMap(field.Type) || isStructSlice(field.Type) || spec.nargs > 0) {
+			errs = append(errs, fmt.Sprintf("%s.%s: choices cannot be used with boolean, map, array, or struct slice fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.choiceList != nil && spec.choicesFn != "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: choices and choicesfn cannot be used together",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.foldCase && spec.choiceList == nil && spec.choicesFn == "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: foldcase can only be used with choices or choicesfn",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.foldCase {
+			if a, b, found := foldDuplicate(spec.choiceList); found {
+				errs = append(errs, fmt.Sprintf("%s.%s: foldcase cannot distinguish the choices %q and %q",
+					t.Name(), field.Name, a, b))
+				return false
+			}
+		}
+
+		if spec.fromFile && !isBytes(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: fromfile can only be used with []byte fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.unit != "" && !isInteger(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: unit can only be used with integer fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.clock && !isDuration(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: clock can only be used with time.Duration fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.prompt && (spec.positional || isBoolean(field.Type) || isMap(field.Type) || isStructSlice(field.Type) || spec.nargs > 0) {
+			errs = append(errs, fmt.Sprintf("%s.%s: prompt can only be used with options that take a value",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.experimental && spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: positional arguments cannot be experimental",
+				t.Name(), field.Name))
+			return false
+		}
+
+		for _, alias := range spec.aliases {
+			if alias == "" || spec.long == "" || spec.positional {
+				errs = append(errs, fmt.Sprintf("%s.%s: aliases can only be given for options with a long name",
+					t.Name(), field.Name))
+				return false
+			}
+		}
+
+		// an option without aliases is deprecated as a whole, and is then
+		// accepted but not listed in help text
+		if spec.deprecated {
+			if spec.positional {
+				errs = append(errs, fmt.Sprintf("%s.%s: deprecated can only be used with options",
+					t.Name(), field.Name))
+				return false
+			}
+			if len(spec.aliases) == 0 {
+				if spec.required {
+					errs = append(errs, fmt.Sprintf("%s.%s: deprecated options cannot be required",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.hidden = true
+			}
+		}
+
+		// slices of structs are populated one field at a time, as in
+		// --server.0.host, with indices of at most maxSliceIndex
+		if isStructSlice(field.Type) {
+			if spec.positional || spec.env != "" {
+				errs = append(errs, fmt.Sprintf("%s.%s: slices of structs cannot be positional or read from the environment",
+					t.Name(), field.Name))
+				return false
+			}
+			if _, hasDefault := field.Tag.Lookup("default"); hasDefault {
+				errs = append(errs, fmt.Sprintf("%s.%s: default values are not supported for slice or map fields",
+					t.Name(), field.Name))
+				return false
+			}
+			if spec.appendDefault {
+				errs = append(errs, fmt.Sprintf("%s.%s: default-policy:append can only be used with slice fields",
+					t.Name(), field.Name))
+				return false
+			}
+			elems, err := elemSpecsFromStruct(field.Type.Elem())
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+				return false
+			}
+			spec.cardinality = multiple
+			spec.elems = elems
+			cmd.specs = append(cmd.specs, &spec)
+			return false
+		}
+
+		if spec.group != "" && !isNumeric(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: grouped can only be used with numeric fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.quoted && spec.sep == "" {
+			errs = append(errs, fmt.Sprintf("%s.%s: quoted can only be used together with sep",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.group != "" && spec.group == spec.sep {
+			errs = append(errs, fmt.Sprintf("%s.%s: the grouping separator %q cannot also be the slice separator",
+				t.Name(), field.Name, spec.group))
+			return false
+		}
+
+		// arrays are populated from exactly as many tokens as their length
+		if spec.nargs > 0 {
+			if !isArrayOfLen(field.Type, spec.nargs) {
+				errs = append(errs, fmt.Sprintf("%s.%s: nargs:%d requires an array field of length %d",
+					t.Name(), field.Name, spec.nargs, spec.nargs))
+				return false
+			}
+			if defaultString, hasDefault := field.Tag.Lookup("default"); hasDefault {
+				if spec.required {
+					errs = append(errs, fmt.Sprintf("%s.%s: 'required' cannot be used when a default value is specified",
+						t.Name(), field.Name))
+					return false
+				}
+				spec.defaultString = defaultString
+				spec.defaultValue = reflect.New(field.Type).Elem()
+				if err := setArray(spec.defaultValue, spec.ungroupAll(strings.Fields(defaultString))); err != nil {
+					errs = append(errs, fmt.Sprintf("%s.%s: error processing default value: %v", t.Name(), field.Name, err))
+					return false
+				}
+			}
+			spec.cardinality = one
+			cmd.specs = append(cmd.specs, &spec)
+			return false
+		}
+
+		// check whether this field is supported. It's good to do this here rather than
+		// wait until ParseValue because it means that a program with invalid argument
+		// fields will always fail regardless of whether the arguments it received
+		// exercised those fields.
+		if isOptionalSequence(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: slices and maps of Optional are not supported",
+				t.Name(), field.Name))
+			return false
+		}
+
+		var err error
+		if cardinalityTag != "" {
+			spec.cardinality, err = overrideCardinality(field.Type, cardinalityTag)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+				return false
+			}
+		} else {
+			spec.cardinality, err = cardinalityOf(field.Type)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: %s fields are not supported",
+					t.Name(), field.Name, field.Type.String()))
+				return false
+			}
+		}
+
+		if spec.counter {
+			if spec.positional || !isInteger(field.Type) || field.Type.Kind() == reflect.Ptr {
+				errs = append(errs, fmt.Sprintf("%s.%s: counter can only be used with integer options",
+					t.Name(), field.Name))
+				return false
+			}
+			spec.cardinality = zero
+		}
+
+		if isOccurrences(field.Type) {
+			if spec.positional {
+				errs = append(errs, fmt.Sprintf("%s.%s: []arg.Occurrence fields cannot be positional",
+					t.Name(), field.Name))
+				return false
+			}
+			spec.separate = true
+		}
+
+		if spec.encoding != "" && !isBinaryUnmarshaler(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: encoding can only be used with fields that implement encoding.BinaryUnmarshaler",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.encoding == "" && needsEncoding(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: fields that implement encoding.BinaryUnmarshaler require an encoding, as in encoding:base64",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.sep != "" && spec.cardinality != multiple {
+			errs = append(errs, fmt.Sprintf("%s.%s: sep can only be used with slice or map fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.clearable && (spec.cardinality != multiple || spec.long == "" || spec.positional) {
+			errs = append(errs, fmt.Sprintf("%s.%s: clearable can only be used with slice or map options that have a long name",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.appendDefault && (spec.cardinality != multiple || isMap(field.Type) || isAppender(field.Type)) {
+			errs = append(errs, fmt.Sprintf("%s.%s: default-policy:append can only be used with slice fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.kvsep != "" && !isMap(field.Type) && !isAppender(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: kvsep can only be used with map fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.profile && (spec.positional || field.Type.Kind() != reflect.String) {
+			errs = append(errs, fmt.Sprintf("%s.%s: profile can only be used with string options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.conffile && (spec.positional || field.Type.Kind() != reflect.String) {
+			errs = append(errs, fmt.Sprintf("%s.%s: conffile can only be used with string options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.listFile != "" && (spec.positional || spec.cardinality != multiple || isMap(field.Type) || isAppender(field.Type)) {
+			errs = append(errs, fmt.Sprintf("%s.%s: listfile can only be used with slice options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.section != "" && spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: section can only be used with options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.groupName != "" && spec.groupMode == "exclusive" && spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: exclusive groups can only be used with options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.strict && !isMap(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: strict can only be used with map fields",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if (spec.split == "") != (len(spec.intoNames) == 0) {
+			errs = append(errs, fmt.Sprintf("%s.%s: split and into must be given together, as in split:x,into:Width|Height",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.split != "" {
+			if field.Type.Kind() != reflect.String {
+				errs = append(errs, fmt.Sprintf("%s.%s: split can only be used with string fields",
+					t.Name(), field.Name))
+				return false
+			}
+			for _, name := range spec.intoNames {
+				target, found := t.FieldByName(name)
+				if !found || !isExported(name) {
+					errs = append(errs, fmt.Sprintf("%s.%s: into refers to %s but there is no such field",
+						t.Name(), field.Name, name))
+					return false
+				}
+				if name == field.Name {
+					errs = append(errs, fmt.Sprintf("%s.%s: into cannot refer to the field itself",
+						t.Name(), field.Name))
+					return false
+				}
+				if c, err := cardinalityOf(target.Type); err != nil || c != one || isSetter(target.Type) {
+					errs = append(errs, fmt.Sprintf("%s.%s: into refers to %s, which cannot be parsed from a single value",
+						t.Name(), field.Name, name))
+					return false
+				}
+				// the index of the target is relative to the struct that
+				// contains this field, which may be embedded in the command
+				target.Index = append(append([]int{}, field.Index[:len(field.Index)-1]...), target.Index...)
+				spec.into = append(spec.into, dest.Child(target))
+			}
+		}
+
+		if spec.setMode != "" && (spec.cardinality != zero || spec.positional || spec.inverted) {
+			errs = append(errs, fmt.Sprintf("%s.%s: setmode can only be used with boolean options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.terminal && spec.positional {
+			errs = append(errs, fmt.Sprintf("%s.%s: terminal can only be used with options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.negatable && (spec.cardinality != zero || spec.positional || spec.long == "" || spec.inverted) {
+			errs = append(errs, fmt.Sprintf("%s.%s: negatable can only be used with boolean options that have a long name",
+				t.Name(), field.Name))
+			return false
+		}
+
+		if spec.inverted && (spec.cardinality != zero || spec.positional) {
+			errs = append(errs, fmt.Sprintf("%s.%s: inverted can only be used with boolean options",
+				t.Name(), field.Name))
+			return false
+		}
+
+		// inverted booleans are true unless the flag is present
+		defaultString, hasDefault := field.Tag.Lookup("default")
+		if spec.inverted && !hasDefault && !spec.required {
+			defaultString, hasDefault = "true", true
+		}
+		if templated && !hasDefault {
+			errs = append(errs, fmt.Sprintf("%s.%s: template requires a default value, as in default:\"{{.Dir}}/app.log\"",
+				t.Name(), field.Name))
+			return false
+		}
+		if hasDefault {
+			// setters are called with values rather than holding them
+			if isSetter(field.Type) {
+				errs = append(errs, fmt.Sprintf("%s.%s: default values are not supported for func(string) error fields",
+					t.Name(), field.Name))
+				return false
+			}
+
+			// we do not support default values for maps and slices
+			if spec.cardinality == multiple {
+				errs = append(errs, fmt.Sprintf("%s.%s: default values are not supported for slice or map fields",
+					t.Name(), field.Name))
+				return false
+			}
+
+			// a required field cannot also have a default value
+			if spec.required {
+				errs = append(errs, fmt.Sprintf("%s.%s: 'required' cannot be used when a default value is specified",
+					t.Name(), field.Name))
+				return false
+			}
+
+			// a template is rendered with the destination struct once the
+			// values of the other fields are known, so help text cannot show
+			// its value
+			spec.defaultString = defaultString
+			if templated {
+				spec.defaultString = "(computed)"
+				if spec.tmpl, err = template.New(field.Name).Parse(defaultString); err != nil {
+					errs = append(errs, fmt.Sprintf("%s.%s: error parsing template default: %v", t.Name(), field.Name, err))
+					return false
+				}
+				cmd.specs = append(cmd.specs, &spec)
+				return false
+			}
+
+			// parse the default value
+			if field.Type.Kind() == reflect.Ptr {
+				// here we have a field of type *T and we create a new T, no need to dereference
+				// in order for the value to be settable
+				spec.defaultValue = reflect.New(field.Type.Elem())
+			} else {
+				// here we have a field of type T and we create a new T and then dereference it
+				// so that the resulting value is settable
+				spec.defaultValue = reflect.New(field.Type).Elem()
+			}
+			err := spec.parse(spec.defaultValue, defaultString)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s.%s: error processing default value: %v", t.Name(), field.Name, err))
+				return false
+			}
+		}
+
+		// a field type may compute its own default when there is no default tag
+		if !hasDefault && spec.cardinality != multiple {
+			spec.defaultFunc = defaultProviderOf(field.Type)
+			if spec.defaultFunc != nil {
+				spec.defaultString = "(dynamic)"
+			}
+		}
+
+		// add the spec to the list of specs
+		cmd.specs = append(cmd.specs, &spec)
+
+		// if this was an embedded field then we already returned true up above
+		return false
+	})
+
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	// check that no two subcommands have the same name, including hidden ones
+	subcommandNames := make(map[string]*command)
+	for _, subcmd := range cmd.subcommands {
+		if other, found := subcommandNames[subcmd.name]; found {
+			return nil, fmt.Errorf("%s: subcommands %s and %s have the same name %s",
+				dest, other.dest.Name(), subcmd.dest.Name(), subcmd.name)
+		}
+		subcommandNames[subcmd.name] = subcmd
+	}
+
+	// check that we don't have both positionals and subcommands
+	var hasPositional bool
+	for _, spec := range cmd.specs {
+		if spec.positional {
+			hasPositional = true
+		}
+	}
+	if hasPositional && len(cmd.subcommands) > 0 {
+		return nil, fmt.Errorf("%s cannot have both subcommands and positional arguments", dest)
+	}
+
+	if err := orderPositionals(&cmd); err != nil {
+		return nil, fmt.Errorf("%s: %v", dest, err)
+	}
+
+	if err := resolveModes(&cmd); err != nil {
+		return nil, err
+	}
+
+	if err := resolveGroups(&cmd); err != nil {
+		return nil, err
+	}
+
+	if err := resolveTemplates(&cmd); err != nil {
+		return nil, err
+	}
+
+	// the option named by a listfile tag cannot also be a regular option
+	for _, spec := range cmd.specs {
+		if spec.listFile != "" && findOption(cmd.specs, spec.listFile) != nil {
+			return nil, fmt.Errorf("%s: listfile names --%s, which is already an option", spec.dest, spec.listFile)
+		}
+	}
+
+	// check that no two positionals have the same name in the help text
+	positionalNames := make(map[string]*spec)
+	for _, spec := range cmd.specs {
+		if !spec.positional {
+			continue
+		}
+		if other, found := positionalNames[spec.placeholder]; found {
+			return nil, fmt.Errorf("%s: positionals %s and %s have the same name %s",
+				dest, other.field.Name, spec.field.Name, spec.placeholder)
+		}
+		positionalNames[spec.placeholder] = spec
+	}
+
+	// a map or slice positional consumes all remaining tokens, so it must
+	// come last, and since positionals are filled in order, a required
+	// positional cannot follow one that is not required, which may be
+	// omitted. A required slice or map positional may still follow them, as
+	// it always could, since it receives whatever tokens they leave.
+	var lastPositional, optionalPositional *spec
+	for _, spec := range cmd.specs {
+		if !spec.positional {
+			continue
+		}
+		if lastPositional != nil {
+			kind := "slice"
+			if isMap(lastPositional.field.Type) {
+				kind = "map"
+			}
+			return nil, fmt.Errorf("%s: %s positional %s must be the last positional but %s follows it",
+				dest, kind, lastPositional.field.Name, spec.field.Name)
+		}
+		if spec.required && spec.cardinality != multiple && optionalPositional != nil {
+			return nil, fmt.Errorf("%s: required positional %s cannot follow the optional positional %s",
+				dest, spec.field.Name, optionalPositional.field.Name)
+		}
+		if spec.cardinality == multiple {
+			lastPositional = spec
+		}
+		if !spec.required && optionalPositional == nil {
+			optionalPositional = spec
+		}
+	}
+
+	return &cmd, nil
+}
+
+// tagOptions lists the options recognized in arg tags other than the short and
+// long names of an option, for use in errors about unknown options. It must
+// list every key handled by cmdFromStruct, which TestTagOptions checks.
+var tagOptions = []string{
+	"cardinality", "choices", "choicesfn", "clearable", "clock", "collectunknown",
+	"conffile", "counter", "default-policy", "deprecated", "encoding", "env",
+	"experimental", "foldcase", "fromfile", "group", "grouped", "help", "hidden",
+	"index", "inherit", "into", "inverted", "kvsep", "listfile", "nargs", "negatable",
+	"noenv", "nohelp", "passthrough", "positional", "profile", "prompt", "quoted",
+	"required", "required-if-env", "sep", "separate", "setmode", "split", "strict", "subcommand",
+	"template", "terminal", "together", "unit",
+}
+
+// tagTakesNoValue holds the options in arg tags that are given without a value
+var tagTakesNoValue = map[string]bool{
+	"clearable":    true,
+	"negatable":    true,
+	"clock":        true,
+	"conffile":     true,
+	"counter":      true,
+	"experimental": true,
+	"foldcase":     true,
+	"fromfile":     true,
+	"hidden":       true,
+	"inherit":      true,
+	"inverted":     true,
+	"noenv":        true,
+	"nohelp":       true,
+	"passthrough":  true,
+	"positional":   true,
+	"profile":      true,
+	"quoted":       true,
+	"template":     true,
+	"terminal":     true,
+	"required":     true,
+	"separate":     true,
+	"strict":       true,
+}
+
+// unescapeSep allows separators that cannot easily be written in a struct tag,
+// so that \t in a tag means a tab character
+func unescapeSep(s string) string {
+	if s == `\t` {
+		return "\t"
+	}
+	return s
+}
+
+// setPassthrough designates the single []string field of a subcommand as the
+// receiver of all the tokens that follow the subcommand on the command line
+func setPassthrough(cmd *command) error {
+	if len(cmd.subcommands) > 0 {
+		return errors.New("passthrough subcommands cannot have subcommands")
+	}
+	stringSlice := reflect.TypeOf([]string{})
+	for _, spec := range cmd.specs {
+		if spec.field.Type != stringSlice {
+			continue
+		}
+		if cmd.passthrough != nil {
+			return fmt.Errorf("passthrough subcommands must have exactly one []string field but %s has %s and %s",
+				cmd.name, cmd.passthrough.field.Name, spec.field.Name)
+		}
+		cmd.passthrough = spec
+	}
+	if cmd.passthrough == nil {
+		return fmt.Errorf("passthrough subcommands must have exactly one []string field but %s has none", cmd.name)
+	}
+	for _, spec := range cmd.specs {
+		if spec.positional && spec != cmd.passthrough {
+			return fmt.Errorf("passthrough subcommands cannot have other positionals but %s has %s",
+				cmd.name, spec.field.Name)
+		}
+	}
+	// the remaining tokens are displayed like positionals in help text
+	cmd.passthrough.positional = true
+	return nil
+}
+
+// setCollectUnknown designates the []string field with the given name as the
+// field in which unknown options given to the subcommand are collected. The
+// field is not itself an option.
+func setCollectUnknown(cmd *command, name string) error {
+	for _, spec := range cmd.specs {
+		if spec.field.Name != name {
+			continue
+		}
+		if spec.field.Type != reflect.TypeOf([]string{}) || spec.positional {
+			return fmt.Errorf("collectunknown requires %s to be a []string field that is not positional", name)
+		}
+		spec.long, spec.short, spec.env = "", "", ""
+		spec.noenv, spec.hidden = true, true
+		cmd.unknown = spec
+		return nil
+	}
+	return fmt.Errorf("collectunknown requires a []string field named %s in subcommand %s", name, cmd.name)
+}
+
+// deriveEnv assigns an environment variable to each option of the command and
+// its subcommands that does not already have one, as described for Config.AutoEnv
+func deriveEnv(cmd *command) {
+	for _, spec := range cmd.specs {
+		if spec.env == "" && !spec.noenv && !spec.positional && spec.elems == nil {
+			spec.env = strings.ToUpper(strings.Join(splitWords(spec.field.Name), "_"))
+			spec.envDerived = true
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		deriveEnv(subcmd)
+	}
+}
+
+// caseEnv converts the environment variables that were derived from field
+// names, rather than given in env tags, to the case given by Config.EnvCase
+func caseEnv(cmd *command, c EnvCase) {
+	for _, spec := range cmd.specs {
+		if !spec.envDerived {
+			continue
+		}
+		// AutoEnv separates words with underscores but an env tag does not
+		name := spec.field.Name
+		if spec.env != strings.ToUpper(name) {
+			name = strings.Join(splitWords(name), "_")
+		}
+		switch c {
+		case EnvCaseLower:
+			spec.env = strings.ToLower(name)
+		case EnvCaseAsIs:
+			spec.env = name
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		caseEnv(subcmd, c)
+	}
+}
+
+// deriveShorts assigns a short name to each option of the command and its
+// subcommands that does not already have one, as described for
+// Config.AutoShortFlags. The used names include those of ancestor commands,
+// since their options may also appear after a subcommand.
+func deriveShorts(cmd *command, inherited map[string]bool) {
+	used := make(map[string]bool)
+	for name := range inherited {
+		used[name] = true
+	}
+	for _, spec := range cmd.specs {
+		if spec.short != "" {
+			used[spec.short] = true
+		}
+	}
+	for _, spec := range cmd.specs {
+		if spec.short != "" || spec.long == "" || spec.positional {
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(spec.field.Name)
+		short := string(unicode.ToLower(r))
+		if !used[short] {
+			spec.short = short
+			used[short] = true
+		}
+	}
+	for _, subcmd := range cmd.subcommands {
+		deriveShorts(subcmd, used)
+	}
+}
+
+// isIdentifier returns true if the name consists of letters, digits, and
+// underscores, and does not start with a digit
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'):
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// splitWords splits a camel case identifier into words, keeping acronyms
+// together, so that "APIKey" becomes "API" and "Key"
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		cur, prev := runes[i], runes[i-1]
+		switch {
+		case cur == '_':
+			if start < i {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case unicode.IsUpper(cur) && start < i:
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// hasChoicesMethod returns true if a pointer to the struct t has a method with
+// the given name of the form func() []string, as required by the choicesfn tag
+func hasChoicesMethod(t reflect.Type, name string) bool {
+	m, ok := reflect.PtrTo(t).MethodByName(name)
+	if !ok {
+		return false
+	}
+	return m.Type.NumIn() == 1 && m.Type.NumOut() == 1 && m.Type.Out(0) == reflect.TypeOf([]string(nil))
+}
+
+// isStructSlice returns true if the type is a slice whose elements are structs
+// that are populated field by field rather than parsed from a single token
+func isStructSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	return !canParse(t.Elem()) && !isTextUnmarshaler(t.Elem()) && !isJSONOnly(t.Elem())
+}
+
+// elemSpecsFromStruct creates a spec for each field of the element type of a
+// slice of structs. Only the --long name of these fields can be customized.
+func elemSpecsFromStruct(t reflect.Type) ([]*spec, error) {
+	var elems []*spec
+	var errs []string
+	walkFields(t, func(field reflect.StructField, owner reflect.Type) bool {
+		tag := field.Tag.Get("arg")
+		if tag == "-" {
+			return false
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			return true
+		}
+		if !isExported(field.Name) {
+			return false
+		}
+
+		elem := spec{
+			field: field,
+			long:  strings.ToLower(field.Name),
+		}
+		for _, key := range strings.Split(tag, ",") {
+			key = strings.TrimLeft(key, " ")
+			switch {
+			case key == "":
+			case strings.HasPrefix(key, "--") && !strings.HasPrefix(key, "---"):
+				elem.long = key[2:]
+			default:
+				errs = append(errs, fmt.Sprintf("%s.%s: only --long names are supported on fields of slice elements",
+					owner.Name(), field.Name))
+				return false
+			}
+		}
+
+		var err error
+		elem.cardinality, err = cardinalityOf(field.Type)
+		if err != nil || elem.cardinality == multiple || needsEncoding(field.Type) {
+			errs = append(errs, fmt.Sprintf("%s.%s: %s fields are not supported in slice elements",
+				owner.Name(), field.Name, field.Type.String()))
+			return false
+		}
+		elem.placeholder = strings.ToUpper(elem.long)
+		elems = append(elems, &elem)
+		return false
+	})
+
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s has no fields that can be parsed", t)
+	}
+	return elems, nil
+}
+
+// orderPositionals sorts the positionals of a command according to their index
+// tags, if any. The positionals either all have an index or none of them do,
+// and the indices must be contiguous, starting at 0 or 1. Options keep their
+// position relative to each other.
+func orderPositionals(cmd *command) error {
+	var slots []int
+	var positionals []*spec
+	var indexed []string
+	var unindexed []string
+	for i, spec := range cmd.specs {
+		if !spec.positional {
+			continue
+		}
+		slots = append(slots, i)
+		positionals = append(positionals, spec)
+		if spec.hasIndex {
+			indexed = append(indexed, spec.field.Name)
+		} else {
+			unindexed = append(unindexed, spec.field.Name)
+		}
+	}
+
+	if len(indexed) == 0 {
+		return nil
+	}
+	if len(unindexed) > 0 {
+		return fmt.Errorf("positional %s has no index but positional %s does; either all positionals or none must have an index",
+			unindexed[0], indexed[0])
+	}
+
+	sort.SliceStable(positionals, func(i, j int) bool {
+		return positionals[i].index < positionals[j].index
+	})
+
+	base := positionals[0].index
+	if base > 1 {
+		return fmt.Errorf("positional indices must start at 0 or 1 but the lowest is %d", base)
+	}
+	for i, spec := range positionals {
+		if i > 0 && spec.index == positionals[i-1].index {
+			return fmt.Errorf("positionals %s and %s have the same index %d",
+				positionals[i-1].field.Name, spec.field.Name, spec.index)
+		}
+		if spec.index != base+i {
+			return fmt.Errorf("positional indices must be contiguous but index %d is missing", base+i)
+		}
+	}
+
+	for i, slot := range slots {
+		cmd.specs[slot] = positionals[i]
+	}
+	return nil
+}
+
+// Freeze prevents any further changes to the configuration of the parser.
+// After Freeze is called, methods that register additional behavior with the
+// parser, such as RegisterChoices, return ErrFrozen. Parse may still be called
+// any number of times. Freeze covers only the parser itself: RegisterParser and
+// RegisterParserFor are global, and continue to affect parsers constructed
+// afterwards.
+func (p *Parser) Freeze() {
+	p.frozen = true
+}
+
+// Frozen returns true if Freeze has been called
+func (p *Parser) Frozen() bool {
+	return p.frozen
+}
+
+// beginParse returns ErrParsed if arguments were already processed since the
+// parser was created or reset, as described for Config.AllowReparse, and
+// otherwise records that they are about to be
+func (p *Parser) beginParse() error {
+	if p.parsed && !p.config.AllowReparse {
+		return ErrParsed
+	}
+	p.parsed = true
+	return nil
+}
+
+// Reset returns the parser to the state it was in after NewParser, so that
+// Parse may be called again. The options of the destination structs are set
+// to their zero values, except for func(string) error fields, and subcommands
+// are cleared, unless Config.IgnoreDefault is set. Default values are applied
+// again by the next call to Parse.
+func (p *Parser) Reset() {
+	p.parsed = false
+	p.lastCmd = nil
+	p.sources = nil
+	p.envSources = nil
+	p.profile = ""
+	p.order = nil
+	p.warnings = nil
+	p.deprecations = nil
+	p.rest = nil
+
+	if p.config.IgnoreDefault {
+		return
+	}
+	for _, spec := range p.cmd.specs {
+		if isSetter(spec.field.Type) {
+			continue // the func receives values rather than holding them
+		}
+		v := p.val(spec.dest)
+		v.Set(reflect.Zero(v.Type()))
+	}
+	for _, subcmd := range p.cmd.subcommands {
+		v := p.val(subcmd.dest)
+		v.Set(reflect.Zero(v.Type()))
+	}
+	p.storeBuilt()
+}
+
+// checkFrozen returns an error naming the given method if the parser is frozen
+func (p *Parser) checkFrozen(method string) error {
+	if p.frozen {
+		return fmt.Errorf("%s: %w", method, ErrFrozen)
+	}
+	return nil
+}
+
+// ParsePartial is like Parse except that arguments it does not recognize are
+// returned rather than rejected, so that they can be given to another parser.
+// These are unknown options, including any value attached with "=", tokens
+// that are not subcommands where a subcommand is expected, and positionals
+// beyond those of the destination structs. They are returned in their
+// original order, preceded by "--" if some of them followed a "--" in args.
+// A value given to an unknown option as a separate token is treated as a
+// positional. The builtin -h, --help, and --version options are handled as
+// they are by Parse.
+func (p *Parser) ParsePartial(args []string) ([]string, error) {
+	p.partial = true
+	defer func() { p.partial = false }()
+	err := p.Parse(args)
+	return p.rest, err
+}
+
+// Parse processes the given command line option, storing the results in the field
+// of the structs from which NewParser was constructed
+func (p *Parser) Parse(args []string) error {
+	if err := p.beginParse(); err != nil {
+		return err
+	}
+
+	if p.config.AllowResponseFiles {
+		if err := p.checkArgsBytes(args); err != nil {
+			return err
+		}
+		var err error
+		args, err = p.expandResponseFiles(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.config.Preprocess != nil {
+		if err := p.checkArgsBytes(args); err != nil {
+			return err
+		}
+		var err error
+		args, err = p.config.Preprocess(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := p.process(args)
+	var terminal *TerminalError
+	var completion *CompletionRequestError
+	if errors.Is(err, ErrArgsTooLong) || errors.As(err, &terminal) || errors.As(err, &completion) {
+		return err
+	}
+	if err != nil && (p.lastCmd == nil || !p.lastCmd.nohelp) {
+		// If -h or --help were specified then make sure help text supercedes other errors
+		for _, arg := range args {
+			if arg == "-h" || arg == "--help" {
+				return ErrHelp
+			}
+			if p.isHelpAll(arg, p.cmd.specs) {
+				return ErrHelpAll
+			}
+			if arg == "--" {
+				break
+			}
+		}
+		if p.config.UsageErrors {
+			return &UsageError{Err: err, Usage: p.errorUsage(p.lastCmd)}
+		}
+	}
+	return err
+}
+
+func (p *Parser) MustParse(args []string) {
+	err := p.Parse(args)
+	for _, msg := range p.deprecations {
+		_, _ = fmt.Fprintln(p.warningDest, "warning:", msg)
+	}
+	var terminal *TerminalError
+	var completion *CompletionRequestError
+	switch {
+	case errors.As(err, &terminal):
+		// the program checks the field of the terminal option itself
+	case errors.As(err, &completion):
+		if err := p.WriteCompletion(p.config.HelpDestination, completion.Shell); err != nil {
+			p.failWithError(err, p.lastCmd)
+			return
+		}
+		p.config.Exit(0)
+	case errors.Is(err, ErrHelpAll):
+		p.writeHelpForSubcommand(p.config.HelpDestination, p.lastCmd, true)
+		p.config.Exit(0)
+	case errors.Is(err, ErrHelp):
+		p.writeHelpForSubcommand(p.config.HelpDestination, p.lastCmd, false)
+		p.config.Exit(0)
+	case errors.Is(err, ErrVersion):
+		_, _ = fmt.Fprintln(p.config.HelpDestination, p.versionFor(p.lastCmd))
+		p.config.Exit(0)
+	case err != nil:
+		// the usage text is written by failWithSubcommand
+		var usageErr *UsageError
+		if errors.As(err, &usageErr) {
+			err = usageErr.Err
+		}
+		p.failWithError(err, p.lastCmd)
+	}
+}
+
+// lookupEnv returns the value of the given environment variable, taking into
+// account Config.IgnoreEnv, Config.Environment, Config.EnvAllowlist, and
+// Config.TreatEmptyEnvAsUnset
+func (p *Parser) lookupEnv(name string) (string, bool) {
+	if !p.envAllowed(name) {
+		return "", false
+	}
+
+	var value string
+	var found bool
+
+	if !p.config.IgnoreEnv {
+		value, found = os.LookupEnv(name)
+	}
+
+	if p.config.Environment != nil {
+		value, found = p.config.Environment[name]
+	}
+
+	if value == "" && p.config.TreatEmptyEnvAsUnset {
+		return "", false
+	}
+	return value, found
+}
+
+// experimentalEnabled returns true if the environment variable named by
+// Config.ExperimentalEnv is set to a true value
+func (p *Parser) experimentalEnabled() bool {
+	if p.config.ExperimentalEnv == "" {
+		return false
+	}
+	value, _ := p.lookupEnv(p.config.ExperimentalEnv)
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && enabled
+}
+
+// helpAllFlag returns the long name of the builtin option that displays help
+// for every option, or the empty string if it is disabled
+func (p *Parser) helpAllFlag() string {
+	switch p.config.HelpAllFlag {
+	case "":
+		return "help-all"
+	case "-":
+		return ""
+	default:
+		return p.config.HelpAllFlag
+	}
+}
+
+// isHelpAll returns true if the given token is the builtin option described
+// for Config.HelpAllFlag, which is not the case if one of the given options
+// has the same name
+func (p *Parser) isHelpAll(token string, specs []*spec) bool {
+	name := p.helpAllFlag()
+	return name != "" && token == "--"+name && findOption(specs, name) == nil
+}
+
+// completionShell returns the shell named by the given token if it is one of
+// the builtin options described for Config.CompletionFlag, which is not the
+// case if one of the given options has the same name
+func (p *Parser) completionShell(token string, specs []*spec) (string, bool) {
+	if p.config.CompletionFlag == "" {
+		return "", false
+	}
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		name := p.config.CompletionFlag + "-" + shell
+		if token == "--"+name && findOption(specs, name) == nil {
+			return shell, true
+		}
+	}
+	return "", false
+}
+
+// isHidden returns true if the given spec is omitted from help and usage text
+func (p *Parser) isHidden(spec *spec) bool {
+	return spec.hidden || (spec.experimental && !p.experimentalEnabled())
+}
+
+// envAllowed returns true if the given environment variable may be consulted,
+// as described for Config.EnvAllowlist
+func (p *Parser) envAllowed(name string) bool {
+	if len(p.config.EnvAllowlist) == 0 {
+		return true
+	}
+	for _, allowed := range p.config.EnvAllowlist {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// process environment vars for the given arguments
+func (p *Parser) captureEnvVars(specs []*spec, wasPresent map[*spec]bool) error {
+	for _, spec := range specs {
+		if spec.env == "" {
+			continue
+		}
+
+		// the first of the environment variables that is set wins
+		var env, value string
+		var found, appended bool
+		names := p.envNames(spec)
+		for i, name := range names {
+			if value, found = p.lookupEnv(name); found {
+				env = name
+				if i > 0 {
+					p.warn("using environment variable %s for %s because %s is not set", name, spec.dest.Name(), names[0])
+				}
+				for _, other := range names[i+1:] {
+					if _, ok := p.lookupEnv(other); ok {
+						p.warn("environment variable %s is ignored because %s is set", other, name)
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		p.envSources[spec] = env
+
+		if spec.nargs > 0 {
+			// arrays are read from a CSV string just like slices
+			values, err := csv.NewReader(strings.NewReader(value)).Read()
+			if err == nil {
+				err = setArray(p.val(spec.dest), spec.ungroupAll(values))
+			}
+			if err != nil {
+				return fmt.Errorf("error processing environment variable %s: %v", env, err)
+			}
+		} else if spec.cardinality == multiple {
+			// expect a CSV string in an environment
+			// variable in the case of multiple values
+			var values []string
+			var err error
+			if len(strings.TrimSpace(value)) > 0 {
+				values, err = csv.NewReader(strings.NewReader(value)).Read()
+				if err != nil {
+					return fmt.Errorf(
+						"error reading a CSV string from environment variable %s with multiple values: %v",
+						env,
+						err,
+					)
+				}
+			}
+			if spec.strict {
+				err = checkDuplicateKeys(spec.field.Type, values, spec.kvsep, make(map[interface{}]bool))
+			}
+			if err == nil {
+				err = p.checkChoices(spec, values...)
+			}
+			clear := !spec.separate
+			if err == nil && p.resetToDefault(spec) {
+				clear = false
+				appended = true
+			}
+			if err == nil {
+				err = setSliceOrMap(p.val(spec.dest), spec.ungroupAll(values), clear, spec.kvsep)
+			}
+			if err != nil {
+				return fmt.Errorf(
+					"error processing environment variable %s with multiple values: %v",
+					env,
+					err,
+				)
+			}
+		} else {
+			var err error
+			if spec.inverted {
+				value, err = invertBool(value)
+			}
+			if err == nil {
+				value, err = p.matchChoice(spec, value)
+			}
+			if err == nil {
+				value, err = spec.readFile(value)
+			}
+			if err == nil {
+				err = spec.parse(p.val(spec.dest), value)
+			}
+			if err != nil {
+				return fmt.Errorf("error processing environment variable %s: %v", env, err)
+			}
+		}
+		wasPresent[spec] = true
+		p.sources[spec] = SourceEnv
+		if appended {
+			p.sources[spec] = SourceAppended
+		}
+	}
+
+	return nil
+}
+
+// checkArgsBytes returns an error wrapping ErrArgsTooLong if the combined
+// length of the given arguments exceeds Config.MaxTotalArgsBytes
+func (p *Parser) checkArgsBytes(args []string) error {
+	var total int
+	for _, arg := range args {
+		total += len(arg)
+	}
+	return p.checkTotalBytes(total)
+}
+
+// checkTotalBytes returns an error wrapping ErrArgsTooLong if the given number
+// of bytes exceeds Config.MaxTotalArgsBytes
+func (p *Parser) checkTotalBytes(total int) error {
+	if limit := p.config.MaxTotalArgsBytes; limit > 0 && total > limit {
+		return fmt.Errorf("%w: their combined length exceeds the limit of %d bytes", ErrArgsTooLong, limit)
+	}
+	return nil
+}
+
+// process goes through arguments one-by-one, parses them, and assigns the result to
+// the underlying struct field
+func (p *Parser) process(args []string) error {
+	defer p.storeBuilt()
+
+	if err := p.checkArgsBytes(args); err != nil {
+		return err
+	}
+
+	// track the options we have seen
+	wasPresent := make(map[*spec]bool)
+
+	// track the keys given for maps tagged "strict"
+	mapKeys := make(map[*spec]map[interface{}]bool)
+
+	// appended records the slices tagged default-policy:append whose default
+	// has received values from the command line, and emptied records the
+	// slices that were emptied with --no-name, after which values are no
+	// longer appended to the default
+	appended := make(map[*spec]bool)
+	emptied := make(map[*spec]bool)
+
+	// given records the multiple-value options that have received values from
+	// the command line, after which further occurrences append to them, as do
+	// the listfile options of slices
+	given := make(map[*spec]bool)
+
+	// counted records the counters that have been given on the command line,
+	// which start from zero rather than from the environment
+	counted := make(map[*spec]bool)
+	p.sources = make(map[*spec]Source)
+	p.envSources = make(map[*spec]string)
+	p.order = nil
+	p.warnings = nil
+	p.deprecations = nil
+	p.rest = nil
+
+	// leftover holds the indices of the arguments that ParsePartial returns,
+	// and terminator is the index of the first "--", if any
+	var leftover []int
+	terminator := -1
+
+	// terminal is the option tagged "terminal" that stopped parsing, if any,
+	// as it was given
+	var terminal *spec
+	var terminalFlag string
+
+	// the profile must be known before any environment variables are read
+	p.profile = p.resolveProfile(args)
+
+	// union of specs for the chain of subcommands encountered so far
+	curCmd := p.cmd
+	p.lastCmd = curCmd
+
+	// make a copy of the specs because we will add to this list each time we expand a subcommand
+	specs := make([]*spec, len(curCmd.specs))
+	copy(specs, curCmd.specs)
+
+	// deal with environment vars
+	if !p.config.IgnoreEnv || p.config.Environment != nil {
+		err := p.captureEnvVars(specs, wasPresent)
+		if err != nil {
+			return err
+		}
+	}
+
+	// determine if the current command has a version option spec
+	var hasVersionOption bool
+	for _, spec := range curCmd.specs {
+		if spec.long == "version" {
+			hasVersionOption = true
+			break
+		}
+	}
+
+	// process each string from the command line
+	var allpositional bool
+	var positionals []string
+	var positionalEvents []int  // index of the event recorded for each positional
+	var positionalIndices []int // index in args of each positional
+
+	// must use explicit for loop, not range, because we manipulate i inside the loop
+	for i := 0; i < len(args) && terminal == nil; i++ {
+		arg := args[i]
+		if arg == "--" {
+			allpositional = true
+			if terminator < 0 {
+				terminator = i
+			}
+			p.record(EventTerminator, arg, "")
+			continue
+		}
+
+		if !isFlag(arg) || allpositional {
+			// each subcommand can have either subcommands or positionals, but not both
+			if len(curCmd.subcommands) == 0 {
+				positionals = append(positionals, arg)
+				positionalEvents = append(positionalEvents, p.record(EventPositional, arg, "", arg))
+				positionalIndices = append(positionalIndices, i)
+				if p.config.NoInterspersedFlags {
+					allpositional = true
+				}
+				continue
+			}
+
+			// if we have a subcommand then make sure it is valid for the current context
+			// a word that is not a subcommand is collected only once a
+			// subcommand has been chosen, so that a mistyped subcommand name
+			// is reported rather than collected, except by ParsePartial,
+			// which leaves it for another parser
+			subcmd := findSubcommand(curCmd.subcommands, arg)
+			if subcmd == nil && (p.partial || curCmd != p.cmd) && p.collectsUnknown(curCmd) {
+				leftover = append(leftover, i)
+				continue
+			}
+			if subcmd == nil {
+				if p.config.SuggestSubcommands {
+					var names []string
+					for _, cmd := range curCmd.subcommands {
+						if !cmd.hidden {
+							names = append(names, cmd.name)
+						}
+					}
+					return &UnknownArgError{Arg: arg, Subcommand: true, Suggestions: suggest(arg, names, 1)}
+				}
+				return &UnknownArgError{Arg: arg, Subcommand: true}
+			}
+
+			// instantiate the field to point to a new struct
+			v := p.val(subcmd.dest)
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem())) // we already checked that all subcommands are struct pointers
+			}
+
+			// add the new options to the set of allowed options
+			if p.config.StrictSubcommands {
+				specs = make([]*spec, len(subcmd.specs))
+				copy(specs, subcmd.specs)
+			} else {
+				specs = append(specs, subcmd.specs...)
+			}
+
+			// capture environment vars for these new options
+			if !p.config.IgnoreEnv || p.config.Environment != nil {
+				err := p.captureEnvVars(subcmd.specs, wasPresent)
+				if err != nil {
+					return err
+				}
+			}
+
+			// a subcommand may define its own --version option
+			if findOption(subcmd.specs, "version") != nil {
+				hasVersionOption = true
+			}
+
+			curCmd = subcmd
+			p.lastCmd = curCmd
+			p.record(EventSubcommand, arg, subcmd.dest.Name())
+
+			// a passthrough subcommand receives all remaining tokens as they are
+			if subcmd.passthrough != nil {
+				rest := append([]string{}, args[i+1:]...)
+				p.val(subcmd.passthrough.dest).Set(reflect.ValueOf(rest))
+				wasPresent[subcmd.passthrough] = true
+				p.sources[subcmd.passthrough] = SourceArg
+				break
+			}
+			continue
+		}
+
+		// check for special --help and --version flags
+		switch arg {
+		case "-h", "--help":
+			if !curCmd.nohelp {
+				return ErrHelp
+			}
+		case "--version":
+			if !hasVersionOption && p.versionFor(curCmd) != "" {
+				return ErrVersion
+			}
+		}
+		if p.isHelpAll(arg, specs) && !curCmd.nohelp {
+			return ErrHelpAll
+		}
+		if shell, ok := p.completionShell(arg, specs); ok {
+			return &CompletionRequestError{Shell: shell}
+		}
+
+		// check for an equals sign, as in "--foo=bar"
+		var value string
+		var hasValue bool
+		opt := strings.TrimLeft(arg, "-")
+		if pos := strings.Index(opt, "="); pos != -1 {
+			value = opt[pos+1:]
+			opt = opt[:pos]
+			hasValue = true
+		}
+
+		// errors report the option without its value, which may be a secret
+		name := arg
+		if hasValue {
+			name = arg[:len(arg)-len(value)-1]
+		}
+
+		// lookup the spec for this option (note that the "specs" slice changes as
+		// we expand subcommands so it is better not to use a map). Options of the
+		// form --name.N.field address a field of an element of a slice of structs.
+		spec, index, elem := findIndexedOption(specs, opt)
+		if elem == nil {
+			spec = findOption(specs, opt)
+		}
+
+		// counters may be given by repeating their short name, as in -vvv
+		repeat := 1
+		if spec == nil && !hasValue {
+			if counter, n := findRepeatedCounter(specs, arg); counter != nil {
+				spec, repeat = counter, n
+			}
+		}
+		if spec == nil && !hasValue {
+			// options of the form --no-name empty a slice or map tagged "clearable"
+			if cleared := findClearOption(specs, opt); cleared != nil {
+				if err := clearSliceOrMap(p.val(cleared.dest)); err != nil {
+					return &ValueError{Arg: name, Field: cleared.dest.Name(), Err: err}
+				}
+				wasPresent[cleared] = true
+				emptied[cleared] = true
+				p.sources[cleared] = SourceArg
+				p.record(EventFlag, arg, cleared.dest.Name())
+				continue
+			}
+
+			// options of the form --no-name set a boolean tagged "negatable"
+			// to false, which for a *bool is distinct from not being given
+			if negated := findNegatedOption(specs, opt); negated != nil {
+				if negated.experimental && !p.experimentalEnabled() {
+					return fmt.Errorf("experimental flag %s requires %s", name, p.config.ExperimentalEnv)
+				}
+				if err := negated.parse(p.val(negated.dest), "false"); err != nil {
+					return &ValueError{Arg: name, Field: negated.dest.Name(), Err: err}
+				}
+				wasPresent[negated] = true
+				p.sources[negated] = SourceArg
+				p.record(EventFlag, arg, negated.dest.Name(), "false")
+				continue
+			}
+		}
+		if spec == nil {
+			// the option named by a listfile tag reads the elements of a slice
+			// from a file, one per line
+			if lister := findListFileOption(specs, opt); lister != nil {
+				if !hasValue {
+					if i+1 == len(args) || isFlag(args[i+1]) {
+						return fmt.Errorf("missing value for %s", arg)
+					}
+					value = args[i+1]
+					i++
+				}
+				values, err := readLines(value)
+				if err == nil {
+					err = p.checkChoices(lister, values...)
+				}
+				clear := !given[lister]
+				given[lister] = true
+				wasPresent[lister] = true
+				p.sources[lister] = SourceArg
+				if clear && !emptied[lister] && p.resetToDefault(lister) {
+					clear = false
+					appended[lister] = true
+					p.sources[lister] = SourceAppended
+					delete(p.envSources, lister)
+				}
+				if err == nil {
+					err = setSliceOrMap(p.val(lister.dest), lister.ungroupAll(values), clear, lister.kvsep)
+				}
+				if err != nil {
+					return &ValueError{Arg: name, Field: lister.dest.Name(), Err: err}
+				}
+				p.record(EventFlag, arg, lister.dest.Name(), values...)
+				continue
+			}
+		}
+		if spec == nil && opt != "" && curCmd.unknown != nil {
+			// collect the unknown option as it was given, including any value
+			collector := curCmd.unknown
+			if err := setSliceOrMap(p.val(collector.dest), []string{arg}, !wasPresent[collector], ""); err != nil {
+				return &ValueError{Arg: name, Field: collector.dest.Name(), Err: err}
+			}
+			wasPresent[collector] = true
+			p.sources[collector] = SourceArg
+			p.record(EventFlag, arg, collector.dest.Name())
+			continue
+		}
+		if (spec == nil || opt == "") && p.collectsUnknown(curCmd) {
+			leftover = append(leftover, i)
+			continue
+		}
+		if spec == nil || opt == "" {
+			return p.unknownArg(specs, arg, opt)
+		}
+		if spec.experimental && !p.experimentalEnabled() {
+			return fmt.Errorf("experimental flag %s requires %s", name, p.config.ExperimentalEnv)
+		}
+		if spec.deprecated && isDeprecatedName(spec, opt) {
+			p.warnDeprecated(spec, name)
+		}
+		wasPresent[spec] = true
+		p.sources[spec] = SourceArg
+		if spec.terminal {
+			terminal, terminalFlag = spec, name
+		}
+
+		// slices of structs are populated one field at a time, so from here on
+		// the value is parsed according to the field of the element
+		valueSpec := spec
+		if spec.elems != nil {
+			if elem == nil {
+				return fmt.Errorf("%s must be given with an index and a field, as in --%s.0.%s",
+					arg, spec.long, spec.elems[0].long)
+			}
+			valueSpec = elem
+		}
+
+		// arrays consume exactly as many tokens as their length
+		if valueSpec.nargs > 0 {
+			var values []string
+			if hasValue {
+				values = append(values, value)
+			}
+			elemType := valueSpec.field.Type.Elem()
+			for len(values) < valueSpec.nargs && i+1 < len(args) {
+				if isFlag(args[i+1]) && !nextIsNumeric(elemType, args[i+1]) {
+					break
+				}
+				values = append(values, args[i+1])
+				i++
+			}
+			if len(values) < valueSpec.nargs {
+				return fmt.Errorf("%s requires %d values but got %d", name, valueSpec.nargs, len(values))
+			}
+			if err := setArray(p.val(spec.dest), spec.ungroupAll(values)); err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+			p.record(EventFlag, arg, spec.dest.Name(), values...)
+			continue
+		}
+
+		// deal with the case of multiple values
+		if valueSpec.cardinality == multiple {
+			// the first occurrence replaces the value of the field and later
+			// ones append to it, whether the values are attached with "=" or
+			// not, and occurrences of a listfile option append to each other
+			var values []string
+			clear := (!spec.separate || spec.listFile != "") && !given[spec]
+			given[spec] = true
+			flagIndex := i
+			if !hasValue {
+				for i+1 < len(args) && p.nextIsValue(specs, curCmd, valueSpec, args[i+1]) {
+					values = append(values, args[i+1])
+					i++
+					if spec.separate {
+						break
+					}
+				}
+			} else if value != "" {
+				// --flag= provides no values, just like --flag on its own
+				values = append(values, value)
+			}
+			values, err := splitValues(spec.field.Type, values, spec.sep, spec.kvsep, spec.quoted)
+			if err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+			if spec.strict {
+				if mapKeys[spec] == nil {
+					mapKeys[spec] = make(map[interface{}]bool)
+				}
+				if err := checkDuplicateKeys(spec.field.Type, values, spec.kvsep, mapKeys[spec]); err != nil {
+					return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+				}
+			}
+			if err := p.checkChoices(spec, values...); err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+			// with default-policy:append the first occurrence replaces the
+			// values of the environment but keeps the default, unless the
+			// option was emptied with --no-name
+			if (clear || !appended[spec]) && !emptied[spec] && p.resetToDefault(spec) {
+				clear = false
+				appended[spec] = true
+				p.sources[spec] = SourceAppended
+				delete(p.envSources, spec)
+			}
+			if err := setSliceOrMap(p.val(spec.dest), spec.ungroupAll(values), clear, spec.kvsep); err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+			if isOccurrences(spec.field.Type) {
+				setOccurrenceIndex(p.val(spec.dest), len(values), flagIndex)
+			}
+			p.record(EventFlag, arg, spec.dest.Name(), values...)
+			continue
+		}
+
+		// each occurrence of a counter adds to its value, whereas a value
+		// given explicitly, as in --verbose=3, replaces it
+		if spec.counter && !hasValue {
+			v := p.val(spec.dest)
+			if !counted[spec] {
+				v.Set(reflect.Zero(v.Type()))
+				counted[spec] = true
+			}
+			if err := addCount(v, repeat); err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+			p.record(EventFlag, arg, spec.dest.Name())
+			continue
+		}
+		if spec.counter {
+			counted[spec] = true
+		}
+
+		// if it's a flag and it has no value then set the value to true
+		// use boolean because this takes account of TextUnmarshaler
+		if valueSpec.cardinality == zero && value == "" {
+			value = "true"
+			hasValue = true
+		}
+
+		// if we have something like "--foo" then the value is the next argument,
+		// whereas "--foo=" explicitly provides an empty value
+		if !hasValue {
+			if i+1 == len(args) {
+				return fmt.Errorf("missing value for %s", arg)
+			}
+			if !nextIsNumeric(valueSpec.field.Type, args[i+1]) && isFlag(args[i+1]) {
+				return fmt.Errorf("missing value for %s", arg)
+			}
+			value = args[i+1]
+			i++
+		}
+
+		var err error
+		if valueSpec.inverted {
+			value, err = invertBool(value)
+			if err != nil {
+				return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+			}
+		}
+		if elem != nil {
+			err = setSliceElemField(p.val(spec.dest), index, elem.field, value)
+		} else if value, err = p.matchChoice(spec, value); err == nil {
+			var contents string
+			if contents, err = spec.readFile(value); err == nil {
+				err = spec.parse(p.val(spec.dest), contents)
+			}
+		}
+		if err != nil {
+			return &ValueError{Arg: name, Field: spec.dest.Name(), Err: err}
+		}
+		p.record(EventFlag, arg, spec.dest.Name(), value)
+	}
+
+	// process positionals
+	for _, spec := range specs {
+		if !spec.positional {
+			continue
+		}
+		if len(positionals) == 0 {
+			break
+		}
+		remaining := len(positionals)
+		wasPresent[spec] = true
+		p.sources[spec] = SourceArg
+		if spec.cardinality == multiple {
+			values, err := splitValues(spec.field.Type, positionals, spec.sep, spec.kvsep, spec.quoted)
+			if err == nil && spec.strict {
+				err = checkDuplicateKeys(spec.field.Type, values, spec.kvsep, make(map[interface{}]bool))
+			}
+			if err == nil {
+				err = p.checkChoices(spec, values...)
+			}
+			clear := true
+			if err == nil && p.resetToDefault(spec) {
+				clear = false
+				p.sources[spec] = SourceAppended
+				delete(p.envSources, spec)
+			}
+			if err == nil {
+				err = setSliceOrMap(p.val(spec.dest), spec.ungroupAll(values), clear, spec.kvsep)
+			}
+			if err != nil {
+				return &ValueError{Arg: spec.field.Name, Field: spec.dest.Name(), Err: err}
+			}
+			positionals = nil
+		} else if spec.nargs > 0 {
+			if len(positionals) < spec.nargs {
+				return fmt.Errorf("%s requires %d values but got %d", spec.placeholder, spec.nargs, len(positionals))
+			}
+			if err := setArray(p.val(spec.dest), spec.ungroupAll(positionals[:spec.nargs])); err != nil {
+				return &ValueError{Arg: spec.field.Name, Field: spec.dest.Name(), Err: err}
+			}
+			positionals = positionals[spec.nargs:]
+		} else {
+			value, err := p.matchChoice(spec, positionals[0])
+			if err == nil {
+				value, err = spec.readFile(value)
+			}
+			if err == nil {
+				err = spec.parse(p.val(spec.dest), value)
+			}
+			if err != nil {
+				return &ValueError{Arg: spec.field.Name, Field: spec.dest.Name(), Err: err}
+			}
+			positionals = positionals[1:]
+		}
+
+		// now that we know which positionals this spec consumed, name them in the parse order
+		consumed := positionalEvents[:remaining-len(positionals)]
+		positionalEvents = positionalEvents[len(consumed):]
+		for _, index := range consumed {
+			if index >= 0 {
+				p.order[index].Field = spec.dest.Name()
+			}
+		}
+	}
+	if len(positionals) > 0 && p.collectsUnknown(p.lastCmd) {
+		leftover = append(leftover, positionalIndices[len(positionalIndices)-len(positionals):]...)
+	} else if len(positionals) > 0 {
+		return &UnknownArgError{Arg: positionals[0], Positional: true}
+	}
+
+	// return the unconsumed arguments in their original order
+	sort.Ints(leftover)
+	for _, index := range leftover {
+		if terminator >= 0 && index > terminator {
+			p.rest = append(p.rest, "--")
+			terminator = -1
+		}
+		p.rest = append(p.rest, args[index])
+	}
+	if collector := restSpec(p.lastCmd); collector != nil && len(p.rest) > 0 {
+		p.val(collector.dest).Set(reflect.ValueOf(append([]string{}, p.rest...)))
+		wasPresent[collector] = true
+		p.sources[collector] = SourceArg
+	}
+
+	// the config file takes precedence over defaults but not over the
+	// command line or environment variables
+	if terminal == nil {
+		if err := p.applyConfigFile(specs, wasPresent); err != nil {
+			return err
+		}
+	}
+
+	if err := p.applyModes(specs, wasPresent); err != nil {
+		return err
+	}
+
+	if terminal == nil {
+		if err := checkGroups(specs, wasPresent); err != nil {
+			return err
+		}
+	}
+
+	// check for environment variables that look like they were meant for us
+	if p.config.StrictEnvPrefix != "" {
+		if err := p.checkStrictEnvPrefix(); err != nil && p.config.StrictEnvPrefixWarnOnly {
+			p.warn("%v", err)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := p.applyDefaults(specs, wasPresent, curCmd, terminal == nil); err != nil {
+		return err
+	}
+	if err := p.splitInto(specs); err != nil {
+		return err
+	}
+	if terminal == nil {
+		if err := p.normalize(curCmd); err != nil {
+			return err
+		}
+		if err := p.validate(curCmd); err != nil {
+			return err
+		}
+	}
+	if terminal != nil {
+		return &TerminalError{Flag: terminalFlag, Field: terminal.dest.Name()}
+	}
+	return nil
+}
+
+// checkGroups returns an error if more than one option of an exclusive group
+// was given, or if some but not all of the arguments of a together group were
+func checkGroups(specs []*spec, wasPresent map[*spec]bool) error {
+	checked := make(map[*optionGroup]bool)
+	for _, s := range specs {
+		g := s.optionGroup
+		if g == nil || checked[g] {
+			continue
+		}
+		checked[g] = true
 
-				cmd.subcommands = append(cmd.subcommands, subcmd)
-				isSubcommand = true
-			default:
-				errs = append(errs, fmt.Sprintf("unrecognized tag '%s' on field %s", key, tag))
-				return false
+		var given, missing []*spec
+		for _, member := range g.members {
+			if wasPresent[member] {
+				given = append(given, member)
+			} else {
+				missing = append(missing, member)
 			}
 		}
+		if g.exclusive && len(given) > 1 {
+			return fmt.Errorf("%s and %s cannot be used together", argName(given[0]), argName(given[1]))
+		}
+		if !g.exclusive && len(given) > 0 && len(missing) > 0 {
+			return fmt.Errorf("%s must be given together with %s", argName(given[0]), argName(missing[0]))
+		}
+	}
+	return nil
+}
 
-		placeholder, hasPlaceholder := field.Tag.Lookup("placeholder")
-		if hasPlaceholder {
-			spec.placeholder = placeholder
-		} else if spec.long != "" {
-			spec.placeholder = strings.ToUpper(spec.long)
-		} else {
-			spec.placeholder = strings.ToUpper(spec.field.Name)
+// argName returns the name by which an argument is referred to in errors about
+// how arguments are combined: the placeholder of a positional, the long or
+// short form of an option, or the environment variable otherwise
+func argName(spec *spec) string {
+	switch {
+	case spec.positional:
+		return spec.placeholder
+	case spec.long != "":
+		return "--" + spec.long
+	case spec.short != "":
+		return "-" + spec.short
+	default:
+		return "environment variable " + spec.env
+	}
+}
+
+// applyModes sets the option named by the setmode tag of each boolean that was
+// set to true. It is an error for two booleans to set the same option to
+// different values, or for a boolean to set an option that was also given a
+// different value explicitly.
+func (p *Parser) applyModes(specs []*spec, wasPresent map[*spec]bool) error {
+	setBy := make(map[*spec]*spec)
+	for _, spec := range specs {
+		if spec.modeTarget == nil || !wasPresent[spec] {
+			continue
+		}
+		v := p.val(spec.dest)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		if !v.Bool() {
+			continue
 		}
 
-		// if this is a subcommand then we've done everything we need to do
-		if isSubcommand {
-			return false
+		target := spec.modeTarget
+		other, setByOther := setBy[target]
+		if setByOther && other.modeValue != spec.modeValue {
+			return fmt.Errorf("--%s and --%s cannot be used together", other.long, spec.long)
 		}
+		setBy[target] = spec
 
-		// check whether this field is supported. It's good to do this here rather than
-		// wait until ParseValue because it means that a program with invalid argument
-		// fields will always fail regardless of whether the arguments it received
-		// exercised those fields.
-		var err error
-		spec.cardinality, err = cardinalityOf(field.Type)
-		if err != nil {
-			errs = append(errs, fmt.Sprintf("%s.%s: %s fields are not supported",
-				t.Name(), field.Name, field.Type.String()))
-			return false
+		value := reflect.New(target.field.Type).Elem()
+		if err := target.parse(value, spec.modeValue); err != nil {
+			return fmt.Errorf("error processing --%s: %v", spec.long, err)
 		}
 
-		defaultString, hasDefault := field.Tag.Lookup("default")
-		if hasDefault {
-			// we do not support default values for maps and slices
-			if spec.cardinality == multiple {
-				errs = append(errs, fmt.Sprintf("%s.%s: default values are not supported for slice or map fields",
-					t.Name(), field.Name))
-				return false
+		// a value given explicitly on the command line takes precedence over a
+		// boolean from an environment variable, but conflicts with one given
+		// on the command line
+		if !setByOther && wasPresent[target] && p.sources[target] == SourceArg {
+			if p.sources[spec] != SourceArg {
+				continue
 			}
-
-			// a required field cannot also have a default value
-			if spec.required {
-				errs = append(errs, fmt.Sprintf("%s.%s: 'required' cannot be used when a default value is specified",
-					t.Name(), field.Name))
-				return false
+			if !reflect.DeepEqual(value.Interface(), p.val(target.dest).Interface()) {
+				return fmt.Errorf("--%s cannot be used together with a different value for --%s", spec.long, target.long)
 			}
+		}
+		p.val(target.dest).Set(value)
+		wasPresent[target] = true
+		p.sources[target] = p.sources[spec]
+	}
+	return nil
+}
 
-			// parse the default value
-			spec.defaultString = defaultString
-			if field.Type.Kind() == reflect.Ptr {
-				// here we have a field of type *T and we create a new T, no need to dereference
-				// in order for the value to be settable
-				spec.defaultValue = reflect.New(field.Type.Elem())
-			} else {
-				// here we have a field of type T and we create a new T and then dereference it
-				// so that the resulting value is settable
-				spec.defaultValue = reflect.New(field.Type).Elem()
-			}
-			err := scalar.ParseValue(spec.defaultValue, defaultString)
-			if err != nil {
-				errs = append(errs, fmt.Sprintf("%s.%s: error processing default value: %v", t.Name(), field.Name, err))
-				return false
+// splitInto splits the value of each option tagged "split" that was set and
+// parses the pieces into the fields named by its into tag, in order
+func (p *Parser) splitInto(specs []*spec) error {
+	for _, spec := range specs {
+		if spec.split == "" || p.sources[spec] == SourceUnset {
+			continue
+		}
+		pieces := strings.Split(p.val(spec.dest).String(), spec.split)
+		if len(pieces) != len(spec.into) {
+			return fmt.Errorf("%s must have %d values separated by %q but got %d",
+				argName(spec), len(spec.into), spec.split, len(pieces))
+		}
+		for i, dest := range spec.into {
+			if err := parseValue(p.val(dest), pieces[i], ""); err != nil {
+				return fmt.Errorf("error processing %s: %s: %v", argName(spec), spec.intoNames[i], err)
 			}
 		}
+	}
+	return nil
+}
 
-		// add the spec to the list of specs
-		cmd.specs = append(cmd.specs, &spec)
-
-		// if this was an embedded field then we already returned true up above
-		return false
-	})
-
-	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, "\n"))
+// eachSelectedDest calls fn with each destination struct, starting with the
+// top-level destinations and continuing with each selected subcommand down to
+// the given one, and stops at the first error
+func (p *Parser) eachSelectedDest(cmd *command, fn func(c *command, dest reflect.Value) error) error {
+	var chain []*command
+	for c := cmd; c != nil; c = c.parent {
+		chain = append([]*command{c}, chain...)
 	}
 
-	// check that we don't have both positionals and subcommands
-	var hasPositional bool
-	for _, spec := range cmd.specs {
-		if spec.positional {
-			hasPositional = true
+	for _, c := range chain {
+		dests := []reflect.Value{p.val(c.dest)}
+		if c.parent == nil {
+			dests = p.roots
+		}
+		for _, dest := range dests {
+			if err := fn(c, dest); err != nil {
+				return err
+			}
 		}
 	}
-	if hasPositional && len(cmd.subcommands) > 0 {
-		return nil, fmt.Errorf("%s cannot have both subcommands and positional arguments", dest)
-	}
-
-	return &cmd, nil
+	return nil
 }
 
-// Parse processes the given command line option, storing the results in the field
-// of the structs from which NewParser was constructed
-func (p *Parser) Parse(args []string) error {
-	err := p.process(args)
-	if err != nil {
-		// If -h or --help were specified then make sure help text supercedes other errors
-		for _, arg := range args {
-			if arg == "-h" || arg == "--help" {
-				return ErrHelp
-			}
-			if arg == "--" {
-				break
-			}
+// normalize calls Normalize on each selected destination struct that
+// implements Normalizer
+func (p *Parser) normalize(cmd *command) error {
+	return p.eachSelectedDest(cmd, func(c *command, dest reflect.Value) error {
+		n, ok := dest.Interface().(Normalizer)
+		if !ok {
+			return nil
 		}
-	}
-	return err
+		if err := n.Normalize(); err != nil {
+			return fmt.Errorf("error normalizing %s: %w", c.name, err)
+		}
+		return nil
+	})
 }
 
-func (p *Parser) MustParse(args []string) {
-	err := p.Parse(args)
-	switch {
-	case errors.Is(err, ErrHelp):
-		p.writeHelpForSubcommand(p.config.Out, p.lastCmd)
-		p.config.Exit(0)
-	case errors.Is(err, ErrVersion):
-		_, _ = fmt.Fprintln(p.config.Out, p.version)
-		p.config.Exit(0)
-	case err != nil:
-		p.failWithSubcommand(err.Error(), p.lastCmd)
-	}
+// validate calls Validate on each selected destination struct that implements
+// Validator, and returns the first error as it is
+func (p *Parser) validate(cmd *command) error {
+	return p.eachSelectedDest(cmd, func(c *command, dest reflect.Value) error {
+		if v, ok := dest.Interface().(Validator); ok {
+			return v.Validate()
+		}
+		return nil
+	})
 }
 
-// process environment vars for the given arguments
-func (p *Parser) captureEnvVars(specs []*spec, wasPresent map[*spec]bool) error {
+// applyDefaults fills in defaults for the specs that were not present and,
+// if checkRequired is true, checks that all the required args were provided.
+// curCmd is the last subcommand that was selected.
+func (p *Parser) applyDefaults(specs []*spec, wasPresent map[*spec]bool, curCmd *command, checkRequired bool) error {
+	var templated []*spec
 	for _, spec := range specs {
-		if spec.env == "" {
+		if wasPresent[spec] {
 			continue
 		}
 
-		var value string
-		var found bool
+		name := strings.ToLower(spec.field.Name)
+		if spec.long != "" && !spec.positional {
+			name = "--" + spec.long
+		}
 
-		if !p.config.IgnoreEnv {
-			value, found = os.LookupEnv(spec.env)
+		// the value of the option of the parent command takes precedence over
+		// the default, but only if that option was set
+		if spec.inheritFrom != nil {
+			if source := p.sources[spec.inheritFrom]; source != SourceUnset {
+				p.val(spec.dest).Set(p.val(spec.inheritFrom.dest))
+				p.sources[spec] = source
+				continue
+			}
 		}
 
-		if p.config.Environment != nil {
-			value, found = p.config.Environment[spec.env]
+		// defaults computed by the field type count towards required arguments
+		if spec.defaultFunc != nil && !spec.hasDefault() && !p.config.IgnoreDefault {
+			provider := reflect.New(spec.defaultFunc).Interface().(DefaultProvider)
+			if err := parseScalar(p.val(spec.dest), provider.DefaultValue()); err != nil {
+				return fmt.Errorf("error processing default value for %s: %v", name, err)
+			}
+			p.sources[spec] = SourceDefault
+			continue
 		}
 
-		if !found {
+		// defaults computed by the destination struct also count towards required arguments
+		if filled, err := p.fillDefault(spec); err != nil {
+			return fmt.Errorf("error processing default value for %s: %v", name, err)
+		} else if filled {
+			p.sources[spec] = SourceDefault
 			continue
 		}
 
-		if spec.cardinality == multiple {
-			// expect a CSV string in an environment
-			// variable in the case of multiple values
-			var values []string
-			var err error
-			if len(strings.TrimSpace(value)) > 0 {
-				values, err = csv.NewReader(strings.NewReader(value)).Read()
-				if err != nil {
-					return fmt.Errorf(
-						"error reading a CSV string from environment variable %s with multiple values: %v",
-						spec.env,
-						err,
-					)
-				}
+		// prompting the user is the last resort for options without a default
+		if spec.prompt && (!spec.hasDefault() || p.config.IgnoreDefault) {
+			if prompted, err := p.promptFor(spec); err != nil {
+				return fmt.Errorf("error processing %s: %v", name, err)
+			} else if prompted {
+				p.sources[spec] = SourcePrompt
+				continue
 			}
-			if err = setSliceOrMap(p.val(spec.dest), values, !spec.separate); err != nil {
-				return fmt.Errorf(
-					"error processing environment variable %s with multiple values: %v",
-					spec.env,
-					err,
-				)
+		}
+
+		if spec.required && checkRequired {
+			// positionals can only belong to the last subcommand, since a
+			// command cannot have both positionals and subcommands
+			if spec.positional && curCmd.parent != nil {
+				return &RequiredError{Arg: spec.placeholder, Field: spec.dest.Name(),
+					Subcommand: strings.Join(commandPath(curCmd), " ")}
 			}
-		} else {
-			if err := scalar.ParseValue(p.val(spec.dest), value); err != nil {
-				return fmt.Errorf("error processing environment variable %s: %v", spec.env, err)
+
+			if spec.short == "" && spec.long == "" {
+				return &RequiredError{Field: spec.dest.Name(), Env: spec.env}
 			}
+			return &RequiredError{Arg: name, Field: spec.dest.Name(), Env: spec.env}
+		}
+
+		// options may be required only in some environments, in which case a
+		// default value satisfies the requirement
+		if spec.requiredIfEnv != "" && checkRequired && (!spec.hasDefault() || p.config.IgnoreDefault) {
+			if _, set := p.lookupEnv(spec.requiredIfEnv); set {
+				return &RequiredError{Arg: name, Field: spec.dest.Name(), IfEnv: spec.requiredIfEnv}
+			}
+		}
+
+		p.sources[spec] = SourceUnset
+		if spec.tmpl != nil && !p.config.IgnoreDefault {
+			templated = append(templated, spec)
+		} else if spec.defaultValue.IsValid() && !p.config.IgnoreDefault {
+			p.sources[spec] = SourceDefault
+			// One issue here is that if the user now modifies the value then
+			// the default value stored in the spec will be corrupted. There
+			// is no general way to "deep-copy" values in Go, and we still
+			// support the old-style method for specifying defaults as
+			// Go values assigned directly to the struct field, so we are stuck.
+			p.val(spec.dest).Set(spec.defaultValue)
 		}
-		wasPresent[spec] = true
 	}
 
-	return nil
+	// template defaults are rendered last, so that they see the values of the
+	// fields to which they refer, including defaults
+	return p.renderTemplates(templated)
 }
 
-// process goes through arguments one-by-one, parses them, and assigns the result to
-// the underlying struct field
-func (p *Parser) process(args []string) error {
-	// track the options we have seen
-	wasPresent := make(map[*spec]bool)
+// ReloadEnv reads the environment variables and the config file again and
+// re-applies default values for all arguments that were not set on the command
+// line during the most recent call to Parse. Arguments that were set on the
+// command line keep their values. This is intended for reloading configuration
+// in long-running processes, for example on SIGHUP.
+//
+// ReloadEnv modifies the destination structs in place, so it must not be
+// called concurrently with Parse, or while other goroutines read the
+// destination structs without synchronization. It returns an error if Parse
+// has not been called. If the environment or the config file now holds a value
+// that cannot be parsed, ReloadEnv returns the error and leaves the
+// destination structs as they were, so that a mistake in the environment does
+// not corrupt the configuration of a running process.
+func (p *Parser) ReloadEnv() error {
+	if p.sources == nil {
+		return errors.New("ReloadEnv called before Parse")
+	}
 
-	// union of specs for the chain of subcommands encountered so far
-	curCmd := p.cmd
-	p.lastCmd = curCmd
+	// collect the arguments of the selected commands that did not come from the command line
+	var specs []*spec
+	var visit func(cmd *command)
+	visit = func(cmd *command) {
+		for _, spec := range cmd.specs {
+			source, found := p.sources[spec]
+			if source == SourceAppended && p.envSources[spec] == "" {
+				continue // the appended values came from the command line
+			}
+			if found && source != SourceArg && source != SourcePrompt {
+				specs = append(specs, spec)
+			}
+		}
+		for _, subcmd := range cmd.subcommands {
+			visit(subcmd)
+		}
+	}
+	visit(p.cmd)
 
-	// make a copy of the specs because we will add to this list each time we expand a subcommand
-	specs := make([]*spec, len(curCmd.specs))
-	copy(specs, curCmd.specs)
+	// save their values and sources so that they can be restored if reloading
+	// fails. Setters are copied because they are updated in place.
+	saved := make([]reflect.Value, len(specs))
+	for i, spec := range specs {
+		saved[i] = copyDefault(p.val(spec.dest))
+	}
+	sources := make(map[*spec]Source, len(p.sources))
+	for spec, source := range p.sources {
+		sources[spec] = source
+	}
+	envSources := make(map[*spec]string, len(p.envSources))
+	for spec, name := range p.envSources {
+		envSources[spec] = name
+	}
 
-	// deal with environment vars
+	defer p.storeBuilt()
+	if err := p.reloadSpecs(specs); err != nil {
+		for i, spec := range specs {
+			p.val(spec.dest).Set(saved[i])
+		}
+		p.sources, p.envSources = sources, envSources
+		return err
+	}
+	return nil
+}
+
+// reloadSpecs resets the given specs and sets them again from the environment,
+// the config file, and their defaults, for ReloadEnv
+func (p *Parser) reloadSpecs(specs []*spec) error {
+	// reset them so that variables that were removed fall back to their defaults
+	for _, spec := range specs {
+		if v := p.val(spec.dest); !isSetter(v.Type()) {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		delete(p.envSources, spec)
+	}
+
+	wasPresent := make(map[*spec]bool)
 	if !p.config.IgnoreEnv || p.config.Environment != nil {
-		err := p.captureEnvVars(specs, wasPresent)
-		if err != nil {
+		if err := p.captureEnvVars(specs, wasPresent); err != nil {
 			return err
 		}
 	}
 
-	// determine if the current command has a version option spec
-	var hasVersionOption bool
-	for _, spec := range curCmd.specs {
-		if spec.long == "version" {
-			hasVersionOption = true
-			break
+	// the config file is read again, since the environment may now leave
+	// options to it or name a different file
+	if conf := p.confSpec; conf != nil {
+		if source := p.sources[conf]; source == SourceArg || source == SourcePrompt {
+			wasPresent[conf] = true
+		}
+	}
+	if err := p.applyConfigFile(specs, wasPresent); err != nil {
+		return err
+	}
+	if err := p.applyDefaults(specs, wasPresent, p.lastCmd, true); err != nil {
+		return err
+	}
+	return p.splitInto(specs)
+}
+
+// promptFor asks the user for the value of the given spec using Config.Prompt,
+// and returns false if the user could not be prompted
+func (p *Parser) promptFor(spec *spec) (bool, error) {
+	prompt := p.config.Prompt
+	if prompt == nil {
+		prompt = promptTerminal
+	}
+	value, ok, err := prompt(promptText(spec), spec.promptSecret)
+	if err != nil || !ok {
+		return false, err
+	}
+	if spec.cardinality == multiple {
+		values, err := splitValues(spec.field.Type, []string{value}, spec.sep, spec.kvsep, spec.quoted)
+		if err == nil {
+			err = p.checkChoices(spec, values...)
 		}
+		if err == nil {
+			err = setSliceOrMap(p.val(spec.dest), spec.ungroupAll(values), true, spec.kvsep)
+		}
+		return err == nil, err
+	}
+	value, err = p.matchChoice(spec, value)
+	if err != nil {
+		return false, err
+	}
+	if err := spec.parse(p.val(spec.dest), value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resetToDefault sets the slice of the given spec to a copy of its default
+// value if it is tagged default-policy:append, so that values can be appended
+// to the default without modifying it, and returns true if it did so
+func (p *Parser) resetToDefault(spec *spec) bool {
+	if !spec.appendDefault || !spec.defaultValue.IsValid() || p.config.IgnoreDefault {
+		return false
+	}
+	p.val(spec.dest).Set(copySlice(spec.defaultValue))
+	return true
+}
+
+// fillDefault sets the value of the given spec using the FillDefaults method of
+// its destination struct, if it implements DefaultFiller, and returns true if
+// a value was set
+func (p *Parser) fillDefault(spec *spec) (bool, error) {
+	if p.config.IgnoreDefault || spec.hasDefault() || spec.cardinality == multiple {
+		return false, nil
+	}
+	filler, ok := p.roots[spec.dest.root].Interface().(DefaultFiller)
+	if !ok {
+		return false, nil
+	}
+	v := p.val(spec.dest)
+	if !isZero(v) {
+		return false, nil
+	}
+	s, found := filler.FillDefaults(spec.dest.Name(), spec.field.Type)
+	if !found {
+		return false, nil
 	}
+	return true, spec.parse(v, s)
+}
 
-	// process each string from the command line
-	var allpositional bool
-	var positionals []string
+// collectsUnknown returns true if unknown arguments given to the command are
+// kept rather than rejected, as they are by ParsePartial, when
+// Config.IgnoreUnknown is set, and by fields tagged "passthrough"
+func (p *Parser) collectsUnknown(cmd *command) bool {
+	return p.partial || p.config.IgnoreUnknown || restSpec(cmd) != nil
+}
 
-	// must use explicit for loop, not range, because we manipulate i inside the loop
-	for i := 0; i < len(args); i++ {
-		arg := args[i]
-		if arg == "--" {
-			allpositional = true
-			continue
+// restSpec returns the field tagged "passthrough" of the command or of its
+// nearest ancestor that has one, or nil if there is none
+func restSpec(cmd *command) *spec {
+	for ; cmd != nil; cmd = cmd.parent {
+		if cmd.rest != nil {
+			return cmd.rest
 		}
+	}
+	return nil
+}
 
-		if !isFlag(arg) || allpositional {
-			// each subcommand can have either subcommands or positionals, but not both
-			if len(curCmd.subcommands) == 0 {
-				positionals = append(positionals, arg)
-				continue
-			}
-
-			// if we have a subcommand then make sure it is valid for the current context
-			subcmd := findSubcommand(curCmd.subcommands, arg)
-			if subcmd == nil {
-				return fmt.Errorf("invalid subcommand: %s", arg)
-			}
-
-			// instantiate the field to point to a new struct
-			v := p.val(subcmd.dest)
-			if v.IsNil() {
-				v.Set(reflect.New(v.Type().Elem())) // we already checked that all subcommands are struct pointers
+// unknownArg creates the error for an argument that does not correspond to any
+// option, including suggestions if Config.SuggestFlags is set
+func (p *Parser) unknownArg(specs []*spec, arg, opt string) error {
+	err := &UnknownArgError{Arg: arg}
+	if p.config.SuggestFlags && opt != "" {
+		var names []string
+		for _, spec := range specs {
+			if !spec.positional && spec.long != "" {
+				names = append(names, spec.long)
 			}
+		}
+		for _, name := range suggest(opt, names, 2) {
+			err.Suggestions = append(err.Suggestions, "--"+name)
+		}
+	}
+	return err
+}
 
-			// add the new options to the set of allowed options
-			if p.config.StrictSubcommands {
-				specs = make([]*spec, len(subcmd.specs))
-				copy(specs, subcmd.specs)
-			} else {
-				specs = append(specs, subcmd.specs...)
+// checkStrictEnvPrefix returns an error if there is an environment variable
+// with the prefix given by Config.StrictEnvPrefix that is not the environment
+// variable of any argument. Variables belonging to subcommands that were not
+// selected are not considered unknown.
+func (p *Parser) checkStrictEnvPrefix() error {
+	known := make(map[string]bool)
+	if p.config.ExperimentalEnv != "" {
+		known[p.config.ExperimentalEnv] = true
+	}
+	var visit func(cmd *command)
+	visit = func(cmd *command) {
+		for _, spec := range cmd.specs {
+			if spec.requiredIfEnv != "" {
+				known[spec.requiredIfEnv] = true
 			}
-
-			// capture environment vars for these new options
-			if !p.config.IgnoreEnv || p.config.Environment != nil {
-				err := p.captureEnvVars(subcmd.specs, wasPresent)
-				if err != nil {
-					return err
+			if spec.env != "" {
+				known[spec.env] = true
+				for _, name := range spec.envFallbacks {
+					known[name] = true
+				}
+				for _, name := range p.envNames(spec) {
+					known[name] = true
 				}
 			}
-
-			curCmd = subcmd
-			p.lastCmd = curCmd
-			continue
 		}
+		for _, subcmd := range cmd.subcommands {
+			visit(subcmd)
+		}
+	}
+	visit(p.cmd)
 
-		// check for special --help and --version flags
-		switch arg {
-		case "-h", "--help":
-			return ErrHelp
-		case "--version":
-			if !hasVersionOption && p.version != "" {
-				return ErrVersion
+	candidates := make(map[string]bool)
+	if !p.config.IgnoreEnv {
+		for _, kv := range os.Environ() {
+			if pos := strings.Index(kv, "="); pos != -1 {
+				candidates[kv[:pos]] = true
 			}
 		}
+	}
+	for name := range p.config.Environment {
+		candidates[name] = true
+	}
 
-		// check for an equals sign, as in "--foo=bar"
-		var value string
-		opt := strings.TrimLeft(arg, "-")
-		if pos := strings.Index(opt, "="); pos != -1 {
-			value = opt[pos+1:]
-			opt = opt[:pos]
+	var unknown []string
+	for name := range candidates {
+		if strings.HasPrefix(name, p.config.StrictEnvPrefix) && !known[name] {
+			unknown = append(unknown, name)
 		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	if len(unknown) == 1 {
+		return fmt.Errorf("unknown environment variable %s", unknown[0])
+	}
+	return fmt.Errorf("unknown environment variables %s", strings.Join(unknown, ", "))
+}
 
-		// lookup the spec for this option (note that the "specs" slice changes as
-		// we expand subcommands so it is better not to use a map)
-		spec := findOption(specs, opt)
-		if spec == nil || opt == "" {
-			return fmt.Errorf("unknown argument %s", arg)
-		}
-		wasPresent[spec] = true
+// resolveProfile determines the value of the option tagged "profile" before
+// the other arguments are processed. It is taken from the last occurrence of
+// the option on the command line, or else from its environment variable, or
+// else from its default value.
+func (p *Parser) resolveProfile(args []string) string {
+	spec := p.profileSpec
+	if spec == nil {
+		return ""
+	}
 
-		// deal with the case of multiple values
-		if spec.cardinality == multiple {
-			var values []string
-			if value == "" {
-				for i+1 < len(args) && !isFlag(args[i+1]) && args[i+1] != "--" {
-					values = append(values, args[i+1])
-					i++
-					if spec.separate {
-						break
-					}
-				}
-			} else {
-				values = append(values, value)
-			}
-			err := setSliceOrMap(p.val(spec.dest), values, !spec.separate)
-			if err != nil {
-				return fmt.Errorf("error processing %s: %v", arg, err)
-			}
+	var profile string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !isFlag(arg) {
 			continue
 		}
-
-		// if it's a flag and it has no value then set the value to true
-		// use boolean because this takes account of TextUnmarshaler
-		if spec.cardinality == zero && value == "" {
-			value = "true"
+		opt := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if pos := strings.Index(opt, "="); pos != -1 {
+			opt, value, hasValue = opt[:pos], opt[pos+1:], true
 		}
-
-		// if we have something like "--foo" then the value is the next argument
-		if value == "" {
+		if opt != spec.long && (spec.short == "" || opt != spec.short) {
+			continue
+		}
+		if !hasValue {
 			if i+1 == len(args) {
-				return fmt.Errorf("missing value for %s", arg)
-			}
-			if !nextIsNumeric(spec.field.Type, args[i+1]) && isFlag(args[i+1]) {
-				return fmt.Errorf("missing value for %s", arg)
+				break
 			}
-			value = args[i+1]
 			i++
+			value = args[i]
 		}
-
-		err := scalar.ParseValue(p.val(spec.dest), value)
-		if err != nil {
-			return fmt.Errorf("error processing %s: %v", arg, err)
-		}
+		profile = value
+	}
+	if profile != "" {
+		return profile
 	}
 
-	// process positionals
-	for _, spec := range specs {
-		if !spec.positional {
-			continue
+	if spec.env != "" && p.envAllowed(spec.env) {
+		if !p.config.IgnoreEnv {
+			profile = os.Getenv(spec.env)
 		}
-		if len(positionals) == 0 {
-			break
+		if value, found := p.config.Environment[spec.env]; found {
+			profile = value
 		}
-		wasPresent[spec] = true
-		if spec.cardinality == multiple {
-			err := setSliceOrMap(p.val(spec.dest), positionals, true)
-			if err != nil {
-				return fmt.Errorf("error processing %s: %v", spec.field.Name, err)
-			}
-			positionals = nil
-		} else {
-			err := scalar.ParseValue(p.val(spec.dest), positionals[0])
-			if err != nil {
-				return fmt.Errorf("error processing %s: %v", spec.field.Name, err)
-			}
-			positionals = positionals[1:]
+		if profile != "" {
+			return profile
 		}
 	}
-	if len(positionals) > 0 {
-		return fmt.Errorf("too many positional arguments at '%s'", positionals[0])
-	}
 
-	// fill in defaults and check that all the required args were provided
-	for _, spec := range specs {
-		if wasPresent[spec] {
-			continue
-		}
+	if !p.config.IgnoreDefault {
+		return spec.defaultString
+	}
+	return ""
+}
 
-		name := strings.ToLower(spec.field.Name)
-		if spec.long != "" && !spec.positional {
-			name = "--" + spec.long
-		}
+// envName returns the name of the environment variable for the given spec,
+// which is prefixed with the current profile, if any, as in PROD_DB_URL
+func (p *Parser) envName(spec *spec) string {
+	return p.prefixEnv(spec, spec.env)
+}
 
-		if spec.required {
-			if spec.short == "" && spec.long == "" {
-				msg := fmt.Sprintf("environment variable %s is required", spec.env)
-				return errors.New(msg)
-			}
+// envNames returns the environment variables of the given spec in order of
+// precedence, as described for envName
+func (p *Parser) envNames(spec *spec) []string {
+	names := []string{p.envName(spec)}
+	for _, name := range spec.envFallbacks {
+		names = append(names, p.prefixEnv(spec, name))
+	}
+	return names
+}
 
-			msg := fmt.Sprintf("%s is required", name)
-			if spec.env != "" {
-				msg += " (or environment variable " + spec.env + ")"
-			}
+// prefixEnv adds the prefix of the selected profile, if any, to the given
+// environment variable of the given spec
+func (p *Parser) prefixEnv(spec *spec, name string) string {
+	if p.profile == "" || spec == p.profileSpec {
+		return name
+	}
+	prefix := strings.ToUpper(strings.ReplaceAll(p.profile, "-", "_"))
+	return prefix + "_" + name
+}
 
-			return errors.New(msg)
+// versionFor returns the version string for the given command, which is the
+// version of the innermost subcommand in the chain from cmd up to the root
+// whose destination implements Versioned, or else the program version
+func (p *Parser) versionFor(cmd *command) string {
+	for c := cmd; c != nil && c.parent != nil; c = c.parent {
+		v := p.val(c.dest)
+		if !v.IsValid() || v.IsNil() {
+			// the subcommand was not selected so use a zero value
+			v = reflect.New(c.dest.fields[len(c.dest.fields)-1].Type.Elem())
+		}
+		if dest, ok := v.Interface().(Versioned); ok {
+			return dest.Version()
 		}
+	}
+	return p.version
+}
 
-		if spec.defaultValue.IsValid() && !p.config.IgnoreDefault {
-			// One issue here is that if the user now modifies the value then
-			// the default value stored in the spec will be corrupted. There
-			// is no general way to "deep-copy" values in Go, and we still
-			// support the old-style method for specifying defaults as
-			// Go values assigned directly to the struct field, so we are stuck.
-			p.val(spec.dest).Set(spec.defaultValue)
+// nextIsValue returns true if the given token should be consumed as one of the
+// values for a slice or map option, as described for Config.AllowDashValues
+func (p *Parser) nextIsValue(specs []*spec, cmd *command, valueSpec *spec, next string) bool {
+	if next == "--" {
+		return false
+	}
+	if !isFlag(next) {
+		return true
+	}
+	if p.isKnownFlag(specs, cmd, next) {
+		return false
+	}
+	if p.config.AllowDashValues {
+		return true
+	}
+
+	elem := valueSpec.field.Type
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Slice {
+		elem = elem.Elem()
+	}
+	return nextIsNumeric(elem, next)
+}
+
+// isKnownFlag returns true if the given token is one of the options in specs,
+// or one of the builtin options for the given command
+func (p *Parser) isKnownFlag(specs []*spec, cmd *command, token string) bool {
+	switch token {
+	case "-h", "--help":
+		return true
+	case "--version":
+		if p.versionFor(cmd) != "" {
+			return true
 		}
 	}
 
-	return nil
+	opt := strings.TrimLeft(token, "-")
+	if pos := strings.Index(opt, "="); pos != -1 {
+		opt = opt[:pos]
+	}
+	if _, _, elem := findIndexedOption(specs, opt); elem != nil {
+		return true
+	}
+	return findOption(specs, opt) != nil || findClearOption(specs, opt) != nil ||
+		findNegatedOption(specs, opt) != nil || findListFileOption(specs, opt) != nil
 }
 
 func nextIsNumeric(t reflect.Type, s string) bool {
+	if elem, ok := optionalElemOf(t); ok {
+		return nextIsNumeric(elem, s)
+	}
 	switch t.Kind() {
 	case reflect.Ptr:
 		return nextIsNumeric(t.Elem(), s)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		v := reflect.New(t)
-		err := scalar.ParseValue(v, s)
+		err := parseScalar(v, s)
 		return err == nil
 	default:
 		return false
 	}
 }
 
+// invertBool returns the string form of the negation of a boolean string, as
+// used for options tagged "inverted"
+func invertBool(s string) (string, error) {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatBool(!b), nil
+}
+
 // isFlag returns true if a token is a flag such as "-v" or "--user" but not "-" or "--"
 func isFlag(s string) bool {
 	return strings.HasPrefix(s, "-") && strings.TrimLeft(s, "-") != ""
@@ -836,6 +4222,144 @@ func (p *Parser) val(dest path) reflect.Value {
 	return v
 }
 
+// commandStruct returns a pointer to the struct of the command at the given
+// path, or to a new instance of it if the command was not selected
+func (p *Parser) commandStruct(dest path) reflect.Value {
+	v := p.val(dest)
+	if len(dest.fields) > 0 && (!v.IsValid() || v.IsNil()) {
+		v = reflect.New(dest.fields[len(dest.fields)-1].Type.Elem())
+	}
+	return v
+}
+
+// choices returns the values listed by the choices tag of the given spec, or
+// calls the method named by its choicesfn tag, or returns nil if the spec has
+// neither
+func (p *Parser) choices(spec *spec) []string {
+	if spec.choiceList != nil {
+		return spec.choiceList
+	}
+	if spec.choicesFn == "" {
+		return nil
+	}
+	out := p.commandStruct(spec.owner).MethodByName(spec.choicesFn).Call(nil)
+	return out[0].Interface().([]string)
+}
+
+// checkChoices returns an error if the spec has a choices or choicesfn tag
+// and any of the values are not among the choices. An empty list of choices
+// allows any value. If the spec is tagged foldcase then each value is
+// replaced with the choice that it matches, as described for matchChoice.
+func (p *Parser) checkChoices(spec *spec, values ...string) error {
+	for i, value := range values {
+		choice, err := p.matchChoice(spec, value)
+		if err != nil {
+			return err
+		}
+		values[i] = choice
+	}
+	return nil
+}
+
+// matchChoice returns the choice of the spec that the value matches, or an
+// error if it matches none of them. If the spec is tagged foldcase then the
+// value is matched case-insensitively and the choice is returned in its own
+// case, as long as the match is not ambiguous.
+func (p *Parser) matchChoice(spec *spec, value string) (string, error) {
+	choices := p.choices(spec)
+	if len(choices) == 0 {
+		return value, nil
+	}
+	var folded []string
+	for _, choice := range choices {
+		if value == choice {
+			return choice, nil
+		}
+		if spec.foldCase && strings.EqualFold(value, choice) {
+			folded = append(folded, choice)
+		}
+	}
+	switch len(folded) {
+	case 0:
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(choices, ", "))
+	case 1:
+		return folded[0], nil
+	default:
+		return "", fmt.Errorf("%q is ambiguous between %s", value, strings.Join(folded, " and "))
+	}
+}
+
+// foldDuplicate returns two of the given choices that differ only by case,
+// if there are any
+func foldDuplicate(choices []string) (string, string, bool) {
+	for i, a := range choices {
+		for _, b := range choices[i+1:] {
+			if strings.EqualFold(a, b) {
+				return a, b, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// findClearOption finds a slice or map option tagged "clearable" from a name of
+// the form no-name, or returns nil if no such spec is found
+func findClearOption(specs []*spec, name string) *spec {
+	if !strings.HasPrefix(name, "no-") {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec.clearable && spec.long == name[3:] {
+			return spec
+		}
+	}
+	return nil
+}
+
+// findNegatedOption finds a boolean option tagged "negatable" from a name of
+// the form no-name, or returns nil if no such spec is found
+func findNegatedOption(specs []*spec, name string) *spec {
+	if !strings.HasPrefix(name, "no-") {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec.negatable && spec.long == name[3:] {
+			return spec
+		}
+	}
+	return nil
+}
+
+// findRepeatedCounter finds an option tagged "counter" from an argument that
+// repeats its short name, as in -vvv, and returns it with the number of
+// repetitions, or returns nil if no such spec is found
+func findRepeatedCounter(specs []*spec, arg string) (*spec, int) {
+	if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' {
+		return nil, 0
+	}
+	name := arg[1:]
+	for _, spec := range specs {
+		if !spec.counter || spec.short == "" || len(name)%len(spec.short) != 0 {
+			continue
+		}
+		if strings.Repeat(spec.short, len(name)/len(spec.short)) == name {
+			return spec, len(name) / len(spec.short)
+		}
+	}
+	return nil, 0
+}
+
+// findListFileOption finds a slice option whose listfile tag names the given
+// option, or returns nil if no such spec is found
+func findListFileOption(specs []*spec, name string) *spec {
+	for _, spec := range specs {
+		if spec.listFile != "" && spec.listFile == name {
+			return spec
+		}
+	}
+	return nil
+}
+
 // findOption finds an option from its name, or returns null if no spec is found
 func findOption(specs []*spec, name string) *spec {
 	for _, spec := range specs {
@@ -845,10 +4369,50 @@ func findOption(specs []*spec, name string) *spec {
 		if spec.long == name || spec.short == name {
 			return spec
 		}
+		for _, alias := range spec.aliases {
+			if alias == name {
+				return spec
+			}
+		}
 	}
 	return nil
 }
 
+// findIndexedOption finds an option of the form name.N.field, which addresses
+// a field of the N-th element of a slice of structs. It returns the spec for
+// the slice, the index, and the spec for the field, or nils if no such option
+// exists.
+func findIndexedOption(specs []*spec, name string) (*spec, int, *spec) {
+	parts := strings.SplitN(name, ".", 3)
+	if len(parts) != 3 {
+		return nil, 0, nil
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		return nil, 0, nil
+	}
+	spec := findOption(specs, parts[0])
+	if spec == nil || spec.elems == nil {
+		return nil, 0, nil
+	}
+	for _, elem := range spec.elems {
+		if elem.long == parts[2] {
+			return spec, index, elem
+		}
+	}
+	return nil, 0, nil
+}
+
+// commandPath returns the names of the subcommands from the root down to the
+// given command, excluding the name of the root command itself
+func commandPath(cmd *command) []string {
+	var names []string
+	for ; cmd != nil && cmd.parent != nil; cmd = cmd.parent {
+		names = append([]string{cmd.name}, names...)
+	}
+	return names
+}
+
 // findSubcommand finds a subcommand using its name, or returns null if no subcommand is found
 func findSubcommand(cmds []*command, name string) *command {
 	for _, cmd := range cmds {
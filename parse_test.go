@@ -3,11 +3,16 @@ package arg
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/mail"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -749,6 +754,35 @@ func TestEnvironmentVariableSliceEmpty(t *testing.T) {
 	assert.Len(t, args.Foo, 0)
 }
 
+func TestEmptyEnvironmentVariable(t *testing.T) {
+	var args struct {
+		Name string   `arg:"env" default:"x"`
+		Tags []string `arg:"env"`
+	}
+	args.Tags = []string{"a"}
+	p, err := parseWithConfigEnvErr(t, Config{}, "", []string{"NAME=", "TAGS="}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "", args.Name)
+	assert.Empty(t, args.Tags)
+	assert.Equal(t, SourceEnv, p.ValueSources()["Name"])
+	assert.Equal(t, SourceEnv, p.ValueSources()["Tags"])
+}
+
+func TestTreatEmptyEnvAsUnset(t *testing.T) {
+	var args struct {
+		Name string   `arg:"env" default:"x"`
+		Tags []string `arg:"env"`
+	}
+	args.Tags = []string{"a"}
+	p, err := parseWithConfigEnvErr(t, Config{TreatEmptyEnvAsUnset: true}, "", []string{"NAME=", "TAGS="}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "x", args.Name)
+	assert.Equal(t, []string{"a"}, args.Tags)
+	assert.Equal(t, SourceDefault, p.ValueSources()["Name"])
+	assert.Equal(t, SourceDefault, p.ValueSources()["Tags"])
+	assert.Empty(t, p.EnvSources())
+}
+
 func TestEnvironmentVariableSliceArgumentInteger(t *testing.T) {
 	var args struct {
 		Foo []int `arg:"env"`
@@ -1327,10 +1361,82 @@ func TestReuseParser(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, args.Foo, "abc")
 
+	p.Reset()
 	err = p.Parse([]string{})
 	assert.Error(t, err)
 }
 
+func TestParseTwice(t *testing.T) {
+	var args struct {
+		Foo string
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--foo=abc"}))
+
+	err = p.Parse([]string{"--foo=def"})
+	assert.Equal(t, ErrParsed, err)
+	assert.EqualError(t, err, "arguments were already parsed; call Reset before parsing again")
+	assert.Equal(t, "abc", args.Foo)
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--foo=def"}))
+	assert.Equal(t, "def", args.Foo)
+}
+
+func TestAllowReparse(t *testing.T) {
+	var args struct {
+		Tags []string `arg:"separate"`
+	}
+	p, err := NewParser(Config{AllowReparse: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--tags=a"}))
+	require.NoError(t, p.Parse([]string{"--tags=b"}))
+	assert.Equal(t, []string{"a", "b"}, args.Tags)
+}
+
+func TestReset(t *testing.T) {
+	type subCmd struct {
+		Count int
+	}
+	var args struct {
+		Tags []string `arg:"separate"`
+		Name string   `default:"x"`
+		Sub  *subCmd  `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{RecordOrder: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--tags=a", "--name=y", "sub", "--count=3"}))
+	require.NotNil(t, args.Sub)
+
+	p.Reset()
+	assert.Nil(t, args.Sub)
+	assert.Nil(t, args.Tags)
+	assert.Empty(t, args.Name)
+	assert.Nil(t, p.ValueSources())
+	assert.Nil(t, p.ParseOrder())
+	assert.Nil(t, p.Subcommand())
+
+	require.NoError(t, p.Parse([]string{"--tags=b"}))
+	assert.Equal(t, []string{"b"}, args.Tags)
+	assert.Equal(t, "x", args.Name)
+	assert.Nil(t, args.Sub)
+}
+
+func TestResetIgnoreDefault(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{IgnoreDefault: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--name=y"}))
+
+	p.Reset()
+	assert.Equal(t, "y", args.Name)
+	require.NoError(t, p.Parse(nil))
+	assert.Equal(t, "y", args.Name)
+}
+
 func TestNoVersion(t *testing.T) {
 	var args struct{}
 
@@ -1679,3 +1785,3441 @@ func TestSubcommandGlobalFlag_InCommand_Strict_Inner(t *testing.T) {
 	assert.False(t, args.Global)
 	assert.True(t, args.Sub.Guard)
 }
+
+func TestStrictEnvPrefix(t *testing.T) {
+	var args struct {
+		Port int `arg:"env:MYAPP_PORT"`
+	}
+	config := Config{
+		StrictEnvPrefix: "MYAPP_",
+		Environment:     map[string]string{"MYAPP_PORT": "80", "OTHER_VAR": "x"},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, 80, args.Port)
+}
+
+func TestStrictEnvPrefixUnknownVariable(t *testing.T) {
+	var args struct {
+		Port int `arg:"env:MYAPP_PORT"`
+	}
+	config := Config{
+		StrictEnvPrefix: "MYAPP_",
+		Environment:     map[string]string{"MYAPP_PROT": "80"},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "", []string{"MYAPP_HOTS=x"}, &args)
+	assert.EqualError(t, err, "unknown environment variables MYAPP_HOTS, MYAPP_PROT")
+}
+
+func TestStrictEnvPrefixWarnOnly(t *testing.T) {
+	var args struct {
+		Port int `arg:"env:MYAPP_PORT"`
+	}
+	config := Config{
+		StrictEnvPrefix:         "MYAPP_",
+		StrictEnvPrefixWarnOnly: true,
+		Environment:             map[string]string{"MYAPP_PORT": "80", "MYAPP_PROT": "81"},
+	}
+	p, err := parseWithConfigEnvErr(t, config, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, 80, args.Port)
+	assert.Equal(t, []string{"unknown environment variable MYAPP_PROT"}, p.Warnings())
+
+	config.StrictEnvPrefixWarnOnly = false
+	_, err = parseWithConfigEnvErr(t, config, "", nil, &args)
+	assert.EqualError(t, err, "unknown environment variable MYAPP_PROT")
+}
+
+func TestStrictEnvPrefixSubcommand(t *testing.T) {
+	var args struct {
+		Deploy *struct {
+			Target string `arg:"env:MYAPP_TARGET"`
+		} `arg:"subcommand"`
+		List *struct{} `arg:"subcommand"`
+	}
+	config := Config{
+		StrictEnvPrefix: "MYAPP_",
+		Environment:     map[string]string{"MYAPP_TARGET": "prod"},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "list", nil, &args)
+	assert.NoError(t, err)
+}
+
+func TestStrictEnvPrefixExperimentalEnv(t *testing.T) {
+	var args struct {
+		NewEngine bool `arg:"--new-engine,experimental"`
+	}
+	config := Config{
+		StrictEnvPrefix: "MYAPP_",
+		ExperimentalEnv: "MYAPP_EXPERIMENTAL",
+		Environment:     map[string]string{"MYAPP_EXPERIMENTAL": "1"},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "--new-engine", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.NewEngine)
+}
+
+func TestStrictEnvPrefixRequiredIfEnv(t *testing.T) {
+	var args struct {
+		Token string `arg:"--token,required-if-env:MYAPP_CI"`
+	}
+	config := Config{
+		StrictEnvPrefix: "MYAPP_",
+		Environment:     map[string]string{"MYAPP_CI": "1"},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "--token x", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "x", args.Token)
+
+	args.Token = ""
+	_, err = parseWithConfigEnvErr(t, config, "", nil, &args)
+	var required *RequiredError
+	require.True(t, errors.As(err, &required))
+	assert.Equal(t, "MYAPP_CI", required.IfEnv)
+}
+
+func TestStrictEnvPrefixIgnoreEnv(t *testing.T) {
+	var args struct {
+		Port int `arg:"env:MYAPP_PORT"`
+	}
+	setenv(t, "MYAPP_PROT", "80")
+	_, err := parseWithConfigEnvErr(t, Config{StrictEnvPrefix: "MYAPP_", IgnoreEnv: true}, "", nil, &args)
+	assert.NoError(t, err)
+}
+
+func TestPositionalIndex(t *testing.T) {
+	type Source struct {
+		Src string `arg:"positional,index:0"`
+	}
+	var args struct {
+		Dst string `arg:"positional,index:1"`
+		Source
+		Verbose bool
+	}
+	p := pparse(t, "a b --verbose", &args)
+	assert.Equal(t, "a", args.Src)
+	assert.Equal(t, "b", args.Dst)
+	assert.True(t, args.Verbose)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, "Usage: "+p.cmd.name+" [--verbose] [SRC [DST]]\n", usage.String())
+}
+
+func TestPositionalIndexStartingAtOne(t *testing.T) {
+	var args struct {
+		B string `arg:"positional,index:2"`
+		A string `arg:"positional,index:1"`
+	}
+	parse(t, "a b", &args)
+	assert.Equal(t, "a", args.A)
+	assert.Equal(t, "b", args.B)
+}
+
+func TestPositionalIndexErrors(t *testing.T) {
+	var gap struct {
+		A string `arg:"positional,index:0"`
+		B string `arg:"positional,index:2"`
+	}
+	_, err := NewParser(Config{}, &gap)
+	assert.EqualError(t, err, "args: positional indices must be contiguous but index 1 is missing")
+
+	var duplicate struct {
+		A string `arg:"positional,index:0"`
+		B string `arg:"positional,index:0"`
+	}
+	_, err = NewParser(Config{}, &duplicate)
+	assert.EqualError(t, err, "args: positionals A and B have the same index 0")
+
+	var start struct {
+		A string `arg:"positional,index:2"`
+	}
+	_, err = NewParser(Config{}, &start)
+	assert.EqualError(t, err, "args: positional indices must start at 0 or 1 but the lowest is 2")
+
+	var mixed struct {
+		A string `arg:"positional,index:0"`
+		B string `arg:"positional"`
+	}
+	_, err = NewParser(Config{}, &mixed)
+	assert.EqualError(t, err, "args: positional B has no index but positional A does; either all positionals or none must have an index")
+
+	var notPositional struct {
+		A string `arg:"index:0"`
+	}
+	_, err = NewParser(Config{}, &notPositional)
+	assert.Error(t, err)
+
+	var invalid struct {
+		A string `arg:"positional,index:x"`
+	}
+	_, err = NewParser(Config{}, &invalid)
+	assert.Error(t, err)
+}
+
+func TestEmptyAttachedValue(t *testing.T) {
+	var args struct {
+		Name  string `default:"bob"`
+		Other string
+	}
+	p := pparse(t, "--name= --other x", &args)
+	assert.Equal(t, "", args.Name)
+	assert.Equal(t, "x", args.Other)
+	assert.Equal(t, SourceArg, p.ValueSources()["Name"])
+}
+
+func TestEmptyAttachedValueNonString(t *testing.T) {
+	var args struct {
+		Count int
+		Ptr   *string
+	}
+	_, err := parseWithEnvErr(t, "--count= 3", nil, &args)
+	assert.Error(t, err)
+
+	parse(t, "--ptr=", &args)
+	require.NotNil(t, args.Ptr)
+	assert.Equal(t, "", *args.Ptr)
+}
+
+func TestSliceOfStructs(t *testing.T) {
+	type Server struct {
+		Host   string
+		Port   int
+		Secure bool `arg:"--tls"`
+	}
+	var args struct {
+		Server []Server
+	}
+	parse(t, "--server.0.host a --server.0.port 1 --server.1.host=b --server.1.tls", &args)
+	assert.Equal(t, []Server{{Host: "a", Port: 1}, {Host: "b", Secure: true}}, args.Server)
+}
+
+func TestSliceOfStructsOutOfOrder(t *testing.T) {
+	type Server struct {
+		Host string
+	}
+	var args struct {
+		Server []Server
+	}
+	parse(t, "--server.2.host c --server.0.host a", &args)
+	assert.Equal(t, []Server{{Host: "a"}, {}, {Host: "c"}}, args.Server)
+}
+
+func TestSliceOfStructsErrors(t *testing.T) {
+	type Server struct {
+		Port int
+	}
+	var args struct {
+		Server []Server
+	}
+	_, err := parseWithEnvErr(t, "--server 1", nil, &args)
+	assert.EqualError(t, err, "--server must be given with an index and a field, as in --server.0.port")
+
+	_, err = parseWithEnvErr(t, "--server.0.host x", nil, &args)
+	assert.EqualError(t, err, "unknown argument --server.0.host")
+
+	_, err = parseWithEnvErr(t, "--server.x.port 1", nil, &args)
+	assert.EqualError(t, err, "unknown argument --server.x.port")
+
+	_, err = parseWithEnvErr(t, "--server.0.port x", nil, &args)
+	assert.Error(t, err)
+
+	_, err = parseWithEnvErr(t, "--server.0.port", nil, &args)
+	assert.EqualError(t, err, "missing value for --server.0.port")
+
+	_, err = parseWithEnvErr(t, "--server.999999999999.port 1", nil, &args)
+	assert.EqualError(t, err, "error processing --server.999999999999.port: index 999999999999 out of range, the largest index is 999")
+
+	parse(t, "--server.999.port 1", &args)
+	assert.Len(t, args.Server, 1000)
+}
+
+func TestSliceOfStructsUnsupportedElement(t *testing.T) {
+	var empty struct {
+		Server []struct{}
+	}
+	_, err := NewParser(Config{}, &empty)
+	assert.Error(t, err)
+
+	var nested struct {
+		Server []struct {
+			Tags []string
+		}
+	}
+	_, err = NewParser(Config{}, &nested)
+	assert.Error(t, err)
+
+	var withEnv struct {
+		Server []struct {
+			Host string
+		} `arg:"env"`
+	}
+	_, err = NewParser(Config{}, &withEnv)
+	assert.Error(t, err)
+}
+
+func TestPreprocess(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Rest    []string `arg:"positional"`
+	}
+	config := Config{
+		Preprocess: func(args []string) ([]string, error) {
+			var out []string
+			for _, arg := range args {
+				if arg == "-verbose-mode" {
+					arg = "--verbose"
+				}
+				out = append(out, arg)
+			}
+			return append(out, "--", "-x"), nil
+		},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "-verbose-mode a", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, []string{"a", "-x"}, args.Rest)
+}
+
+func TestPreprocessError(t *testing.T) {
+	var args struct {
+		Verbose bool
+	}
+	config := Config{
+		Preprocess: func(args []string) ([]string, error) {
+			return nil, errors.New("old flags are no longer supported")
+		},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "--help", nil, &args)
+	assert.EqualError(t, err, "old flags are no longer supported")
+}
+
+func TestMapOfSlices(t *testing.T) {
+	var args struct {
+		Header map[string][]string `arg:"separate"`
+	}
+	parse(t, "--header Accept=json --header Accept=xml --header Host=x", &args)
+	assert.Equal(t, map[string][]string{"Accept": {"json", "xml"}, "Host": {"x"}}, args.Header)
+}
+
+func TestMapOfSlicesWithSeparator(t *testing.T) {
+	var args struct {
+		Header map[string][]string `arg:"sep"`
+	}
+	parse(t, "--header X=a,b Y=c", &args)
+	assert.Equal(t, map[string][]string{"X": {"a", "b"}, "Y": {"c"}}, args.Header)
+}
+
+func TestSliceWithSeparator(t *testing.T) {
+	var args struct {
+		Tags []string `arg:"sep:;"`
+		Ids  []int    `arg:"positional,sep"`
+	}
+	parse(t, "1,2 3 --tags a;b c", &args)
+	assert.Equal(t, []string{"a", "b", "c"}, args.Tags)
+	assert.Equal(t, []int{1, 2, 3}, args.Ids)
+}
+
+func TestSeparatorNotAllowedOnScalar(t *testing.T) {
+	var args struct {
+		Name string `arg:"sep"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.Error(t, err)
+}
+
+func TestMapOfUnsupportedSlices(t *testing.T) {
+	var args struct {
+		Header map[string][]struct{}
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Header: map[string][]struct {} fields are not supported")
+}
+
+func TestSplitWords(t *testing.T) {
+	assert.Equal(t, []string{"API", "Key"}, splitWords("APIKey"))
+	assert.Equal(t, []string{"Database", "URL"}, splitWords("DatabaseURL"))
+	assert.Equal(t, []string{"Foo", "Bar"}, splitWords("Foo_Bar"))
+	assert.Equal(t, []string{"Port"}, splitWords("Port"))
+	assert.Equal(t, []string{"Http2", "Enabled"}, splitWords("Http2Enabled"))
+	assert.Equal(t, []string{"ID"}, splitWords("ID"))
+}
+
+func TestAutoEnv(t *testing.T) {
+	var args struct {
+		APIKey  string
+		Port    int    `arg:"env:LISTEN_PORT"`
+		Token   string `arg:"noenv"`
+		Verbose bool
+		Input   string `arg:"positional"`
+	}
+	env := []string{"API_KEY=secret", "LISTEN_PORT=80", "TOKEN=abc", "VERBOSE=true", "INPUT=x"}
+	_, err := parseWithConfigEnvErr(t, Config{AutoEnv: true}, "", env, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", args.APIKey)
+	assert.Equal(t, 80, args.Port)
+	assert.Equal(t, "", args.Token)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, "", args.Input)
+}
+
+func TestAutoEnvSubcommand(t *testing.T) {
+	var args struct {
+		Deploy *struct {
+			Target string
+		} `arg:"subcommand"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AutoEnv: true}, "deploy", []string{"TARGET=prod"}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", args.Deploy.Target)
+}
+
+func TestEnvCase(t *testing.T) {
+	type caseArgs struct {
+		APIKey     string
+		DBHostName string
+		Token      string `arg:"env"`
+		Port       int    `arg:"env:LISTEN_PORT"`
+		Deploy     *struct {
+			TargetRegion string
+		} `arg:"subcommand"`
+	}
+	for _, test := range []struct {
+		envCase EnvCase
+		names   map[string]string
+	}{
+		{EnvCaseUpper, map[string]string{
+			"APIKey": "API_KEY", "DBHostName": "DB_HOST_NAME", "Token": "TOKEN", "Port": "LISTEN_PORT", "Deploy.TargetRegion": "TARGET_REGION",
+		}},
+		{EnvCaseLower, map[string]string{
+			"APIKey": "api_key", "DBHostName": "db_host_name", "Token": "token", "Port": "LISTEN_PORT", "Deploy.TargetRegion": "target_region",
+		}},
+		{EnvCaseAsIs, map[string]string{
+			"APIKey": "API_Key", "DBHostName": "DB_Host_Name", "Token": "Token", "Port": "LISTEN_PORT", "Deploy.TargetRegion": "Target_Region",
+		}},
+	} {
+		var args caseArgs
+		config := Config{AutoEnv: true, EnvCase: test.envCase, Environment: map[string]string{}}
+		for _, name := range test.names {
+			config.Environment[name] = "1"
+		}
+		p, err := NewParser(config, &args)
+		require.NoError(t, err)
+		require.NoError(t, p.Parse([]string{"deploy"}))
+		assert.Equal(t, test.names, p.EnvSources(), "case %d", test.envCase)
+		assert.Equal(t, "1", args.APIKey)
+		assert.Equal(t, "1", args.Deploy.TargetRegion)
+	}
+}
+
+func TestEnvCaseWithoutAutoEnv(t *testing.T) {
+	var args struct {
+		APIKey string `arg:"env"`
+		Other  string
+	}
+	config := Config{EnvCase: EnvCaseLower, Environment: map[string]string{"apikey": "secret", "other": "x"}}
+	p, err := NewParser(config, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(nil))
+	assert.Equal(t, "secret", args.APIKey)
+	assert.Equal(t, "", args.Other)
+	assert.Equal(t, map[string]string{"APIKey": "apikey"}, p.EnvSources())
+}
+
+func TestNoEnvWithoutAutoEnv(t *testing.T) {
+	var args struct {
+		Token string `arg:"--token,noenv"`
+	}
+	parseWithEnv(t, "--token x", []string{"TOKEN=abc"}, &args)
+	assert.Equal(t, "x", args.Token)
+}
+
+func TestNoEnvAndEnv(t *testing.T) {
+	var args struct {
+		Token string `arg:"env,noenv"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Token: env and noenv cannot be used together")
+}
+
+func TestInterspersedFlags(t *testing.T) {
+	var args struct {
+		Verbose   bool     `arg:"-v"`
+		Recursive bool     `arg:"-r"`
+		Files     []string `arg:"positional"`
+	}
+	parse(t, "-v src dst -r", &args)
+	assert.True(t, args.Verbose)
+	assert.True(t, args.Recursive)
+	assert.Equal(t, []string{"src", "dst"}, args.Files)
+}
+
+func TestNoInterspersedFlags(t *testing.T) {
+	var args struct {
+		Verbose   bool     `arg:"-v"`
+		Recursive bool     `arg:"-r"`
+		Files     []string `arg:"positional"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{NoInterspersedFlags: true}, "-v src dst -r", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.Verbose)
+	assert.False(t, args.Recursive)
+	assert.Equal(t, []string{"src", "dst", "-r"}, args.Files)
+}
+
+func TestNoInterspersedFlagsWithSubcommand(t *testing.T) {
+	var args struct {
+		Exec *struct {
+			Verbose bool     `arg:"-v"`
+			Command []string `arg:"positional"`
+		} `arg:"subcommand"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{NoInterspersedFlags: true}, "exec -v ls -l", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.Exec.Verbose)
+	assert.Equal(t, []string{"ls", "-l"}, args.Exec.Command)
+}
+
+func TestDuplicatePositionalNames(t *testing.T) {
+	type Embedded struct {
+		Src string `arg:"positional"`
+	}
+	var args struct {
+		Embedded
+		Source string `arg:"positional" placeholder:"SRC"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args: positionals Src and Source have the same name SRC")
+}
+
+func TestDuplicatePositionalNamesInSubcommand(t *testing.T) {
+	var args struct {
+		Copy *struct {
+			From string `arg:"positional" placeholder:"PATH"`
+			To   string `arg:"positional" placeholder:"PATH"`
+		} `arg:"subcommand"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args.Copy: positionals From and To have the same name PATH")
+}
+
+func TestSliceStopsAtKnownFlag(t *testing.T) {
+	var args struct {
+		Files   []string
+		Verbose bool
+	}
+	parse(t, "--files a b c --verbose", &args)
+	assert.Equal(t, []string{"a", "b", "c"}, args.Files)
+	assert.True(t, args.Verbose)
+}
+
+func TestSliceStopsAtUnknownDash(t *testing.T) {
+	var args struct {
+		Files []string
+	}
+	_, err := parseWithEnvErr(t, "--files a -b", nil, &args)
+	assert.EqualError(t, err, "unknown argument -b")
+}
+
+func TestSliceOfNegativeNumbers(t *testing.T) {
+	var args struct {
+		Nums    []int
+		Floats  []float64
+		Verbose bool `arg:"-1"`
+	}
+	parse(t, "--nums -2 2 -3 --floats -1.5 -1", &args)
+	assert.Equal(t, []int{-2, 2, -3}, args.Nums)
+	assert.Equal(t, []float64{-1.5}, args.Floats)
+	assert.True(t, args.Verbose)
+}
+
+func TestAllowDashValues(t *testing.T) {
+	var args struct {
+		Exclude []string
+		Verbose bool `arg:"-v"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AllowDashValues: true}, "--exclude -foo --bar=x -v", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-foo", "--bar=x"}, args.Exclude)
+	assert.True(t, args.Verbose)
+}
+
+func TestAllowDashValuesStopsAtBuiltins(t *testing.T) {
+	var args struct {
+		Exclude []string
+		Rest    []string `arg:"positional"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AllowDashValues: true}, "--exclude -foo -- -bar", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-foo"}, args.Exclude)
+	assert.Equal(t, []string{"-bar"}, args.Rest)
+
+	_, err = parseWithConfigEnvErr(t, Config{AllowDashValues: true}, "--exclude -foo --help", nil, &args)
+	assert.Equal(t, ErrHelp, err)
+}
+
+func TestSuggestFlags(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Version string `arg:"--versions"`
+		Output  string
+	}
+	_, err := parseWithConfigEnvErr(t, Config{SuggestFlags: true}, "--verbsoe", nil, &args)
+	assert.EqualError(t, err, "unknown argument --verbsoe (did you mean --verbose?)")
+
+	var unknown *UnknownArgError
+	require.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "--verbsoe", unknown.Arg)
+	assert.Equal(t, []string{"--verbose"}, unknown.Suggestions)
+
+	_, err = parseWithConfigEnvErr(t, Config{SuggestFlags: true}, "--versio=1", nil, &args)
+	assert.EqualError(t, err, "unknown argument --versio=1 (did you mean --versions?)")
+
+	_, err = parseWithConfigEnvErr(t, Config{SuggestFlags: true}, "--xyz", nil, &args)
+	assert.EqualError(t, err, "unknown argument --xyz")
+}
+
+func TestSuggestFlagsAtMostTwo(t *testing.T) {
+	var args struct {
+		Cat bool
+		Car bool
+		Cab bool
+	}
+	_, err := parseWithConfigEnvErr(t, Config{SuggestFlags: true}, "--ca", nil, &args)
+	assert.EqualError(t, err, "unknown argument --ca (did you mean --cat or --car?)")
+
+	_, err = parseWithEnvErr(t, "--ca", nil, &args)
+	assert.EqualError(t, err, "unknown argument --ca")
+}
+
+type workDir string
+
+func (workDir) DefaultValue() string {
+	return "/work"
+}
+
+type dynamicLevel struct {
+	level int
+}
+
+func (l *dynamicLevel) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "level-%d", &l.level)
+	return err
+}
+
+func (l *dynamicLevel) DefaultValue() string {
+	return "level-3"
+}
+
+func TestDefaultProvider(t *testing.T) {
+	var args struct {
+		Dir    workDir
+		Ptr    *workDir
+		Tagged workDir `default:"/tagged"`
+		Level  dynamicLevel
+	}
+	p := pparse(t, "", &args)
+	assert.Equal(t, workDir("/work"), args.Dir)
+	require.NotNil(t, args.Ptr)
+	assert.Equal(t, workDir("/work"), *args.Ptr)
+	assert.Equal(t, workDir("/tagged"), args.Tagged)
+	assert.Equal(t, 3, args.Level.level)
+	assert.Equal(t, SourceDefault, p.ValueSources()["Dir"])
+
+	parse(t, "--dir /here --level level-5", &args)
+	assert.Equal(t, workDir("/here"), args.Dir)
+	assert.Equal(t, 5, args.Level.level)
+}
+
+func TestDefaultProviderSatisfiesRequired(t *testing.T) {
+	var args struct {
+		Dir workDir `arg:"required"`
+	}
+	parse(t, "", &args)
+	assert.Equal(t, workDir("/work"), args.Dir)
+}
+
+func TestDefaultProviderIgnoreDefault(t *testing.T) {
+	var args struct {
+		Dir workDir
+	}
+	_, err := parseWithConfigEnvErr(t, Config{IgnoreDefault: true}, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, workDir(""), args.Dir)
+}
+
+func TestNargs(t *testing.T) {
+	var args struct {
+		Point  [2]float64 `arg:"--point,nargs:2"`
+		Size   [2]int     `arg:"nargs:2" default:"640 480"`
+		Origin [3]int     `arg:"positional,nargs:3"`
+	}
+	parse(t, "--point 1.5 -2 1 2 3", &args)
+	assert.Equal(t, [2]float64{1.5, -2}, args.Point)
+	assert.Equal(t, [2]int{640, 480}, args.Size)
+	assert.Equal(t, [3]int{1, 2, 3}, args.Origin)
+
+	parse(t, "--size=800 600 4 5 6", &args)
+	assert.Equal(t, [2]int{800, 600}, args.Size)
+}
+
+func TestNargsFromEnv(t *testing.T) {
+	var args struct {
+		Point [2]int `arg:"env,nargs:2"`
+	}
+	parseWithEnv(t, "", []string{"POINT=3,4"}, &args)
+	assert.Equal(t, [2]int{3, 4}, args.Point)
+}
+
+func TestNargsErrors(t *testing.T) {
+	var args struct {
+		Point   [2]int `arg:"--point,nargs:2"`
+		Verbose bool
+	}
+	_, err := parseWithEnvErr(t, "--point 1", nil, &args)
+	assert.EqualError(t, err, "--point requires 2 values but got 1")
+
+	_, err = parseWithEnvErr(t, "--point 1 --verbose", nil, &args)
+	assert.EqualError(t, err, "--point requires 2 values but got 1")
+
+	var pos struct {
+		Point [2]int `arg:"positional,nargs:2"`
+	}
+	_, err = parseWithEnvErr(t, "1", nil, &pos)
+	assert.EqualError(t, err, "POINT requires 2 values but got 1")
+}
+
+func TestNargsRequiresArray(t *testing.T) {
+	var slice struct {
+		Point []int `arg:"nargs:2"`
+	}
+	_, err := NewParser(Config{}, &slice)
+	assert.EqualError(t, err, ".Point: nargs:2 requires an array field of length 2")
+
+	var short struct {
+		Point [3]int `arg:"nargs:2"`
+	}
+	_, err = NewParser(Config{}, &short)
+	assert.Error(t, err)
+
+	var bad struct {
+		Point [2]int `arg:"nargs:x"`
+	}
+	_, err = NewParser(Config{}, &bad)
+	assert.Error(t, err)
+}
+
+func TestInvertedBool(t *testing.T) {
+	var args struct {
+		Verbose bool  `arg:"--quiet,inverted,env:QUIET"`
+		Color   *bool `arg:"--no-color,inverted"`
+	}
+	parse(t, "", &args)
+	assert.True(t, args.Verbose)
+	require.NotNil(t, args.Color)
+	assert.True(t, *args.Color)
+
+	parse(t, "--quiet --no-color", &args)
+	assert.False(t, args.Verbose)
+	assert.False(t, *args.Color)
+
+	parse(t, "--quiet=false", &args)
+	assert.True(t, args.Verbose)
+
+	parseWithEnv(t, "", []string{"QUIET=1"}, &args)
+	assert.False(t, args.Verbose)
+}
+
+func TestInvertedRequiresBool(t *testing.T) {
+	var args struct {
+		Name string `arg:"inverted"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Name: inverted can only be used with boolean options")
+}
+
+func TestMapWithCustomSeparators(t *testing.T) {
+	var args struct {
+		Meta   map[string]string `arg:"--meta,sep:;"`
+		Labels map[string]int    `arg:"sep:\t,kvsep::"`
+	}
+	parse(t, "--meta a=1,x;b=2 --labels x:1\ty:2", &args)
+	assert.Equal(t, map[string]string{"a": "1,x", "b": "2"}, args.Meta)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, args.Labels)
+}
+
+func TestMapWithCustomSeparatorMissing(t *testing.T) {
+	var args struct {
+		Labels map[string]int `arg:"kvsep::"`
+	}
+	_, err := parseWithEnvErr(t, "--labels x=1", nil, &args)
+	assert.EqualError(t, err, `error processing --labels: cannot parse "x=1" into a map, expected format key:value`)
+}
+
+func TestKVSepRequiresMap(t *testing.T) {
+	var args struct {
+		Names []string `arg:"kvsep::"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Names: kvsep can only be used with map fields")
+}
+
+func TestFreeze(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.False(t, p.Frozen())
+	assert.NoError(t, p.checkFrozen("Register"))
+
+	p.Freeze()
+	assert.True(t, p.Frozen())
+	err = p.checkFrozen("Register")
+	assert.True(t, errors.Is(err, ErrFrozen))
+	assert.EqualError(t, err, "Register: parser is frozen")
+
+	err = p.RegisterChoices("Name", []string{"a", "b"})
+	assert.True(t, errors.Is(err, ErrFrozen))
+	assert.EqualError(t, err, "RegisterChoices: parser is frozen")
+
+	require.NoError(t, p.Parse([]string{"--name", "a"}))
+	assert.Equal(t, "a", args.Name)
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--name", "b"}))
+	assert.Equal(t, "b", args.Name)
+}
+
+// region is a map key type that only accepts lowercase two-letter codes
+type region struct {
+	code string
+}
+
+func (r *region) UnmarshalText(b []byte) error {
+	s := string(b)
+	if len(s) != 2 || strings.ToLower(s) != s {
+		return fmt.Errorf("invalid region %q", s)
+	}
+	r.code = s
+	return nil
+}
+
+func TestMapWithTextUnmarshalerKey(t *testing.T) {
+	var args struct {
+		Capacity map[region]int
+	}
+	parse(t, "--capacity us=3 eu=2", &args)
+	assert.Equal(t, map[region]int{{"us"}: 3, {"eu"}: 2}, args.Capacity)
+
+	_, err := parseWithEnvErr(t, "--capacity US=3", nil, &args)
+	assert.EqualError(t, err, `error processing --capacity: error parsing key "US": invalid region "US"`)
+}
+
+func TestMustParseHelpAndErrorDestinations(t *testing.T) {
+	var exitCode int
+	var out, help, errs bytes.Buffer
+	exit := func(code int) { exitCode = code }
+
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{Program: "example", Out: &out, HelpDestination: &help, ErrorDestination: &errs, Exit: exit}, &args)
+	require.NoError(t, err)
+
+	p.MustParse([]string{"--help"})
+	assert.Equal(t, 0, exitCode)
+	assert.Contains(t, help.String(), "Usage: example [--name NAME]")
+	assert.Empty(t, errs.String())
+
+	help.Reset()
+	p.Reset()
+	p.MustParse([]string{"--bogus"})
+	assert.Equal(t, -1, exitCode)
+	assert.Equal(t, "Usage: example [--name NAME]\nerror: unknown argument --bogus\n", errs.String())
+	assert.Empty(t, help.String())
+	assert.Empty(t, out.String())
+}
+
+func TestMustParseErrorDestinationForInvalidParser(t *testing.T) {
+	var exitCode int
+	var out, errs bytes.Buffer
+	exit := func(code int) { exitCode = code }
+
+	var args struct {
+		CannotParse struct{}
+	}
+	parser := mustParse(Config{Out: &out, ErrorDestination: &errs, Exit: exit}, &args)
+	assert.Nil(t, parser)
+	assert.Equal(t, -1, exitCode)
+	assert.NotEmpty(t, errs.String())
+	assert.Empty(t, out.String())
+}
+
+func TestMapDuplicateKeys(t *testing.T) {
+	var args struct {
+		Labels map[string]string
+		Tags   map[string][]string
+	}
+	parse(t, "--labels a=1 a=2 --tags k=x k=y", &args)
+	assert.Equal(t, map[string]string{"a": "2"}, args.Labels)
+	assert.Equal(t, map[string][]string{"k": {"x", "y"}}, args.Tags)
+}
+
+func TestStrictMap(t *testing.T) {
+	var args struct {
+		Labels map[string]string `arg:"--label,separate,strict,env:LABELS"`
+	}
+	parse(t, "--label a=1 --label b=2", &args)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, args.Labels)
+
+	_, err := parseWithEnvErr(t, "--label a=1 --label a=2", nil, &args)
+	assert.EqualError(t, err, `error processing --label: duplicate key "a"`)
+
+	// values from the command line take precedence over the environment
+	parseWithEnv(t, "--label a=2", []string{"LABELS=a=1"}, &args)
+	assert.Equal(t, "2", args.Labels["a"])
+
+	_, err = parseWithEnvErr(t, "", []string{"LABELS=a=1,a=2"}, &args)
+	assert.EqualError(t, err, `error processing environment variable LABELS with multiple values: duplicate key "a"`)
+}
+
+func TestStrictRequiresMap(t *testing.T) {
+	var args struct {
+		Names []string `arg:"strict"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Names: strict can only be used with map fields")
+}
+
+func TestProfileEnvPrefix(t *testing.T) {
+	var args struct {
+		Profile string `arg:"--profile,profile,env:APP_PROFILE"`
+		DBURL   string `arg:"--db-url,env:DB_URL"`
+	}
+	env := []string{"DB_URL=local", "PROD_DB_URL=prod", "STAGING_EU_DB_URL=staging"}
+
+	parseWithEnv(t, "", env, &args)
+	assert.Equal(t, "", args.Profile)
+	assert.Equal(t, "local", args.DBURL)
+
+	parseWithEnv(t, "--profile prod", env, &args)
+	assert.Equal(t, "prod", args.Profile)
+	assert.Equal(t, "prod", args.DBURL)
+
+	parseWithEnv(t, "--db-url x --profile=staging-eu", env, &args)
+	assert.Equal(t, "x", args.DBURL)
+
+	parseWithEnv(t, "--profile=staging-eu", env, &args)
+	assert.Equal(t, "staging", args.DBURL)
+
+	args.DBURL = ""
+	parseWithEnv(t, "", append(env, "APP_PROFILE=prod"), &args)
+	assert.Equal(t, "prod", args.Profile)
+	assert.Equal(t, "prod", args.DBURL)
+}
+
+func TestProfileFromDefault(t *testing.T) {
+	var args struct {
+		Profile string `arg:"profile" default:"prod"`
+		DBURL   string `arg:"env:DB_URL"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{Environment: map[string]string{"PROD_DB_URL": "prod"}}, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", args.DBURL)
+}
+
+func TestProfileErrors(t *testing.T) {
+	var notString struct {
+		Profile int `arg:"profile"`
+	}
+	_, err := NewParser(Config{}, &notString)
+	assert.EqualError(t, err, ".Profile: profile can only be used with string options")
+
+	var twice struct {
+		A string `arg:"profile"`
+		B string `arg:"profile"`
+	}
+	_, err = NewParser(Config{}, &twice)
+	assert.Error(t, err)
+
+	var nested struct {
+		Run *struct {
+			Profile string `arg:"profile"`
+		} `arg:"subcommand"`
+	}
+	_, err = NewParser(Config{}, &nested)
+	assert.EqualError(t, err, "Run.Profile: profile can only be used in the top-level command")
+}
+
+// binaryKey is a type that can only be unmarshaled from bytes
+type binaryKey struct {
+	data []byte
+}
+
+func (k *binaryKey) UnmarshalBinary(b []byte) error {
+	if len(b) != 4 {
+		return fmt.Errorf("key must be 4 bytes but got %d", len(b))
+	}
+	k.data = b
+	return nil
+}
+
+func TestBinaryUnmarshaler(t *testing.T) {
+	var args struct {
+		Key    binaryKey  `arg:"--key,encoding:base64"`
+		Salt   *binaryKey `arg:"encoding:hex,env"`
+		Secret binaryKey  `arg:"positional,encoding:base64url" default:"AQID_w=="`
+	}
+	parseWithEnv(t, "--key AQIDBA==", []string{"SALT=deadbeef"}, &args)
+	assert.Equal(t, []byte{1, 2, 3, 4}, args.Key.data)
+	require.NotNil(t, args.Salt)
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, args.Salt.data)
+	assert.Equal(t, []byte{1, 2, 3, 0xff}, args.Secret.data)
+}
+
+func TestBinaryUnmarshalerErrors(t *testing.T) {
+	var args struct {
+		Key binaryKey `arg:"--key,encoding:base64"`
+	}
+	_, err := parseWithEnvErr(t, "--key !!!", nil, &args)
+	assert.EqualError(t, err, "error processing --key: error decoding base64: illegal base64 data at input byte 0")
+
+	_, err = parseWithEnvErr(t, "--key AQID", nil, &args)
+	assert.EqualError(t, err, "error processing --key: key must be 4 bytes but got 3")
+
+	var noEncoding struct {
+		Key binaryKey
+	}
+	_, err = NewParser(Config{}, &noEncoding)
+	assert.EqualError(t, err, ".Key: fields that implement encoding.BinaryUnmarshaler require an encoding, as in encoding:base64")
+
+	var notBinary struct {
+		Name string `arg:"encoding:hex"`
+	}
+	_, err = NewParser(Config{}, &notBinary)
+	assert.EqualError(t, err, ".Name: encoding can only be used with fields that implement encoding.BinaryUnmarshaler")
+
+	var unknown struct {
+		Key binaryKey `arg:"encoding:rot13"`
+	}
+	_, err = NewParser(Config{}, &unknown)
+	assert.EqualError(t, err, `.Key: unknown encoding "rot13", expected base64, base64url, or hex`)
+}
+
+type filledDefaults struct {
+	Host    string
+	Port    int `arg:"required"`
+	Name    string
+	Tagged  string `default:"tag"`
+	Verbose bool
+	Deploy  *struct {
+		Target string
+	} `arg:"subcommand"`
+	calls []string
+}
+
+func (f *filledDefaults) FillDefaults(field string, t reflect.Type) (string, bool) {
+	f.calls = append(f.calls, field)
+	switch field {
+	case "Host":
+		return "localhost", true
+	case "Port":
+		return "8080", true
+	case "Deploy.Target":
+		return "staging", true
+	case "Verbose":
+		return "maybe", true
+	}
+	return "", false
+}
+
+func TestDefaultFiller(t *testing.T) {
+	var args filledDefaults
+	p := pparse(t, "--verbose deploy", &args)
+	assert.Equal(t, "localhost", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, "", args.Name)
+	assert.Equal(t, "tag", args.Tagged)
+	require.NotNil(t, args.Deploy)
+	assert.Equal(t, "staging", args.Deploy.Target)
+	assert.Equal(t, []string{"Host", "Port", "Name", "Deploy.Target"}, args.calls)
+	assert.Equal(t, SourceDefault, p.ValueSources()["Port"])
+
+	var other filledDefaults
+	parse(t, "--host example.com --port 1 --verbose", &other)
+	assert.Equal(t, "example.com", other.Host)
+	assert.Equal(t, 1, other.Port)
+}
+
+func TestDefaultFillerParseError(t *testing.T) {
+	var args filledDefaults
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	assert.EqualError(t, err, `error processing default value for --verbose: strconv.ParseBool: parsing "maybe": invalid syntax`)
+}
+
+func TestClearableSlice(t *testing.T) {
+	var args struct {
+		Tags   []string          `arg:"--tags,clearable"`
+		Labels map[string]string `arg:"clearable"`
+	}
+	args.Tags = []string{"a", "b"}
+	args.Labels = map[string]string{"x": "1"}
+	p := pparse(t, "--no-tags --no-labels", &args)
+	assert.NotNil(t, args.Tags)
+	assert.Empty(t, args.Tags)
+	assert.NotNil(t, args.Labels)
+	assert.Empty(t, args.Labels)
+	assert.Equal(t, SourceArg, p.ValueSources()["Tags"])
+
+	parse(t, "--no-tags --tags x", &args)
+	assert.Equal(t, []string{"x"}, args.Tags)
+}
+
+func TestClearableErrors(t *testing.T) {
+	var args struct {
+		Name string `arg:"clearable"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Name: clearable can only be used with slice or map options that have a long name")
+
+	var unknown struct {
+		Tags []string
+	}
+	_, err = parseWithEnvErr(t, "--no-tags", nil, &unknown)
+	assert.EqualError(t, err, "unknown argument --no-tags")
+}
+
+func TestGroupedNumbers(t *testing.T) {
+	var args struct {
+		Budget  int      `arg:"--budget,grouped"`
+		Rate    *float64 `arg:"grouped:_"`
+		Limits  []uint   `arg:"grouped:.,sep"`
+		Default int64    `arg:"grouped,env" default:"1,000"`
+		Pos     [2]int   `arg:"positional,nargs:2,grouped"`
+		Plain   int
+	}
+	parseWithEnv(t, "10,000 20 --budget 1,000,000 --rate 12_345.5 --limits 1.000,2.000.000", []string{"DEFAULT=2,500"}, &args)
+	assert.Equal(t, 1000000, args.Budget)
+	require.NotNil(t, args.Rate)
+	assert.Equal(t, 12345.5, *args.Rate)
+	assert.Equal(t, []uint{1000, 2000000}, args.Limits)
+	assert.Equal(t, int64(2500), args.Default)
+	assert.Equal(t, [2]int{10000, 20}, args.Pos)
+
+	_, err := parseWithEnvErr(t, "--plain 1,000", nil, &args)
+	assert.Error(t, err)
+}
+
+func TestGroupedErrors(t *testing.T) {
+	var notNumeric struct {
+		Name string `arg:"grouped"`
+	}
+	_, err := NewParser(Config{}, &notNumeric)
+	assert.EqualError(t, err, ".Name: grouped can only be used with numeric fields")
+
+	var conflict struct {
+		Sizes []int `arg:"grouped,sep"`
+	}
+	_, err = NewParser(Config{}, &conflict)
+	assert.EqualError(t, err, `.Sizes: the grouping separator "," cannot also be the slice separator`)
+}
+
+func TestReloadEnv(t *testing.T) {
+	var args struct {
+		Host    string `arg:"env:APP_HOST" default:"localhost"`
+		Port    int    `arg:"env:APP_PORT"`
+		Level   string `arg:"env:APP_LEVEL"`
+		Workers int
+	}
+	args.Workers = 4
+	p := parseWithEnv(t, "--level debug", []string{"APP_HOST=example.com", "APP_PORT=80", "APP_LEVEL=info"}, &args)
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, 80, args.Port)
+	assert.Equal(t, "debug", args.Level)
+
+	os.Unsetenv("APP_HOST")
+	setenv(t, "APP_PORT", "8080")
+	setenv(t, "APP_LEVEL", "warn")
+	args.Workers = 100
+	require.NoError(t, p.ReloadEnv())
+	assert.Equal(t, "localhost", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, "debug", args.Level)
+	assert.Equal(t, 4, args.Workers)
+
+	sources := p.ValueSources()
+	assert.Equal(t, SourceDefault, sources["Host"])
+	assert.Equal(t, SourceEnv, sources["Port"])
+	assert.Equal(t, SourceArg, sources["Level"])
+}
+
+func TestReloadEnvInvalid(t *testing.T) {
+	var args struct {
+		Host  string   `arg:"env:APP_HOST"`
+		Port  int      `arg:"env:APP_PORT"`
+		Token string   `arg:"env:APP_TOKEN"`
+		Tags  []string `arg:"env:APP_TAGS"`
+	}
+	p := parseWithEnv(t, "", []string{"APP_HOST=h1", "APP_PORT=80", "APP_TOKEN=t", "APP_TAGS=a,b"}, &args)
+
+	setenv(t, "APP_HOST", "h2")
+	setenv(t, "APP_PORT", "abc")
+	setenv(t, "APP_TAGS", "c")
+	assert.Error(t, p.ReloadEnv())
+	assert.Equal(t, "h1", args.Host)
+	assert.Equal(t, 80, args.Port)
+	assert.Equal(t, "t", args.Token)
+	assert.Equal(t, []string{"a", "b"}, args.Tags)
+	assert.Equal(t, SourceEnv, p.ValueSources()["Port"])
+	assert.Equal(t, "APP_TOKEN", p.EnvSources()["Token"])
+
+	setenv(t, "APP_PORT", "8080")
+	require.NoError(t, p.ReloadEnv())
+	assert.Equal(t, "h2", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, []string{"c"}, args.Tags)
+}
+
+func TestReloadEnvBeforeParse(t *testing.T) {
+	var args struct {
+		Host string `arg:"env"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.EqualError(t, p.ReloadEnv(), "ReloadEnv called before Parse")
+}
+
+func TestLazyField(t *testing.T) {
+	var args struct {
+		Port    func() (int, error)
+		URL     func() (*url.URL, error)      `arg:"env:APP_URL"`
+		Timeout func() (time.Duration, error) `default:"5s"`
+		Unset   func() (string, error)
+	}
+	parseWithEnv(t, "--port abc", []string{"APP_URL=https://example.com"}, &args)
+
+	require.NotNil(t, args.Port)
+	_, err := args.Port()
+	assert.Error(t, err)
+
+	require.NotNil(t, args.URL)
+	u, err := args.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", u.Host)
+
+	require.NotNil(t, args.Timeout)
+	d, err := args.Timeout()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, d)
+
+	assert.Nil(t, args.Unset)
+}
+
+func TestLazyFieldUnsupported(t *testing.T) {
+	var args struct {
+		Bad func() (struct{}, error)
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.Error(t, err)
+
+	var noError struct {
+		Bad func() int
+	}
+	_, err = NewParser(Config{}, &noError)
+	assert.Error(t, err)
+}
+
+// testUUID has the same shape as popular UUID types such as github.com/google/uuid.UUID
+type testUUID [16]byte
+
+func (u *testUUID) UnmarshalText(b []byte) error {
+	s := strings.ReplaceAll(string(b), "-", "")
+	if len(s) != 32 {
+		return fmt.Errorf("invalid UUID length: %d", len(b))
+	}
+	var out testUUID
+	for i := range out {
+		if _, err := fmt.Sscanf(s[2*i:2*i+2], "%02x", &out[i]); err != nil {
+			return fmt.Errorf("invalid UUID format")
+		}
+	}
+	*u = out
+	return nil
+}
+
+func (u testUUID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])), nil
+}
+
+func TestMapWithUUIDKeys(t *testing.T) {
+	const a = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	const b = "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	var ua, ub testUUID
+	require.NoError(t, ua.UnmarshalText([]byte(a)))
+	require.NoError(t, ub.UnmarshalText([]byte(b)))
+
+	var args struct {
+		Owners map[testUUID]string
+		Strict map[testUUID]string `arg:"strict"`
+	}
+	parse(t, "--owners "+a+"=alice "+b+"=bob "+a+"=carol", &args)
+	assert.Equal(t, map[testUUID]string{ua: "carol", ub: "bob"}, args.Owners)
+
+	// the same UUID spelled differently is still a duplicate in strict mode
+	_, err := parseWithEnvErr(t, "--strict "+a+"=alice "+strings.ToUpper(a)+"=bob", nil, &args)
+	assert.EqualError(t, err, `error processing --strict: duplicate key "`+strings.ToUpper(a)+`"`)
+
+	_, err = parseWithEnvErr(t, "--owners not-a-uuid=alice", nil, &args)
+	assert.EqualError(t, err, `error processing --owners: error parsing key "not-a-uuid": invalid UUID length: 10`)
+}
+
+func TestNewParserWithDefaults(t *testing.T) {
+	type cfg struct {
+		Host    string
+		Port    int `arg:"env:APP_PORT"`
+		Servers []string
+		Timeout time.Duration
+		Verbose bool
+	}
+	defaults := cfg{Host: "localhost", Port: 80, Servers: []string{"a", "b"}, Timeout: time.Minute}
+	var args cfg
+	p, err := NewParserWithDefaults(Config{Program: "example"}, &defaults, &args)
+	require.NoError(t, err)
+
+	setenv(t, "APP_PORT", "8080")
+	require.NoError(t, p.Parse([]string{"--servers", "c"}))
+	assert.Equal(t, "localhost", args.Host)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, []string{"c"}, args.Servers)
+	assert.Equal(t, time.Minute, args.Timeout)
+	assert.False(t, args.Verbose)
+	assert.Equal(t, []string{"a", "b"}, defaults.Servers)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--host HOST [default: localhost]")
+}
+
+func TestNewParserWithDefaultsTypeMismatch(t *testing.T) {
+	type a struct{ X int }
+	type b struct{ X int }
+	_, err := NewParserWithDefaults(Config{}, &a{}, &b{})
+	assert.EqualError(t, err, "defaults must have the same type as dest but *arg.a is not *arg.b")
+
+	_, err = NewParserWithDefaults(Config{}, a{}, a{})
+	assert.Error(t, err)
+}
+
+func TestSliceAttachedValues(t *testing.T) {
+	var args struct {
+		Tags []string
+		Nums []int
+	}
+	args.Tags = []string{"default"}
+	parse(t, "--tags=a --tags=b --nums=1", &args)
+	assert.Equal(t, []string{"a", "b"}, args.Tags)
+	assert.Equal(t, []int{1}, args.Nums)
+}
+
+func TestSliceAttachedValuesWithSeparator(t *testing.T) {
+	var args struct {
+		Tags []string          `arg:"sep:,"`
+		Vars map[string]string `arg:"sep:,"`
+	}
+	parse(t, "--tags=a,b --tags=c --vars=x=1,y=2", &args)
+	assert.Equal(t, []string{"a", "b", "c"}, args.Tags)
+	assert.Equal(t, map[string]string{"x": "1", "y": "2"}, args.Vars)
+}
+
+func TestSliceAttachedEmptyValue(t *testing.T) {
+	var args struct {
+		Tags []string
+		Pos  string `arg:"positional"`
+	}
+	args.Tags = []string{"default"}
+	parse(t, "--tags= xyz", &args)
+	assert.Empty(t, args.Tags)
+	assert.Equal(t, "xyz", args.Pos)
+}
+
+func TestSliceRepeatedOccurrences(t *testing.T) {
+	tests := []struct {
+		cmdline  string
+		expected []string
+	}{
+		{"--tags a b --tags c", []string{"a", "b", "c"}},
+		{"--tags a b --tags=c", []string{"a", "b", "c"}},
+		{"--tags=a --tags b c", []string{"a", "b", "c"}},
+		{"--tags=a --tags=b --tags=c", []string{"a", "b", "c"}},
+		{"--tags a", []string{"a"}},
+		{"--tags=a", []string{"a"}},
+		{"--tags", []string{}},
+		{"--tags=", []string{}},
+		{"--tags= --tags a", []string{"a"}},
+		{"--tags a --tags=", []string{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.cmdline, func(t *testing.T) {
+			var args struct {
+				Tags []string
+			}
+			args.Tags = []string{"default"}
+			parse(t, test.cmdline, &args)
+			assert.Equal(t, test.expected, args.Tags)
+		})
+	}
+}
+
+func TestCardinalityTag(t *testing.T) {
+	var args struct {
+		List   semicolonList
+		Each   semicolonList `arg:"cardinality:multiple"`
+		Enable bool          `arg:"cardinality:one"`
+	}
+	parse(t, "--list a;b --each c;d e --enable false", &args)
+	assert.Equal(t, semicolonList{"a", "b"}, args.List)
+	assert.Equal(t, semicolonList{"c;d", "e"}, args.Each)
+	assert.False(t, args.Enable)
+
+	_, err := parseWithEnvErr(t, "--enable", nil, &args)
+	assert.EqualError(t, err, "missing value for --enable")
+}
+
+func TestCardinalityTagInvalid(t *testing.T) {
+	var args struct {
+		Name string `arg:"cardinality:zero"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Name: cardinality zero can only be used with boolean fields")
+}
+
+func TestSliceSeparatorWithQuotes(t *testing.T) {
+	var args struct {
+		Tags []string          `arg:"sep:,,quoted"`
+		Vars map[string]string `arg:"sep:,,quoted"`
+		Raw  []string          `arg:"sep:,"`
+	}
+	parse(t, `--tags "a,b",c --vars x="1,2",y=3 --raw "a,b\c"`, &args)
+	assert.Equal(t, []string{"a,b", "c"}, args.Tags)
+	assert.Equal(t, map[string]string{"x": "1,2", "y": "3"}, args.Vars)
+	assert.Equal(t, []string{`"a`, `b\c"`}, args.Raw)
+}
+
+func TestQuotedWithoutSep(t *testing.T) {
+	var args struct {
+		Tags []string `arg:"quoted"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Tags: quoted can only be used together with sep")
+}
+
+func TestSliceSeparatorUnterminatedQuote(t *testing.T) {
+	var args struct {
+		Tags []string `arg:"sep:,,quoted"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"--tags", `"a,b`})
+	assert.EqualError(t, err, `error processing --tags: unterminated quote in "\"a,b"`)
+}
+
+func TestValidateSpec(t *testing.T) {
+	type valid struct {
+		Name  string `arg:"positional"`
+		Count int    `arg:"-c"`
+	}
+	assert.NoError(t, ValidateSpec(&valid{}))
+	assert.NoError(t, ValidateSpec(reflect.TypeOf(valid{})))
+	assert.NoError(t, ValidateSpec(reflect.TypeOf(&valid{})))
+
+	type invalid struct {
+		Short   string `arg:"-ab"`
+		Channel chan int
+		Tags    []string `arg:"kvsep:="`
+	}
+	err := ValidateSpec(reflect.TypeOf(invalid{}))
+	require.Error(t, err)
+	assert.Equal(t, 3, len(strings.Split(err.Error(), "\n")), err.Error())
+	assert.Contains(t, err.Error(), "invalid.Short: short arguments must be one character only")
+	assert.Contains(t, err.Error(), "invalid.Channel: chan int fields are not supported")
+}
+
+func TestValidateSpecSubcommands(t *testing.T) {
+	type sub struct{}
+	var args struct {
+		A *sub `arg:"subcommand:run"`
+		B *sub `arg:"subcommand:run"`
+	}
+	assert.EqualError(t, ValidateSpec(&args), "args: subcommands A and B have the same name run")
+}
+
+func TestValidateSpecNotStruct(t *testing.T) {
+	assert.EqualError(t, ValidateSpec(reflect.TypeOf(1)), "ValidateSpec requires a pointer to a struct but got int")
+	assert.EqualError(t, ValidateSpec(nil), "ValidateSpec requires a pointer to a struct")
+}
+
+func TestAutoShortFlags(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Values  []string
+		Force   bool `arg:"-x"`
+		Fast    bool
+		Help2   bool
+		File    string `arg:"positional"`
+		Output  string `arg:"-f"`
+	}
+	p, err := NewParser(Config{AutoShortFlags: true, Program: "example"}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-v", "-f", "out", "-x", "--fast", "in"}))
+	assert.True(t, args.Verbose)
+	assert.True(t, args.Force)
+	assert.True(t, args.Fast)
+	assert.Equal(t, "out", args.Output)
+	assert.Equal(t, "in", args.File)
+
+	flag, ok := p.FlagByName("values")
+	require.True(t, ok)
+	assert.Equal(t, "", flag.Short)
+	flag, ok = p.FlagByName("help2")
+	require.True(t, ok)
+	assert.Equal(t, "", flag.Short)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--verbose, -v")
+	assert.Contains(t, help.String(), "--fast\n")
+}
+
+func TestAutoShortFlagsSubcommand(t *testing.T) {
+	var args struct {
+		Debug bool
+		Run   *struct {
+			Detach bool
+			Name   string
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{AutoShortFlags: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"run", "-d", "-n", "x"}))
+	assert.True(t, args.Debug)
+	assert.False(t, args.Run.Detach)
+	assert.Equal(t, "x", args.Run.Name)
+}
+
+func TestJSONUnmarshaler(t *testing.T) {
+	var args struct {
+		Origin point
+		Ptr    *point
+		Path   []point
+		Pos    *point `arg:"positional"`
+	}
+	parse(t, "--origin [1,2] --ptr [3,4] --path [5,6] [7,8] -- [9,10]", &args)
+	assert.Equal(t, point{1, 2}, args.Origin)
+	assert.Equal(t, &point{3, 4}, args.Ptr)
+	assert.Equal(t, []point{{5, 6}, {7, 8}}, args.Path)
+	assert.Equal(t, &point{9, 10}, args.Pos)
+}
+
+func TestJSONUnmarshalerInvalid(t *testing.T) {
+	var args struct {
+		Origin point
+	}
+	_, err := parseWithEnvErr(t, "--origin [1,x]", nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing --origin: ")
+}
+
+func TestEnvAllowlist(t *testing.T) {
+	var args struct {
+		Host   string `arg:"env"`
+		Secret string `arg:"env" default:"none"`
+		Token  string `arg:"env"`
+	}
+	setenv(t, "HOST", "example.com")
+	setenv(t, "SECRET", "hunter2")
+	setenv(t, "TOKEN", "abc")
+	p, err := parseWithConfigEnvErr(t, Config{EnvAllowlist: []string{"HOST"}}, "--token xyz", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, "none", args.Secret)
+	assert.Equal(t, "xyz", args.Token)
+
+	sources := p.ValueSources()
+	assert.Equal(t, SourceEnv, sources["Host"])
+	assert.Equal(t, SourceDefault, sources["Secret"])
+	assert.Equal(t, SourceArg, sources["Token"])
+}
+
+func TestEnvAllowlistWithEnvironment(t *testing.T) {
+	var args struct {
+		Host   string `arg:"env"`
+		Secret string `arg:"env"`
+	}
+	config := Config{
+		EnvAllowlist: []string{"HOST"},
+		Environment:  map[string]string{"HOST": "example.com", "SECRET": "hunter2"},
+	}
+	p, err := parseWithConfigEnvErr(t, config, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", args.Host)
+	assert.Equal(t, "", args.Secret)
+	assert.Equal(t, SourceUnset, p.ValueSources()["Secret"])
+}
+
+type regionArgs struct {
+	Region    string   `arg:"env,choicesfn:Regions"`
+	Replicas  []string `arg:"choicesfn:Regions"`
+	Primary   string   `arg:"positional,choicesfn:Regions"`
+	available []string
+}
+
+func (a *regionArgs) Regions() []string {
+	return a.available
+}
+
+func TestChoicesFn(t *testing.T) {
+	args := regionArgs{available: []string{"us", "eu"}}
+	parse(t, "eu --region us --replicas eu us", &args)
+	assert.Equal(t, "us", args.Region)
+	assert.Equal(t, []string{"eu", "us"}, args.Replicas)
+	assert.Equal(t, "eu", args.Primary)
+}
+
+func TestChoicesFnInvalid(t *testing.T) {
+	args := regionArgs{available: []string{"us", "eu"}}
+	_, err := parseWithEnvErr(t, "--region ap", nil, &args)
+	assert.EqualError(t, err, `error processing --region: "ap" is not one of us, eu`)
+
+	_, err = parseWithEnvErr(t, "--replicas us ap", nil, &args)
+	assert.EqualError(t, err, `error processing --replicas: "ap" is not one of us, eu`)
+
+	_, err = parseWithEnvErr(t, "ap", nil, &args)
+	assert.EqualError(t, err, `error processing Primary: "ap" is not one of us, eu`)
+
+	_, err = parseWithEnvErr(t, "", []string{"REGION=ap"}, &args)
+	assert.EqualError(t, err, `error processing environment variable REGION: "ap" is not one of us, eu`)
+}
+
+func TestChoicesFnEmpty(t *testing.T) {
+	var args regionArgs
+	parse(t, "--region anywhere", &args)
+	assert.Equal(t, "anywhere", args.Region)
+}
+
+func TestChoicesFnMissingMethod(t *testing.T) {
+	var args struct {
+		Region string `arg:"choicesfn:Regions"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Region: choicesfn:Regions requires a method Regions() []string")
+}
+
+func TestChoicesFnBoolean(t *testing.T) {
+	var args struct {
+		Region bool `arg:"choicesfn:Regions"`
+		regionArgs
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Region: choicesfn cannot be used with boolean, map, array, or struct slice fields")
+}
+
+func TestChoices(t *testing.T) {
+	var args struct {
+		Level  string   `arg:"env,choices:debug|info|warn"`
+		Levels []string `arg:"choices:debug|info|warn"`
+	}
+	parse(t, "--level info --levels warn debug", &args)
+	assert.Equal(t, "info", args.Level)
+	assert.Equal(t, []string{"warn", "debug"}, args.Levels)
+
+	_, err := parseWithEnvErr(t, "--level INFO", nil, &args)
+	assert.EqualError(t, err, `error processing --level: "INFO" is not one of debug, info, warn`)
+}
+
+func TestChoicesFoldCase(t *testing.T) {
+	var args struct {
+		Level  string   `arg:"env,choices:debug|info|Warn,foldcase"`
+		Levels []string `arg:"choices:debug|info|Warn,foldcase"`
+		Mode   string   `arg:"positional,choices:Fast|slow,foldcase"`
+	}
+	parse(t, "FAST --level INFO --levels WARN Debug", &args)
+	assert.Equal(t, "info", args.Level)
+	assert.Equal(t, []string{"Warn", "debug"}, args.Levels)
+	assert.Equal(t, "Fast", args.Mode)
+
+	parseWithEnv(t, "slow", []string{"LEVEL=DeBuG"}, &args)
+	assert.Equal(t, "debug", args.Level)
+
+	_, err := parseWithEnvErr(t, "--level trace", nil, &args)
+	assert.EqualError(t, err, `error processing --level: "trace" is not one of debug, info, Warn`)
+}
+
+func TestChoicesFoldCaseChoicesFn(t *testing.T) {
+	var args struct {
+		Region string `arg:"choicesfn:Regions,foldcase"`
+		regionArgs
+	}
+	args.available = []string{"us", "US", "eu"}
+	parse(t, "eu --region EU", &args)
+	assert.Equal(t, "eu", args.Region)
+
+	parse(t, "eu --region US", &args)
+	assert.Equal(t, "US", args.Region)
+
+	_, err := parseWithEnvErr(t, "eu --region Us", nil, &args)
+	assert.EqualError(t, err, `error processing --region: "Us" is ambiguous between us and US`)
+}
+
+func TestChoicesFoldCaseAmbiguous(t *testing.T) {
+	var args struct {
+		Level string `arg:"choices:info|INFO,foldcase"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, `.Level: foldcase cannot distinguish the choices "info" and "INFO"`)
+}
+
+func TestChoicesInvalidTags(t *testing.T) {
+	var boolArgs struct {
+		Verbose bool `arg:"choices:a|b"`
+	}
+	_, err := NewParser(Config{}, &boolArgs)
+	assert.EqualError(t, err, ".Verbose: choices cannot be used with boolean, map, array, or struct slice fields")
+
+	var foldArgs struct {
+		Level string `arg:"foldcase"`
+	}
+	_, err = NewParser(Config{}, &foldArgs)
+	assert.EqualError(t, err, ".Level: foldcase can only be used with choices or choicesfn")
+
+	var bothArgs struct {
+		Region string `arg:"choices:us|eu,choicesfn:Regions"`
+		regionArgs
+	}
+	_, err = NewParser(Config{}, &bothArgs)
+	assert.EqualError(t, err, ".Region: choices and choicesfn cannot be used together")
+}
+
+func TestBytes(t *testing.T) {
+	var args struct {
+		Data    []byte
+		Chunks  [][]byte
+		Ptr     *[]byte
+		Default []byte `default:"xyz"`
+	}
+	parse(t, "--data hello --chunks ab cd --ptr 123", &args)
+	assert.Equal(t, []byte("hello"), args.Data)
+	assert.Equal(t, [][]byte{[]byte("ab"), []byte("cd")}, args.Chunks)
+	require.NotNil(t, args.Ptr)
+	assert.Equal(t, []byte("123"), *args.Ptr)
+	assert.Equal(t, []byte("xyz"), args.Default)
+}
+
+func TestBytesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, []byte{0, 1, 2}, 0600))
+
+	var args struct {
+		Data []byte `arg:"env,fromfile"`
+		Key  []byte `arg:"positional,fromfile"`
+	}
+	parse(t, "--data "+path+" "+path, &args)
+	assert.Equal(t, []byte{0, 1, 2}, args.Data)
+	assert.Equal(t, []byte{0, 1, 2}, args.Key)
+
+	args.Data = nil
+	parseWithEnv(t, "", []string{"DATA=" + path}, &args)
+	assert.Equal(t, []byte{0, 1, 2}, args.Data)
+
+	_, err := parseWithEnvErr(t, "--data "+filepath.Join(t.TempDir(), "missing"), nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing --data: ")
+}
+
+func TestFromFileRequiresBytes(t *testing.T) {
+	var args struct {
+		Name string `arg:"fromfile"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Name: fromfile can only be used with []byte fields")
+}
+
+type normalizedSub struct {
+	Name  string
+	order *[]string
+}
+
+func (s *normalizedSub) Normalize() error {
+	*s.order = append(*s.order, "sub")
+	if s.Name == "" {
+		return errors.New("name is empty")
+	}
+	s.Name = strings.ToLower(s.Name)
+	return nil
+}
+
+type normalizedArgs struct {
+	Host  string `arg:"required"`
+	URL   string
+	Sub   *normalizedSub `arg:"subcommand"`
+	order []string
+}
+
+func (a *normalizedArgs) Normalize() error {
+	a.order = append(a.order, "root")
+	if a.URL == "" {
+		a.URL = "https://" + strings.TrimSpace(a.Host)
+	}
+	if a.Sub != nil {
+		a.Sub.order = &a.order
+	}
+	return nil
+}
+
+func TestNormalize(t *testing.T) {
+	var args normalizedArgs
+	parse(t, "--host example.com sub --name ABC", &args)
+	assert.Equal(t, "https://example.com", args.URL)
+	assert.Equal(t, "abc", args.Sub.Name)
+	assert.Equal(t, []string{"root", "sub"}, args.order)
+}
+
+func TestNormalizeError(t *testing.T) {
+	var args normalizedArgs
+	_, err := parseWithEnvErr(t, "--host example.com sub", nil, &args)
+	assert.EqualError(t, err, "error normalizing sub: name is empty")
+}
+
+func TestNormalizeAfterRequired(t *testing.T) {
+	var args normalizedArgs
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	assert.EqualError(t, err, "--host is required")
+	assert.Empty(t, args.order)
+}
+
+type terminalNormalizedArgs struct {
+	Region      *string `arg:"required"`
+	PrintConfig bool    `arg:"--print-config,terminal"`
+	Zone        string
+}
+
+func (a *terminalNormalizedArgs) Normalize() error {
+	a.Zone = *a.Region + "-a"
+	return nil
+}
+
+func TestNormalizeNotCalledForTerminal(t *testing.T) {
+	var args terminalNormalizedArgs
+	_, err := parseWithEnvErr(t, "--print-config", nil, &args)
+	var terminal *TerminalError
+	require.True(t, errors.As(err, &terminal))
+	assert.True(t, args.PrintConfig)
+	assert.Equal(t, "", args.Zone)
+}
+
+type validatedSub struct {
+	Replicas int
+	order    *[]string
+}
+
+func (s *validatedSub) Validate() error {
+	*s.order = append(*s.order, "sub")
+	if s.Replicas < 0 {
+		return errors.New("--replicas cannot be negative")
+	}
+	return nil
+}
+
+type validatedArgs struct {
+	Min    int
+	Max    int              `default:"10"`
+	Deploy *validatedSub    `arg:"subcommand"`
+	Other  *struct{ X int } `arg:"subcommand"`
+	order  []string
+}
+
+func (a *validatedArgs) Normalize() error {
+	a.order = append(a.order, "normalize")
+	if a.Deploy != nil {
+		a.Deploy.order = &a.order
+	}
+	return nil
+}
+
+func (a *validatedArgs) Validate() error {
+	a.order = append(a.order, "root")
+	if a.Min > a.Max {
+		return fmt.Errorf("--min %d is greater than --max %d", a.Min, a.Max)
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	var args validatedArgs
+	parse(t, "--min 3 deploy --replicas 2", &args)
+	assert.Equal(t, []string{"normalize", "root", "sub"}, args.order)
+
+	args = validatedArgs{}
+	_, err := parseWithEnvErr(t, "--min 20", nil, &args)
+	assert.EqualError(t, err, "--min 20 is greater than --max 10")
+
+	args = validatedArgs{}
+	_, err = parseWithEnvErr(t, "deploy --replicas -1", nil, &args)
+	assert.EqualError(t, err, "--replicas cannot be negative")
+}
+
+func TestValidateNotCalledOnError(t *testing.T) {
+	var args validatedArgs
+	_, err := parseWithEnvErr(t, "--min x", nil, &args)
+	require.Error(t, err)
+	assert.Empty(t, args.order)
+}
+
+func TestMustParseValidationError(t *testing.T) {
+	var exitCode int
+	var stdout bytes.Buffer
+	exit := func(code int) { exitCode = code }
+
+	originalArgs := os.Args
+	defer func() {
+		os.Args = originalArgs
+	}()
+	os.Args = []string{"example", "deploy", "--replicas", "-1"}
+
+	var args validatedArgs
+	mustParse(Config{Out: &stdout, Exit: exit}, &args)
+	assert.Equal(t, -1, exitCode)
+	assert.Equal(t, "Usage: example deploy [--replicas REPLICAS]\nerror: --replicas cannot be negative\n", stdout.String())
+}
+
+func TestUnits(t *testing.T) {
+	var args struct {
+		Rate    int64  `arg:"unit:si"`
+		Memory  uint64 `arg:"env,unit:iec"`
+		Limit   *int   `arg:"unit:iec"`
+		Default int    `arg:"unit:iec" default:"4K"`
+		Plain   int
+	}
+	parseWithEnv(t, "--rate 1.5M --limit 2K --plain 5", []string{"MEMORY=1GiB"}, &args)
+	assert.Equal(t, int64(1500000), args.Rate)
+	assert.Equal(t, uint64(1<<30), args.Memory)
+	require.NotNil(t, args.Limit)
+	assert.Equal(t, 2048, *args.Limit)
+	assert.Equal(t, 4096, args.Default)
+	assert.Equal(t, 5, args.Plain)
+}
+
+func TestUnitsErrors(t *testing.T) {
+	var args struct {
+		Memory int `arg:"unit:iec"`
+	}
+	_, err := parseWithEnvErr(t, "--memory 1MB", nil, &args)
+	assert.EqualError(t, err, `error processing --memory: ambiguous unit suffix "MB", use M or MiB`)
+
+	var bad struct {
+		Name string `arg:"unit:si"`
+	}
+	_, err = NewParser(Config{}, &bad)
+	assert.EqualError(t, err, ".Name: unit can only be used with integer fields")
+
+	var unknown struct {
+		Size int `arg:"unit:metric"`
+	}
+	_, err = NewParser(Config{}, &unknown)
+	assert.EqualError(t, err, `.Size: unknown unit "metric", expected si or iec`)
+}
+
+func TestExperimental(t *testing.T) {
+	var args struct {
+		NewEngine bool `arg:"--new-engine,experimental"`
+		Old       bool
+	}
+	config := Config{ExperimentalEnv: "MYAPP_EXPERIMENTAL", Environment: map[string]string{}}
+	_, err := parseWithConfigEnvErr(t, config, "--new-engine", nil, &args)
+	assert.EqualError(t, err, "experimental flag --new-engine requires MYAPP_EXPERIMENTAL")
+
+	config.Environment["MYAPP_EXPERIMENTAL"] = "0"
+	_, err = parseWithConfigEnvErr(t, config, "--new-engine", nil, &args)
+	assert.Error(t, err)
+
+	config.Environment["MYAPP_EXPERIMENTAL"] = "1"
+	_, err = parseWithConfigEnvErr(t, config, "--new-engine", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.NewEngine)
+}
+
+func TestExperimentalRequiresEnv(t *testing.T) {
+	var args struct {
+		Sub *struct {
+			NewEngine bool `arg:"experimental"`
+		} `arg:"subcommand"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args.Sub.NewEngine: experimental options require Config.ExperimentalEnv")
+}
+
+func TestClockDuration(t *testing.T) {
+	var args struct {
+		Timeout  time.Duration  `arg:"clock"`
+		Interval *time.Duration `arg:"env,clock" default:"0:30"`
+		Plain    time.Duration
+	}
+	parse(t, "--timeout 1:30:00 --plain 2m", &args)
+	assert.Equal(t, 90*time.Minute, args.Timeout)
+	require.NotNil(t, args.Interval)
+	assert.Equal(t, 30*time.Second, *args.Interval)
+	assert.Equal(t, 2*time.Minute, args.Plain)
+
+	_, err := parseWithEnvErr(t, "--plain 1:30", nil, &args)
+	assert.Error(t, err)
+
+	_, err = parseWithEnvErr(t, "--timeout 1:75", nil, &args)
+	assert.EqualError(t, err, `error processing --timeout: invalid clock duration "1:75", expected m:ss, h:mm:ss, or a duration such as 1h30m`)
+}
+
+func TestClockRequiresDuration(t *testing.T) {
+	var args struct {
+		Timeout int `arg:"clock"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Timeout: clock can only be used with time.Duration fields")
+}
+
+func TestEnvFallbacksInvalid(t *testing.T) {
+	var invalid struct {
+		Token string `arg:"env:NEW_TOKEN|OLD-TOKEN"`
+	}
+	_, err := NewParser(Config{}, &invalid)
+	assert.EqualError(t, err, `.Token: "OLD-TOKEN" is not a valid environment variable name`)
+
+	// an env struct tag belongs to other libraries and is ignored
+	var other struct {
+		Token string `arg:"env:TOKEN" env:"NEW-TOKEN"`
+	}
+	p := parseWithEnv(t, "", []string{"TOKEN=a", "NEW-TOKEN=b"}, &other)
+	assert.Equal(t, "a", other.Token)
+	assert.Equal(t, map[string]string{"Token": "TOKEN"}, p.EnvSources())
+}
+
+func TestEnvFallbacksHelp(t *testing.T) {
+	var args struct {
+		Token string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--token TOKEN [env: NEW_TOKEN]")
+}
+
+func TestMapPositionalAfterRequired(t *testing.T) {
+	var args struct {
+		Cmd string            `arg:"positional,required"`
+		Env map[string]string `arg:"positional"`
+	}
+	parse(t, "run A=1 B=x=y", &args)
+	assert.Equal(t, "run", args.Cmd)
+	assert.Equal(t, map[string]string{"A": "1", "B": "x=y"}, args.Env)
+}
+
+func TestMapPositionalMissingEquals(t *testing.T) {
+	var args struct {
+		Cmd string            `arg:"positional,required"`
+		Env map[string]string `arg:"positional"`
+	}
+	_, err := parseWithEnvErr(t, "run A=1 B", nil, &args)
+	assert.EqualError(t, err, `error processing Env: cannot parse "B" into a map, expected format key=value`)
+}
+
+func TestMapPositionalNotLast(t *testing.T) {
+	var args struct {
+		Env map[string]string `arg:"positional"`
+		Cmd string            `arg:"positional"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args: map positional Env must be the last positional but Cmd follows it")
+
+	var twoMaps struct {
+		A map[string]int `arg:"positional"`
+		B map[string]int `arg:"positional"`
+	}
+	_, err = NewParser(Config{}, &twoMaps)
+	assert.EqualError(t, err, "args: map positional A must be the last positional but B follows it")
+}
+
+func TestAppenderField(t *testing.T) {
+	var args struct {
+		Set    orderedMap  `arg:"separate"`
+		Labels *orderedMap `arg:"env"`
+	}
+	parseWithEnv(t, "--set b=1 --set a=2 --set c=3", []string{"LABELS=y=1,x=2"}, &args)
+	assert.Equal(t, []string{"b", "a", "c"}, args.Set.keys)
+	require.NotNil(t, args.Labels)
+	assert.Equal(t, []string{"y", "x"}, args.Labels.keys)
+}
+
+func TestAppenderFieldError(t *testing.T) {
+	var args struct {
+		Set orderedMap
+	}
+	_, err := parseWithEnvErr(t, "--set =1", nil, &args)
+	assert.EqualError(t, err, `error processing --set: error appending "=1": empty key`)
+}
+
+type speedArgs struct {
+	Speed string `arg:"choices:fast|slow|normal" default:"normal" help:"how fast to go"`
+	Fast  bool   `arg:"setmode:speed=fast" help:"go fast"`
+	Slow  bool   `arg:"env,setmode:speed=slow"`
+}
+
+func TestSetMode(t *testing.T) {
+	var args speedArgs
+	p := pparse(t, "--fast", &args)
+	assert.Equal(t, "fast", args.Speed)
+	assert.True(t, args.Fast)
+	assert.Equal(t, SourceArg, p.ValueSources()["Speed"])
+
+	args = speedArgs{}
+	parse(t, "", &args)
+	assert.Equal(t, "normal", args.Speed)
+
+	args = speedArgs{}
+	parse(t, "--speed slow --slow", &args)
+	assert.Equal(t, "slow", args.Speed)
+
+	args = speedArgs{}
+	parse(t, "--fast --fast", &args)
+	assert.Equal(t, "fast", args.Speed)
+
+	// a value on the command line takes precedence over the environment
+	args = speedArgs{}
+	parseWithEnv(t, "--speed fast", []string{"SLOW=true"}, &args)
+	assert.Equal(t, "fast", args.Speed)
+
+	args = speedArgs{}
+	parseWithEnv(t, "", []string{"SLOW=true"}, &args)
+	assert.Equal(t, "slow", args.Speed)
+}
+
+func TestSetModeConflicts(t *testing.T) {
+	var args speedArgs
+	_, err := parseWithEnvErr(t, "--fast --slow", nil, &args)
+	assert.EqualError(t, err, "--fast and --slow cannot be used together")
+
+	args = speedArgs{}
+	_, err = parseWithEnvErr(t, "--speed slow --fast", nil, &args)
+	assert.EqualError(t, err, "--fast cannot be used together with a different value for --speed")
+}
+
+func TestSetModeHelp(t *testing.T) {
+	var args speedArgs
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "go fast (same as --speed fast)")
+	assert.Contains(t, help.String(), "how fast to go (one of fast, slow, normal)")
+}
+
+func TestSetModeInvalid(t *testing.T) {
+	var missing struct {
+		Fast bool `arg:"setmode:speed=fast"`
+	}
+	_, err := NewParser(Config{}, &missing)
+	assert.EqualError(t, err, "args.Fast: setmode refers to --speed but there is no such option")
+
+	var notBool struct {
+		Speed string
+		Fast  string `arg:"setmode:speed=fast"`
+	}
+	_, err = NewParser(Config{}, &notBool)
+	assert.EqualError(t, err, ".Fast: setmode can only be used with boolean options")
+
+	var badValue struct {
+		Speed int
+		Fast  bool `arg:"setmode:speed=fast"`
+	}
+	_, err = NewParser(Config{}, &badValue)
+	assert.EqualError(t, err, `args.Fast: error processing setmode value "fast": strconv.ParseInt: parsing "fast": invalid syntax`)
+
+	var notChoice struct {
+		Speed string `arg:"choices:slow"`
+		Fast  bool   `arg:"setmode:speed=fast"`
+	}
+	_, err = NewParser(Config{}, &notChoice)
+	assert.EqualError(t, err, `args.Fast: setmode value "fast" is not one of slow`)
+
+	var malformed struct {
+		Fast bool `arg:"setmode:speed"`
+	}
+	_, err = NewParser(Config{}, &malformed)
+	assert.EqualError(t, err, ".Fast: setmode must be of the form setmode:option=value")
+}
+
+func TestRequiredIfEnv(t *testing.T) {
+	var args struct {
+		TLSCert string `arg:"--tls-cert,required-if-env:KUBERNETES_SERVICE_HOST"`
+	}
+	parse(t, "", &args)
+	assert.Equal(t, "", args.TLSCert)
+
+	_, err := parseWithEnvErr(t, "", []string{"KUBERNETES_SERVICE_HOST=10.0.0.1"}, &args)
+	assert.EqualError(t, err, "--tls-cert is required when environment variable KUBERNETES_SERVICE_HOST is set")
+
+	parseWithEnv(t, "--tls-cert cert.pem", []string{"KUBERNETES_SERVICE_HOST=10.0.0.1"}, &args)
+	assert.Equal(t, "cert.pem", args.TLSCert)
+}
+
+func TestRequiredIfEnvSatisfiedByEnvAndDefault(t *testing.T) {
+	var args struct {
+		Cert string `arg:"env,required-if-env:DEPLOYED"`
+		Key  string `arg:"required-if-env:DEPLOYED" default:"key.pem"`
+	}
+	parseWithEnv(t, "", []string{"DEPLOYED=1", "CERT=cert.pem"}, &args)
+	assert.Equal(t, "cert.pem", args.Cert)
+	assert.Equal(t, "key.pem", args.Key)
+}
+
+func TestRequiredIfEnvInvalid(t *testing.T) {
+	var args struct {
+		Cert string `arg:"required-if-env:"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Cert: required-if-env requires the name of an environment variable")
+}
+
+func TestUnknownTagOption(t *testing.T) {
+	var args struct {
+		Name string `arg:"--name,requird,positionl"`
+	}
+	_, err := NewParser(Config{}, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `.Name: unknown option "requird", unknown option "positionl" in arg tag; recognized options are -x, --name, cardinality, choices,`)
+	assert.Contains(t, err.Error(), "required, required-if-env,")
+
+	assert.Error(t, ValidateSpec(&args))
+}
+
+func TestMalformedTagOption(t *testing.T) {
+	var args struct {
+		Name string `arg:"required:true"`
+	}
+	_, err := NewParser(Config{}, &args)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), `.Name: required does not take a value but got "true" in arg tag;`), err.Error())
+
+	var envArgs struct {
+		Token string `arg:"env:"`
+	}
+	_, err = NewParser(Config{}, &envArgs)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), ".Token: env: requires the name of an environment variable, or use env alone"), err.Error())
+}
+
+func TestTagWarnings(t *testing.T) {
+	var args struct {
+		Name  string `arg:"--name,required:true"`
+		Token string `arg:"env:"`
+		Run   *struct {
+			Force bool `arg:"positional:yes"`
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{LenientTags: true, Environment: map[string]string{"TOKEN": "secret"}}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{})
+	assert.EqualError(t, err, "--name is required")
+	assert.Equal(t, "secret", args.Token)
+
+	warnings := p.Warnings()
+	require.Len(t, warnings, 3)
+	assert.True(t, strings.HasPrefix(warnings[0], `.Name: required does not take a value but got "true" in arg tag;`), warnings[0])
+	assert.True(t, strings.HasPrefix(warnings[1], ".Token: env: requires the name of an environment variable"), warnings[1])
+	assert.True(t, strings.HasPrefix(warnings[2], `.Force: positional does not take a value but got "yes" in arg tag;`), warnings[2])
+
+	// an unknown option is an error even with LenientTags
+	var unknown struct {
+		Name string `arg:"--name,requird,required:true"`
+	}
+	_, err = NewParser(Config{LenientTags: true}, &unknown)
+	assert.Contains(t, err.Error(), `.Name: unknown option "requird", required does not take a value but got "true" in arg tag;`)
+}
+
+func TestTagOptions(t *testing.T) {
+	// every key handled by cmdFromStruct is listed in tagOptions
+	src, err := os.ReadFile("parse.go")
+	require.NoError(t, err)
+	var keys []string
+	seen := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`key == "([^"]+)"`).FindAllSubmatch(src, -1) {
+		if key := string(m[1]); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	assert.Equal(t, tagOptions, keys)
+	assert.True(t, sort.StringsAreSorted(tagOptions))
+
+	// and none of them is reported as unknown
+	for _, key := range tagOptions {
+		field := reflect.StructField{Name: "A", Type: reflect.TypeOf(""), Tag: reflect.StructTag(`arg:"` + key + `"`)}
+		_, err := cmdFromStruct("", path{}, reflect.PtrTo(reflect.StructOf([]reflect.StructField{field})), true)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "unknown option", key)
+		}
+	}
+}
+
+func TestDefaultPolicyAppend(t *testing.T) {
+	type searchArgs struct {
+		Paths []string `arg:"--paths,default-policy:append"`
+	}
+	args := searchArgs{Paths: []string{"/usr/lib", "/lib"}}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Parse([]string{"--paths", "/opt/lib", "/home/lib"}))
+	assert.Equal(t, []string{"/usr/lib", "/lib", "/opt/lib", "/home/lib"}, args.Paths)
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--paths", "/a", "--paths", "/b"}))
+	assert.Equal(t, []string{"/usr/lib", "/lib", "/a", "/b"}, args.Paths)
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--paths=/a", "--paths=/b"}))
+	assert.Equal(t, []string{"/usr/lib", "/lib", "/a", "/b"}, args.Paths)
+
+	p.Reset()
+	require.NoError(t, p.Parse(nil))
+	assert.Equal(t, []string{"/usr/lib", "/lib"}, args.Paths)
+	assert.Equal(t, SourceDefault, p.ValueSources()["Paths"])
+}
+
+func TestDefaultPolicyReplace(t *testing.T) {
+	args := struct {
+		Paths []string `arg:"--paths,default-policy:replace"`
+	}{Paths: []string{"/usr/lib"}}
+	parse(t, "--paths /opt/lib", &args)
+	assert.Equal(t, []string{"/opt/lib"}, args.Paths)
+}
+
+func TestDefaultPolicyAppendSeparate(t *testing.T) {
+	args := struct {
+		Paths []string `arg:"--paths,separate,default-policy:append"`
+	}{Paths: []string{"/usr/lib"}}
+	parse(t, "--paths /a --paths /b", &args)
+	assert.Equal(t, []string{"/usr/lib", "/a", "/b"}, args.Paths)
+}
+
+func TestDefaultPolicyAppendEnv(t *testing.T) {
+	args := struct {
+		Paths []string `arg:"--paths,env,default-policy:append"`
+	}{Paths: []string{"/usr/lib"}}
+	parseWithEnv(t, "", []string{"PATHS=/a,/b"}, &args)
+	assert.Equal(t, []string{"/usr/lib", "/a", "/b"}, args.Paths)
+
+	// values on the command line replace those from the environment
+	args.Paths = []string{"/usr/lib"}
+	parseWithEnv(t, "--paths /c", []string{"PATHS=/a,/b"}, &args)
+	assert.Equal(t, []string{"/usr/lib", "/c"}, args.Paths)
+}
+
+func TestDefaultPolicyAppendPositional(t *testing.T) {
+	args := struct {
+		Files []string `arg:"positional,default-policy:append"`
+	}{Files: []string{"main.go"}}
+	parse(t, "a.go b.go", &args)
+	assert.Equal(t, []string{"main.go", "a.go", "b.go"}, args.Files)
+}
+
+func TestDefaultPolicyAppendClearable(t *testing.T) {
+	type searchArgs struct {
+		Paths []string `arg:"--paths,clearable,default-policy:append"`
+	}
+	args := searchArgs{Paths: []string{"/usr/lib"}}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	// --no-paths discards the default too, so later values start from empty
+	require.NoError(t, p.Parse([]string{"--no-paths", "--paths", "/a"}))
+	assert.Equal(t, []string{"/a"}, args.Paths)
+
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--paths", "/a", "--no-paths"}))
+	assert.Equal(t, []string{}, args.Paths)
+}
+
+func TestDefaultPolicyIgnoreDefault(t *testing.T) {
+	args := struct {
+		Paths []string `arg:"--paths,default-policy:append"`
+	}{Paths: []string{"/usr/lib"}}
+	_, err := parseWithConfigEnvErr(t, Config{IgnoreDefault: true}, "--paths /a", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a"}, args.Paths)
+}
+
+func TestDefaultPolicyInvalid(t *testing.T) {
+	var unknown struct {
+		Paths []string `arg:"default-policy:merge"`
+	}
+	_, err := NewParser(Config{}, &unknown)
+	assert.EqualError(t, err, `.Paths: unknown default-policy "merge", expected append or replace`)
+
+	var scalar struct {
+		Path string `arg:"default-policy:append"`
+	}
+	_, err = NewParser(Config{}, &scalar)
+	assert.EqualError(t, err, ".Path: default-policy:append can only be used with slice fields")
+
+	var m struct {
+		Vars map[string]string `arg:"default-policy:append"`
+	}
+	_, err = NewParser(Config{}, &m)
+	assert.EqualError(t, err, ".Vars: default-policy:append can only be used with slice fields")
+}
+
+func TestSetterFunc(t *testing.T) {
+	var levels []string
+	var args struct {
+		Level func(string) error `arg:"-l"`
+		Tag   func(string) error `arg:"cardinality:multiple"`
+		Input func(string) error `arg:"positional"`
+	}
+	args.Level = func(s string) error {
+		levels = append(levels, s)
+		return nil
+	}
+	var tags, inputs []string
+	args.Tag = func(s string) error {
+		tags = append(tags, s)
+		return nil
+	}
+	args.Input = func(s string) error {
+		inputs = append(inputs, s)
+		return nil
+	}
+	parse(t, "-l debug --tag a b --level info in.txt", &args)
+	assert.Equal(t, []string{"debug", "info"}, levels)
+	assert.Equal(t, []string{"a", "b"}, tags)
+	assert.Equal(t, []string{"in.txt"}, inputs)
+}
+
+func TestSetterFuncEnv(t *testing.T) {
+	var got []string
+	var args struct {
+		Hosts func(string) error `arg:"env,cardinality:multiple"`
+	}
+	args.Hosts = func(s string) error {
+		got = append(got, s)
+		return nil
+	}
+	parseWithEnv(t, "", []string{"HOSTS=a,b"}, &args)
+	assert.Equal(t, []string{"a", "b"}, got)
+}
+
+func TestSetterFuncError(t *testing.T) {
+	var args struct {
+		Level func(string) error
+	}
+	args.Level = func(s string) error {
+		return fmt.Errorf("unknown level %q", s)
+	}
+	_, err := parseWithEnvErr(t, "--level loud", nil, &args)
+	assert.EqualError(t, err, `error processing --level: unknown level "loud"`)
+}
+
+func TestSetterFuncNil(t *testing.T) {
+	var args struct {
+		Level func(string) error
+	}
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	require.NoError(t, err)
+
+	_, err = parseWithEnvErr(t, "--level info", nil, &args)
+	assert.EqualError(t, err, "error processing --level: cannot call a nil func(string) error")
+}
+
+func TestSetterFuncSurvivesReset(t *testing.T) {
+	var count int
+	var args struct {
+		Level func(string) error
+	}
+	args.Level = func(string) error {
+		count++
+		return nil
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"--level", "a"}))
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"--level", "b"}))
+	assert.Equal(t, 2, count)
+}
+
+func TestSetterFuncDefault(t *testing.T) {
+	var args struct {
+		Level func(string) error `default:"info"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Level: default values are not supported for func(string) error fields")
+}
+
+func TestOptionalPositionalWithDefault(t *testing.T) {
+	var args struct {
+		Src string `arg:"positional,required"`
+		Dst string `arg:"positional" default:"."`
+	}
+	parse(t, "a.txt", &args)
+	assert.Equal(t, "a.txt", args.Src)
+	assert.Equal(t, ".", args.Dst)
+
+	parse(t, "a.txt /tmp", &args)
+	assert.Equal(t, "/tmp", args.Dst)
+
+	args.Src, args.Dst = "", ""
+	p, err := NewParser(Config{Program: "cp"}, &args)
+	require.NoError(t, err)
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "Usage: cp SRC [DST]\n")
+	assert.Contains(t, help.String(), "  DST [default: .]\n")
+}
+
+func TestOptionalPositionalPointer(t *testing.T) {
+	var args struct {
+		Src string  `arg:"positional,required"`
+		Dst *string `arg:"positional"`
+	}
+	parse(t, "a.txt", &args)
+	assert.Nil(t, args.Dst)
+
+	parse(t, "a.txt b.txt", &args)
+	require.NotNil(t, args.Dst)
+	assert.Equal(t, "b.txt", *args.Dst)
+}
+
+func TestOptionalPositionalRest(t *testing.T) {
+	var args struct {
+		Src  string   `arg:"positional,required"`
+		Rest []string `arg:"positional"`
+	}
+	parse(t, "a.txt", &args)
+	assert.Equal(t, "a.txt", args.Src)
+	assert.Empty(t, args.Rest)
+
+	parse(t, "a.txt b.txt c.txt", &args)
+	assert.Equal(t, []string{"b.txt", "c.txt"}, args.Rest)
+}
+
+func TestRequiredPositionalAfterOptional(t *testing.T) {
+	var withDefault struct {
+		Dst string `arg:"positional" default:"."`
+		Src string `arg:"positional,required"`
+	}
+	_, err := NewParser(Config{}, &withDefault)
+	assert.EqualError(t, err, "args: required positional Src cannot follow the optional positional Dst")
+
+	var pointer struct {
+		Dst *string `arg:"positional"`
+		Src string  `arg:"positional,required"`
+	}
+	_, err = NewParser(Config{}, &pointer)
+	assert.EqualError(t, err, "args: required positional Src cannot follow the optional positional Dst")
+
+	var plain struct {
+		A string `arg:"positional"`
+		B string `arg:"positional,required"`
+	}
+	_, err = NewParser(Config{}, &plain)
+	assert.EqualError(t, err, "args: required positional B cannot follow the optional positional A")
+
+	var slice struct {
+		A string   `arg:"positional"`
+		B []string `arg:"positional,required"`
+	}
+	_, err = NewParser(Config{}, &slice)
+	assert.NoError(t, err)
+}
+
+func TestSlicePositionalNotLast(t *testing.T) {
+	var args struct {
+		Rest []string `arg:"positional"`
+		Dst  string   `arg:"positional"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args: slice positional Rest must be the last positional but Dst follows it")
+}
+
+func TestTogetherPositionalAndFlag(t *testing.T) {
+	var args struct {
+		File   string `arg:"positional,together:Format"`
+		Format string
+	}
+	parse(t, "", &args)
+	parse(t, "data.bin --format raw", &args)
+	assert.Equal(t, "data.bin", args.File)
+	assert.Equal(t, "raw", args.Format)
+
+	_, err := parseWithEnvErr(t, "data.bin", nil, &args)
+	assert.EqualError(t, err, "FILE must be given together with --format")
+
+	_, err = parseWithEnvErr(t, "--format raw", nil, &args)
+	assert.EqualError(t, err, "--format must be given together with FILE")
+}
+
+func TestTogetherSeveralMembers(t *testing.T) {
+	var args struct {
+		User     string `arg:"together:Password|Host"`
+		Password string `arg:"env"`
+		Host     string `arg:"positional"`
+	}
+	_, err := parseWithEnvErr(t, "--user bob db.example.com", nil, &args)
+	assert.EqualError(t, err, "--user must be given together with --password")
+
+	parseWithEnv(t, "--user bob db.example.com", []string{"PASSWORD=secret"}, &args)
+	assert.Equal(t, "secret", args.Password)
+}
+
+func TestTogetherInvalid(t *testing.T) {
+	var missing struct {
+		File string `arg:"positional,together:Fromat"`
+	}
+	_, err := NewParser(Config{}, &missing)
+	assert.EqualError(t, err, "args.File: together refers to Fromat but there is no such field")
+
+	var self struct {
+		File string `arg:"positional,together:File"`
+	}
+	_, err = NewParser(Config{}, &self)
+	assert.EqualError(t, err, "args.File: together cannot refer to the field itself")
+
+	var empty struct {
+		File string `arg:"positional,together:"`
+	}
+	_, err = NewParser(Config{}, &empty)
+	assert.EqualError(t, err, ".File: together requires the names of other fields, as in together:Format")
+}
+
+func TestGroupExclusive(t *testing.T) {
+	var args struct {
+		JSON bool `arg:"--json,group:format"`
+		YAML bool `arg:"--yaml,group:format"`
+		TOML bool `arg:"--toml,env,group:format=exclusive"`
+	}
+	parse(t, "", &args)
+	parse(t, "--yaml", &args)
+	assert.True(t, args.YAML)
+
+	_, err := parseWithEnvErr(t, "--json --yaml", nil, &args)
+	assert.EqualError(t, err, "--json and --yaml cannot be used together")
+
+	_, err = parseWithEnvErr(t, "--yaml", []string{"TOML=true"}, &args)
+	assert.EqualError(t, err, "--yaml and --toml cannot be used together")
+}
+
+func TestGroupTogether(t *testing.T) {
+	var args struct {
+		User     string `arg:"group:auth=together"`
+		Password string `arg:"env,group:auth=together"`
+		Host     string
+	}
+	parse(t, "--host db", &args)
+	parse(t, "--user bob --password secret", &args)
+	assert.Equal(t, "bob", args.User)
+
+	_, err := parseWithEnvErr(t, "--password secret", nil, &args)
+	assert.EqualError(t, err, "--password must be given together with --user")
+
+	parseWithEnv(t, "--user bob", []string{"PASSWORD=secret"}, &args)
+	assert.Equal(t, "secret", args.Password)
+}
+
+func TestGroupInvalid(t *testing.T) {
+	var mixed struct {
+		A bool `arg:"group:g"`
+		B bool `arg:"group:g=together"`
+	}
+	_, err := NewParser(Config{}, &mixed)
+	assert.EqualError(t, err, "args.B: group g is both exclusive and together")
+
+	var single struct {
+		A bool `arg:"group:g"`
+	}
+	_, err = NewParser(Config{}, &single)
+	assert.EqualError(t, err, "args.A: group g must have at least two members")
+
+	var badMode struct {
+		A bool `arg:"group:g=either"`
+	}
+	_, err = NewParser(Config{}, &badMode)
+	assert.EqualError(t, err, `.A: group mode must be exclusive or together but got "either"`)
+
+	var empty struct {
+		A bool `arg:"group:"`
+	}
+	_, err = NewParser(Config{}, &empty)
+	assert.EqualError(t, err, ".A: group requires the name of a group, as in group:format")
+
+	var positional struct {
+		A string `arg:"positional,group:g"`
+	}
+	_, err = NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".A: exclusive groups can only be used with options")
+
+	var exclusive struct {
+		A bool `arg:"group:g"`
+		B bool `arg:"group:g"`
+		C bool `arg:"together:A"`
+	}
+	_, err = NewParser(Config{}, &exclusive)
+	assert.EqualError(t, err, "args.C: together cannot be used with the exclusive group g")
+}
+
+func TestGroupTogetherWithTag(t *testing.T) {
+	var args struct {
+		User     string `arg:"group:auth=together"`
+		Password string `arg:"group:auth=together"`
+		Token    string `arg:"together:Password"`
+		File     string `arg:"positional,group:auth=together"`
+	}
+	parse(t, "", &args)
+	parse(t, "--user bob --password secret --token abc data.bin", &args)
+	assert.Equal(t, "abc", args.Token)
+
+	_, err := parseWithEnvErr(t, "--user bob --password secret data.bin", nil, &args)
+	assert.EqualError(t, err, "--user must be given together with --token")
+
+	_, err = parseWithEnvErr(t, "--token abc", nil, &args)
+	assert.EqualError(t, err, "--token must be given together with --user")
+
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, "Usage: example [--user USER --password PASSWORD --token TOKEN] [FILE]\n", usage.String())
+}
+
+func TestMaxTotalArgsBytes(t *testing.T) {
+	var args struct {
+		Names []string
+	}
+	config := Config{MaxTotalArgsBytes: 20}
+	_, err := parseWithConfigEnvErr(t, config, "--names abc def ghi", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc", "def", "ghi"}, args.Names)
+
+	huge := strings.Repeat("x", 100)
+	_, err = parseWithConfigEnvErr(t, config, "--help --names "+huge, nil, &args)
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+	assert.EqualError(t, err, "arguments are too long: their combined length exceeds the limit of 20 bytes")
+	assert.NotContains(t, err.Error(), huge)
+}
+
+func TestMaxTotalArgsBytesPreprocess(t *testing.T) {
+	var args struct {
+		Names []string
+	}
+	var called bool
+	config := Config{
+		MaxTotalArgsBytes: 10,
+		Preprocess: func(args []string) ([]string, error) {
+			called = true
+			return append(args, "--names", "more", "values"), nil
+		},
+	}
+	_, err := parseWithConfigEnvErr(t, config, "--names "+strings.Repeat("x", 20), nil, &args)
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+	assert.False(t, called)
+
+	_, err = parseWithConfigEnvErr(t, config, "--names a", nil, &args)
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+	assert.True(t, called)
+}
+
+func TestParsePartial(t *testing.T) {
+	var framework struct {
+		Verbose bool
+		Config  string
+	}
+	p, err := NewParser(Config{}, &framework)
+	require.NoError(t, err)
+	rest, err := p.ParsePartial([]string{"--port=80", "--verbose", "in.txt", "--config", "a.yaml", "-x", "--debug"})
+	require.NoError(t, err)
+	assert.True(t, framework.Verbose)
+	assert.Equal(t, "a.yaml", framework.Config)
+	assert.Equal(t, []string{"--port=80", "in.txt", "-x", "--debug"}, rest)
+
+	var app struct {
+		Port  int
+		Input string `arg:"positional"`
+		X     bool   `arg:"-x"`
+		Debug bool
+	}
+	p, err = NewParser(Config{}, &app)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(rest))
+	assert.Equal(t, 80, app.Port)
+	assert.Equal(t, "in.txt", app.Input)
+	assert.True(t, app.X)
+	assert.True(t, app.Debug)
+}
+
+func TestParsePartialPositionals(t *testing.T) {
+	var args struct {
+		Src string `arg:"positional"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	rest, err := p.ParsePartial([]string{"a", "--other", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", args.Src)
+	assert.Equal(t, []string{"--other", "b", "c"}, rest)
+}
+
+func TestParsePartialTerminator(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Src     string `arg:"positional"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	rest, err := p.ParsePartial([]string{"--unknown", "--", "--verbose", "--not-a-flag"})
+	require.NoError(t, err)
+	assert.False(t, args.Verbose)
+	assert.Equal(t, "--verbose", args.Src)
+	assert.Equal(t, []string{"--unknown", "--", "--not-a-flag"}, rest)
+
+	p.Reset()
+	rest, err = p.ParsePartial([]string{"--verbose"})
+	require.NoError(t, err)
+	assert.Nil(t, rest)
+}
+
+func TestParsePartialSubcommand(t *testing.T) {
+	var args struct {
+		Deploy *struct {
+			Target string
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	rest, err := p.ParsePartial([]string{"serve", "deploy", "--target", "prod", "--force"})
+	require.NoError(t, err)
+	require.NotNil(t, args.Deploy)
+	assert.Equal(t, "prod", args.Deploy.Target)
+	assert.Equal(t, []string{"serve", "--force"}, rest)
+}
+
+func TestAliases(t *testing.T) {
+	var args struct {
+		Color   string `arg:"-c,--color,--colour"`
+		Verbose bool   `arg:"--verbose,--loud,--noisy"`
+	}
+	parse(t, "--colour red --noisy", &args)
+	assert.Equal(t, "red", args.Color)
+	assert.True(t, args.Verbose)
+
+	var invalid struct {
+		Color string `arg:"--,--colour"`
+	}
+	_, err := NewParser(Config{}, &invalid)
+	assert.EqualError(t, err, ".Color: aliases can only be given for options with a long name")
+}
+
+func TestPassthroughField(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Image   string   `arg:"positional"`
+		Rest    []string `arg:"passthrough"`
+	}
+	parse(t, "--rm alpine --verbose -it sh -- --verbose", &args)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, "alpine", args.Image)
+	assert.Equal(t, []string{"--rm", "-it", "sh", "--", "--verbose"}, args.Rest)
+
+	var none struct {
+		Verbose bool
+		Rest    []string `arg:"passthrough"`
+	}
+	parse(t, "--verbose", &none)
+	assert.Nil(t, none.Rest)
+}
+
+func TestPassthroughFieldSubcommand(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Run     *struct {
+			Name string
+		} `arg:"subcommand"`
+		Rest []string `arg:"passthrough"`
+	}
+	parse(t, "--verbose run --name x --port 80", &args)
+	require.NotNil(t, args.Run)
+	assert.Equal(t, "x", args.Run.Name)
+	assert.Equal(t, []string{"--port", "80"}, args.Rest)
+}
+
+func TestPassthroughFieldMistypedSubcommand(t *testing.T) {
+	var args struct {
+		Deploy *struct {
+			Target string
+		} `arg:"subcommand"`
+		Rest []string `arg:"passthrough"`
+	}
+	_, err := parseWithEnvErr(t, "--force deplyo --target prod", nil, &args)
+	assert.EqualError(t, err, "invalid subcommand: deplyo")
+	var unknown *UnknownArgError
+	require.True(t, errors.As(err, &unknown))
+	assert.True(t, unknown.Subcommand)
+
+	args.Rest = nil
+	parse(t, "--force deploy --target prod extra", &args)
+	require.NotNil(t, args.Deploy)
+	assert.Equal(t, "prod", args.Deploy.Target)
+	assert.Equal(t, []string{"--force", "extra"}, args.Rest)
+}
+
+func TestPassthroughFieldErrors(t *testing.T) {
+	var positional struct {
+		Rest []string `arg:"positional,passthrough"`
+	}
+	_, err := NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".Rest: passthrough can only be used with subcommands and []string fields that are not positional")
+
+	var two struct {
+		A []string `arg:"passthrough"`
+		B []string `arg:"passthrough"`
+	}
+	_, err = NewParser(Config{}, &two)
+	assert.EqualError(t, err, ".B: passthrough cannot be used on both A and B")
+}
+
+func TestIgnoreUnknown(t *testing.T) {
+	var args struct {
+		Verbose bool
+		Src     string `arg:"positional"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{IgnoreUnknown: true}, "--port=80 --verbose a b", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, "a", args.Src)
+
+	_, err = parseWithEnvErr(t, "--port=80", nil, &args)
+	assert.EqualError(t, err, "unknown argument --port=80")
+}
+
+func TestParsePartialRequired(t *testing.T) {
+	var args struct {
+		Name string `arg:"required"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	_, err = p.ParsePartial([]string{"--other"})
+	assert.EqualError(t, err, "--name is required")
+
+	// Parse still rejects unknown arguments afterwards
+	p.Reset()
+	assert.EqualError(t, p.Parse([]string{"--name", "x", "--other"}), "unknown argument --other")
+}
+
+func TestTerminalOption(t *testing.T) {
+	var args struct {
+		PrintConfig bool   `arg:"--print-config,terminal"`
+		Name        string `arg:"required"`
+		Level       int    `default:"3"`
+		Input       string `arg:"positional,required"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"--level", "5", "--print-config", "--unknown", "extra"})
+	var terminal *TerminalError
+	require.True(t, errors.As(err, &terminal))
+	assert.Equal(t, "--print-config", terminal.Flag)
+	assert.Equal(t, "PrintConfig", terminal.Field)
+	assert.EqualError(t, err, "terminal option --print-config was given")
+	assert.True(t, args.PrintConfig)
+	assert.Equal(t, 5, args.Level)
+	assert.Equal(t, "", args.Name)
+	assert.Equal(t, "", args.Input)
+}
+
+func TestTerminalOptionWithValue(t *testing.T) {
+	var args struct {
+		Explain string `arg:"terminal"`
+		Level   int    `default:"3"`
+		Name    string `arg:"required"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"--explain=E42", "--level", "x"})
+	var terminal *TerminalError
+	require.True(t, errors.As(err, &terminal))
+	assert.Equal(t, "--explain", terminal.Flag)
+	assert.Equal(t, "E42", args.Explain)
+	assert.Equal(t, 3, args.Level)
+}
+
+func TestTerminalOptionNotGiven(t *testing.T) {
+	var args struct {
+		PrintConfig bool   `arg:"--print-config,terminal"`
+		Name        string `arg:"required"`
+	}
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	assert.EqualError(t, err, "--name is required")
+	parse(t, "--name x", &args)
+	assert.False(t, args.PrintConfig)
+}
+
+func TestTerminalOptionErrorsBefore(t *testing.T) {
+	var args struct {
+		PrintConfig bool `arg:"--print-config,terminal"`
+		Level       int
+	}
+	_, err := parseWithEnvErr(t, "--level x --print-config", nil, &args)
+	assert.Error(t, err)
+	var terminal *TerminalError
+	assert.False(t, errors.As(err, &terminal))
+}
+
+func TestTerminalOptionSubcommand(t *testing.T) {
+	var args struct {
+		Run *struct {
+			PrintConfig bool   `arg:"--print-config,terminal"`
+			Target      string `arg:"positional,required"`
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"run", "--print-config"})
+	var terminal *TerminalError
+	require.True(t, errors.As(err, &terminal))
+	assert.Equal(t, "Run.PrintConfig", terminal.Field)
+	require.NotNil(t, args.Run)
+	assert.True(t, args.Run.PrintConfig)
+}
+
+func TestTerminalOptionMustParse(t *testing.T) {
+	var args struct {
+		PrintConfig bool   `arg:"--print-config,terminal"`
+		Name        string `arg:"required"`
+	}
+	exitCode := -1
+	var stdout, stderr bytes.Buffer
+	p, err := NewParser(Config{Exit: func(code int) { exitCode = code }, Out: &stdout, HelpDestination: &stderr}, &args)
+	require.NoError(t, err)
+	p.MustParse([]string{"--print-config"})
+	assert.Equal(t, -1, exitCode)
+	assert.Empty(t, stdout.String())
+	assert.True(t, args.PrintConfig)
+}
+
+func TestTerminalPositional(t *testing.T) {
+	var args struct {
+		Input string `arg:"positional,terminal"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Input: terminal can only be used with options")
+}
+
+func TestSplitInto(t *testing.T) {
+	var args struct {
+		Size   string `arg:"--size,split:x,into:Width|Height"`
+		Width  int    `arg:"-"`
+		Height int    `arg:"-"`
+	}
+	parse(t, "--size 1920x1080", &args)
+	assert.Equal(t, "1920x1080", args.Size)
+	assert.Equal(t, 1920, args.Width)
+	assert.Equal(t, 1080, args.Height)
+}
+
+func TestSplitIntoDefaultAndEnv(t *testing.T) {
+	var args struct {
+		Size   string `arg:"env,split:x,into:Width|Height" default:"800x600"`
+		Width  int    `arg:"-"`
+		Height int    `arg:"-"`
+	}
+	parse(t, "", &args)
+	assert.Equal(t, 800, args.Width)
+	assert.Equal(t, 600, args.Height)
+
+	args.Size, args.Width, args.Height = "", 0, 0
+	parseWithEnv(t, "", []string{"SIZE=640x480"}, &args)
+	assert.Equal(t, 640, args.Width)
+	assert.Equal(t, 480, args.Height)
+}
+
+func TestSplitIntoUnset(t *testing.T) {
+	var args struct {
+		Size   string `arg:"split:x,into:Width|Height"`
+		Width  int    `default:"3"`
+		Height int
+	}
+	parse(t, "--height 4", &args)
+	assert.Equal(t, 3, args.Width)
+	assert.Equal(t, 4, args.Height)
+}
+
+func TestSplitIntoPositional(t *testing.T) {
+	var args struct {
+		Range string `arg:"positional,split:..,into:From|To"`
+		From  uint   `arg:"-"`
+		To    uint   `arg:"-"`
+	}
+	parse(t, "3..7", &args)
+	assert.Equal(t, uint(3), args.From)
+	assert.Equal(t, uint(7), args.To)
+}
+
+func TestSplitIntoEmbedded(t *testing.T) {
+	type dims struct {
+		Size   string `arg:"split:x,into:Width|Height"`
+		Width  int    `arg:"-"`
+		Height int    `arg:"-"`
+	}
+	var args struct {
+		Verbose bool
+		dims
+	}
+	parse(t, "--size 2x3", &args)
+	assert.Equal(t, 2, args.Width)
+	assert.Equal(t, 3, args.Height)
+}
+
+func TestSplitIntoWrongCount(t *testing.T) {
+	var args struct {
+		Size   string `arg:"split:x,into:Width|Height"`
+		Width  int    `arg:"-"`
+		Height int    `arg:"-"`
+	}
+	_, err := parseWithEnvErr(t, "--size 1x2x3", nil, &args)
+	assert.EqualError(t, err, `--size must have 2 values separated by "x" but got 3`)
+
+	_, err = parseWithEnvErr(t, "--size 1xa", nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing --size: Height: ")
+}
+
+func TestSplitIntoInvalid(t *testing.T) {
+	var noInto struct {
+		Size string `arg:"split:x"`
+	}
+	_, err := NewParser(Config{}, &noInto)
+	assert.EqualError(t, err, ".Size: split and into must be given together, as in split:x,into:Width|Height")
+
+	var notString struct {
+		Size  int `arg:"split:x,into:Width"`
+		Width int
+	}
+	_, err = NewParser(Config{}, &notString)
+	assert.EqualError(t, err, ".Size: split can only be used with string fields")
+
+	var missing struct {
+		Size  string `arg:"split:x,into:Width|Depth"`
+		Width int
+	}
+	_, err = NewParser(Config{}, &missing)
+	assert.EqualError(t, err, ".Size: into refers to Depth but there is no such field")
+
+	var self struct {
+		Size string `arg:"split:x,into:Size"`
+	}
+	_, err = NewParser(Config{}, &self)
+	assert.EqualError(t, err, ".Size: into cannot refer to the field itself")
+
+	var notScalar struct {
+		Size  string `arg:"split:x,into:Width"`
+		Width []int
+	}
+	_, err = NewParser(Config{}, &notScalar)
+	assert.EqualError(t, err, ".Size: into refers to Width, which cannot be parsed from a single value")
+}
+
+func TestNegatablePointerBool(t *testing.T) {
+	type argsType struct {
+		Color *bool `arg:"negatable"`
+	}
+
+	var args argsType
+	parse(t, "", &args)
+	assert.Nil(t, args.Color)
+
+	args = argsType{}
+	parse(t, "--color", &args)
+	require.NotNil(t, args.Color)
+	assert.True(t, *args.Color)
+
+	args = argsType{}
+	parse(t, "--no-color", &args)
+	require.NotNil(t, args.Color)
+	assert.False(t, *args.Color)
+
+	args = argsType{}
+	parse(t, "--color=false", &args)
+	require.NotNil(t, args.Color)
+	assert.False(t, *args.Color)
+
+	// the last occurrence wins
+	args = argsType{}
+	parse(t, "--no-color --color", &args)
+	require.NotNil(t, args.Color)
+	assert.True(t, *args.Color)
+}
+
+func TestNegatableBool(t *testing.T) {
+	var args struct {
+		Cache bool `arg:"negatable" default:"true"`
+	}
+	parse(t, "--no-cache", &args)
+	assert.False(t, args.Cache)
+
+	_, err := parseWithEnvErr(t, "--no-cache=true", nil, &args)
+	assert.EqualError(t, err, "unknown argument --no-cache=true")
+}
+
+func TestNegatableNotEnabled(t *testing.T) {
+	var args struct {
+		Color *bool
+	}
+	_, err := parseWithEnvErr(t, "--no-color", nil, &args)
+	assert.EqualError(t, err, "unknown argument --no-color")
+}
+
+func TestNegatableInvalid(t *testing.T) {
+	var notBool struct {
+		Name string `arg:"negatable"`
+	}
+	_, err := NewParser(Config{}, &notBool)
+	assert.EqualError(t, err, ".Name: negatable can only be used with boolean options that have a long name")
+
+	var inverted struct {
+		Color bool `arg:"inverted,negatable"`
+	}
+	_, err = NewParser(Config{}, &inverted)
+	assert.EqualError(t, err, ".Color: negatable can only be used with boolean options that have a long name")
+}
+
+func TestCounter(t *testing.T) {
+	type argsType struct {
+		Verbose int   `arg:"-v,--verbose,counter"`
+		Level   uint8 `arg:"-l,counter,env"`
+	}
+
+	var args argsType
+	parse(t, "", &args)
+	assert.Equal(t, 0, args.Verbose)
+
+	args = argsType{}
+	parse(t, "-v", &args)
+	assert.Equal(t, 1, args.Verbose)
+
+	args = argsType{}
+	parse(t, "-vvv --verbose -l -v", &args)
+	assert.Equal(t, 5, args.Verbose)
+	assert.Equal(t, uint8(1), args.Level)
+
+	// a value given explicitly replaces the count, and later occurrences add to it
+	args = argsType{}
+	parse(t, "-v --verbose=3 -vv", &args)
+	assert.Equal(t, 5, args.Verbose)
+
+	// occurrences on the command line count from zero rather than from the environment
+	args = argsType{}
+	parseWithEnv(t, "", []string{"LEVEL=4"}, &args)
+	assert.Equal(t, uint8(4), args.Level)
+	args = argsType{}
+	parseWithEnv(t, "-ll", []string{"LEVEL=4"}, &args)
+	assert.Equal(t, uint8(2), args.Level)
+}
+
+func TestCounterOverflow(t *testing.T) {
+	var args struct {
+		Level int8 `arg:"-l,counter"`
+	}
+	_, err := parseWithEnvErr(t, "--level=127 -l", nil, &args)
+	assert.EqualError(t, err, "error processing -l: count 128 is out of range for int8")
+}
+
+func TestCounterRepeatedOnlyForCounters(t *testing.T) {
+	var args struct {
+		Verbose bool `arg:"-v"`
+	}
+	_, err := parseWithEnvErr(t, "-vv", nil, &args)
+	assert.EqualError(t, err, "unknown argument -vv")
+}
+
+func TestCounterInvalid(t *testing.T) {
+	var notInt struct {
+		Verbose bool `arg:"-v,counter"`
+	}
+	_, err := NewParser(Config{}, &notInt)
+	assert.EqualError(t, err, ".Verbose: counter can only be used with integer options")
+
+	var pointer struct {
+		Verbose *int `arg:"-v,counter"`
+	}
+	_, err = NewParser(Config{}, &pointer)
+	assert.EqualError(t, err, ".Verbose: counter can only be used with integer options")
+
+	var positional struct {
+		Verbose int `arg:"positional,counter"`
+	}
+	_, err = NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".Verbose: counter can only be used with integer options")
+}
+
+func TestValueError(t *testing.T) {
+	var args struct {
+		Count int
+		Input int `arg:"positional"`
+	}
+	_, err := parseWithEnvErr(t, "--count x", nil, &args)
+	var valueErr *ValueError
+	require.True(t, errors.As(err, &valueErr))
+	assert.Equal(t, "--count", valueErr.Arg)
+	assert.Equal(t, "Count", valueErr.Field)
+	assert.Error(t, valueErr.Err)
+	assert.EqualError(t, err, `error processing --count: strconv.ParseInt: parsing "x": invalid syntax`)
+
+	_, err = parseWithEnvErr(t, "y", nil, &args)
+	require.True(t, errors.As(err, &valueErr))
+	assert.Equal(t, "Input", valueErr.Arg)
+	assert.Equal(t, "Input", valueErr.Field)
+
+	// the value is left out when it is attached to the option
+	_, err = parseWithEnvErr(t, "--count=secret", nil, &args)
+	require.True(t, errors.As(err, &valueErr))
+	assert.Equal(t, "--count", valueErr.Arg)
+}
+
+func TestRequiredError(t *testing.T) {
+	var args struct {
+		Name  string `arg:"required"`
+		Token string `arg:"--,required,env:TOKEN"`
+	}
+	_, err := parseWithEnvErr(t, "", []string{"TOKEN=x"}, &args)
+	var requiredErr *RequiredError
+	require.True(t, errors.As(err, &requiredErr))
+	assert.Equal(t, &RequiredError{Arg: "--name", Field: "Name"}, requiredErr)
+
+	var envOnly struct {
+		Secret string `arg:"--,required,env:SECRET"`
+	}
+	_, err = parseWithEnvErr(t, "", nil, &envOnly)
+	require.True(t, errors.As(err, &requiredErr))
+	assert.Equal(t, &RequiredError{Field: "Secret", Env: "SECRET"}, requiredErr)
+	assert.EqualError(t, err, "environment variable SECRET is required")
+
+	var ifEnv struct {
+		Cert string `arg:"required-if-env:IN_CLUSTER"`
+	}
+	_, err = parseWithEnvErr(t, "", []string{"IN_CLUSTER=1"}, &ifEnv)
+	require.True(t, errors.As(err, &requiredErr))
+	assert.Equal(t, &RequiredError{Arg: "--cert", Field: "Cert", IfEnv: "IN_CLUSTER"}, requiredErr)
+
+	var sub struct {
+		Deploy *struct {
+			Target string `arg:"positional,required"`
+		} `arg:"subcommand"`
+	}
+	_, err = parseWithEnvErr(t, "deploy", nil, &sub)
+	require.True(t, errors.As(err, &requiredErr))
+	assert.Equal(t, &RequiredError{Arg: "TARGET", Field: "Deploy.Target", Subcommand: "deploy"}, requiredErr)
+}
+
+func TestUnknownArgError(t *testing.T) {
+	var args struct {
+		Run *struct{} `arg:"subcommand"`
+	}
+	_, err := parseWithEnvErr(t, "--nosuch", nil, &args)
+	var unknownErr *UnknownArgError
+	require.True(t, errors.As(err, &unknownErr))
+	assert.Equal(t, &UnknownArgError{Arg: "--nosuch"}, unknownErr)
+
+	_, err = parseWithEnvErr(t, "rnu", nil, &args)
+	require.True(t, errors.As(err, &unknownErr))
+	assert.Equal(t, &UnknownArgError{Arg: "rnu", Subcommand: true}, unknownErr)
+
+	var positionals struct {
+		Input string `arg:"positional"`
+	}
+	_, err = parseWithEnvErr(t, "a b", nil, &positionals)
+	require.True(t, errors.As(err, &unknownErr))
+	assert.Equal(t, &UnknownArgError{Arg: "b", Positional: true}, unknownErr)
+	assert.EqualError(t, err, "too many positional arguments at 'b'")
+}
+
+func TestListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	require.NoError(t, os.WriteFile(path, []byte("*.o\r\n\n*.tmp\n"), 0600))
+
+	type argsType struct {
+		Exclude []string `arg:"listfile:exclude-from"`
+	}
+
+	var args argsType
+	parse(t, "--exclude-from "+path, &args)
+	assert.Equal(t, []string{"*.o", "*.tmp"}, args.Exclude)
+
+	args = argsType{}
+	parse(t, "--exclude a b --exclude-from="+path+" --exclude c", &args)
+	assert.Equal(t, []string{"a", "b", "*.o", "*.tmp", "c"}, args.Exclude)
+}
+
+func TestListFileReplacesDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	require.NoError(t, os.WriteFile(path, []byte("*.o\n"), 0600))
+
+	var args struct {
+		Exclude []string `arg:"env,listfile:--exclude-from"`
+	}
+	p := parseWithEnv(t, "--exclude-from "+path, []string{"EXCLUDE=x,y"}, &args)
+	assert.Equal(t, []string{"*.o"}, args.Exclude)
+	assert.Equal(t, SourceArg, p.ValueSources()["Exclude"])
+}
+
+func TestListFileErrors(t *testing.T) {
+	var args struct {
+		Exclude []int `arg:"listfile:exclude-from"`
+	}
+	_, err := parseWithEnvErr(t, "--exclude-from", nil, &args)
+	assert.EqualError(t, err, "missing value for --exclude-from")
+
+	_, err = parseWithEnvErr(t, "--exclude-from "+filepath.Join(t.TempDir(), "missing"), nil, &args)
+	var valueErr *ValueError
+	require.True(t, errors.As(err, &valueErr))
+	assert.Equal(t, "Exclude", valueErr.Field)
+
+	path := filepath.Join(t.TempDir(), "numbers.txt")
+	require.NoError(t, os.WriteFile(path, []byte("1\nx\n"), 0600))
+	_, err = parseWithEnvErr(t, "--exclude-from "+path, nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing --exclude-from: ")
+}
+
+func TestListFileInvalid(t *testing.T) {
+	var notSlice struct {
+		Exclude string `arg:"listfile:exclude-from"`
+	}
+	_, err := NewParser(Config{}, &notSlice)
+	assert.EqualError(t, err, ".Exclude: listfile can only be used with slice options")
+
+	var isMap struct {
+		Exclude map[string]string `arg:"listfile:exclude-from"`
+	}
+	_, err = NewParser(Config{}, &isMap)
+	assert.EqualError(t, err, ".Exclude: listfile can only be used with slice options")
+
+	var noName struct {
+		Exclude []string `arg:"listfile:"`
+	}
+	_, err = NewParser(Config{}, &noName)
+	assert.EqualError(t, err, ".Exclude: listfile requires the name of an option, as in listfile:exclude-from")
+
+	var taken struct {
+		Exclude     []string `arg:"listfile:exclude-from"`
+		ExcludeFrom string   `arg:"--exclude-from"`
+	}
+	_, err = NewParser(Config{}, &taken)
+	assert.EqualError(t, err, "args.Exclude: listfile names --exclude-from, which is already an option")
+}
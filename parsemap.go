@@ -0,0 +1,69 @@
+package arg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ParseMap processes a set of named values as if each had been given on the
+// command line as --key=value, so that the same validation, environment
+// variables, defaults, and required checks apply as for Parse. This is useful
+// when the values come from somewhere other than a command line, such as a
+// web form or configuration. The keys are the long names of the options of
+// the top-level command; positionals and subcommands cannot be set this way.
+// The values of slice, map, and array options are split at the separator
+// given by the "sep" tag, or at commas outside of double quotes otherwise.
+// A key that is not the long name of any option is an error unless
+// Config.IgnoreUnknownKeys is set. Like Parse, it may be called only once
+// unless Reset is called in between.
+func (p *Parser) ParseMap(values map[string]string) error {
+	if err := p.beginParse(); err != nil {
+		return err
+	}
+
+	var total int
+	for key, value := range values {
+		total += len(key) + len(value)
+	}
+	if err := p.checkTotalBytes(total); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, key := range keys {
+		spec := findOption(p.cmd.specs, key)
+		if spec == nil || spec.long != key {
+			if p.config.IgnoreUnknownKeys {
+				continue
+			}
+			return fmt.Errorf("unknown key %q", key)
+		}
+
+		value := values[key]
+		if spec.sep != "" || (spec.cardinality != multiple && spec.nargs == 0) {
+			args = append(args, "--"+key+"="+value)
+			continue
+		}
+
+		parts, err := splitQuoted(value, ",")
+		if err != nil {
+			return fmt.Errorf("error processing key %q: %v", key, err)
+		}
+		if spec.nargs > 0 {
+			// arrays consume the tokens following the first value
+			args = append(args, "--"+key+"="+parts[0])
+			args = append(args, parts[1:]...)
+			continue
+		}
+		for _, part := range parts {
+			args = append(args, "--"+key+"="+part)
+		}
+	}
+	return p.process(args)
+}
@@ -0,0 +1,101 @@
+package arg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMap(t *testing.T) {
+	var args struct {
+		Name    string
+		Count   int `arg:"--n"`
+		Verbose bool
+		Tags    []string
+		Labels  map[string]int
+		Ports   []int    `arg:"separate"`
+		Words   []string `arg:"sep:;"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.ParseMap(map[string]string{
+		"name":    "x",
+		"n":       "3",
+		"verbose": "true",
+		"tags":    `a,"b,c"`,
+		"labels":  "a=1,b=2",
+		"ports":   "80,443",
+		"words":   "x;y",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "x", args.Name)
+	assert.Equal(t, 3, args.Count)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, []string{"a", "b,c"}, args.Tags)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, args.Labels)
+	assert.Equal(t, []int{80, 443}, args.Ports)
+	assert.Equal(t, []string{"x", "y"}, args.Words)
+}
+
+func TestParseMapArray(t *testing.T) {
+	var args struct {
+		Point [2]int `arg:"nargs:2"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.ParseMap(map[string]string{"point": "1,2"}))
+	assert.Equal(t, [2]int{1, 2}, args.Point)
+}
+
+func TestParseMapDefaultsAndRequired(t *testing.T) {
+	var args struct {
+		Name  string `arg:"required"`
+		Level string `default:"info"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.EqualError(t, p.ParseMap(map[string]string{"level": "debug"}), "--name is required")
+
+	p.Reset()
+	require.NoError(t, p.ParseMap(map[string]string{"name": "x"}))
+	assert.Equal(t, "x", args.Name)
+	assert.Equal(t, "info", args.Level)
+}
+
+func TestParseMapInvalidValue(t *testing.T) {
+	var args struct {
+		Count int
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.Error(t, p.ParseMap(map[string]string{"count": "x"}))
+}
+
+func TestParseMapUnknownKey(t *testing.T) {
+	var args struct {
+		Name string `arg:"-n"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.EqualError(t, p.ParseMap(map[string]string{"other": "x"}), `unknown key "other"`)
+	p.Reset()
+	assert.EqualError(t, p.ParseMap(map[string]string{"n": "x"}), `unknown key "n"`)
+
+	p, err = NewParser(Config{IgnoreUnknownKeys: true}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.ParseMap(map[string]string{"other": "x", "name": "y"}))
+	assert.Equal(t, "y", args.Name)
+}
+
+func TestParseMapMaxTotalArgsBytes(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{MaxTotalArgsBytes: 10}, &args)
+	require.NoError(t, err)
+	err = p.ParseMap(map[string]string{"name": "a very long value"})
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+	assert.Equal(t, "", args.Name)
+}
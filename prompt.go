@@ -0,0 +1,69 @@
+package arg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// promptTerminal is the default for Config.Prompt. It writes the prompt to
+// standard error and reads a line from standard input, provided that standard
+// input is a terminal. Echo is disabled while reading secret values. If
+// standard input ends before a line is entered, the user could not be
+// prompted.
+func promptTerminal(text string, secret bool) (string, bool, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", false, nil
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, text)
+	if secret {
+		line, err := term.ReadPassword(fd)
+		_, _ = fmt.Fprintln(os.Stderr)
+		if err != nil && len(line) == 0 {
+			return readPromptError(err)
+		}
+		return string(line), true, nil
+	}
+	return readPromptLine(os.Stdin)
+}
+
+// readPromptLine reads the answer to a prompt from r, which ends at the end
+// of the line or the input
+func readPromptLine(r io.Reader) (string, bool, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return readPromptError(err)
+	}
+	return strings.TrimRight(line, "\r\n"), true, nil
+}
+
+// readPromptError returns the result of a prompt that failed with the given
+// error before anything was read. The end of the input means that the user
+// could not be prompted, which is not itself an error.
+func readPromptError(err error) (string, bool, error) {
+	if errors.Is(err, io.EOF) {
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+// promptText returns the text with which the user is prompted for the value
+// of the given spec, which is taken from the prompt tag, or from the help text,
+// or from the name of the option, in that order
+func promptText(spec *spec) string {
+	text := spec.promptText
+	if text == "" {
+		text = spec.help
+	}
+	if text == "" {
+		text = spec.placeholder
+	}
+	return strings.TrimRight(text, ": ") + ": "
+}
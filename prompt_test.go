@@ -0,0 +1,144 @@
+package arg
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePrompt records the prompts it receives and answers them from a map
+type fakePrompt struct {
+	answers map[string]string
+	asked   []string
+	secret  []bool
+}
+
+func (f *fakePrompt) prompt(text string, secret bool) (string, bool, error) {
+	f.asked = append(f.asked, text)
+	f.secret = append(f.secret, secret)
+	answer, ok := f.answers[text]
+	return answer, ok, nil
+}
+
+func TestPrompt(t *testing.T) {
+	var args struct {
+		User     string   `arg:"required,prompt" help:"user name"`
+		Password string   `arg:"required,prompt:secret" prompt:"Password"`
+		Port     int      `arg:"prompt" default:"8080"`
+		Tags     []string `arg:"prompt"`
+	}
+	f := fakePrompt{answers: map[string]string{
+		"user name: ": "alice",
+		"Password: ":  "hunter2",
+		"TAGS: ":      "a",
+	}}
+	p, err := parseWithConfigEnvErr(t, Config{Prompt: f.prompt}, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", args.User)
+	assert.Equal(t, "hunter2", args.Password)
+	assert.Equal(t, 8080, args.Port)
+	assert.Equal(t, []string{"a"}, args.Tags)
+	assert.Equal(t, []string{"user name: ", "Password: ", "TAGS: "}, f.asked)
+	assert.Equal(t, []bool{false, true, false}, f.secret)
+	assert.Equal(t, SourcePrompt, p.ValueSources()["Password"])
+}
+
+func TestPromptNotNeeded(t *testing.T) {
+	var args struct {
+		Password string `arg:"env,prompt:secret"`
+	}
+	f := fakePrompt{}
+	_, err := parseWithConfigEnvErr(t, Config{Prompt: f.prompt}, "", []string{"PASSWORD=abc"}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", args.Password)
+	assert.Empty(t, f.asked)
+}
+
+func TestPromptUnavailable(t *testing.T) {
+	var args struct {
+		Password string `arg:"required,prompt:secret"`
+	}
+	f := fakePrompt{}
+	_, err := parseWithConfigEnvErr(t, Config{Prompt: f.prompt}, "", nil, &args)
+	assert.EqualError(t, err, "--password is required")
+	assert.Len(t, f.asked, 1)
+}
+
+func TestPromptErrors(t *testing.T) {
+	var args struct {
+		Port int `arg:"prompt"`
+	}
+	f := fakePrompt{answers: map[string]string{"PORT: ": "abc"}}
+	_, err := parseWithConfigEnvErr(t, Config{Prompt: f.prompt}, "", nil, &args)
+	assert.EqualError(t, err, `error processing --port: strconv.ParseInt: parsing "abc": invalid syntax`)
+
+	failing := func(string, bool) (string, bool, error) { return "", false, errors.New("closed") }
+	_, err = parseWithConfigEnvErr(t, Config{Prompt: failing}, "", nil, &args)
+	assert.EqualError(t, err, "error processing --port: closed")
+}
+
+func TestPromptInvalidTag(t *testing.T) {
+	var positional struct {
+		Name string `arg:"positional,prompt"`
+	}
+	_, err := NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".Name: prompt can only be used with options that take a value")
+
+	var mode struct {
+		Name string `arg:"prompt:loud"`
+	}
+	_, err = NewParser(Config{}, &mode)
+	assert.EqualError(t, err, `.Name: unknown prompt mode "loud", expected prompt or prompt:secret`)
+}
+
+func TestPromptText(t *testing.T) {
+	assert.Equal(t, "Enter code: ", promptText(&spec{promptText: "Enter code:", help: "the code"}))
+	assert.Equal(t, "the code: ", promptText(&spec{help: "the code"}))
+	assert.Equal(t, "CODE: ", promptText(&spec{placeholder: "CODE"}))
+}
+
+func TestPromptTerminalNotATerminal(t *testing.T) {
+	devNull, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer devNull.Close()
+	stdin := os.Stdin
+	os.Stdin = devNull
+	defer func() { os.Stdin = stdin }()
+
+	var args struct {
+		Password string `arg:"required,prompt:secret"`
+	}
+	_, err = parseWithEnvErr(t, "", nil, &args)
+	assert.EqualError(t, err, "--password is required")
+}
+
+func TestReadPromptLine(t *testing.T) {
+	value, ok, err := readPromptLine(strings.NewReader("alice\r\nbob\n"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", value)
+
+	value, ok, err = readPromptLine(strings.NewReader("alice"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", value)
+
+	_, ok, err = readPromptLine(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = readPromptLine(&failingReader{})
+	assert.EqualError(t, err, "closed")
+	assert.False(t, ok)
+}
+
+// failingReader is an io.Reader that always fails
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("closed")
+}
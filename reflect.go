@@ -2,15 +2,24 @@ package arg
 
 import (
 	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"time"
 	"unicode"
 	"unicode/utf8"
-
-	"github.com/alexflint/go-scalar"
 )
 
 var textUnmarshalerType = reflect.TypeOf([]encoding.TextUnmarshaler{}).Elem()
+var errorType = reflect.TypeOf([]error{}).Elem()
+var binaryUnmarshalerType = reflect.TypeOf([]encoding.BinaryUnmarshaler{}).Elem()
+var jsonUnmarshalerType = reflect.TypeOf([]json.Unmarshaler{}).Elem()
+var defaultProviderType = reflect.TypeOf([]DefaultProvider{}).Elem()
+var appenderType = reflect.TypeOf([]Appender{}).Elem()
+var stringType = reflect.TypeOf("")
+var helpProviderType = reflect.TypeOf([]HelpProvider{}).Elem()
 
 // cardinality tracks how many tokens are expected for a given spec
 //   - zero is a boolean, which does to expect any value
@@ -42,13 +51,69 @@ func (k cardinality) String() string {
 
 // cardinalityOf returns true if the type can be parsed from a string
 func cardinalityOf(t reflect.Type) (cardinality, error) {
-	if scalar.CanParse(t) {
+	// an Optional has the cardinality of its value, which must be a single value
+	if elem, ok := optionalElemOf(t); ok {
+		k, err := cardinalityOf(elem)
+		if err != nil {
+			return unsupported, err
+		}
+		if k == multiple {
+			return unsupported, fmt.Errorf("cannot parse into %v because %v has multiple values", t, elem)
+		}
+		return k, nil
+	}
+
+	if canParse(t) {
 		if isBoolean(t) {
 			return zero, nil
 		}
 		return one, nil
 	}
 
+	// setters of the form func(string) error are called with each value, and
+	// are given a single value unless tagged cardinality:multiple
+	if isSetter(t) {
+		return one, nil
+	}
+
+	// closures of the form func() (T, error) parse a single value when called
+	if elem, ok := lazyElemOf(t); ok {
+		k, err := cardinalityOf(elem)
+		if err != nil {
+			return unsupported, err
+		}
+		if k != one {
+			return unsupported, fmt.Errorf("cannot parse into %v because %v is not a single value", t, elem)
+		}
+		return one, nil
+	}
+
+	// types that can only be unmarshaled from JSON are given a single token
+	if isJSONOnly(t) {
+		return one, nil
+	}
+
+	// types that can only be unmarshaled from bytes are given in an encoding
+	if isBinaryUnmarshaler(t) {
+		return one, nil
+	}
+
+	// byte slices hold the raw bytes of a single token
+	if isBytes(t) {
+		return one, nil
+	}
+
+	// types with an Append method receive key=value pairs one at a time
+	if isAppender(t) {
+		return multiple, nil
+	}
+
+	return sequenceCardinalityOf(t)
+}
+
+// sequenceCardinalityOf returns multiple if t is a slice or map, or a pointer
+// to one, whose elements can each be parsed from a string
+func sequenceCardinalityOf(t reflect.Type) (cardinality, error) {
 	// look inside pointer types
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
@@ -57,16 +122,20 @@ func cardinalityOf(t reflect.Type) (cardinality, error) {
 	// look inside slice and map types
 	switch t.Kind() {
 	case reflect.Slice:
-		if !scalar.CanParse(t.Elem()) {
+		if !canParse(t.Elem()) && !isJSONOnly(t.Elem()) && !isBytes(t.Elem()) {
 			return unsupported, fmt.Errorf("cannot parse into %v because %v not supported", t, t.Elem())
 		}
 		return multiple, nil
 	case reflect.Map:
-		if !scalar.CanParse(t.Key()) {
-			return unsupported, fmt.Errorf("cannot parse into %v because key type %v not supported", t, t.Elem())
+		if !canParse(t.Key()) && !isTextUnmarshaler(t.Key()) {
+			return unsupported, fmt.Errorf("cannot parse into %v because key type %v not supported", t, t.Key())
+		}
+		elem := t.Elem()
+		if isSliceValue(elem) {
+			elem = elem.Elem()
 		}
-		if !scalar.CanParse(t.Elem()) {
-			return unsupported, fmt.Errorf("cannot parse into %v because value type %v not supported", t, t.Elem())
+		if !canParse(elem) {
+			return unsupported, fmt.Errorf("cannot parse into %v because value type %v not supported", t, elem)
 		}
 		return multiple, nil
 	default:
@@ -74,6 +143,123 @@ func cardinalityOf(t reflect.Type) (cardinality, error) {
 	}
 }
 
+// overrideCardinality returns the cardinality named by a cardinality tag, or
+// an error if the type cannot be parsed with that cardinality
+func overrideCardinality(t reflect.Type, name string) (cardinality, error) {
+	inferred, err := cardinalityOf(t)
+	switch name {
+	case "zero":
+		if err != nil || inferred != zero {
+			return unsupported, fmt.Errorf("cardinality zero can only be used with boolean fields")
+		}
+		return zero, nil
+	case "one":
+		if err != nil || inferred == multiple {
+			return unsupported, fmt.Errorf("cardinality one requires a type that can be parsed from a single string, but got %v", t)
+		}
+		return one, nil
+	case "multiple":
+		if _, err := sequenceCardinalityOf(t); err != nil && !isSetter(t) {
+			return unsupported, fmt.Errorf("cardinality multiple requires a slice or map, but got %v", t)
+		}
+		return multiple, nil
+	default:
+		return unsupported, fmt.Errorf("unknown cardinality %q, expected zero, one, or multiple", name)
+	}
+}
+
+// isSliceValue returns true if the type is a slice that is not itself parsed
+// from a single string, such as the []string in map[string][]string
+func isSliceValue(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && !canParse(t)
+}
+
+// isAppender returns true if the type, or a pointer to it, implements Appender
+func isAppender(t reflect.Type) bool {
+	return t.Implements(appenderType) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(appenderType))
+}
+
+// isMap returns true if the type is a map or a pointer to a map
+func isMap(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Map
+}
+
+// isNumeric returns true if the type is an integer or floating point number,
+// or a pointer, slice, or array of such numbers
+func isNumeric(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return !isTextUnmarshaler(t)
+	default:
+		return false
+	}
+}
+
+// isInteger returns true if the type is an integer, or a pointer to one, other
+// than a time.Duration
+func isInteger(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isDuration(t) || isTextUnmarshaler(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDuration returns true if the type is a time.Duration or a pointer to one
+func isDuration(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == reflect.TypeOf(time.Duration(0))
+}
+
+// isArrayOfLen returns true if the type is an array with n elements, each of
+// which can be parsed from a single string
+func isArrayOfLen(t reflect.Type, n int) bool {
+	return t.Kind() == reflect.Array && t.Len() == n && canParse(t.Elem())
+}
+
+// addCount adds n to the integer in v, as for options tagged "counter"
+func addCount(v reflect.Value, n int) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sum := v.Int() + int64(n)
+		if v.OverflowInt(sum) {
+			return fmt.Errorf("count %d is out of range for %v", sum, v.Type())
+		}
+		v.SetInt(sum)
+	default:
+		sum := v.Uint() + uint64(n)
+		if v.OverflowUint(sum) {
+			return fmt.Errorf("count %d is out of range for %v", sum, v.Type())
+		}
+		v.SetUint(sum)
+	}
+	return nil
+}
+
 // isBoolean returns true if the type is a boolean or a pointer to a boolean
 func isBoolean(t reflect.Type) bool {
 	switch {
@@ -93,6 +279,88 @@ func isTextUnmarshaler(t reflect.Type) bool {
 	return t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)
 }
 
+// lazyElemOf returns the type T if t is func() (T, error)
+func lazyElemOf(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() != 2 || t.IsVariadic() {
+		return nil, false
+	}
+	if t.Out(1) != errorType {
+		return nil, false
+	}
+	return t.Out(0), true
+}
+
+// isSetter returns true if t is func(string) error, which is called with each
+// value given for an option instead of the value being stored, like flag.Func
+func isSetter(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 1 || t.IsVariadic() {
+		return false
+	}
+	return t.In(0) == stringType && t.Out(0) == errorType
+}
+
+// callSetter calls the func(string) error held by v with the given value
+func callSetter(v reflect.Value, s string) error {
+	if v.IsNil() {
+		return fmt.Errorf("cannot call a nil %v", v.Type())
+	}
+	if err, _ := v.Call([]reflect.Value{reflect.ValueOf(s)})[0].Interface().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fieldHelp returns the help text for the named field of the struct type t
+// from its FieldHelp method, if t or its pointer implements HelpProvider
+func fieldHelp(t reflect.Type, name string) (string, bool) {
+	if !reflect.PtrTo(t).Implements(helpProviderType) {
+		return "", false
+	}
+	help, ok := reflect.New(t).Interface().(HelpProvider).FieldHelp()[name]
+	return help, ok
+}
+
+// isBinaryUnmarshaler returns true if the type or its pointer implements encoding.BinaryUnmarshaler
+func isBinaryUnmarshaler(t reflect.Type) bool {
+	return t.Implements(binaryUnmarshalerType) || reflect.PtrTo(t).Implements(binaryUnmarshalerType)
+}
+
+// needsEncoding returns true if the type can only be parsed from a string by
+// decoding it to bytes first, as described for the "encoding" tag
+func needsEncoding(t reflect.Type) bool {
+	return isBinaryUnmarshaler(t) && !canParse(t) && !isJSONOnly(t)
+}
+
+// isBytes returns true if the type is a byte slice, or a pointer to one, that
+// cannot otherwise be parsed from a string
+func isBytes(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && !canParse(t)
+}
+
+// isJSONOnly returns true if the type, or a pointer to it, implements
+// json.Unmarshaler and the type cannot otherwise be parsed from a string
+func isJSONOnly(t reflect.Type) bool {
+	if canParse(t) {
+		return false
+	}
+	return t.Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(jsonUnmarshalerType)
+}
+
+// defaultProviderOf returns the type T such that *T implements DefaultProvider,
+// where t is either T or *T, or nil if there is no such type
+func defaultProviderOf(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(defaultProviderType) {
+		return t
+	}
+	return nil
+}
+
 // isExported returns true if the struct field name is exported
 func isExported(field string) bool {
 	r, _ := utf8.DecodeRuneInString(field) // returns RuneError for empty string or invalid UTF8
@@ -102,11 +370,107 @@ func isExported(field string) bool {
 // isZero returns true if v contains the zero value for its type
 func isZero(v reflect.Value) bool {
 	t := v.Type()
-	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Chan || t.Kind() == reflect.Interface {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Chan || t.Kind() == reflect.Interface || t.Kind() == reflect.Func {
 		return v.IsNil()
 	}
 	if !t.Comparable() {
-		return false
+		// structs containing slices or maps, such as the result of UnmarshalBinary
+		return t.Kind() == reflect.Struct && v.IsZero()
 	}
 	return v.Interface() == reflect.Zero(t).Interface()
 }
+
+// isBinaryEncoding returns true if the name is one of the encodings supported
+// by the "encoding" tag
+func isBinaryEncoding(name string) bool {
+	switch name {
+	case "base64", "base64url", "hex":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseValue parses a string into v. If enc is non-empty then the string is
+// first decoded from that encoding and the bytes are passed to UnmarshalBinary.
+// Types that can only be parsed by UnmarshalJSON are given the string as it is
+// if it is valid JSON, or quoted as a JSON string otherwise. Byte slices are set
+// to the bytes of the string. All other values are parsed with the parser
+// registered for their type with RegisterParser, if any, or scalar.ParseValue.
+func parseValue(v reflect.Value, s string, enc string) error {
+	if isSetter(v.Type()) {
+		return callSetter(v, s)
+	}
+	if _, ok := lazyElemOf(v.Type()); ok {
+		v.Set(lazyParser(v.Type(), s, enc))
+		return nil
+	}
+	if enc == "" && isBytes(v.Type()) && !isJSONOnly(v.Type()) {
+		b := allocate(v).Elem()
+		b.SetBytes([]byte(s))
+		return nil
+	}
+	if enc == "" && isJSONOnly(v.Type()) {
+		data := []byte(s)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(s)
+		}
+		u, ok := allocate(v).Interface().(json.Unmarshaler)
+		if !ok {
+			return fmt.Errorf("%v does not implement json.Unmarshaler", v.Type())
+		}
+		return u.UnmarshalJSON(data)
+	}
+	if enc == "" {
+		return parseScalar(v, s)
+	}
+
+	var b []byte
+	var err error
+	switch enc {
+	case "base64":
+		b, err = base64.StdEncoding.DecodeString(s)
+	case "base64url":
+		b, err = base64.URLEncoding.DecodeString(s)
+	case "hex":
+		b, err = hex.DecodeString(s)
+	default:
+		return fmt.Errorf("unknown encoding %q", enc)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %v", enc, err)
+	}
+
+	u, ok := allocate(v).Interface().(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%v does not implement encoding.BinaryUnmarshaler", v.Type())
+	}
+	return u.UnmarshalBinary(b)
+}
+
+// allocate returns a pointer to the value in v, allocating it first if v is a
+// nil pointer, as scalar.ParseValue does
+func allocate(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v
+	}
+	return v.Addr()
+}
+
+// lazyParser creates a closure of type t, which must be func() (T, error), that
+// parses the given string into a new T each time it is called
+func lazyParser(t reflect.Type, s string, enc string) reflect.Value {
+	elem := t.Out(0)
+	return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+		v := reflect.New(elem).Elem()
+		errv := reflect.Zero(errorType)
+		if err := parseValue(v, s, enc); err != nil {
+			v = reflect.Zero(elem)
+			errv = reflect.ValueOf(&err).Elem()
+		}
+		return []reflect.Value{v, errv}
+	})
+}
@@ -1,10 +1,14 @@
 package arg
 
 import (
+	"bytes"
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func assertCardinality(t *testing.T, typ reflect.Type, expected cardinality) {
@@ -63,6 +67,16 @@ func (*implementsTextUnmarshaler) UnmarshalText(_ []byte) error {
 	return nil
 }
 
+func TestCardinalityMapOfSlices(t *testing.T) {
+	var m map[string][]string
+	var unsupported1 map[string][]struct{}
+	var unsupported2 map[string][][]string
+	assertCardinality(t, reflect.TypeOf(m), multiple)
+	assertCardinality(t, reflect.TypeOf(&m), multiple)
+	assertCardinality(t, reflect.TypeOf(unsupported1), unsupported)
+	assertCardinality(t, reflect.TypeOf(unsupported2), unsupported)
+}
+
 func TestCardinalityTextUnmarshaler(t *testing.T) {
 	var x implementsTextUnmarshaler
 	var s []implementsTextUnmarshaler
@@ -75,6 +89,17 @@ func TestCardinalityTextUnmarshaler(t *testing.T) {
 	assertCardinality(t, reflect.TypeOf(&m), multiple)
 }
 
+func TestCardinalitySetter(t *testing.T) {
+	var set func(string) error
+	var notSetter func(int) error
+	assertCardinality(t, reflect.TypeOf(set), one)
+	assertCardinality(t, reflect.TypeOf(notSetter), unsupported)
+
+	k, err := overrideCardinality(reflect.TypeOf(set), "multiple")
+	require.NoError(t, err)
+	assert.Equal(t, multiple, k)
+}
+
 func TestIsExported(t *testing.T) {
 	assert.True(t, isExported("Exported"))
 	assert.False(t, isExported("notExported"))
@@ -109,4 +134,195 @@ func TestIsZero(t *testing.T) {
 	assert.False(t, isZero(reflect.ValueOf(nonNilMap)))
 
 	assert.False(t, isZero(reflect.ValueOf(uncomparable)))
+
+	// subcommands that were not selected are nil pointers to structs
+	var nilSubcommand *struct{ Name string }
+	var subcommand = &struct{ Name string }{}
+	assert.True(t, isZero(reflect.ValueOf(nilSubcommand)))
+	assert.False(t, isZero(reflect.ValueOf(subcommand)))
+}
+
+func TestCardinalityMapWithTextUnmarshalerKey(t *testing.T) {
+	var m map[implementsTextUnmarshaler]string
+	var p map[*implementsTextUnmarshaler]int
+	assertCardinality(t, reflect.TypeOf(m), multiple)
+	assertCardinality(t, reflect.TypeOf(&m), multiple)
+	assertCardinality(t, reflect.TypeOf(p), multiple)
+}
+
+type implementsBinaryUnmarshaler struct{}
+
+func (*implementsBinaryUnmarshaler) UnmarshalBinary(_ []byte) error {
+	return nil
+}
+
+func TestCardinalityBinaryUnmarshaler(t *testing.T) {
+	var x implementsBinaryUnmarshaler
+	assertCardinality(t, reflect.TypeOf(x), one)
+	assertCardinality(t, reflect.TypeOf(&x), one)
+}
+
+func TestIsZeroUncomparableStruct(t *testing.T) {
+	var zero binaryKey
+	notZero := binaryKey{data: []byte{1}}
+	assert.True(t, isZero(reflect.ValueOf(zero)))
+	assert.False(t, isZero(reflect.ValueOf(notZero)))
+}
+
+func TestCardinalityLazy(t *testing.T) {
+	var f func() (int, error)
+	var b func() (bool, error)
+	var s func() ([]int, error)
+	assertCardinality(t, reflect.TypeOf(f), one)
+	assertCardinality(t, reflect.TypeOf(b), unsupported)
+	assertCardinality(t, reflect.TypeOf(s), unsupported)
+}
+
+// semicolonList is a slice that is parsed from a single semicolon-separated string
+type semicolonList []string
+
+func (l *semicolonList) UnmarshalText(b []byte) error {
+	*l = strings.Split(string(b), ";")
+	return nil
+}
+
+func TestOverrideCardinality(t *testing.T) {
+	var b bool
+	var s string
+	var l semicolonList
+	var m map[string]int
+
+	k, err := overrideCardinality(reflect.TypeOf(b), "one")
+	require.NoError(t, err)
+	assert.Equal(t, one, k)
+
+	k, err = overrideCardinality(reflect.TypeOf(&b), "zero")
+	require.NoError(t, err)
+	assert.Equal(t, zero, k)
+
+	k, err = overrideCardinality(reflect.TypeOf(l), "multiple")
+	require.NoError(t, err)
+	assert.Equal(t, multiple, k)
+
+	_, err = overrideCardinality(reflect.TypeOf(s), "zero")
+	assert.EqualError(t, err, "cardinality zero can only be used with boolean fields")
+
+	_, err = overrideCardinality(reflect.TypeOf(s), "multiple")
+	assert.EqualError(t, err, "cardinality multiple requires a slice or map, but got string")
+
+	_, err = overrideCardinality(reflect.TypeOf(m), "one")
+	assert.EqualError(t, err, "cardinality one requires a type that can be parsed from a single string, but got map[string]int")
+
+	_, err = overrideCardinality(reflect.TypeOf(s), "many")
+	assert.EqualError(t, err, `unknown cardinality "many", expected zero, one, or multiple`)
+}
+
+// point is a type that can only be parsed as JSON
+type point struct {
+	X, Y int
+}
+
+func (p *point) UnmarshalJSON(b []byte) error {
+	var xy [2]int
+	if err := json.Unmarshal(b, &xy); err != nil {
+		return err
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+func TestCardinalityJSONUnmarshaler(t *testing.T) {
+	var p point
+	var ps []point
+	assertCardinality(t, reflect.TypeOf(p), one)
+	assertCardinality(t, reflect.TypeOf(&p), one)
+	assertCardinality(t, reflect.TypeOf(ps), multiple)
+}
+
+func TestParseValueJSON(t *testing.T) {
+	var p point
+	require.NoError(t, parseValue(reflect.ValueOf(&p).Elem(), "[1,2]", ""))
+	assert.Equal(t, point{1, 2}, p)
+
+	var tag jsonString
+	require.NoError(t, parseValue(reflect.ValueOf(&tag).Elem(), "not json", ""))
+	assert.Equal(t, jsonString("not json"), tag)
+}
+
+// jsonString is a string type that is parsed from a JSON string
+type jsonString string
+
+func (s *jsonString) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = jsonString(v)
+	return nil
+}
+
+func TestCardinalityBytes(t *testing.T) {
+	var b []byte
+	var bs [][]byte
+	assertCardinality(t, reflect.TypeOf(b), one)
+	assertCardinality(t, reflect.TypeOf(&b), one)
+	assertCardinality(t, reflect.TypeOf(bs), multiple)
+}
+
+type helpProviderCommon struct {
+	Config string
+}
+
+func (helpProviderCommon) FieldHelp() map[string]string {
+	return map[string]string{"Config": "the configuration file"}
+}
+
+type helpProviderDeploy struct {
+	Target string `arg:"positional"`
+}
+
+func (helpProviderDeploy) FieldHelp() map[string]string {
+	return map[string]string{"Target": "the server to deploy to"}
+}
+
+type helpProviderArgs struct {
+	helpProviderCommon
+	Verbose bool
+	Name    string              `help:"from the tag"`
+	Deploy  *helpProviderDeploy `arg:"subcommand"`
+}
+
+func (helpProviderArgs) FieldHelp() map[string]string {
+	return map[string]string{
+		"Verbose": "turn on verbose output",
+		"Name":    "overridden by the tag",
+		"Deploy":  "deploy to a server",
+	}
+}
+
+func TestHelpProvider(t *testing.T) {
+	expectedHelp := `
+Usage: example [--config CONFIG] [--verbose] [--name NAME] <command> [<args>]
+
+Options:
+  --config CONFIG        the configuration file
+  --verbose              turn on verbose output
+  --name NAME            from the tag
+  --help, -h             display this help and exit
+
+Commands:
+  deploy                 deploy to a server
+`
+	var args helpProviderArgs
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	_ = p.Parse([]string{"deploy", "--help"})
+	help.Reset()
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "  TARGET                 the server to deploy to\n")
 }
@@ -0,0 +1,103 @@
+package arg
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/alexflint/go-scalar"
+)
+
+// parsers holds the functions registered with RegisterParser, by type
+var parsers = struct {
+	sync.RWMutex
+	m map[reflect.Type]func(string) (interface{}, error)
+}{m: make(map[reflect.Type]func(string) (interface{}, error))}
+
+// RegisterParser makes the library parse values of the given type with fn,
+// which is useful for types from other packages that cannot be given a
+// TextUnmarshaler method, such as *url.URL, decimal.Decimal, or protobuf
+// enums. The value returned by fn must be assignable to typ. Fields of type
+// typ or of a pointer to typ, as well as slices, arrays, and maps with such
+// elements, are accepted once the parser is registered, and a registered
+// parser takes precedence over the way the type would otherwise be parsed.
+// Registering a nil fn removes the parser for typ. Parsers apply to all
+// Parsers constructed afterwards, so they are usually registered in an init
+// function. They are not affected by Parser.Freeze.
+func RegisterParser(typ reflect.Type, fn func(string) (interface{}, error)) {
+	// the types accepted by cached commands may change
+	defer clearCommandCache()
+
+	parsers.Lock()
+	defer parsers.Unlock()
+	if fn == nil {
+		delete(parsers.m, typ)
+		return
+	}
+	parsers.m[typ] = fn
+}
+
+// RegisterParserFor registers fn as the parser for values of type T, as
+// described for RegisterParser
+func RegisterParserFor[T any](fn func(string) (T, error)) {
+	RegisterParser(reflect.TypeOf((*T)(nil)).Elem(), func(s string) (interface{}, error) {
+		return fn(s)
+	})
+}
+
+// registeredParser returns the parser registered for t, or for the type to
+// which t points, and whether t is itself a pointer to the registered type
+func registeredParser(t reflect.Type) (func(string) (interface{}, error), bool) {
+	parsers.RLock()
+	defer parsers.RUnlock()
+	if fn, ok := parsers.m[t]; ok {
+		return fn, false
+	}
+	if t.Kind() == reflect.Ptr {
+		if fn, ok := parsers.m[t.Elem()]; ok {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// canParse returns true if values of the type can be parsed from a single
+// string, either by a parser registered with RegisterParser or by
+// scalar.ParseValue
+func canParse(t reflect.Type) bool {
+	if fn, _ := registeredParser(t); fn != nil {
+		return true
+	}
+	return scalar.CanParse(t)
+}
+
+// parseScalar parses a string into v with the parser registered for its type,
+// if any, and otherwise with scalar.ParseValue
+func parseScalar(v reflect.Value, s string) error {
+	fn, isPtr := registeredParser(v.Type())
+	if fn == nil {
+		return scalar.ParseValue(v, s)
+	}
+
+	x, err := fn(s)
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	if isPtr {
+		t = t.Elem()
+	}
+	parsed := reflect.ValueOf(x)
+	if !parsed.IsValid() {
+		parsed = reflect.Zero(t)
+	}
+	if !parsed.Type().AssignableTo(t) {
+		return fmt.Errorf("parser registered for %v returned a %v", t, parsed.Type())
+	}
+	if isPtr {
+		allocate(v).Elem().Set(parsed)
+		return nil
+	}
+	v.Set(parsed)
+	return nil
+}
@@ -0,0 +1,93 @@
+package arg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coord is a type that the library cannot parse unless a parser is registered
+type coord struct {
+	X, Y int
+}
+
+func parseCoord(s string) (coord, error) {
+	var p coord
+	if _, err := fmt.Sscanf(s, "%d:%d", &p.X, &p.Y); err != nil {
+		return coord{}, fmt.Errorf("invalid coord %q", s)
+	}
+	return p, nil
+}
+
+func registerCoord(t *testing.T) {
+	RegisterParserFor(parseCoord)
+	t.Cleanup(func() { RegisterParser(reflect.TypeOf(coord{}), nil) })
+}
+
+func TestRegisterParser(t *testing.T) {
+	var args struct {
+		Origin coord
+		Target *coord
+		Path   []coord
+		Named  map[string]coord
+		Pair   [2]coord `arg:"nargs:2"`
+	}
+	_, err := NewParser(Config{}, &args)
+	require.Error(t, err)
+
+	registerCoord(t)
+	parse(t, "--origin 1:2 --target 3:4 --path 5:6 7:8 --named a=9:10 --pair 1:1 2:2", &args)
+	assert.Equal(t, coord{1, 2}, args.Origin)
+	require.NotNil(t, args.Target)
+	assert.Equal(t, coord{3, 4}, *args.Target)
+	assert.Equal(t, []coord{{5, 6}, {7, 8}}, args.Path)
+	assert.Equal(t, map[string]coord{"a": {9, 10}}, args.Named)
+	assert.Equal(t, [2]coord{{1, 1}, {2, 2}}, args.Pair)
+
+	_, err = parseWithEnvErr(t, "--origin nowhere", nil, &args)
+	assert.EqualError(t, err, `error processing --origin: invalid coord "nowhere"`)
+}
+
+func TestRegisterParserOverridesTextUnmarshaler(t *testing.T) {
+	RegisterParser(reflect.TypeOf(textUnmarshaler{}), func(s string) (interface{}, error) {
+		return textUnmarshaler{val: len(s) * 10}, nil
+	})
+	t.Cleanup(func() { RegisterParser(reflect.TypeOf(textUnmarshaler{}), nil) })
+
+	var args struct {
+		Foo textUnmarshaler
+	}
+	parse(t, "--foo abc", &args)
+	assert.Equal(t, 30, args.Foo.val)
+}
+
+func TestRegisterParserWrongType(t *testing.T) {
+	RegisterParser(reflect.TypeOf(coord{}), func(s string) (interface{}, error) {
+		return strings.ToUpper(s), nil
+	})
+	t.Cleanup(func() { RegisterParser(reflect.TypeOf(coord{}), nil) })
+
+	var args struct {
+		Origin coord
+	}
+	_, err := parseWithEnvErr(t, "--origin x", nil, &args)
+	assert.EqualError(t, err, "error processing --origin: parser registered for arg.coord returned a string")
+}
+
+func TestRegisterParserError(t *testing.T) {
+	RegisterParser(reflect.TypeOf(coord{}), func(s string) (interface{}, error) {
+		return nil, errors.New("no points today")
+	})
+	t.Cleanup(func() { RegisterParser(reflect.TypeOf(coord{}), nil) })
+
+	var args struct {
+		Origin coord `arg:"env"`
+	}
+	_, err := parseWithEnvErr(t, "", []string{"ORIGIN=1:2"}, &args)
+	assert.EqualError(t, err, "error processing environment variable ORIGIN: no points today")
+}
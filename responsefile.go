@@ -0,0 +1,122 @@
+package arg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// expandResponseFiles replaces each argument of the form @path with the
+// arguments read from that file, as described for Config.AllowResponseFiles.
+// Arguments after "--" are not expanded, whether the "--" was given directly
+// or read from a response file.
+func (p *Parser) expandResponseFiles(args []string) ([]string, error) {
+	var read int
+	out, _, err := p.expandResponseFilesFrom(args, nil, &read)
+	return out, err
+}
+
+// expandResponseFilesFrom expands the given arguments, which were read from
+// the last of the given chain of response files, if any. It reports whether
+// one of the expanded arguments was "--", and adds the number of bytes read
+// from response files to read.
+func (p *Parser) expandResponseFilesFrom(args []string, chain []string, read *int) ([]string, bool, error) {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...), true, nil
+		}
+		if len(arg) < 2 || arg[0] != '@' {
+			out = append(out, arg)
+			continue
+		}
+
+		filename := arg[1:]
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			abs = filename
+		}
+		for _, included := range chain {
+			if included == abs {
+				return nil, false, fmt.Errorf("response file %s includes itself", filename)
+			}
+		}
+		data, err := p.readResponseFile(filename, *read)
+		if err != nil {
+			return nil, false, err
+		}
+		*read += len(data)
+		lines, err := parseResponseFile(string(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading response file %s: %v", filename, err)
+		}
+		expanded, done, err := p.expandResponseFilesFrom(lines, append(chain, abs), read)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, expanded...)
+		if done {
+			return append(out, args[i+1:]...), true, nil
+		}
+	}
+	return out, false, nil
+}
+
+// readResponseFile returns the contents of the named response file. If
+// Config.MaxTotalArgsBytes is set then no more is read than the limit allows
+// after the given number of bytes read from other response files, and an error
+// wrapping ErrArgsTooLong is returned if the file is longer.
+func (p *Parser) readResponseFile(filename string, read int) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response file %s: %v", filename, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	limit := p.config.MaxTotalArgsBytes
+	if limit > 0 {
+		// one byte more than the limit is enough to tell that it was exceeded
+		r = io.LimitReader(f, int64(limit-read)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response file %s: %v", filename, err)
+	}
+	if err := p.checkTotalBytes(read + len(data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parseResponseFile returns the arguments in the contents of a response file,
+// one for each line that is neither blank nor a comment starting with "#".
+// Lines are trimmed of surrounding whitespace unless they are quoted. Double
+// quoted lines may contain the escape sequences of Go string literals, and
+// single quoted lines are taken as they are.
+func parseResponseFile(contents string) ([]string, error) {
+	var args []string
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, `"`):
+			arg, err := strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted argument %s", i+1, line)
+			}
+			args = append(args, arg)
+		case strings.HasPrefix(line, "'"):
+			if len(line) < 2 || !strings.HasSuffix(line, "'") {
+				return nil, fmt.Errorf("line %d: invalid quoted argument %s", i+1, line)
+			}
+			args = append(args, line[1:len(line)-1])
+		default:
+			args = append(args, line)
+		}
+	}
+	return args, nil
+}
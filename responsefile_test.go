@@ -0,0 +1,127 @@
+package arg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeResponseFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestResponseFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeResponseFile(t, dir, "args.txt", `# build settings
+--name
+  build one
+" padded "
+
+--tag
+'#not-a-comment'
+"tab\there"
+`)
+	var args struct {
+		Name    string
+		Tag     []string
+		Verbose bool
+		Rest    []string `arg:"positional"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AllowResponseFiles: true}, "--verbose @"+path+" -- @literal", nil, &args)
+	require.NoError(t, err)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, "build one", args.Name)
+	assert.Equal(t, []string{"#not-a-comment", "tab\there"}, args.Tag)
+	assert.Equal(t, []string{" padded ", "@literal"}, args.Rest)
+}
+
+func TestResponseFileDisabled(t *testing.T) {
+	var args struct {
+		Src string `arg:"positional"`
+	}
+	parse(t, "@user", &args)
+	assert.Equal(t, "@user", args.Src)
+}
+
+func TestResponseFileNested(t *testing.T) {
+	dir := t.TempDir()
+	inner := writeResponseFile(t, dir, "inner.txt", "--tag\nb\n")
+	outer := writeResponseFile(t, dir, "outer.txt", "--tag\na\n@"+inner+"\n")
+	var args struct {
+		Tag []string `arg:"separate"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AllowResponseFiles: true}, "@"+outer, nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, args.Tag)
+}
+
+func TestResponseFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	var args struct {
+		Name string
+	}
+	config := Config{AllowResponseFiles: true}
+
+	missing := filepath.Join(dir, "missing.txt")
+	_, err := parseWithConfigEnvErr(t, config, "@"+missing, nil, &args)
+	assert.ErrorContains(t, err, "error reading response file "+missing+": ")
+
+	loop := filepath.Join(dir, "loop.txt")
+	writeResponseFile(t, dir, "loop.txt", "@"+loop+"\n")
+	_, err = parseWithConfigEnvErr(t, config, "@"+loop, nil, &args)
+	assert.EqualError(t, err, "response file "+loop+" includes itself")
+
+	bad := writeResponseFile(t, dir, "bad.txt", "--name\n\"unterminated\n")
+	_, err = parseWithConfigEnvErr(t, config, "@"+bad, nil, &args)
+	assert.EqualError(t, err, "error reading response file "+bad+": line 2: invalid quoted argument \"unterminated")
+}
+
+func TestResponseFileArgsTooLong(t *testing.T) {
+	path := writeResponseFile(t, t.TempDir(), "args.txt", "--name\n"+strings.Repeat("x", 1000)+"\n")
+	var args struct {
+		Name string
+	}
+	config := Config{AllowResponseFiles: true, MaxTotalArgsBytes: len(path) + 1}
+	_, err := parseWithConfigEnvErr(t, config, "@"+path, nil, &args)
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+}
+
+func TestResponseFilesTooLongInTotal(t *testing.T) {
+	// comments count toward the limit, even though they are not arguments
+	dir := t.TempDir()
+	comment := "# " + strings.Repeat("x", 400) + "\n"
+	first := writeResponseFile(t, dir, "first.txt", comment+"--tag\na\n")
+	second := writeResponseFile(t, dir, "second.txt", comment+"--tag\nb\n")
+	var args struct {
+		Tag []string
+	}
+	config := Config{AllowResponseFiles: true, MaxTotalArgsBytes: 600}
+	_, err := parseWithConfigEnvErr(t, config, "@"+first, nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, args.Tag)
+
+	_, err = parseWithConfigEnvErr(t, config, "@"+first+" @"+second, nil, &args)
+	assert.True(t, errors.Is(err, ErrArgsTooLong))
+}
+
+func TestResponseFileDoubleDash(t *testing.T) {
+	dir := t.TempDir()
+	inner := writeResponseFile(t, dir, "inner.txt", "--name\nx\n--\n@literal\n")
+	other := writeResponseFile(t, dir, "other.txt", "--name\ny\n")
+	var args struct {
+		Name string
+		Rest []string `arg:"positional"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{AllowResponseFiles: true}, "@"+inner+" @"+other, nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "x", args.Name)
+	assert.Equal(t, []string{"@literal", "@" + other}, args.Rest)
+}
@@ -2,20 +2,31 @@ package arg
 
 import (
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
-
-	"github.com/alexflint/go-scalar"
 )
 
 // setSliceOrMap parses a sequence of strings into a slice or map. If clear is
-// true then any values already in the slice or map are first removed.
-func setSliceOrMap(dest reflect.Value, values []string, clear bool) error {
+// true then any values already in the slice or map are first removed. Map
+// entries are split into key and value at kvsep, or at "=" if kvsep is empty.
+func setSliceOrMap(dest reflect.Value, values []string, clear bool, kvsep string) error {
 	if !dest.CanSet() {
 		return fmt.Errorf("field is not writable")
 	}
 
 	t := dest.Type()
+	if isAppender(t) {
+		return setAppender(dest, values, clear, kvsep)
+	}
+	if isSetter(t) {
+		for _, s := range values {
+			if err := callSetter(dest, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if t.Kind() == reflect.Ptr {
 		dest = dest.Elem()
 		t = t.Elem()
@@ -25,7 +36,7 @@ func setSliceOrMap(dest reflect.Value, values []string, clear bool) error {
 	case reflect.Slice:
 		return setSlice(dest, values, clear)
 	case reflect.Map:
-		return setMap(dest, values, clear)
+		return setMap(dest, values, clear, kvsep)
 	default:
 		return fmt.Errorf("setSliceOrMap cannot insert values into a %v", t)
 	}
@@ -49,7 +60,7 @@ func setSlice(dest reflect.Value, values []string, clear bool) error {
 	// parse the values one-by-one
 	for _, s := range values {
 		v := reflect.New(elem)
-		if err := scalar.ParseValue(v.Elem(), s); err != nil {
+		if err := parseValue(v.Elem(), s, ""); err != nil {
 			return err
 		}
 		if !ptr {
@@ -61,8 +72,14 @@ func setSlice(dest reflect.Value, values []string, clear bool) error {
 }
 
 // setMap parses a sequence of name=value strings and inserts them into a map.
-// If clear is true then any values already in the map are removed.
-func setMap(dest reflect.Value, values []string, clear bool) error {
+// If clear is true then any values already in the map are removed. If the map
+// values are slices then each value is appended to the slice for its key. If
+// kvsep is non-empty then it separates names from values instead of "=".
+func setMap(dest reflect.Value, values []string, clear bool, kvsep string) error {
+	if kvsep == "" {
+		kvsep = "="
+	}
+
 	// determine the key and value type
 	var keyIsPtr bool
 	keyType := dest.Type().Key()
@@ -73,6 +90,7 @@ func setMap(dest reflect.Value, values []string, clear bool) error {
 
 	var valIsPtr bool
 	valType := dest.Type().Elem()
+	valIsSlice := isSliceValue(valType)
 	if valType.Kind() == reflect.Ptr && !valType.Implements(textUnmarshalerType) {
 		valIsPtr = true
 		valType = valType.Elem()
@@ -92,24 +110,37 @@ func setMap(dest reflect.Value, values []string, clear bool) error {
 
 	// parse the values one-by-one
 	for _, s := range values {
-		// split at the first equals sign
-		pos := strings.Index(s, "=")
+		// split at the first separator
+		pos := strings.Index(s, kvsep)
 		if pos == -1 {
-			return fmt.Errorf("cannot parse %q into a map, expected format key=value", s)
+			return fmt.Errorf("cannot parse %q into a map, expected format key%svalue", s, kvsep)
 		}
 
 		// parse the key
 		k := reflect.New(keyType)
-		if err := scalar.ParseValue(k.Elem(), s[:pos]); err != nil {
-			return err
+		if err := parseScalar(k.Elem(), s[:pos]); err != nil {
+			return fmt.Errorf("error parsing key %q: %v", s[:pos], err)
 		}
 		if !keyIsPtr {
 			k = k.Elem()
 		}
 
+		// for maps with slice values, repeated keys append to the slice
+		if valIsSlice {
+			v := reflect.New(valType).Elem()
+			if existing := dest.MapIndex(k); existing.IsValid() {
+				v.Set(existing)
+			}
+			if err := setSlice(v, []string{s[pos+len(kvsep):]}, false); err != nil {
+				return err
+			}
+			dest.SetMapIndex(k, v)
+			continue
+		}
+
 		// parse the value
 		v := reflect.New(valType)
-		if err := scalar.ParseValue(v.Elem(), s[pos+1:]); err != nil {
+		if err := parseScalar(v.Elem(), s[pos+len(kvsep):]); err != nil {
 			return err
 		}
 		if !valIsPtr {
@@ -121,3 +152,249 @@ func setMap(dest reflect.Value, values []string, clear bool) error {
 	}
 	return nil
 }
+
+// setAppender splits a sequence of name=value strings into names and values
+// and passes them to the Append method of dest, which must implement Appender
+// or be addressable and have a pointer that does. If clear is true then dest
+// is first reset to its zero value, or to a new value if it is a pointer. If
+// kvsep is non-empty then it separates names from values instead of "=".
+func setAppender(dest reflect.Value, values []string, clear bool, kvsep string) error {
+	if kvsep == "" {
+		kvsep = "="
+	}
+
+	var appender Appender
+	if t := dest.Type(); t.Kind() == reflect.Ptr {
+		if clear || dest.IsNil() {
+			dest.Set(reflect.New(t.Elem()))
+		}
+		appender = dest.Interface().(Appender)
+	} else {
+		if clear {
+			dest.Set(reflect.Zero(t))
+		}
+		appender = dest.Addr().Interface().(Appender)
+	}
+
+	for _, s := range values {
+		pos := strings.Index(s, kvsep)
+		if pos == -1 {
+			return fmt.Errorf("cannot parse %q, expected format key%svalue", s, kvsep)
+		}
+		if err := appender.Append(s[:pos], s[pos+len(kvsep):]); err != nil {
+			return fmt.Errorf("error appending %q: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// maxSliceIndex is the largest index that may be given for an element of a
+// slice of structs, as in --server.N.host, so that an index given on the
+// command line cannot make the slice arbitrarily large
+const maxSliceIndex = 999
+
+// setSliceElemField parses a string into a field of the element at the given
+// index of a slice of structs. The slice is extended with zero-valued elements
+// as needed so that the index is in range, up to maxSliceIndex.
+func setSliceElemField(dest reflect.Value, index int, field reflect.StructField, value string) error {
+	if !dest.CanSet() {
+		return fmt.Errorf("field is not writable")
+	}
+	if index > maxSliceIndex {
+		return fmt.Errorf("index %d out of range, the largest index is %d", index, maxSliceIndex)
+	}
+	if n := index + 1 - dest.Len(); n > 0 {
+		dest.Set(reflect.AppendSlice(dest, reflect.MakeSlice(dest.Type(), n, n)))
+	}
+	return parseValue(dest.Index(index).FieldByIndex(field.Index), value, "")
+}
+
+// splitValues splits each value at the given separator. If the destination is
+// a map whose values are slices then only the part after the first occurrence
+// of kvsep (or "=" if kvsep is empty) is split, so that "k=a,b" is equivalent
+// to "k=a" followed by "k=b". If quoted is true then separators inside double
+// quotes or escaped with a backslash do not split, as described for
+// splitQuoted. If sep is empty then the values are returned unchanged.
+func splitValues(t reflect.Type, values []string, sep, kvsep string, quoted bool) ([]string, error) {
+	if sep == "" {
+		return values, nil
+	}
+	if kvsep == "" {
+		kvsep = "="
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	split := func(s string) ([]string, error) {
+		if quoted {
+			return splitQuoted(s, sep)
+		}
+		return strings.Split(s, sep), nil
+	}
+
+	var out []string
+	for _, s := range values {
+		if t.Kind() == reflect.Map && isSliceValue(t.Elem()) {
+			if pos := strings.Index(s, kvsep); pos != -1 {
+				prefix := s[:pos+len(kvsep)]
+				parts, err := split(s[len(prefix):])
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range parts {
+					out = append(out, prefix+v)
+				}
+				continue
+			}
+		}
+		parts, err := split(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, parts...)
+	}
+	return out, nil
+}
+
+// splitQuoted splits s at each occurrence of sep that is outside of double
+// quotes. The quotes are removed, and a backslash causes the character that
+// follows it to be taken literally, so that values may contain sep.
+func splitQuoted(s, sep string) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+	var quoted bool
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(s[i:], sep):
+			out = append(out, cur.String())
+			cur.Reset()
+			i += len(sep) - 1
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	return append(out, cur.String()), nil
+}
+
+// readLines reads the file with the given name and returns its lines, without
+// line endings. Lines that are empty or consist only of spaces are omitted.
+func readLines(name string) ([]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// setArray parses a sequence of strings into an array, which must have exactly
+// as many elements as there are strings
+func setArray(dest reflect.Value, values []string) error {
+	if !dest.CanSet() {
+		return fmt.Errorf("field is not writable")
+	}
+	if dest.Len() != len(values) {
+		return fmt.Errorf("expected %d values but got %d", dest.Len(), len(values))
+	}
+	for i, s := range values {
+		if err := parseScalar(dest.Index(i), s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDuplicateKeys returns an error if the key of any of the given entries
+// for a map of type t is already in seen or appears more than once, and
+// otherwise adds the keys to seen. Keys are compared after parsing, so that
+// two spellings of the same key are duplicates, except that keys which cannot
+// be parsed are compared as strings and left for setMap to report.
+func checkDuplicateKeys(t reflect.Type, values []string, kvsep string, seen map[interface{}]bool) error {
+	if kvsep == "" {
+		kvsep = "="
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	keyType := t.Key()
+	if keyType.Kind() == reflect.Ptr && !keyType.Implements(textUnmarshalerType) {
+		keyType = keyType.Elem()
+	}
+
+	for _, s := range values {
+		raw := s
+		if pos := strings.Index(s, kvsep); pos != -1 {
+			raw = s[:pos]
+		}
+		var key interface{} = raw
+		if k := reflect.New(keyType).Elem(); keyType.Comparable() && parseScalar(k, raw) == nil {
+			key = k.Interface()
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate key %q", raw)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// copySlice returns a copy of a slice, or of a pointer to a slice, that does
+// not share its backing array with the original
+func copySlice(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(copySlice(v.Elem()))
+		return out
+	}
+	if v.IsNil() {
+		return v
+	}
+	out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(out, v)
+	return out
+}
+
+// clearSliceOrMap sets a slice or map to an empty but non-nil value, allocating
+// the pointer if dest is a pointer to a slice or map
+func clearSliceOrMap(dest reflect.Value) error {
+	if !dest.CanSet() {
+		return fmt.Errorf("field is not writable")
+	}
+
+	t := dest.Type()
+	if t.Kind() == reflect.Ptr {
+		if dest.IsNil() {
+			dest.Set(reflect.New(t.Elem()))
+		}
+		dest = dest.Elem()
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		dest.Set(reflect.MakeSlice(t, 0, 0))
+	case reflect.Map:
+		dest.Set(reflect.MakeMap(t))
+	default:
+		return fmt.Errorf("clearSliceOrMap cannot clear a %v", t)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package arg
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -57,7 +60,7 @@ func TestSetSliceTextUnmarshaller(t *testing.T) {
 func TestSetMapWithoutClearing(t *testing.T) {
 	m := map[string]int{"foo": 10}
 	entries := []string{"a=1", "b=2"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, false)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, false, "")
 	require.NoError(t, err)
 	require.Len(t, m, 3)
 	assert.Equal(t, 1, m["a"])
@@ -68,7 +71,7 @@ func TestSetMapWithoutClearing(t *testing.T) {
 func TestSetMapAfterClearing(t *testing.T) {
 	m := map[string]int{"foo": 10}
 	entries := []string{"a=1", "b=2"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	require.NoError(t, err)
 	require.Len(t, m, 2)
 	assert.Equal(t, 1, m["a"])
@@ -79,7 +82,7 @@ func TestSetMapWithKeyPointer(t *testing.T) {
 	// textUnmarshaler is a struct that captures the length of the string passed to it
 	var m map[*string]int
 	entries := []string{"abc=123"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	require.NoError(t, err)
 	require.Len(t, m, 1)
 }
@@ -88,7 +91,7 @@ func TestSetMapWithValuePointer(t *testing.T) {
 	// textUnmarshaler is a struct that captures the length of the string passed to it
 	var m map[string]*int
 	entries := []string{"abc=123"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	require.NoError(t, err)
 	require.Len(t, m, 1)
 	assert.Equal(t, 123, *m["abc"])
@@ -98,7 +101,7 @@ func TestSetMapTextUnmarshaller(t *testing.T) {
 	// textUnmarshaler is a struct that captures the length of the string passed to it
 	var m map[textUnmarshaler]*textUnmarshaler
 	entries := []string{"a=123", "aa=12", "aaa=1"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	require.NoError(t, err)
 	require.Len(t, m, 3)
 	assert.Equal(t, &textUnmarshaler{3}, m[textUnmarshaler{1}])
@@ -109,14 +112,14 @@ func TestSetMapTextUnmarshaller(t *testing.T) {
 func TestSetMapInvalidKey(t *testing.T) {
 	var m map[int]int
 	entries := []string{"invalid=123"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	assert.Error(t, err)
 }
 
 func TestSetMapInvalidValue(t *testing.T) {
 	var m map[int]int
 	entries := []string{"123=invalid"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	assert.Error(t, err)
 }
 
@@ -124,7 +127,7 @@ func TestSetMapMalformed(t *testing.T) {
 	// textUnmarshaler is a struct that captures the length of the string passed to it
 	var m map[string]string
 	entries := []string{"missing_equals_sign"}
-	err := setMap(reflect.ValueOf(&m).Elem(), entries, true)
+	err := setMap(reflect.ValueOf(&m).Elem(), entries, true, "")
 	assert.Error(t, err)
 }
 
@@ -135,18 +138,151 @@ func TestSetSliceOrMapErrors(t *testing.T) {
 	// converting a slice to a reflect.Value in this way will make it read only
 	var cannotSet []int
 	dest = reflect.ValueOf(cannotSet)
-	err = setSliceOrMap(dest, nil, false)
+	err = setSliceOrMap(dest, nil, false, "")
 	assert.Error(t, err)
 
 	// check what happens when we pass in something that is not a slice or a map
 	var notSliceOrMap string
 	dest = reflect.ValueOf(&notSliceOrMap).Elem()
-	err = setSliceOrMap(dest, nil, false)
+	err = setSliceOrMap(dest, nil, false, "")
 	assert.Error(t, err)
 
 	// check what happens when we pass in a pointer to something that is not a slice or a map
 	var stringPtr *string
 	dest = reflect.ValueOf(&stringPtr).Elem()
-	err = setSliceOrMap(dest, nil, false)
+	err = setSliceOrMap(dest, nil, false, "")
+	assert.Error(t, err)
+}
+
+func TestSetMapWithSliceValues(t *testing.T) {
+	m := map[string][]int{"a": {1}}
+	err := setMap(reflect.ValueOf(&m).Elem(), []string{"a=2", "b=3", "a=4"}, false, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int{"a": {1, 2, 4}, "b": {3}}, m)
+}
+
+func TestSetMapWithSliceValuesBadElement(t *testing.T) {
+	var m map[string][]int
+	err := setMap(reflect.ValueOf(&m).Elem(), []string{"a=x"}, false, "")
+	assert.Error(t, err)
+}
+
+func splitValuesOrFail(t *testing.T, typ reflect.Type, values []string, sep, kvsep string, quoted bool) []string {
+	out, err := splitValues(typ, values, sep, kvsep, quoted)
+	require.NoError(t, err)
+	return out
+}
+
+func TestSplitValues(t *testing.T) {
+	var s []string
+	var m map[string]string
+	var ms map[string][]string
+	assert.Equal(t, []string{"a,b", "c"}, splitValuesOrFail(t, reflect.TypeOf(s), []string{"a,b", "c"}, "", "", false))
+	assert.Equal(t, []string{"a", "b", "c"}, splitValuesOrFail(t, reflect.TypeOf(s), []string{"a,b", "c"}, ",", "", false))
+	assert.Equal(t, []string{"a=1", "b=2"}, splitValuesOrFail(t, reflect.TypeOf(&m), []string{"a=1;b=2"}, ";", "", false))
+	assert.Equal(t, []string{"k=a", "k=b", "j"}, splitValuesOrFail(t, reflect.TypeOf(ms), []string{"k=a,b", "j"}, ",", "", false))
+
+	// quotes and backslashes are not interpreted unless quoted is set
+	assert.Equal(t, []string{`"a`, `b"`, `c\`, "d"}, splitValuesOrFail(t, reflect.TypeOf(s), []string{`"a,b",c\,d`}, ",", "", false))
+}
+
+func TestSplitValuesQuoted(t *testing.T) {
+	var s []string
+	var m map[string]string
+	var ms map[string][]string
+	assert.Equal(t, []string{"a,b", "c"}, splitValuesOrFail(t, reflect.TypeOf(s), []string{`"a,b",c`}, ",", "", true))
+	assert.Equal(t, []string{"a,b", `c"d`, `e\f`}, splitValuesOrFail(t, reflect.TypeOf(s), []string{`a\,b,c\"d,e\\f`}, ",", "", true))
+	assert.Equal(t, []string{"x=1,2", "y=3"}, splitValuesOrFail(t, reflect.TypeOf(m), []string{`x="1,2",y=3`}, ",", "", true))
+	assert.Equal(t, []string{"k=a,b", "k=c"}, splitValuesOrFail(t, reflect.TypeOf(ms), []string{`k="a,b",c`}, ",", "", true))
+	assert.Equal(t, []string{"a", "", "b c"}, splitValuesOrFail(t, reflect.TypeOf(s), []string{`a,"","b c"`}, ",", "", true))
+}
+
+func TestSplitValuesUnterminatedQuote(t *testing.T) {
+	var s []string
+	_, err := splitValues(reflect.TypeOf(s), []string{`"a,b`}, ",", "", true)
+	assert.EqualError(t, err, `unterminated quote in "\"a,b"`)
+
+	// quotes are not interpreted when there is no separator
+	out := splitValuesOrFail(t, reflect.TypeOf(s), []string{`"a`}, "", "", true)
+	assert.Equal(t, []string{`"a`}, out)
+}
+
+func TestSetMapWithKVSep(t *testing.T) {
+	var m map[string][]int
+	err := setMap(reflect.ValueOf(&m).Elem(), splitValuesOrFail(t, reflect.TypeOf(m), []string{"a:1|2", "b:3"}, "|", ":", false), false, ":")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int{"a": {1, 2}, "b": {3}}, m)
+}
+
+func TestCheckDuplicateKeys(t *testing.T) {
+	var m map[string]int
+	seen := make(map[interface{}]bool)
+	require.NoError(t, checkDuplicateKeys(reflect.TypeOf(m), []string{"a=1", "b=2"}, "", seen))
+	assert.EqualError(t, checkDuplicateKeys(reflect.TypeOf(m), []string{"c=3", "a=4"}, "", seen), `duplicate key "a"`)
+	assert.EqualError(t, checkDuplicateKeys(reflect.TypeOf(&m), []string{"x:1", "x:2"}, ":", make(map[interface{}]bool)), `duplicate key "x"`)
+}
+
+func TestCheckDuplicateKeysAfterParsing(t *testing.T) {
+	var m map[int]string
+	assert.EqualError(t, checkDuplicateKeys(reflect.TypeOf(m), []string{"1=a", "01=b"}, "", make(map[interface{}]bool)), `duplicate key "01"`)
+	assert.NoError(t, checkDuplicateKeys(reflect.TypeOf(m), []string{"x=a", "y=b"}, "", make(map[interface{}]bool)))
+}
+
+type orderedMap struct {
+	keys   []string
+	values map[string]string
+}
+
+func (m *orderedMap) Append(key, value string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	if m.values == nil {
+		m.values = make(map[string]string)
+	}
+	if _, found := m.values[key]; !found {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+	return nil
+}
+
+func TestSetAppender(t *testing.T) {
+	m := orderedMap{keys: []string{"z"}, values: map[string]string{"z": "0"}}
+	err := setSliceOrMap(reflect.ValueOf(&m).Elem(), []string{"b=1", "a=2", "b=3"}, false, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"z", "b", "a"}, m.keys)
+	assert.Equal(t, "3", m.values["b"])
+
+	err = setSliceOrMap(reflect.ValueOf(&m).Elem(), []string{"c:1"}, true, ":")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, m.keys)
+}
+
+func TestSetAppenderPtr(t *testing.T) {
+	var m *orderedMap
+	err := setSliceOrMap(reflect.ValueOf(&m).Elem(), []string{"b=1", "a=2"}, false, "")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, []string{"b", "a"}, m.keys)
+}
+
+func TestSetAppenderErrors(t *testing.T) {
+	var m orderedMap
+	err := setSliceOrMap(reflect.ValueOf(&m).Elem(), []string{"missing"}, false, "")
+	assert.EqualError(t, err, `cannot parse "missing", expected format key=value`)
+
+	err = setSliceOrMap(reflect.ValueOf(&m).Elem(), []string{"=x"}, false, "")
+	assert.EqualError(t, err, `error appending "=x": empty key`)
+}
+
+func TestReadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	require.NoError(t, os.WriteFile(path, []byte("a\r\n  \nb c\n\nd"), 0600))
+	lines, err := readLines(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b c", "d"}, lines)
+
+	_, err = readLines(filepath.Join(t.TempDir(), "missing"))
 	assert.Error(t, err)
 }
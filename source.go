@@ -0,0 +1,85 @@
+package arg
+
+import "fmt"
+
+// Source describes where the value of an argument came from
+type Source int
+
+const (
+	// SourceUnset means that the argument was not set at all
+	SourceUnset Source = iota
+	// SourceDefault means that the argument was set from its default value
+	SourceDefault
+	// SourceEnv means that the argument was set from an environment variable
+	SourceEnv
+	// SourceArg means that the argument was set on the command line
+	SourceArg
+	// SourcePrompt means that the argument was entered by the user in response
+	// to a prompt, as described for the "prompt" tag
+	SourcePrompt
+	// SourceAppended means that values from the command line or an environment
+	// variable were appended to the default value of a slice tagged
+	// default-policy:append. EnvSources reports the environment variable when
+	// the values came from one.
+	SourceAppended
+	// SourceConfigFile means that the argument was set from the config file
+	// named by the option tagged "conffile"
+	SourceConfigFile
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceUnset:
+		return "unset"
+	case SourceDefault:
+		return "default"
+	case SourceEnv:
+		return "env"
+	case SourceArg:
+		return "arg"
+	case SourcePrompt:
+		return "prompt"
+	case SourceAppended:
+		return "appended"
+	case SourceConfigFile:
+		return "config file"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// ValueSources returns where the value of each argument came from during the
+// most recent call to Parse. The keys are the names of the struct fields,
+// with fields of subcommands qualified by the subcommand field, as in
+// "Deploy.Target". Only the arguments of the top-level command and of the
+// subcommands that were selected are included. If no command line arguments
+// have been processed by this parser then it returns nil.
+func (p *Parser) ValueSources() map[string]Source {
+	if p.sources == nil {
+		return nil
+	}
+	out := make(map[string]Source, len(p.sources))
+	for spec, source := range p.sources {
+		out[spec.dest.Name()] = source
+	}
+	return out
+}
+
+// EnvSources returns the name of the environment variable from which each
+// argument was set during the most recent call to Parse, for the arguments
+// whose source is SourceEnv, or SourceAppended if the appended values came
+// from the environment. This identifies which of several environment
+// variables listed in an env option, as in env:NEW_TOKEN|OLD_TOKEN, supplied
+// the value. The keys are the names of the struct fields as described for
+// ValueSources. If no command line arguments have been processed by this
+// parser then it returns nil.
+func (p *Parser) EnvSources() map[string]string {
+	if p.envSources == nil {
+		return nil
+	}
+	out := make(map[string]string, len(p.envSources))
+	for spec, env := range p.envSources {
+		out[spec.dest.Name()] = env
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package arg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSources(t *testing.T) {
+	var args struct {
+		Name    string
+		Level   int    `default:"3"`
+		Token   string `arg:"env"`
+		Input   string `arg:"positional"`
+		Verbose bool
+	}
+	p := parseWithEnv(t, "--name=foo in", []string{"TOKEN=abc"}, &args)
+	assert.Equal(t, map[string]Source{
+		"Name":    SourceArg,
+		"Level":   SourceDefault,
+		"Token":   SourceEnv,
+		"Input":   SourceArg,
+		"Verbose": SourceUnset,
+	}, p.ValueSources())
+}
+
+func TestValueSourcesSubcommand(t *testing.T) {
+	var args struct {
+		Debug  bool
+		Deploy *struct {
+			Target string
+		} `arg:"subcommand"`
+		List *struct {
+			All bool
+		} `arg:"subcommand"`
+	}
+	p := pparse(t, "deploy --target prod", &args)
+	assert.Equal(t, map[string]Source{
+		"Debug":         SourceUnset,
+		"Deploy.Target": SourceArg,
+	}, p.ValueSources())
+}
+
+func TestValueSourcesBeforeParsing(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.Nil(t, p.ValueSources())
+}
+
+func TestSourceString(t *testing.T) {
+	assert.Equal(t, "unset", SourceUnset.String())
+	assert.Equal(t, "default", SourceDefault.String())
+	assert.Equal(t, "env", SourceEnv.String())
+	assert.Equal(t, "arg", SourceArg.String())
+	assert.Equal(t, "prompt", SourcePrompt.String())
+	assert.Equal(t, "appended", SourceAppended.String())
+	assert.Equal(t, "config file", SourceConfigFile.String())
+	assert.Equal(t, "unknown(42)", Source(42).String())
+}
+
+func TestEnvSources(t *testing.T) {
+	var args struct {
+		Token  string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+		Region string `arg:"env:NEW_REGION|OLD_REGION"`
+		Name   string `arg:"env"`
+		Level  int    `arg:"env:LEVEL" default:"3"`
+	}
+	p := parseWithEnv(t, "", []string{"OLD_TOKEN=old", "NEW_REGION=eu", "OLD_REGION=us", "NAME=x"}, &args)
+	assert.Equal(t, "old", args.Token)
+	assert.Equal(t, "eu", args.Region)
+	assert.Equal(t, 3, args.Level)
+	assert.Equal(t, map[string]string{
+		"Token":  "OLD_TOKEN",
+		"Region": "NEW_REGION",
+		"Name":   "NAME",
+	}, p.EnvSources())
+	assert.Equal(t, SourceEnv, p.ValueSources()["Token"])
+}
+
+func TestValueSourcesAppended(t *testing.T) {
+	type searchArgs struct {
+		Paths    []string `arg:"--paths,env,default-policy:append"`
+		Includes []string `arg:"--includes,env,default-policy:append"`
+	}
+	args := searchArgs{Paths: []string{"/usr/lib"}, Includes: []string{"/usr/include"}}
+	p := parseWithEnv(t, "--paths /opt/lib", []string{"INCLUDES=/opt/include"}, &args)
+	assert.Equal(t, map[string]Source{
+		"Paths":    SourceAppended,
+		"Includes": SourceAppended,
+	}, p.ValueSources())
+	assert.Equal(t, map[string]string{"Includes": "INCLUDES"}, p.EnvSources())
+}
+
+func TestValueSourcesNegatable(t *testing.T) {
+	var args struct {
+		Color *bool `arg:"negatable"`
+		Cache *bool `arg:"negatable"`
+	}
+	p := pparse(t, "--no-color", &args)
+	assert.Equal(t, map[string]Source{
+		"Color": SourceArg,
+		"Cache": SourceUnset,
+	}, p.ValueSources())
+	assert.Nil(t, args.Cache)
+}
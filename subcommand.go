@@ -19,19 +19,9 @@ func (p *Parser) SubcommandNames() []string {
 	if p.lastCmd == nil {
 		return nil
 	}
-
-	// make a list of ancestor commands
-	var ancestors []string
-	cur := p.lastCmd
-	for cur.parent != nil { // we want to exclude the root
-		ancestors = append(ancestors, cur.name)
-		cur = cur.parent
-	}
-
-	// reverse the list
-	out := make([]string, len(ancestors))
-	for i := 0; i < len(ancestors); i++ {
-		out[i] = ancestors[len(ancestors)-i-1]
+	out := commandPath(p.lastCmd)
+	if out == nil {
+		out = []string{}
 	}
 	return out
 }
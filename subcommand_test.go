@@ -1,7 +1,9 @@
 package arg
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -383,3 +385,456 @@ func TestValForNilStruct(t *testing.T) {
 	v := p.val(path{fields: []reflect.StructField{subField, subField}})
 	assert.False(t, v.IsValid())
 }
+
+type versionedPlugin struct {
+	Name string
+}
+
+func (versionedPlugin) Version() string {
+	return "plugin 0.1.0"
+}
+
+func TestSubcommandVersion(t *testing.T) {
+	var args struct {
+		versioned
+		Plugin *versionedPlugin `arg:"subcommand"`
+		Other  *struct{}        `arg:"subcommand"`
+	}
+
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	err = p.Parse([]string{"plugin", "--version"})
+	assert.Equal(t, ErrVersion, err)
+	assert.Equal(t, "plugin 0.1.0", p.versionFor(p.lastCmd))
+
+	p.Reset()
+	err = p.Parse([]string{"other", "--version"})
+	assert.Equal(t, ErrVersion, err)
+	assert.Equal(t, "example 3.2.1", p.versionFor(p.lastCmd))
+}
+
+func TestSubcommandVersionWithoutProgramVersion(t *testing.T) {
+	var args struct {
+		Plugin *versionedPlugin `arg:"subcommand"`
+	}
+
+	var stdout bytes.Buffer
+	var exitCode int
+	p, err := NewParser(Config{Out: &stdout, Exit: func(code int) { exitCode = code }}, &args)
+	require.NoError(t, err)
+
+	err = p.Parse([]string{"--version"})
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrVersion, err)
+
+	stdout.Reset()
+	p.Reset()
+	p.MustParse([]string{"plugin", "--version"})
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, "plugin 0.1.0\n", stdout.String())
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "plugin"))
+	assert.True(t, strings.HasPrefix(help.String(), "plugin 0.1.0\n"))
+	assert.Contains(t, help.String(), "--version              display version and exit")
+}
+
+func TestSubcommandRequiredPositional(t *testing.T) {
+	var args struct {
+		Deploy *struct {
+			Target string `arg:"positional,required"`
+			Region string `arg:"positional"`
+		} `arg:"subcommand"`
+	}
+	_, err := parseWithEnvErr(t, "deploy", nil, &args)
+	assert.EqualError(t, err, "subcommand 'deploy' requires positional TARGET")
+
+	p := pparse(t, "deploy prod", &args)
+	assert.Equal(t, "prod", args.Deploy.Target)
+	assert.Equal(t, "", args.Deploy.Region)
+	assert.Equal(t, []string{"deploy"}, p.SubcommandNames())
+}
+
+func TestNestedSubcommandRequiredPositional(t *testing.T) {
+	var args struct {
+		Remote *struct {
+			Add *struct {
+				Name string `arg:"positional,required"`
+				URL  string `arg:"positional,required"`
+			} `arg:"subcommand"`
+		} `arg:"subcommand"`
+	}
+	_, err := parseWithEnvErr(t, "remote add", nil, &args)
+	assert.EqualError(t, err, "subcommand 'remote add' requires positional NAME")
+
+	_, err = parseWithEnvErr(t, "remote add origin", nil, &args)
+	assert.EqualError(t, err, "subcommand 'remote add' requires positional URL")
+
+	parse(t, "remote add origin https://example.com", &args)
+	assert.Equal(t, "origin", args.Remote.Add.Name)
+	assert.Equal(t, "https://example.com", args.Remote.Add.URL)
+}
+
+func TestSuggestSubcommands(t *testing.T) {
+	var args struct {
+		Deploy *struct{} `arg:"subcommand"`
+		Delete *struct{} `arg:"subcommand"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{SuggestSubcommands: true}, "depoly", nil, &args)
+	assert.EqualError(t, err, "invalid subcommand: depoly (did you mean deploy?)")
+
+	_, err = parseWithConfigEnvErr(t, Config{SuggestSubcommands: true}, "status", nil, &args)
+	assert.EqualError(t, err, "invalid subcommand: status")
+
+	_, err = parseWithConfigEnvErr(t, Config{}, "depoly", nil, &args)
+	assert.EqualError(t, err, "invalid subcommand: depoly")
+}
+
+func TestHiddenSubcommand(t *testing.T) {
+	type beta struct {
+		Fast bool
+	}
+	type list struct{}
+	var args struct {
+		Debug bool  `arg:"hidden"`
+		Beta  *beta `arg:"subcommand:beta,hidden"`
+		List  *list `arg:"subcommand" help:"list things"`
+	}
+	p := pparse(t, "beta --fast --debug", &args)
+	require.NotNil(t, args.Beta)
+	assert.True(t, args.Beta.Fast)
+	assert.True(t, args.Debug)
+	assert.Equal(t, []string{"beta"}, p.SubcommandNames())
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help))
+	assert.NotContains(t, help.String(), "beta")
+	assert.NotContains(t, help.String(), "debug")
+	assert.Contains(t, help.String(), "list things")
+}
+
+func TestHiddenSubcommandNotSuggested(t *testing.T) {
+	var args struct {
+		Beta *struct{} `arg:"subcommand:beta,hidden"`
+		Bet  *struct{} `arg:"subcommand:bet"`
+	}
+	p, err := NewParser(Config{SuggestSubcommands: true}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"betaa"})
+	assert.EqualError(t, err, "invalid subcommand: betaa (did you mean bet?)")
+}
+
+func TestOnlyHiddenSubcommands(t *testing.T) {
+	var args struct {
+		Beta *struct{} `arg:"subcommand:beta,hidden"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, "Usage: example\n", usage.String())
+}
+
+func TestDuplicateSubcommandNames(t *testing.T) {
+	var args struct {
+		Beta  *struct{} `arg:"subcommand:beta,hidden"`
+		Other *struct{} `arg:"subcommand:beta"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args: subcommands Beta and Other have the same name beta")
+}
+
+func TestPassthroughSubcommand(t *testing.T) {
+	type execCmd struct {
+		Args []string
+	}
+	var args struct {
+		Verbose bool
+		Exec    *execCmd `arg:"subcommand:exec,passthrough"`
+	}
+	p := pparse(t, "--verbose exec ls -la --help -- --verbose", &args)
+	assert.True(t, args.Verbose)
+	require.NotNil(t, args.Exec)
+	assert.Equal(t, []string{"ls", "-la", "--help", "--", "--verbose"}, args.Exec.Args)
+	assert.Equal(t, SourceArg, p.ValueSources()["Exec.Args"])
+
+	args.Exec = nil
+	parse(t, "exec", &args)
+	require.NotNil(t, args.Exec)
+	assert.NotNil(t, args.Exec.Args)
+	assert.Empty(t, args.Exec.Args)
+
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	var usage bytes.Buffer
+	require.NoError(t, p.WriteUsageForSubcommand(&usage, "exec"))
+	assert.Equal(t, "Usage: example exec [ARGS [ARGS ...]]\n", usage.String())
+}
+
+func TestPassthroughSubcommandErrors(t *testing.T) {
+	var none struct {
+		Exec *struct {
+			Name string
+		} `arg:"subcommand:exec,passthrough"`
+	}
+	_, err := NewParser(Config{}, &none)
+	assert.EqualError(t, err, ".Exec: passthrough subcommands must have exactly one []string field but exec has none")
+
+	var two struct {
+		Exec *struct {
+			A []string
+			B []string
+		} `arg:"subcommand:exec,passthrough"`
+	}
+	_, err = NewParser(Config{}, &two)
+	assert.EqualError(t, err, ".Exec: passthrough subcommands must have exactly one []string field but exec has A and B")
+
+	var notSlice struct {
+		Args string `arg:"passthrough"`
+	}
+	_, err = NewParser(Config{}, &notSlice)
+	assert.EqualError(t, err, ".Args: passthrough can only be used with subcommands and []string fields that are not positional")
+}
+
+func TestSubcommandCollectUnknown(t *testing.T) {
+	type runCmd struct {
+		Image   string `arg:"positional"`
+		Detach  bool   `arg:"-d"`
+		Unknown []string
+	}
+	var args struct {
+		Verbose bool
+		Run     *runCmd `arg:"subcommand:run,collectunknown"`
+	}
+	p := pparse(t, "run -d --memory=4g ubuntu --cpus -x --verbose", &args)
+	require.NotNil(t, args.Run)
+	assert.True(t, args.Run.Detach)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, "ubuntu", args.Run.Image)
+	assert.Equal(t, []string{"--memory=4g", "--cpus", "-x"}, args.Run.Unknown)
+
+	// the collecting field is not an option, so its name is collected too
+	parse(t, "run --unknown a", &args)
+	assert.Equal(t, []string{"--unknown"}, args.Run.Unknown)
+	assert.Equal(t, "a", args.Run.Image)
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "run"))
+	assert.NotContains(t, help.String(), "unknown")
+}
+
+func TestSubcommandCollectUnknownNamedField(t *testing.T) {
+	var args struct {
+		Proxy *struct {
+			Extra []string
+		} `arg:"subcommand,collectunknown:Extra"`
+	}
+	parse(t, "proxy --a=1 --b", &args)
+	assert.Equal(t, []string{"--a=1", "--b"}, args.Proxy.Extra)
+
+	// unknown options are still an error outside the subcommand
+	_, err := parseWithEnvErr(t, "--a proxy", nil, &args)
+	assert.EqualError(t, err, "unknown argument --a")
+}
+
+func TestSubcommandCollectUnknownInvalid(t *testing.T) {
+	var missing struct {
+		Run *struct{} `arg:"subcommand,collectunknown"`
+	}
+	_, err := NewParser(Config{}, &missing)
+	assert.EqualError(t, err, ".Run: collectunknown requires a []string field named Unknown in subcommand run")
+
+	var wrongType struct {
+		Run *struct {
+			Unknown string
+		} `arg:"subcommand,collectunknown"`
+	}
+	_, err = NewParser(Config{}, &wrongType)
+	assert.EqualError(t, err, ".Run: collectunknown requires Unknown to be a []string field that is not positional")
+
+	var notSubcommand struct {
+		Extra []string `arg:"collectunknown"`
+	}
+	_, err = NewParser(Config{}, &notSubcommand)
+	assert.EqualError(t, err, ".Extra: collectunknown can only be used with subcommands")
+}
+
+func TestInheritFromParent(t *testing.T) {
+	type runCmd struct {
+		LogLevel string `arg:"--log-level,inherit" default:"warn"`
+	}
+	var args struct {
+		LogLevel string  `arg:"--log-level"`
+		Run      *runCmd `arg:"subcommand"`
+	}
+	p := pparse(t, "--log-level debug run", &args)
+	require.NotNil(t, args.Run)
+	assert.Equal(t, "debug", args.Run.LogLevel)
+	assert.Equal(t, SourceArg, p.ValueSources()["Run.LogLevel"])
+}
+
+func TestInheritParentUnset(t *testing.T) {
+	type runCmd struct {
+		LogLevel string `arg:"--log-level,inherit" default:"warn"`
+	}
+	var args struct {
+		LogLevel string  `arg:"--log-level"`
+		Run      *runCmd `arg:"subcommand"`
+	}
+	parse(t, "run", &args)
+	require.NotNil(t, args.Run)
+	assert.Equal(t, "warn", args.Run.LogLevel)
+}
+
+func TestInheritParentDefault(t *testing.T) {
+	type runCmd struct {
+		LogLevel string `arg:"--log-level,inherit,required"`
+	}
+	var args struct {
+		LogLevel string  `arg:"--log-level" default:"info"`
+		Run      *runCmd `arg:"subcommand"`
+	}
+	parse(t, "run", &args)
+	require.NotNil(t, args.Run)
+	assert.Equal(t, "info", args.Run.LogLevel)
+}
+
+func TestInheritFromGrandparent(t *testing.T) {
+	type leafCmd struct {
+		Level int `arg:"inherit"`
+	}
+	type midCmd struct {
+		Leaf *leafCmd `arg:"subcommand"`
+	}
+	var args struct {
+		Level int     `arg:"env"`
+		Mid   *midCmd `arg:"subcommand"`
+	}
+	parseWithEnv(t, "mid leaf", []string{"LEVEL=3"}, &args)
+	require.NotNil(t, args.Mid)
+	require.NotNil(t, args.Mid.Leaf)
+	assert.Equal(t, 3, args.Mid.Leaf.Level)
+}
+
+func TestInheritMissingParentOption(t *testing.T) {
+	type runCmd struct {
+		LogLevel string `arg:"inherit"`
+	}
+	var args struct {
+		Run *runCmd `arg:"subcommand"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args.Run.LogLevel: inherit requires an option --loglevel in a parent command")
+}
+
+func TestInheritTypeMismatch(t *testing.T) {
+	type runCmd struct {
+		Level int `arg:"inherit"`
+	}
+	var args struct {
+		Level string
+		Run   *runCmd `arg:"subcommand"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args.Run.Level: cannot inherit --level because it is string in the parent command but int here")
+}
+
+func TestNoHelpSubcommand(t *testing.T) {
+	type execCmd struct {
+		Help bool     `arg:"-h,--help"`
+		Args []string `arg:"positional"`
+	}
+	var args struct {
+		Exec *execCmd `arg:"subcommand:exec,nohelp"`
+	}
+	p := pparse(t, "exec --help ls", &args)
+	require.NotNil(t, args.Exec)
+	assert.True(t, args.Exec.Help)
+	assert.Equal(t, []string{"ls"}, args.Exec.Args)
+	assert.Empty(t, p.Warnings())
+
+	// help before the subcommand is still handled by the library
+	p.Reset()
+	assert.Equal(t, ErrHelp, p.Parse([]string{"--help", "exec"}))
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "exec"))
+	assert.NotContains(t, help.String(), "display this help and exit")
+}
+
+func TestNoHelpPassthrough(t *testing.T) {
+	type execCmd struct {
+		Args []string
+	}
+	var args struct {
+		Exec *execCmd `arg:"subcommand:exec,nohelp,passthrough"`
+	}
+	p := pparse(t, "exec -h", &args)
+	require.NotNil(t, args.Exec)
+	assert.Equal(t, []string{"-h"}, args.Exec.Args)
+	assert.Empty(t, p.Warnings())
+}
+
+func TestNoHelpDoesNotSupersedeErrors(t *testing.T) {
+	var args struct {
+		Exec *struct{} `arg:"subcommand:exec,nohelp"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"subcommand exec is tagged nohelp but has no -h or --help option, so it cannot display help"}, p.Warnings())
+
+	err = p.Parse([]string{"exec", "--help"})
+	assert.EqualError(t, err, "unknown argument --help")
+	assert.Equal(t, []string{"subcommand exec is tagged nohelp but has no -h or --help option, so it cannot display help"}, p.Warnings())
+}
+
+func TestNoHelpOnlyForSubcommands(t *testing.T) {
+	var args struct {
+		Name string `arg:"nohelp"`
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, ".Name: nohelp can only be used with subcommands")
+}
+
+func TestOnlySelectedSubcommandsAllocated(t *testing.T) {
+	type leaf struct {
+		Force bool   `arg:"env:FORCE"`
+		Name  string `default:"x"`
+	}
+	type branch struct {
+		Apply *leaf `arg:"subcommand"`
+		Plan  *leaf `arg:"subcommand"`
+	}
+	type root struct {
+		Deploy *branch `arg:"subcommand"`
+		Status *leaf   `arg:"subcommand"`
+	}
+
+	t.Setenv("FORCE", "true")
+	var args root
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"deploy", "plan"}))
+	require.NotNil(t, args.Deploy)
+	require.NotNil(t, args.Deploy.Plan)
+	assert.True(t, args.Deploy.Plan.Force)
+	assert.Nil(t, args.Deploy.Apply)
+	assert.Nil(t, args.Status)
+
+	// inspecting the parser does not allocate the other subcommands
+	_ = p.ValueSources()
+	require.NoError(t, p.ReloadEnv())
+	require.NoError(t, p.WriteHelpForSubcommand(&bytes.Buffer{}, "status"))
+	require.NoError(t, p.WriteHelpForSubcommand(&bytes.Buffer{}, "deploy", "apply"))
+	assert.Nil(t, args.Deploy.Apply)
+	assert.Nil(t, args.Status)
+
+	// selecting another chain after Reset clears the previous one
+	p.Reset()
+	require.NoError(t, p.Parse([]string{"status"}))
+	assert.Nil(t, args.Deploy)
+	require.NotNil(t, args.Status)
+	assert.Equal(t, "x", args.Status.Name)
+}
@@ -0,0 +1,70 @@
+package arg
+
+import "sort"
+
+// maxSuggestionDistance is the largest edit distance at which a known name is
+// suggested as a replacement for a name that was not recognized
+const maxSuggestionDistance = 2
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggest returns up to max candidates that are within a small edit distance
+// of the given name, closest first. Candidates at the same distance are
+// returned in the order given.
+func suggest(name string, candidates []string, max int) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate == "" || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		d := levenshtein(name, candidate)
+		if d <= maxSuggestionDistance && d < len([]rune(candidate)) {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	var out []string
+	for i := 0; i < len(matches) && i < max; i++ {
+		out = append(out, matches[i].name)
+	}
+	return out
+}
@@ -0,0 +1,25 @@
+package arg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("", ""))
+	assert.Equal(t, 3, levenshtein("", "abc"))
+	assert.Equal(t, 3, levenshtein("abc", ""))
+	assert.Equal(t, 0, levenshtein("deploy", "deploy"))
+	assert.Equal(t, 2, levenshtein("deploy", "deplyo"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"deploy", "delete", "list", "status"}
+	assert.Equal(t, []string{"deploy"}, suggest("depoly", candidates, 1))
+	assert.Equal(t, []string{"list"}, suggest("lsit", candidates, 2))
+	assert.Nil(t, suggest("xyz", candidates, 2))
+	assert.Equal(t, []string{"ab", "ac"}, suggest("a", []string{"ab", "ac", "ab"}, 2))
+	assert.Nil(t, suggest("x", []string{"y"}, 1))
+}
@@ -0,0 +1,151 @@
+package arg
+
+import (
+	"fmt"
+	"strings"
+	templateparse "text/template/parse"
+)
+
+// resolveTemplates finds, for each option of the command with a template
+// default, the options with template defaults to which the template refers,
+// and returns an error if some of them refer to each other in a cycle
+func resolveTemplates(cmd *command) error {
+	for _, s := range cmd.specs {
+		if s.tmpl == nil {
+			continue
+		}
+		names := make(map[string]bool)
+		templateFields(s.tmpl.Tree.Root, names)
+		for _, other := range cmd.specs {
+			if other.tmpl != nil && names[other.field.Name] {
+				s.templateDeps = append(s.templateDeps, other)
+			}
+		}
+	}
+
+	// report the first cycle found by a depth-first search
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*spec]int)
+	var stack []string
+	var visit func(s *spec) error
+	visit = func(s *spec) error {
+		switch state[s] {
+		case visiting:
+			start := 0
+			for stack[start] != s.field.Name {
+				start++
+			}
+			cycle := append(append([]string{}, stack[start:]...), s.field.Name)
+			return fmt.Errorf("%s: template defaults refer to each other in a cycle: %s",
+				s.dest, strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[s] = visiting
+		stack = append(stack, s.field.Name)
+		for _, dep := range s.templateDeps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[s] = visited
+		return nil
+	}
+	for _, s := range cmd.specs {
+		if s.tmpl != nil {
+			if err := visit(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// templateFields adds to names the first field of each reference to a field
+// of dot in the given template node, such as DataDir for {{.DataDir}}. Inside
+// range and with, dot refers to something else, but such references are
+// included too, so that a cycle may be reported where there is none, but no
+// cycle is missed.
+func templateFields(node templateparse.Node, names map[string]bool) {
+	switch node := node.(type) {
+	case *templateparse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			templateFields(n, names)
+		}
+	case *templateparse.ActionNode:
+		templateFields(node.Pipe, names)
+	case *templateparse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			templateFields(cmd, names)
+		}
+	case *templateparse.CommandNode:
+		for _, arg := range node.Args {
+			templateFields(arg, names)
+		}
+	case *templateparse.FieldNode:
+		names[node.Ident[0]] = true
+	case *templateparse.ChainNode:
+		templateFields(node.Node, names)
+	case *templateparse.IfNode:
+		templateFields(&node.BranchNode, names)
+	case *templateparse.RangeNode:
+		templateFields(&node.BranchNode, names)
+	case *templateparse.WithNode:
+		templateFields(&node.BranchNode, names)
+	case *templateparse.BranchNode:
+		templateFields(node.Pipe, names)
+		templateFields(node.List, names)
+		templateFields(node.ElseList, names)
+	case *templateparse.TemplateNode:
+		templateFields(node.Pipe, names)
+	}
+}
+
+// renderTemplates sets each of the given specs to its template default,
+// rendered with the struct that contains it. A spec whose template refers to
+// another of the specs is rendered after that one.
+func (p *Parser) renderTemplates(specs []*spec) error {
+	pending := make(map[*spec]bool)
+	for _, spec := range specs {
+		pending[spec] = true
+	}
+	var render func(spec *spec) error
+	render = func(spec *spec) error {
+		delete(pending, spec)
+		for _, dep := range spec.templateDeps {
+			if pending[dep] {
+				if err := render(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		var b strings.Builder
+		if err := spec.tmpl.Execute(&b, p.val(spec.owner).Interface()); err != nil {
+			return fmt.Errorf("error processing default value for %s: %v", argName(spec), err)
+		}
+		if err := spec.parse(p.val(spec.dest), b.String()); err != nil {
+			return fmt.Errorf("error processing default value for %s: %v", argName(spec), err)
+		}
+		p.sources[spec] = SourceDefault
+		return nil
+	}
+	for _, spec := range specs {
+		if pending[spec] {
+			if err := render(spec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
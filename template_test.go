@@ -0,0 +1,143 @@
+package arg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateDefault(t *testing.T) {
+	type argsType struct {
+		DataDir string `default:"/var/lib/app"`
+		LogFile string `arg:"--log-file,template" default:"{{.DataDir}}/app.log"`
+	}
+
+	var args argsType
+	p := pparse(t, "", &args)
+	assert.Equal(t, "/var/lib/app/app.log", args.LogFile)
+	assert.Equal(t, SourceDefault, p.ValueSources()["LogFile"])
+
+	args = argsType{}
+	parse(t, "--datadir /tmp", &args)
+	assert.Equal(t, "/tmp/app.log", args.LogFile)
+
+	args = argsType{}
+	parse(t, "--datadir /tmp --log-file other.log", &args)
+	assert.Equal(t, "other.log", args.LogFile)
+}
+
+func TestTemplateDefaultChain(t *testing.T) {
+	var args struct {
+		Archive  string `arg:"template" default:"{{.CacheDir}}/archive"`
+		Home     string
+		Port     int    `arg:"template" default:"{{len .Home}}"`
+		CacheDir string `arg:"template" default:"{{.Home}}/.cache"`
+	}
+	parse(t, "--home /home/me", &args)
+	assert.Equal(t, "/home/me/.cache", args.CacheDir)
+	assert.Equal(t, "/home/me/.cache/archive", args.Archive)
+	assert.Equal(t, 8, args.Port)
+}
+
+func TestTemplateDefaultSubcommand(t *testing.T) {
+	var args struct {
+		Build *struct {
+			Name   string `default:"app"`
+			Output string `arg:"template" default:"bin/{{.Name}}"`
+		} `arg:"subcommand"`
+	}
+	parse(t, "build --name tool", &args)
+	require.NotNil(t, args.Build)
+	assert.Equal(t, "bin/tool", args.Build.Output)
+}
+
+func TestTemplateDefaultIgnoreDefault(t *testing.T) {
+	var args struct {
+		Name   string `default:"app"`
+		Output string `arg:"template" default:"bin/{{.Name}}"`
+	}
+	_, err := parseWithConfigEnvErr(t, Config{IgnoreDefault: true}, "", nil, &args)
+	require.NoError(t, err)
+	assert.Equal(t, "", args.Output)
+}
+
+func TestTemplateDefaultExecutionError(t *testing.T) {
+	var args struct {
+		LogFile string `arg:"--log-file,template" default:"{{.Missing}}/app.log"`
+	}
+	_, err := parseWithEnvErr(t, "", nil, &args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing default value for --log-file: template: LogFile:")
+	assert.Contains(t, err.Error(), "Missing")
+
+	var notInt struct {
+		Name string `default:"x"`
+		Port int    `arg:"template" default:"{{.Name}}"`
+	}
+	_, err = parseWithEnvErr(t, "", nil, &notInt)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error processing default value for --port: ")
+}
+
+func TestTemplateDefaultCycle(t *testing.T) {
+	var args struct {
+		A string `arg:"template" default:"{{.B}}"`
+		B string `arg:"template" default:"{{if .C}}{{.A}}{{end}}"`
+		C string
+	}
+	_, err := NewParser(Config{}, &args)
+	assert.EqualError(t, err, "args.A: template defaults refer to each other in a cycle: A -> B -> A")
+
+	var self struct {
+		A string `arg:"template" default:"x{{.A}}"`
+	}
+	_, err = NewParser(Config{}, &self)
+	assert.EqualError(t, err, "args.A: template defaults refer to each other in a cycle: A -> A")
+}
+
+func TestTemplateDefaultInvalid(t *testing.T) {
+	var noDefault struct {
+		A string `arg:"template"`
+	}
+	_, err := NewParser(Config{}, &noDefault)
+	assert.EqualError(t, err, `.A: template requires a default value, as in default:"{{.Dir}}/app.log"`)
+
+	var badTemplate struct {
+		A string `arg:"template" default:"{{.B"`
+	}
+	_, err = NewParser(Config{}, &badTemplate)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ".A: error parsing template default: ")
+}
+
+func TestTemplateDefaultHelp(t *testing.T) {
+	var args struct {
+		LogFile string `arg:"--log-file,template" default:"{{.DataDir}}/app.log"`
+		DataDir string
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "[default: (computed)]")
+	assert.NotContains(t, help.String(), "{{")
+}
+
+func TestTemplateDefaultCountsAsDefault(t *testing.T) {
+	var args struct {
+		Cert string `arg:"required-if-env:IN_CLUSTER,template" default:"{{.Dir}}/tls.crt"`
+		Dir  string `default:"/etc"`
+	}
+	parseWithEnv(t, "", []string{"IN_CLUSTER=1"}, &args)
+	assert.Equal(t, "/etc/tls.crt", args.Cert)
+
+	var positionals struct {
+		Output string   `arg:"positional,template" default:"{{.Input}}.out"`
+		Rest   []string `arg:"positional"`
+		Input  string
+	}
+	parse(t, "--input a", &positionals)
+	assert.Equal(t, "a.out", positionals.Output)
+}
@@ -0,0 +1,101 @@
+package arg
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unitPowers maps the first letter of a unit suffix, in upper case, to the
+// power of the base by which it multiplies
+var unitPowers = map[string]int{"K": 1, "M": 2, "G": 3, "T": 4, "P": 5, "E": 6}
+
+// convertUnits converts a number with an optional unit suffix, such as "1.5M",
+// to a plain integer string in base units. The unit is "si", in which a suffix
+// such as "M" or "MB" multiplies by a power of 1000, or "iec", in which a
+// suffix such as "M" or "MiB" multiplies by a power of 1024. A suffix that
+// belongs to the other system, such as "MiB" for si, is an error, as is "MB"
+// for iec since it is commonly used for both.
+func convertUnits(s, unit string) (string, error) {
+	num := strings.TrimRightFunc(s, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	})
+	suffix := s[len(num):]
+	if suffix == "" {
+		return s, nil
+	}
+
+	prefix, rest := strings.ToUpper(suffix[:1]), suffix[1:]
+	power, ok := unitPowers[prefix]
+	switch {
+	case suffix == "B":
+		power, rest = 0, ""
+	case !ok:
+		return "", fmt.Errorf("unknown unit suffix %q", suffix)
+	case rest == "" || (rest == "B" && unit == "si") || (rest == "iB" && unit == "iec"):
+	case rest == "B":
+		return "", fmt.Errorf("ambiguous unit suffix %q, use %s or %siB", suffix, prefix, prefix)
+	case rest == "iB":
+		return "", fmt.Errorf("unit suffix %q is not an SI unit, use %s or %sB", suffix, prefix, prefix)
+	default:
+		return "", fmt.Errorf("unknown unit suffix %q", suffix)
+	}
+
+	base := int64(1000)
+	if unit == "iec" {
+		base = 1024
+	}
+
+	n, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return "", fmt.Errorf("invalid number %q", num)
+	}
+	multiplier := new(big.Int).Exp(big.NewInt(base), big.NewInt(int64(power)), nil)
+	n.Mul(n, new(big.Rat).SetInt(multiplier))
+	if !n.IsInt() {
+		return "", fmt.Errorf("%s is not a whole number of base units", s)
+	}
+	return n.Num().String(), nil
+}
+
+// convertClock converts a duration of the form m:s or h:m:s, such as "1:30:00",
+// to a string that time.ParseDuration accepts, such as "1h30m0s". Minutes and
+// seconds must be less than 60 when preceded by a larger component, and the
+// seconds may have a fractional part. Values that do not contain a colon are
+// returned unchanged so that standard durations are still accepted.
+func convertClock(s string) (string, error) {
+	if !strings.Contains(s, ":") {
+		return s, nil
+	}
+	invalid := fmt.Errorf("invalid clock duration %q, expected m:ss, h:mm:ss, or a duration such as 1h30m", s)
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return "", invalid
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 || seconds >= 60 || strings.ContainsAny(parts[len(parts)-1], "+-eE") {
+		return "", invalid
+	}
+	var hours, minutes int
+	for i, part := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || strings.HasPrefix(part, "+") {
+			return "", invalid
+		}
+		isMinutes := i == len(parts)-2
+		if isMinutes {
+			if len(parts) == 3 && n >= 60 {
+				return "", invalid
+			}
+			minutes = n
+		} else {
+			hours = n
+		}
+	}
+
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	return d.String(), nil
+}
@@ -0,0 +1,73 @@
+package arg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertUnits(t *testing.T) {
+	cases := []struct {
+		in, unit, out string
+	}{
+		{"123", "si", "123"},
+		{"123B", "iec", "123"},
+		{"4k", "si", "4000"},
+		{"4K", "iec", "4096"},
+		{"4KB", "si", "4000"},
+		{"4KiB", "iec", "4096"},
+		{"1.5M", "si", "1500000"},
+		{"1.5M", "iec", "1572864"},
+		{"2G", "iec", "2147483648"},
+		{"-3T", "si", "-3000000000000"},
+	}
+	for _, c := range cases {
+		out, err := convertUnits(c.in, c.unit)
+		require.NoError(t, err, c.in)
+		assert.Equal(t, c.out, out, c.in)
+	}
+}
+
+func TestConvertUnitsErrors(t *testing.T) {
+	_, err := convertUnits("4MB", "iec")
+	assert.EqualError(t, err, `ambiguous unit suffix "MB", use M or MiB`)
+
+	_, err = convertUnits("4MiB", "si")
+	assert.EqualError(t, err, `unit suffix "MiB" is not an SI unit, use M or MB`)
+
+	_, err = convertUnits("4X", "si")
+	assert.EqualError(t, err, `unknown unit suffix "X"`)
+
+	_, err = convertUnits("4Mb", "si")
+	assert.EqualError(t, err, `unknown unit suffix "Mb"`)
+
+	_, err = convertUnits("1.0001K", "si")
+	assert.EqualError(t, err, "1.0001K is not a whole number of base units")
+
+	_, err = convertUnits("abcM", "si")
+	assert.Error(t, err)
+}
+
+func TestConvertClock(t *testing.T) {
+	cases := map[string]string{
+		"1:30:00": "1h30m0s",
+		"0:05":    "5s",
+		"90:00":   "1h30m0s",
+		"1:02:03": "1h2m3s",
+		"2:30.5":  "2m30.5s",
+		"1h30m":   "1h30m",
+	}
+	for in, out := range cases {
+		actual, err := convertClock(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, out, actual, in)
+	}
+}
+
+func TestConvertClockErrors(t *testing.T) {
+	for _, in := range []string{"1:60", "1:60:00", "1:2:3:4", "a:00", "1:-5", "-1:00", ":30", "1:"} {
+		_, err := convertClock(in)
+		assert.EqualError(t, err, `invalid clock duration "`+in+`", expected m:ss, h:mm:ss, or a duration such as 1h30m`, in)
+	}
+}
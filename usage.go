@@ -1,8 +1,14 @@
 package arg
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -31,11 +37,73 @@ func (p *Parser) FailSubcommand(msg string, subcommand ...string) error {
 
 // failWithSubcommand prints usage information for the given subcommand to stderr and exits with non-zero status
 func (p *Parser) failWithSubcommand(msg string, cmd *command) {
-	p.writeUsageForSubcommand(p.config.Out, cmd)
-	_, _ = fmt.Fprintln(p.config.Out, "error:", msg)
+	p.failWithError(errors.New(msg), cmd)
+}
+
+// failWithError reports the given error in the format given by
+// Config.ErrorFormat and exits with non-zero status
+func (p *Parser) failWithError(err error, cmd *command) {
+	if p.config.ErrorFormat == ErrorFormatJSON {
+		b, _ := json.Marshal(newJSONError(err, cmd))
+		_, _ = fmt.Fprintln(p.config.ErrorDestination, string(b))
+	} else {
+		_, _ = io.WriteString(p.config.ErrorDestination, p.errorUsage(cmd))
+		_, _ = fmt.Fprintln(p.config.ErrorDestination, "error:", err.Error())
+	}
 	p.config.Exit(-1)
 }
 
+// JSONError is the object that is written for an error when Config.ErrorFormat
+// is ErrorFormatJSON. Type is the name of the type of the error, which is
+// ValueError, RequiredError, or UnknownArgError for the errors of those types,
+// ErrArgsTooLong for errors wrapping it, and Error for all other errors. The
+// fields other than Type and Message are omitted when they do not apply.
+type JSONError struct {
+	Type        string   `json:"type"`                  // the kind of error, as described above
+	Message     string   `json:"message"`               // the message of the error, as in the text format
+	Field       string   `json:"field,omitempty"`       // the name of the field involved, as used by ValueSources
+	Token       string   `json:"token,omitempty"`       // the argument involved, as reported by the Arg field of the error
+	Env         string   `json:"env,omitempty"`         // the environment variable of a missing option
+	Suggestions []string `json:"suggestions,omitempty"` // known options similar to an unknown one
+	Command     []string `json:"command,omitempty"`     // the subcommands that were selected, if any
+}
+
+// newJSONError describes the given error, which occurred in the given
+// subcommand, as a JSONError
+func newJSONError(err error, cmd *command) JSONError {
+	out := JSONError{Type: "Error", Message: err.Error(), Command: commandPath(cmd)}
+	var valueErr *ValueError
+	var requiredErr *RequiredError
+	var unknownErr *UnknownArgError
+	switch {
+	case errors.As(err, &valueErr):
+		out.Type, out.Field, out.Token = "ValueError", valueErr.Field, valueErr.Arg
+	case errors.As(err, &requiredErr):
+		out.Type, out.Field, out.Token, out.Env = "RequiredError", requiredErr.Field, requiredErr.Arg, requiredErr.Env
+	case errors.As(err, &unknownErr):
+		out.Type, out.Token, out.Suggestions = "UnknownArgError", unknownErr.Arg, unknownErr.Suggestions
+	case errors.Is(err, ErrArgsTooLong):
+		out.Type = "ErrArgsTooLong"
+	}
+	return out
+}
+
+// errorUsage returns the text that accompanies an error in the given
+// subcommand, which is the usage text or, if Config.HelpOnError is set, the
+// full help text
+func (p *Parser) errorUsage(cmd *command) string {
+	if cmd == nil {
+		cmd = p.cmd
+	}
+	var b strings.Builder
+	if p.config.HelpOnError {
+		p.writeHelpForSubcommand(&b, cmd, false)
+	} else {
+		p.writeUsageForSubcommand(&b, cmd)
+	}
+	return b.String()
+}
+
 // WriteUsage writes usage information to the given writer
 func (p *Parser) WriteUsage(w io.Writer) {
 	cmd := p.cmd
@@ -61,9 +129,22 @@ func (p *Parser) WriteUsageForSubcommand(w io.Writer, subcommand ...string) erro
 
 // writeUsageForSubcommand writes usage information for the given subcommand
 func (p *Parser) writeUsageForSubcommand(w io.Writer, cmd *command) {
+	if version := p.versionFor(cmd); version != "" {
+		_, _ = fmt.Fprintln(w, version)
+	}
+	_, _ = fmt.Fprint(w, labelOr(p.config.Labels.Usage, "Usage")+":")
+	p.writeCommandSynopsis(w, cmd)
+	_, _ = fmt.Fprint(w, "\n")
+}
+
+// writeCommandSynopsis writes the names of the given command and its ancestors
+// followed by its options and positionals, as in the usage string, with each
+// item preceded by a space
+func (p *Parser) writeCommandSynopsis(w io.Writer, cmd *command) {
 	var positionals, longOptions, shortOptions []*spec
 	for _, spec := range cmd.specs {
 		switch {
+		case p.isHidden(spec):
 		case spec.positional:
 			positionals = append(positionals, spec)
 		case spec.long != "":
@@ -73,10 +154,6 @@ func (p *Parser) writeUsageForSubcommand(w io.Writer, cmd *command) {
 		}
 	}
 
-	if p.version != "" {
-		_, _ = fmt.Fprintln(w, p.version)
-	}
-
 	// make a list of ancestor commands so that we print with full context
 	var ancestors []string
 	ancestor := cmd
@@ -86,36 +163,41 @@ func (p *Parser) writeUsageForSubcommand(w io.Writer, cmd *command) {
 	}
 
 	// print the beginning of the usage string
-	_, _ = fmt.Fprint(w, "Usage:")
 	for i := len(ancestors) - 1; i >= 0; i-- {
 		_, _ = fmt.Fprint(w, " "+ancestors[i])
 	}
 
 	// write the option component of the usage message
-	for _, spec := range shortOptions {
-		// prefix with a space
-		_, _ = fmt.Fprint(w, " ")
-		if !spec.required {
-			_, _ = fmt.Fprint(w, "[")
+	compact := p.config.UsageLayout == UsageLayoutCompact
+	omitted := !cmd.nohelp // whether an option is summarized by [options], counting --help
+	written := make(map[*optionGroup]bool)
+	for _, spec := range append(shortOptions, longOptions...) {
+		if g := spec.optionGroup; g != nil {
+			if !written[g] {
+				written[g] = true
+				omitted = p.writeGroupUsage(w, g, compact) || omitted
+			}
+			continue
 		}
-		_, _ = fmt.Fprint(w, synopsis(spec, "-"+spec.short))
-		if !spec.required {
-			_, _ = fmt.Fprint(w, "]")
+		if compact && !spec.required {
+			omitted = true
+			continue
 		}
-	}
-
-	for _, spec := range longOptions {
 		// prefix with a space
 		_, _ = fmt.Fprint(w, " ")
 		if !spec.required {
 			_, _ = fmt.Fprint(w, "[")
 		}
-		_, _ = fmt.Fprint(w, synopsis(spec, "--"+spec.long))
+		_, _ = fmt.Fprint(w, synopsis(spec, usageName(spec)))
 		if !spec.required {
 			_, _ = fmt.Fprint(w, "]")
 		}
 	}
 
+	if compact && omitted {
+		_, _ = fmt.Fprint(w, " [options]")
+	}
+
 	// When we parse positionals, we check that:
 	//  1. required positionals come before non-required positionals
 	//  2. there is at most one multiple-value positional
@@ -137,6 +219,8 @@ func (p *Parser) writeUsageForSubcommand(w io.Writer, cmd *command) {
 		}
 		if spec.cardinality == multiple {
 			_, _ = fmt.Fprintf(w, "%s [%s ...]", spec.placeholder, spec.placeholder)
+		} else if spec.nargs > 0 {
+			_, _ = fmt.Fprint(w, strings.TrimPrefix(strings.Repeat(" "+spec.placeholder, spec.nargs), " "))
 		} else {
 			_, _ = fmt.Fprint(w, spec.placeholder)
 		}
@@ -144,11 +228,52 @@ func (p *Parser) writeUsageForSubcommand(w io.Writer, cmd *command) {
 	_, _ = fmt.Fprint(w, strings.Repeat("]", closeBrackets))
 
 	// if the program supports subcommands, give a hint to the user about their existence
-	if len(cmd.subcommands) > 0 {
+	if len(visibleSubcommands(cmd)) > 0 {
 		_, _ = fmt.Fprint(w, " <command> [<args>]")
 	}
+}
 
-	_, _ = fmt.Fprint(w, "\n")
+// writeGroupUsage writes the visible options of a group to the usage string,
+// as in [--json | --yaml] for an exclusive group or [--user USER --password
+// PASSWORD] for a together group, and returns true if the group was omitted
+// because the layout is compact and not all of its options are required. The
+// positionals of a together group are written with the other positionals.
+func (p *Parser) writeGroupUsage(w io.Writer, g *optionGroup, compact bool) bool {
+	var synopses []string
+	required := true
+	for _, spec := range g.members {
+		if p.isHidden(spec) || spec.positional {
+			continue
+		}
+		synopses = append(synopses, synopsis(spec, usageName(spec)))
+		required = required && spec.required
+	}
+	if len(synopses) == 0 {
+		return false
+	}
+	if compact && !required {
+		return true
+	}
+
+	sep := " "
+	if g.exclusive {
+		sep = " | "
+	}
+	usage := strings.Join(synopses, sep)
+	if !required || g.exclusive {
+		usage = "[" + usage + "]"
+	}
+	_, _ = fmt.Fprint(w, " "+usage)
+	return false
+}
+
+// usageName returns the form of an option shown in the usage string, which is
+// the long form if there is one
+func usageName(spec *spec) string {
+	if spec.long != "" {
+		return "--" + spec.long
+	}
+	return "-" + spec.short
 }
 
 func printTwoCols(w io.Writer, left, help string, defaultVal string, envVal string) {
@@ -163,6 +288,14 @@ func printTwoCols(w io.Writer, left, help string, defaultVal string, envVal stri
 		_, _ = fmt.Fprint(w, help)
 	}
 
+	_, _ = fmt.Fprint(w, annotation(defaultVal, envVal))
+	_, _ = fmt.Fprint(w, "\n")
+}
+
+// annotation returns the text that follows the help for an argument to show
+// its default value and environment variable, as in " [default: 1, env: N]",
+// or an empty string if it has neither
+func annotation(defaultVal, envVal string) string {
 	var bracketsContent []string
 
 	if defaultVal != "" {
@@ -177,10 +310,10 @@ func printTwoCols(w io.Writer, left, help string, defaultVal string, envVal stri
 		)
 	}
 
-	if len(bracketsContent) > 0 {
-		_, _ = fmt.Fprintf(w, " [%s]", strings.Join(bracketsContent, ", "))
+	if len(bracketsContent) == 0 {
+		return ""
 	}
-	_, _ = fmt.Fprint(w, "\n")
+	return fmt.Sprintf(" [%s]", strings.Join(bracketsContent, ", "))
 }
 
 // WriteHelp writes the usage string followed by the full help string for each option
@@ -189,29 +322,86 @@ func (p *Parser) WriteHelp(w io.Writer) {
 	if p.lastCmd != nil {
 		cmd = p.lastCmd
 	}
-	p.writeHelpForSubcommand(w, cmd)
+	p.writeHelpForSubcommand(w, cmd, false)
+}
+
+// WriteHelpAll writes the help text like WriteHelp, but also lists the
+// options and subcommands that are hidden or experimental, as for the
+// builtin --help-all option
+func (p *Parser) WriteHelpAll(w io.Writer) {
+	cmd := p.cmd
+	if p.lastCmd != nil {
+		cmd = p.lastCmd
+	}
+	p.writeHelpForSubcommand(w, cmd, true)
 }
 
 // WriteHelpForSubcommand writes the usage string followed by the full help
 // string for a specified subcommand. To write help for a top-level subcommand,
 // provide just the name of that subcommand. To write help for a subcommand that
 // is nested under another subcommand, provide a sequence of subcommand names
-// starting with the top-level subcommand and so on down the tree.
+// starting with the top-level subcommand and so on down the tree. If
+// Config.HelpTemplate cannot be executed for the subcommand then the help is
+// written in the default layout and the error is returned.
 func (p *Parser) WriteHelpForSubcommand(w io.Writer, subcommand ...string) error {
 	cmd, err := p.lookupCommand(subcommand...)
 	if err != nil {
 		return err
 	}
-	p.writeHelpForSubcommand(w, cmd)
-	return nil
+	return p.writeHelpForSubcommand(w, cmd, false)
 }
 
-// writeHelp writes the usage string for the given subcommand
-func (p *Parser) writeHelpForSubcommand(w io.Writer, cmd *command) {
-	var positionals, longOptions, shortOptions, envOnlyOptions []*spec
+// dynamicDescription calls DynamicDescription on the destination struct of the
+// given command if it implements DynamicDescribed. A subcommand that was not
+// selected is described by a new instance of its struct.
+func (p *Parser) dynamicDescription(cmd *command) (string, bool) {
+	var dests []reflect.Value
+	if cmd.parent == nil {
+		dests = p.roots
+	} else {
+		dests = []reflect.Value{p.commandStruct(cmd.dest)}
+	}
+
+	var description string
+	var found bool
+	for _, dest := range dests {
+		if d, ok := dest.Interface().(DynamicDescribed); ok {
+			description, found = d.DynamicDescription(), true
+		}
+	}
+	return description, found
+}
+
+// writeHelp writes the usage string for the given subcommand. If all is true
+// then hidden and experimental options and subcommands are included, with
+// hidden options listed in a separate section. If Config.HelpTemplate cannot
+// be executed for the subcommand then the default layout is written instead,
+// and the error is recorded as a warning and returned.
+func (p *Parser) writeHelpForSubcommand(w io.Writer, cmd *command, all bool) error {
+	var tmplErr error
+	if p.helpTmpl != nil && !all {
+		// the template is executed into a buffer so that nothing is written
+		// if it fails part of the way through
+		var b bytes.Buffer
+		err := p.helpTmpl.Execute(&b, p.helpData(cmd))
+		if err == nil {
+			_, _ = w.Write(b.Bytes())
+			return nil
+		}
+		tmplErr = fmt.Errorf("error in HelpTemplate: %v", err)
+		p.warn("%v", tmplErr)
+	}
+
+	var positionals, longOptions, shortOptions, envOnlyOptions, hiddenOptions, sectioned []*spec
 	var hasVersionOption bool
 	for _, spec := range cmd.specs {
 		switch {
+		case p.isHidden(spec) && !all:
+		case p.isHidden(spec) && !spec.positional && (spec.long != "" || spec.short != ""):
+			hiddenOptions = append(hiddenOptions, spec)
+		case spec.section != "" && (spec.long != "" || spec.short != ""):
+			sectioned = append(sectioned, spec)
+			hasVersionOption = hasVersionOption || spec.long == "version"
 		case spec.positional:
 			positionals = append(positionals, spec)
 		case spec.long != "":
@@ -223,22 +413,26 @@ func (p *Parser) writeHelpForSubcommand(w io.Writer, cmd *command) {
 		}
 	}
 
-	if p.description != "" {
-		_, _ = fmt.Fprintln(w, p.description)
+	description := p.description
+	if d, ok := p.dynamicDescription(cmd); ok {
+		description = d
+	}
+	if description != "" {
+		_, _ = fmt.Fprintln(w, description)
 	}
 	p.writeUsageForSubcommand(w, cmd)
 
 	// write the list of positionals
 	if len(positionals) > 0 {
-		_, _ = fmt.Fprint(w, "\nPositional arguments:\n")
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.Positional, "Positional arguments"))
 		for _, spec := range positionals {
-			printTwoCols(w, spec.placeholder, spec.help, "", "")
+			printTwoCols(w, spec.placeholder+p.requiredMarker(spec), p.withChoices(spec, spec.help), spec.defaultString, "")
 		}
 	}
 
 	// write the list of options with the short-only ones first to match the usage string
 	if len(shortOptions)+len(longOptions) > 0 || cmd.parent == nil {
-		_, _ = fmt.Fprint(w, "\nOptions:\n")
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.Options, "Options"))
 		for _, spec := range shortOptions {
 			p.printOption(w, spec)
 		}
@@ -260,8 +454,11 @@ func (p *Parser) writeHelpForSubcommand(w io.Writer, cmd *command) {
 
 	// write the list of global options
 	if len(globals) > 0 {
-		_, _ = fmt.Fprint(w, "\nGlobal options:\n")
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.GlobalOptions, "Global options"))
 		for _, spec := range globals {
+			if p.isHidden(spec) && !all {
+				continue
+			}
 			p.printOption(w, spec)
 			if spec.long == "version" {
 				hasVersionOption = true
@@ -270,52 +467,134 @@ func (p *Parser) writeHelpForSubcommand(w io.Writer, cmd *command) {
 	}
 
 	// write the list of built in options
-	p.printOption(w, &spec{
-		cardinality: zero,
-		long:        "help",
-		short:       "h",
-		help:        "display this help and exit",
-	})
-	if !hasVersionOption && p.version != "" {
+	for _, spec := range p.builtinOptions(cmd, hasVersionOption) {
+		p.printOption(w, spec)
+	}
+	if name := p.helpAllFlag(); name != "" && !cmd.nohelp && p.hasHidden(cmd) && findOption(cmd.specs, name) == nil {
 		p.printOption(w, &spec{
 			cardinality: zero,
-			long:        "version",
-			help:        "display version and exit",
+			long:        name,
+			help:        "display help for all options, including hidden ones, and exit",
 		})
 	}
 
+	// write the options that have a section struct tag under their headings
+	for _, title := range sectionTitles(sectioned) {
+		_, _ = fmt.Fprintf(w, "\n%s:\n", title)
+		for _, spec := range sectioned {
+			if spec.section == title {
+				p.printOption(w, spec)
+			}
+		}
+	}
+
+	// write the list of hidden options
+	if len(hiddenOptions) > 0 {
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.HiddenOptions, "Hidden options"))
+		for _, spec := range hiddenOptions {
+			p.printOption(w, spec)
+		}
+	}
+
 	// write the list of environment only variables
 	if len(envOnlyOptions) > 0 {
-		_, _ = fmt.Fprint(w, "\nEnvironment variables:\n")
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.Env, "Environment variables"))
 		for _, spec := range envOnlyOptions {
 			p.printEnvOnlyVar(w, spec)
 		}
 	}
 
-	// write the list of subcommands
-	if len(cmd.subcommands) > 0 {
-		_, _ = fmt.Fprint(w, "\nCommands:\n")
-		for _, subcmd := range cmd.subcommands {
-			printTwoCols(w, subcmd.name, subcmd.help, "", "")
+	// write the list of subcommands, other than hidden ones
+	subcommands := visibleSubcommands(cmd)
+	if all {
+		subcommands = cmd.subcommands
+	}
+	if len(subcommands) > 0 {
+		_, _ = fmt.Fprintf(w, "\n%s:\n", labelOr(p.config.Labels.Commands, "Commands"))
+		for _, subcmd := range subcommands {
+			help := subcmd.help
+			if d, ok := p.dynamicDescription(subcmd); ok {
+				help = d
+			}
+			printTwoCols(w, subcmd.name, help, "", "")
 		}
 	}
 
 	if p.epilogue != "" {
 		_, _ = fmt.Fprintln(w, "\n"+p.epilogue)
 	}
+	return tmplErr
 }
 
 func (p *Parser) printOption(w io.Writer, spec *spec) {
+	ways := optionForms(spec)
+	if len(ways) > 0 {
+		env := ""
+		if !p.config.HideEnvInHelp {
+			env = p.helpEnv(spec)
+		}
+		printTwoCols(w, strings.Join(ways, ", ")+p.requiredMarker(spec), p.optionHelp(spec), spec.defaultString, env)
+	}
+}
+
+// optionForms returns the ways in which an option can be given, as listed in
+// help text, such as "--name NAME" and "-n NAME"
+func optionForms(spec *spec) []string {
 	ways := make([]string, 0, 2)
 	if spec.long != "" {
 		ways = append(ways, synopsis(spec, "--"+spec.long))
 	}
+	if !spec.deprecated {
+		for _, alias := range spec.aliases {
+			ways = append(ways, synopsis(spec, "--"+alias))
+		}
+	}
 	if spec.short != "" {
 		ways = append(ways, synopsis(spec, "-"+spec.short))
 	}
-	if len(ways) > 0 {
-		printTwoCols(w, strings.Join(ways, ", "), spec.help, spec.defaultString, spec.env)
+	if spec.clearable || spec.negatable {
+		ways = append(ways, "--no-"+spec.long)
 	}
+	if spec.listFile != "" {
+		ways = append(ways, "--"+spec.listFile+" FILE")
+	}
+	return ways
+}
+
+// optionHelp returns the help text of an option as listed in help text,
+// including notes on how it behaves and the values it allows
+func (p *Parser) optionHelp(spec *spec) string {
+	help := spec.help
+	if spec.inverted {
+		help = strings.TrimSpace(help + " (sets false when present)")
+	}
+	if spec.modeTarget != nil {
+		help = strings.TrimSpace(help + " (same as --" + spec.setMode + " " + spec.modeValue + ")")
+	}
+	return p.withChoices(spec, help)
+}
+
+// builtinOptions returns the options that the library handles itself for the
+// given command, which are -h and --help unless the command is tagged
+// "nohelp", and --version if there is a version and hasVersionOption is false
+func (p *Parser) builtinOptions(cmd *command, hasVersionOption bool) []*spec {
+	var specs []*spec
+	if !cmd.nohelp {
+		specs = append(specs, &spec{
+			cardinality: zero,
+			long:        "help",
+			short:       "h",
+			help:        "display this help and exit",
+		})
+	}
+	if !hasVersionOption && p.versionFor(cmd) != "" {
+		specs = append(specs, &spec{
+			cardinality: zero,
+			long:        "version",
+			help:        "display version and exit",
+		})
+	}
+	return specs
 }
 
 func (p *Parser) printEnvOnlyVar(w io.Writer, spec *spec) {
@@ -330,7 +609,73 @@ func (p *Parser) printEnvOnlyVar(w io.Writer, spec *spec) {
 		ways = append(ways, spec.help)
 	}
 
-	printTwoCols(w, spec.env, strings.Join(ways, " "), spec.defaultString, "")
+	env := p.helpEnv(spec)
+	if env == "" {
+		env = p.envName(spec)
+	}
+	printTwoCols(w, env, strings.Join(ways, " "), spec.defaultString, "")
+}
+
+// helpEnv returns the environment variable of the given spec as it is shown
+// in help text, which is the name that Parse looks up first, including the
+// prefix of the selected profile, if any. It returns an empty string if the
+// variable is excluded by Config.EnvAllowlist or Config.IgnoreEnv.
+func (p *Parser) helpEnv(spec *spec) string {
+	if spec.env == "" || (p.config.IgnoreEnv && p.config.Environment == nil) {
+		return ""
+	}
+	if name := p.envName(spec); p.envAllowed(name) {
+		return name
+	}
+	return ""
+}
+
+// withChoices appends the list of allowed values for the given spec to its
+// help text, if it has a choicesfn tag that returns any
+func (p *Parser) withChoices(spec *spec, help string) string {
+	choices := p.choices(spec)
+	if len(choices) == 0 {
+		return help
+	}
+	return strings.TrimSpace(help + " (one of " + strings.Join(choices, ", ") + ")")
+}
+
+// requiredMarker returns the text that marks the given spec as required in
+// help text, which is empty unless Config.RequiredMarker is set
+func (p *Parser) requiredMarker(spec *spec) string {
+	if !spec.required {
+		return ""
+	}
+	return p.config.RequiredMarker
+}
+
+// hasHidden returns true if any option of the given command or its ancestors,
+// or any of its subcommands, is omitted from help text
+func (p *Parser) hasHidden(cmd *command) bool {
+	for _, subcmd := range cmd.subcommands {
+		if subcmd.hidden {
+			return true
+		}
+	}
+	for c := cmd; c != nil; c = c.parent {
+		for _, spec := range c.specs {
+			if p.isHidden(spec) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// visibleSubcommands returns the subcommands of cmd that are not hidden
+func visibleSubcommands(cmd *command) []*command {
+	var subcommands []*command
+	for _, subcmd := range cmd.subcommands {
+		if !subcmd.hidden {
+			subcommands = append(subcommands, subcmd)
+		}
+	}
+	return subcommands
 }
 
 // lookupCommand finds a subcommand based on a sequence of subcommand names. The
@@ -355,9 +700,80 @@ func (p *Parser) lookupCommand(path ...string) (*command, error) {
 	return cmd, nil
 }
 
+// formatDefault formats a default value for display in help text. Values that
+// implement encoding.TextMarshaler are formatted with MarshalText. Slices and
+// maps with no elements are formatted as the empty string, and otherwise their
+// elements are joined by sep, or if sep is empty they are formatted as [a b c].
+func formatDefault(v reflect.Value, sep string) (string, error) {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		s, err := m.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(s), nil
+	}
+
+	if v.Kind() == reflect.Ptr && !v.IsNil() && !canParse(v.Type()) {
+		v = v.Elem()
+	}
+	if isBytes(v.Type()) && v.Kind() == reflect.Slice {
+		return string(v.Bytes()), nil
+	}
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Map) || canParse(v.Type()) {
+		return fmt.Sprintf("%v", v), nil
+	}
+
+	if v.Len() == 0 {
+		return "", nil
+	}
+	if sep == "" {
+		return fmt.Sprintf("%v", v), nil
+	}
+
+	var parts []string
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			s, err := formatDefault(v.Index(i), "")
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+	} else {
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := formatDefault(iter.Key(), "")
+			if err != nil {
+				return "", err
+			}
+			val, err := formatDefault(iter.Value(), "")
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, key+"="+val)
+		}
+		sort.Strings(parts)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// labelOr returns the given label, or the fallback if the label is empty
+func labelOr(label, fallback string) string {
+	if label == "" {
+		return fallback
+	}
+	return label
+}
+
 func synopsis(spec *spec, form string) string {
 	if spec.cardinality == zero {
 		return form
 	}
+	if spec.elems != nil {
+		return form + ".N.FIELD VALUE"
+	}
+	if spec.nargs > 0 {
+		return form + strings.Repeat(" "+spec.placeholder, spec.nargs)
+	}
 	return form + " " + spec.placeholder
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"testing"
@@ -290,6 +291,72 @@ Options:
 	assert.Equal(t, expectedUsage, strings.TrimSpace(usage.String()))
 }
 
+// dynamicallyDescribed computes its description from its mode when help is written
+type dynamicallyDescribed struct {
+	described
+	mode string
+}
+
+func (d *dynamicallyDescribed) DynamicDescription() string {
+	return "this program runs in " + d.mode + " mode"
+}
+
+type describedSubcommand struct{}
+
+func (*describedSubcommand) DynamicDescription() string {
+	return "computed subcommand description"
+}
+
+func TestUsageWithDynamicDescription(t *testing.T) {
+	expectedHelp := `
+this program runs in fast mode
+Usage: example
+
+Options:
+  --help, -h             display this help and exit
+`
+	var args dynamicallyDescribed
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	// the description is computed when help is written, not at construction
+	args.mode = "fast"
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestSubcommandWithDynamicDescription(t *testing.T) {
+	expectedHelp := `
+Usage: example <command> [<args>]
+
+Options:
+  --help, -h             display this help and exit
+
+Commands:
+  sub                    computed subcommand description
+  other                  static description
+`
+	expectedSubHelp := `
+computed subcommand description
+Usage: example sub
+`
+	var args struct {
+		Sub   *describedSubcommand `arg:"subcommand" help:"static description"`
+		Other *struct{}            `arg:"subcommand" help:"static description"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var subhelp bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&subhelp, "sub"))
+	assert.True(t, strings.HasPrefix(subhelp.String(), expectedSubHelp[1:]), subhelp.String())
+}
+
 type epilogued struct{}
 
 // Epilogued returns the epilogue for this program
@@ -585,6 +652,56 @@ Environment variables:
 	assert.Equal(t, expectedUsage, strings.TrimSpace(usage.String()))
 }
 
+func TestUsageHideEnvInHelp(t *testing.T) {
+	expectedHelp := `
+Usage: example [--token TOKEN]
+
+Options:
+  --token TOKEN
+  --help, -h             display this help and exit
+
+Environment variables:
+  SECRET                 Optional.
+`
+	var args struct {
+		Token  string `arg:"env"`
+		Secret string `arg:"--,env"`
+	}
+
+	p, err := NewParser(Config{Program: "example", HideEnvInHelp: true}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageEnvMatchesLookup(t *testing.T) {
+	var args struct {
+		Profile string `arg:"--profile,profile"`
+		DBURL   string `arg:"--db-url,env:DB_URL"`
+		Secret  string `arg:"--,env:SECRET"`
+		Level   string
+	}
+
+	p, err := NewParser(Config{Program: "example", AutoEnv: true}, &args)
+	require.NoError(t, err)
+	require.Equal(t, ErrHelp, p.Parse([]string{"--profile", "prod", "--help"}))
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--db-url DB-URL [env: PROD_DB_URL]")
+	assert.Contains(t, help.String(), "--level LEVEL [env: PROD_LEVEL]")
+	assert.Contains(t, help.String(), "  PROD_SECRET            Optional.")
+
+	p, err = NewParser(Config{Program: "example", EnvAllowlist: []string{"SECRET"}}, &args)
+	require.NoError(t, err)
+	help.Reset()
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "  --db-url DB-URL\n")
+	assert.Contains(t, help.String(), "  SECRET                 Optional.")
+}
+
 func TestEnvOnlyArgs(t *testing.T) {
 	expectedUsage := "Usage: example [--arg ARG]"
 
@@ -689,3 +806,744 @@ Options:
 	p.WriteHelp(&help)
 	assert.Equal(t, expectedHelp[1:], help.String())
 }
+
+func TestUsageForSliceOfStructs(t *testing.T) {
+	expectedUsage := "Usage: example [--server.N.FIELD VALUE]"
+
+	expectedHelp := `
+Usage: example [--server.N.FIELD VALUE]
+
+Options:
+  --server.N.FIELD VALUE
+                         servers to connect to
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Server []struct {
+			Host string
+			Port int
+		} `help:"servers to connect to"`
+	}
+
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, expectedUsage, strings.TrimSpace(usage.String()))
+}
+
+func TestUsageWithLabels(t *testing.T) {
+	expectedUsage := "Utilisation: example sub [--force] [--level LEVEL] FILE"
+
+	expectedHelp := `
+Utilisation: example sub [--force] [--level LEVEL] FILE
+
+Arguments positionnels:
+  FILE
+
+Options:
+  --force
+  --level LEVEL
+
+Options globales:
+  --verbose
+  --help, -h             display this help and exit
+
+Variables d'environnement:
+  TOKEN                  Optional.
+`
+
+	var args struct {
+		Verbose bool
+		Sub     *struct {
+			Force bool
+			Level int    `arg:"--level"`
+			Token string `arg:"--,env:TOKEN"`
+			File  string `arg:"positional,required"`
+		} `arg:"subcommand"`
+	}
+
+	labels := Labels{
+		Usage:         "Utilisation",
+		Positional:    "Arguments positionnels",
+		GlobalOptions: "Options globales",
+		Env:           "Variables d'environnement",
+		Commands:      "Commandes",
+	}
+	p, err := NewParser(Config{Program: "example", Labels: labels}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	require.NoError(t, p.WriteHelpForSubcommand(&help, "sub"))
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var usage bytes.Buffer
+	require.NoError(t, p.WriteUsageForSubcommand(&usage, "sub"))
+	assert.Equal(t, expectedUsage, strings.TrimSpace(usage.String()))
+
+	var rootHelp bytes.Buffer
+	p.WriteHelp(&rootHelp)
+	assert.Contains(t, rootHelp.String(), "\nCommandes:\n  sub\n")
+}
+
+func TestUsageWithSliceDefaults(t *testing.T) {
+	expectedHelp := `
+Usage: example [--tags TAGS] [--ports PORTS] [--labels LABELS] [--empty EMPTY]
+
+Options:
+  --tags TAGS [default: [a b c]]
+  --ports PORTS [default: 80;443]
+  --labels LABELS [default: map[x:1 y:2]]
+  --empty EMPTY
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Tags   []string
+		Ports  []int `arg:"sep:;"`
+		Labels map[string]int
+		Empty  []string
+	}
+	args.Tags = []string{"a", "b", "c"}
+	args.Ports = []int{80, 443}
+	args.Labels = map[string]int{"y": 2, "x": 1}
+	args.Empty = []string{}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithSliceDefaultSeparator(t *testing.T) {
+	expectedHelp := `
+Usage: example [--tags TAGS] [--labels LABELS] [--addr ADDR]
+
+Options:
+  --tags TAGS [default: a,b,c]
+  --labels LABELS [default: x=1,y=2]
+  --addr ADDR [default: 01:02:03:04:05:06]
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Tags   []string
+		Labels map[string]int
+		Addr   net.HardwareAddr
+	}
+	args.Tags = []string{"a", "b", "c"}
+	args.Labels = map[string]int{"y": 2, "x": 1}
+	args.Addr = net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	p, err := NewParser(Config{Program: "example", SliceDefaultSeparator: ","}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithDynamicDefault(t *testing.T) {
+	expectedHelp := `
+Usage: example [--dir DIR]
+
+Options:
+  --dir DIR              working directory [default: (dynamic)]
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Dir workDir `help:"working directory"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithNargs(t *testing.T) {
+	expectedUsage := "Usage: example [--point POINT POINT] [CORNER CORNER]\n"
+	var args struct {
+		Point  [2]float64 `arg:"nargs:2"`
+		Corner [2]int     `arg:"positional,nargs:2"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, expectedUsage, usage.String())
+}
+
+func TestUsageWithInvertedBool(t *testing.T) {
+	expectedHelp := `
+Usage: example [--quiet]
+
+Options:
+  --quiet                suppress progress output (sets false when present) [default: true]
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Progress bool `arg:"--quiet,inverted" help:"suppress progress output"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithClearable(t *testing.T) {
+	expectedHelp := `
+Usage: example [--tags TAGS]
+
+Options:
+  --tags TAGS, --no-tags
+                         tags to apply
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Tags []string `arg:"clearable" help:"tags to apply"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithNegatable(t *testing.T) {
+	expectedHelp := `
+Usage: example [--color]
+
+Options:
+  --color, --no-color    colorize the output
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Color *bool `arg:"negatable" help:"colorize the output"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithGroups(t *testing.T) {
+	expectedUsage := "Usage: example [--json | --yaml] [--user USER --password PASSWORD] [--verbose]\n"
+	var args struct {
+		JSON     bool   `arg:"--json,group:format"`
+		User     string `arg:"group:auth=together"`
+		YAML     bool   `arg:"--yaml,group:format"`
+		Password string `arg:"group:auth=together"`
+		Verbose  bool
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, expectedUsage, usage.String())
+
+	p, err = NewParser(Config{Program: "example", UsageLayout: UsageLayoutCompact}, &args)
+	require.NoError(t, err)
+	usage.Reset()
+	p.WriteUsage(&usage)
+	assert.Equal(t, "Usage: example [options]\n", usage.String())
+}
+
+func TestUsageWithCounter(t *testing.T) {
+	expectedHelp := `
+Usage: example [--verbose]
+
+Options:
+  --verbose, -v          increase verbosity
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Verbose int `arg:"-v,counter" help:"increase verbosity"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithAliases(t *testing.T) {
+	expectedHelp := `
+Usage: example [--color COLOR] [--size SIZE]
+
+Options:
+  --color COLOR, -c COLOR
+                         the color to use
+  --size SIZE, --dimension SIZE
+                         the size to use
+  --help, -h             display this help and exit
+  --help-all             display help for all options, including hidden ones, and exit
+`
+	var args struct {
+		Color  string `arg:"-c,--color,--colour,deprecated" help:"the color to use"`
+		Size   string `arg:"--size,--dimension" help:"the size to use"`
+		Legacy bool   `arg:"deprecated" help:"no longer used"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithSections(t *testing.T) {
+	expectedHelp := `
+Usage: example [--verbose] [--host HOST] [--port PORT] [--logfile LOGFILE]
+
+Options:
+  --verbose              print more
+  --help, -h             display this help and exit
+
+Networking:
+  --host HOST            the host to connect to
+  --port PORT            the port to connect to [default: 80]
+
+Logging:
+  --logfile LOGFILE      where to write logs
+`
+	var args struct {
+		Verbose bool   `help:"print more"`
+		Host    string `help:"the host to connect to" section:"Networking"`
+		Port    int    `default:"80" help:"the port to connect to" section:"Networking"`
+		LogFile string `help:"where to write logs" section:"Logging"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var positional struct {
+		Src string `arg:"positional" section:"Input"`
+	}
+	_, err = NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".Src: section can only be used with options")
+}
+
+func TestUsageWithListFile(t *testing.T) {
+	expectedHelp := `
+Usage: example [--exclude EXCLUDE]
+
+Options:
+  --exclude EXCLUDE, --exclude-from FILE
+                         patterns to exclude
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Exclude []string `arg:"listfile:exclude-from" help:"patterns to exclude"`
+	}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithRequiredMarker(t *testing.T) {
+	expectedHelp := `
+Usage: example --name NAME [--count COUNT] SRC [DST]
+
+Positional arguments:
+  SRC*                   source file
+  DST                    destination file
+
+Options:
+  --name NAME*           the name
+  --count COUNT          the count
+  --help, -h             display this help and exit
+`
+	var args struct {
+		Name  string `arg:"required" help:"the name"`
+		Count int    `help:"the count"`
+		Src   string `arg:"positional,required" help:"source file"`
+		Dst   string `arg:"positional" help:"destination file"`
+	}
+	p, err := NewParser(Config{Program: "example", RequiredMarker: "*"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageErrors(t *testing.T) {
+	expected := `
+Usage: example sub [--count COUNT]
+error: error processing --count: strconv.ParseInt: parsing "x": invalid syntax`
+
+	var args struct {
+		Sub *struct {
+			Count int
+		} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example", UsageErrors: true}, &args)
+	require.NoError(t, err)
+
+	err = p.Parse([]string{"sub", "--count", "x"})
+	var usageErr *UsageError
+	require.True(t, errors.As(err, &usageErr))
+	assert.Equal(t, expected[1:], err.Error())
+	assert.Equal(t, "Usage: example sub [--count COUNT]\n", usageErr.Usage)
+
+	// help and version requests are not wrapped
+	p.Reset()
+	assert.Equal(t, ErrHelp, p.Parse([]string{"--help"}))
+}
+
+func TestHelpOnError(t *testing.T) {
+	var stdout bytes.Buffer
+	var exitCode int
+	exit := func(code int) { exitCode = code }
+
+	expectedStdout := `
+Usage: example [--foo FOO]
+
+Options:
+  --foo FOO
+  --help, -h             display this help and exit
+error: unknown argument --bar
+`
+	var args struct {
+		Foo int
+	}
+	p, err := NewParser(Config{Program: "example", Exit: exit, Out: &stdout, HelpOnError: true, UsageErrors: true}, &args)
+	require.NoError(t, err)
+	p.MustParse([]string{"--bar"})
+
+	assert.Equal(t, expectedStdout[1:], stdout.String())
+	assert.Equal(t, -1, exitCode)
+}
+
+func TestUsageWithChoicesFn(t *testing.T) {
+	expectedHelp := `
+Usage: example [--region REGION] [--replicas REPLICAS] [PRIMARY]
+
+Positional arguments:
+  PRIMARY                (one of us, eu)
+
+Options:
+  --region REGION        (one of us, eu) [env: REGION]
+  --replicas REPLICAS    (one of us, eu)
+  --help, -h             display this help and exit
+`
+	args := regionArgs{available: []string{"us", "eu"}}
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+}
+
+func TestUsageWithBytesDefault(t *testing.T) {
+	var args struct {
+		Data []byte
+	}
+	args.Data = []byte("hello")
+	p, err := NewParser(Config{Program: "example"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--data DATA [default: hello]")
+}
+
+func TestUsageWithExperimental(t *testing.T) {
+	var args struct {
+		NewEngine bool `arg:"--new-engine,experimental" help:"use the new engine"`
+		Old       bool
+	}
+	config := Config{Program: "example", ExperimentalEnv: "MYAPP_EXPERIMENTAL", Environment: map[string]string{}}
+	p, err := NewParser(config, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.NotContains(t, help.String(), "new-engine")
+
+	config.Environment["MYAPP_EXPERIMENTAL"] = "true"
+	p, err = NewParser(config, &args)
+	require.NoError(t, err)
+	help.Reset()
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--new-engine           use the new engine")
+	assert.Contains(t, help.String(), "Usage: example [--new-engine] [--old]")
+}
+
+func TestHelpAll(t *testing.T) {
+	expectedHelp := `
+Usage: example [--name NAME] <command> [<args>]
+
+Options:
+  --name NAME            your name
+  --help, -h             display this help and exit
+  --help-all             display help for all options, including hidden ones, and exit
+
+Commands:
+  list
+`
+	expectedHelpAll := `
+Usage: example [--name NAME] <command> [<args>]
+
+Options:
+  --name NAME            your name
+  --help, -h             display this help and exit
+  --help-all             display help for all options, including hidden ones, and exit
+
+Hidden options:
+  --debug                enable debugging
+  --turbo                go faster
+
+Commands:
+  list
+  beta
+`
+	var args struct {
+		Name  string    `help:"your name"`
+		Debug bool      `arg:"hidden" help:"enable debugging"`
+		Turbo bool      `arg:"experimental" help:"go faster"`
+		List  *struct{} `arg:"subcommand"`
+		Beta  *struct{} `arg:"subcommand,hidden"`
+	}
+	p, err := NewParser(Config{Program: "example", ExperimentalEnv: "EXAMPLE_EXPERIMENTAL"}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Equal(t, expectedHelp[1:], help.String())
+
+	var helpAll bytes.Buffer
+	p.WriteHelpAll(&helpAll)
+	assert.Equal(t, expectedHelpAll[1:], helpAll.String())
+}
+
+func TestHelpAllNotListedWithoutHidden(t *testing.T) {
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.NotContains(t, help.String(), "--help-all")
+}
+
+func TestHelpAllFlag(t *testing.T) {
+	var args struct {
+		Debug bool `arg:"hidden"`
+	}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	err = p.Parse([]string{"--help-all"})
+	assert.Equal(t, ErrHelpAll, err)
+	assert.True(t, errors.Is(err, ErrHelp))
+
+	// help supersedes other errors
+	p.Reset()
+	assert.Equal(t, ErrHelpAll, p.Parse([]string{"--unknown", "--help-all"}))
+}
+
+func TestHelpAllFlagCustomName(t *testing.T) {
+	var args struct {
+		Debug bool `arg:"hidden"`
+	}
+	p, err := NewParser(Config{HelpAllFlag: "help-full"}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, ErrHelpAll, p.Parse([]string{"--help-full"}))
+
+	var help bytes.Buffer
+	p.WriteHelp(&help)
+	assert.Contains(t, help.String(), "--help-full")
+
+	p, err = NewParser(Config{HelpAllFlag: "-"}, &args)
+	require.NoError(t, err)
+	assert.Error(t, p.Parse([]string{"--help-all"}))
+}
+
+func TestHelpAllFlagOverriddenByOption(t *testing.T) {
+	var args struct {
+		HelpAll bool   `arg:"--help-all"`
+		Debug   string `arg:"hidden"`
+	}
+	parse(t, "--help-all", &args)
+	assert.True(t, args.HelpAll)
+}
+
+func TestMustParseHelpAll(t *testing.T) {
+	var args struct {
+		Debug bool `arg:"hidden" help:"enable debugging"`
+	}
+	var stdout bytes.Buffer
+	var exitCode int
+	os.Args = []string{"example", "--help-all"}
+	mustParse(Config{Out: &stdout, HelpDestination: &stdout, Exit: func(code int) { exitCode = code }}, &args)
+	assert.Equal(t, 0, exitCode)
+	assert.Contains(t, stdout.String(), "enable debugging")
+}
+
+func TestUsageLayoutCompact(t *testing.T) {
+	expectedUsage := "Usage: example -u USER --name NAME [options] SRC [DST]\n"
+	var args struct {
+		User    string `arg:"-u,--,required"`
+		Name    string `arg:"required"`
+		Count   int
+		Verbose bool   `arg:"-v"`
+		Src     string `arg:"positional,required"`
+		Dst     string `arg:"positional"`
+	}
+	p, err := NewParser(Config{Program: "example", UsageLayout: UsageLayoutCompact}, &args)
+	require.NoError(t, err)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, expectedUsage, usage.String())
+}
+
+func TestUsageLayoutCompactSubcommand(t *testing.T) {
+	var args struct {
+		Debug bool
+		Run   *struct {
+			Target string `arg:"required"`
+		} `arg:"subcommand,nohelp"`
+	}
+	p, err := NewParser(Config{Program: "example", UsageLayout: UsageLayoutCompact}, &args)
+	require.NoError(t, err)
+
+	var usage bytes.Buffer
+	p.WriteUsage(&usage)
+	assert.Equal(t, "Usage: example [options] <command> [<args>]\n", usage.String())
+
+	// without --help, there is nothing to summarize
+	usage.Reset()
+	require.NoError(t, p.WriteUsageForSubcommand(&usage, "run"))
+	assert.Equal(t, "Usage: example run --target TARGET\n", usage.String())
+}
+
+func TestErrorFormatJSON(t *testing.T) {
+	var args struct {
+		Count int    `arg:"env:COUNT"`
+		Name  string `arg:"required,env:NAME"`
+		Sub   *struct {
+			Level int
+		} `arg:"subcommand"`
+	}
+
+	tests := []struct {
+		name     string
+		cmdLine  []string
+		expected string
+	}{
+		{
+			name:     "value",
+			cmdLine:  []string{"--count=x"},
+			expected: `{"type":"ValueError","message":"error processing --count: strconv.ParseInt: parsing \"x\": invalid syntax","field":"Count","token":"--count"}`,
+		},
+		{
+			name:     "required",
+			cmdLine:  []string{},
+			expected: `{"type":"RequiredError","message":"--name is required (or environment variable NAME)","field":"Name","token":"--name","env":"NAME"}`,
+		},
+		{
+			name:     "unknown",
+			cmdLine:  []string{"--nmae", "x"},
+			expected: `{"type":"UnknownArgError","message":"unknown argument --nmae (did you mean --name?)","token":"--nmae","suggestions":["--name"]}`,
+		},
+		{
+			name:     "subcommand",
+			cmdLine:  []string{"--name", "x", "sub", "--level", "y"},
+			expected: `{"type":"ValueError","message":"error processing --level: strconv.ParseInt: parsing \"y\": invalid syntax","field":"Sub.Level","token":"--level","command":["sub"]}`,
+		},
+		{
+			name:     "invalid subcommand",
+			cmdLine:  []string{"--name", "x", "nosuch"},
+			expected: `{"type":"UnknownArgError","message":"invalid subcommand: nosuch","token":"nosuch"}`,
+		},
+		{
+			name:     "other",
+			cmdLine:  []string{"--name"},
+			expected: `{"type":"Error","message":"missing value for --name"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			var exitCode int
+			p, err := NewParser(Config{
+				Program:          "example",
+				Exit:             func(code int) { exitCode = code },
+				Out:              &stdout,
+				ErrorDestination: &stderr,
+				ErrorFormat:      ErrorFormatJSON,
+				SuggestFlags:     true,
+			}, &args)
+			require.NoError(t, err)
+			p.MustParse(tt.cmdLine)
+			assert.Equal(t, tt.expected+"\n", stderr.String())
+			assert.Empty(t, stdout.String())
+			assert.Equal(t, -1, exitCode)
+		})
+	}
+}
+
+func TestErrorFormatJSONFail(t *testing.T) {
+	var stdout bytes.Buffer
+	var exitCode int
+	var args struct {
+		Sub *struct{} `arg:"subcommand"`
+	}
+	p, err := NewParser(Config{Program: "example", Exit: func(code int) { exitCode = code }, Out: &stdout, ErrorFormat: ErrorFormatJSON}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.FailSubcommand("something went wrong", "sub"))
+	assert.Equal(t, `{"type":"Error","message":"something went wrong","command":["sub"]}`+"\n", stdout.String())
+	assert.Equal(t, -1, exitCode)
+}
+
+func TestErrorDestinationDefault(t *testing.T) {
+	var args struct{}
+	p, err := NewParser(Config{}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, os.Stdout, p.config.ErrorDestination)
+	assert.Equal(t, os.Stdout, p.config.HelpDestination)
+
+	var stdout, stderr bytes.Buffer
+	p, err = NewParser(Config{Out: &stdout}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, &stdout, p.config.ErrorDestination)
+
+	p, err = NewParser(Config{Out: &stdout, ErrorDestination: &stderr}, &args)
+	require.NoError(t, err)
+	assert.Equal(t, &stderr, p.config.ErrorDestination)
+	assert.Equal(t, &stdout, p.config.HelpDestination)
+}
+
+func TestErrorFormatJSONArgsTooLong(t *testing.T) {
+	var stdout bytes.Buffer
+	var args struct {
+		Name string
+	}
+	p, err := NewParser(Config{Exit: func(int) {}, Out: &stdout, ErrorFormat: ErrorFormatJSON, MaxTotalArgsBytes: 4}, &args)
+	require.NoError(t, err)
+	p.MustParse([]string{"--name", "abcdef"})
+	assert.Equal(t, `{"type":"ErrArgsTooLong","message":"arguments are too long: their combined length exceeds the limit of 4 bytes"}`+"\n", stdout.String())
+}
@@ -0,0 +1,63 @@
+package arg
+
+import "fmt"
+
+// Warnings returns the problems that were found during the most recent call
+// to Parse that were not serious enough to cause an error, such as a value
+// that was read from a fallback environment variable listed in an env option,
+// or an option tagged "deprecated" that was given. Each warning names the
+// argument or environment variable concerned. Help text that could not be
+// written with Config.HelpTemplate, and was written in the default layout
+// instead, is reported here too. It is up to the application whether to print
+// them. The warnings are discarded each time Parse is called, except for those
+// about the destination structs found by NewParser, which come first.
+func (p *Parser) Warnings() []string {
+	if len(p.specWarnings) == 0 {
+		return p.warnings
+	}
+	return append(append([]string{}, p.specWarnings...), p.warnings...)
+}
+
+// warn records a warning to be returned by Warnings. A warning that has
+// already been recorded is not recorded again, so that reprocessing the
+// environment with ReloadEnv does not repeat it.
+func (p *Parser) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, w := range p.warnings {
+		if w == msg {
+			return
+		}
+	}
+	p.warnings = append(p.warnings, msg)
+}
+
+// warnDeprecated records a warning that a deprecated option was given by the
+// given name, which MustParse also writes to Config.ErrorDestination if it
+// was set, or else to standard error
+func (p *Parser) warnDeprecated(spec *spec, name string) {
+	msg := name + " is deprecated"
+	if spec.deprecation != "" {
+		msg += ": " + spec.deprecation
+	}
+	for _, w := range p.deprecations {
+		if w == msg {
+			return
+		}
+	}
+	p.deprecations = append(p.deprecations, msg)
+	p.warn("%s", msg)
+}
+
+// isDeprecatedName returns true if the given name of a deprecated option is
+// one of its aliases, or is any of its names if it has no aliases
+func isDeprecatedName(spec *spec, name string) bool {
+	if len(spec.aliases) == 0 {
+		return true
+	}
+	for _, alias := range spec.aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
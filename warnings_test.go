@@ -0,0 +1,118 @@
+package arg
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarningsFallbackEnv(t *testing.T) {
+	var args struct {
+		Token string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+	}
+	p := parseWithEnv(t, "", []string{"OLD_TOKEN=abc"}, &args)
+	assert.Equal(t, "abc", args.Token)
+	assert.Equal(t, []string{"using environment variable OLD_TOKEN for Token because NEW_TOKEN is not set"}, p.Warnings())
+}
+
+func TestWarningsIgnoredEnv(t *testing.T) {
+	var args struct {
+		Token string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+	}
+	p := parseWithEnv(t, "", []string{"NEW_TOKEN=abc", "OLD_TOKEN=xyz"}, &args)
+	assert.Equal(t, "abc", args.Token)
+	assert.Equal(t, []string{"environment variable OLD_TOKEN is ignored because NEW_TOKEN is set"}, p.Warnings())
+}
+
+func TestWarningsNone(t *testing.T) {
+	var args struct {
+		Token string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+	}
+	p := parseWithEnv(t, "", []string{"NEW_TOKEN=abc"}, &args)
+	assert.Empty(t, p.Warnings())
+}
+
+func TestWarningsResetOnParse(t *testing.T) {
+	var args struct {
+		Token string `arg:"env:NEW_TOKEN|OLD_TOKEN"`
+	}
+	env := map[string]string{"OLD_TOKEN": "abc"}
+	p, err := NewParser(Config{Environment: env}, &args)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(nil))
+	assert.Len(t, p.Warnings(), 1)
+
+	// reloading the environment does not repeat the warning
+	require.NoError(t, p.ReloadEnv())
+	assert.Len(t, p.Warnings(), 1)
+
+	env["NEW_TOKEN"] = "xyz"
+	delete(env, "OLD_TOKEN")
+	p.Reset()
+	require.NoError(t, p.Parse(nil))
+	assert.Empty(t, p.Warnings())
+}
+
+func TestWarningsDeprecatedAlias(t *testing.T) {
+	var args struct {
+		Color string `arg:"--color,--colour,deprecated:use --color instead"`
+	}
+	p := parseWithEnv(t, "--colour=red", nil, &args)
+	assert.Equal(t, "red", args.Color)
+	assert.Equal(t, []string{"--colour is deprecated: use --color instead"}, p.Warnings())
+
+	p = parseWithEnv(t, "--color blue", nil, &args)
+	assert.Equal(t, "blue", args.Color)
+	assert.Empty(t, p.Warnings())
+}
+
+func TestWarningsDeprecatedOption(t *testing.T) {
+	var args struct {
+		Legacy bool `arg:"-l,deprecated"`
+	}
+	p := parseWithEnv(t, "-l --legacy", nil, &args)
+	assert.True(t, args.Legacy)
+	assert.Equal(t, []string{"-l is deprecated", "--legacy is deprecated"}, p.Warnings())
+
+	p = parseWithEnv(t, "", nil, &args)
+	assert.Empty(t, p.Warnings())
+}
+
+func TestWarningsDeprecatedInvalid(t *testing.T) {
+	var positional struct {
+		Src string `arg:"positional,deprecated"`
+	}
+	_, err := NewParser(Config{}, &positional)
+	assert.EqualError(t, err, ".Src: deprecated can only be used with options")
+
+	var required struct {
+		Name string `arg:"required,deprecated"`
+	}
+	_, err = NewParser(Config{}, &required)
+	assert.EqualError(t, err, ".Name: deprecated options cannot be required")
+}
+
+func TestMustParseDeprecated(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	originalArgs := os.Args
+	defer func() {
+		os.Args = originalArgs
+	}()
+	os.Args = []string{"example", "--colour", "red", "--colour", "blue"}
+
+	var args struct {
+		Color string `arg:"--color,--colour,deprecated"`
+	}
+	mustParse(Config{Out: &stdout, ErrorDestination: &stderr, Exit: func(int) {}}, &args)
+	assert.Equal(t, "blue", args.Color)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "warning: --colour is deprecated\n", stderr.String())
+
+	// without an ErrorDestination the warnings do not go to Out
+	stdout.Reset()
+	mustParse(Config{Out: &stdout, Exit: func(int) {}}, &args)
+	assert.Empty(t, stdout.String())
+}